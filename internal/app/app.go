@@ -1,12 +1,16 @@
 package app
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -65,6 +69,7 @@ func rootCmd() *cobra.Command {
 	cmd.AddCommand(serveCmd())
 	cmd.AddCommand(syncCmd())
 	cmd.AddCommand(seedCmd())
+	cmd.AddCommand(dbCmd())
 	cmd.AddCommand(versionCmd())
 	return cmd
 }
@@ -118,6 +123,148 @@ func seedCmd() *cobra.Command {
 	return cmd
 }
 
+func dbCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Database maintenance",
+	}
+	cmd.AddCommand(dbGCCmd(), dbCheckStatsCmd(), dbStatusCmd(), dbMigrateCmd(), dbRollbackCmd(),
+		dbBackupCmd(), dbExportCmd(), dbImportCmd(), dbPruneAuditCmd())
+	return cmd
+}
+
+func dbGCCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete unreferenced manifests and blobs, then vacuum",
+		Run: func(cmd *cobra.Command, _ []string) {
+			runDBGC(configFromCommand(cmd))
+		},
+	}
+	addDBFlags(cmd)
+	cmd.Flags().Duration("grace-period", 30*24*time.Hour, "Keep unreferenced manifests seen or in tag history this recently")
+	return cmd
+}
+
+func dbCheckStatsCmd() *cobra.Command {
+	var repair bool
+	cmd := &cobra.Command{
+		Use:   "check-stats",
+		Short: "Recount repository statistics and report those that drifted",
+		Run: func(cmd *cobra.Command, _ []string) {
+			runDBCheckStats(configFromCommand(cmd), repair)
+		},
+	}
+	addDBFlags(cmd)
+	cmd.Flags().BoolVar(&repair, "repair", false, "Replace drifted statistics with the recount")
+	return cmd
+}
+
+func dbStatusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "List schema migrations and whether each is applied",
+		Run: func(cmd *cobra.Command, _ []string) {
+			runDBStatus(configFromCommand(cmd))
+		},
+	}
+	addDBFlags(cmd)
+	return cmd
+}
+
+func dbMigrateCmd() *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending schema migrations",
+		Run: func(cmd *cobra.Command, _ []string) {
+			runDBMigrate(configFromCommand(cmd), dryRun)
+		},
+	}
+	addDBFlags(cmd)
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the pending migrations without applying them")
+	return cmd
+}
+
+func dbRollbackCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollback N",
+		Short: "Revert the last N schema migrations",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			steps, err := strconv.Atoi(args[0])
+			if err != nil || steps < 1 {
+				clog.Fatal("Rollback needs a positive number of migrations", "value", args[0])
+			}
+			runDBRollback(configFromCommand(cmd), steps)
+		},
+	}
+	addDBFlags(cmd)
+	return cmd
+}
+
+func dbBackupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup PATH",
+		Short: "Copy the SQLite database to PATH while the app keeps running",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runDBBackup(configFromCommand(cmd), args[0])
+		},
+	}
+	addDBFlags(cmd)
+	return cmd
+}
+
+func dbExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export [PATH]",
+		Short: "Write the database as newline-delimited JSON to PATH or stdout",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "-"
+			if len(args) == 1 {
+				path = args[0]
+			}
+			runDBExport(configFromCommand(cmd), path)
+		},
+	}
+	addDBFlags(cmd)
+	return cmd
+}
+
+func dbImportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import PATH",
+		Short: "Load a db export into an empty database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			runDBImport(configFromCommand(cmd), args[0])
+		},
+	}
+	addDBFlags(cmd)
+	return cmd
+}
+
+func dbPruneAuditCmd() *cobra.Command {
+	var olderThan time.Duration
+	var archive string
+	cmd := &cobra.Command{
+		Use:   "prune-audit",
+		Short: "Delete old audit events, appending them to an archive first",
+		Run: func(cmd *cobra.Command, _ []string) {
+			if olderThan <= 0 {
+				clog.Fatal("Prune needs a positive --older-than", "value", olderThan)
+			}
+			runDBPruneAudit(configFromCommand(cmd), time.Now().Add(-olderThan), archive)
+		},
+	}
+	addDBFlags(cmd)
+	cmd.Flags().DurationVar(&olderThan, "older-than", 365*24*time.Hour, "Delete events older than this")
+	cmd.Flags().StringVar(&archive, "archive", "", "Append the deleted events to this file as JSON lines; empty discards them")
+	return cmd
+}
+
 func versionCmd() *cobra.Command {
 	var short bool
 	cmd := &cobra.Command{
@@ -139,7 +286,7 @@ func versionCmd() *cobra.Command {
 func addServerFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP("host", "H", "localhost", "Host")
 	cmd.Flags().StringP("port", "p", "3000", "Port")
-	cmd.Flags().String("database-url", "data/ui.db", "SQLite path")
+	cmd.Flags().String("database-url", "data/ui.db", "SQLite path or PostgreSQL connection string")
 	cmd.Flags().Bool("show-sql", false, "Log SQL queries")
 }
 
@@ -151,7 +298,7 @@ func addSyncFlags(cmd *cobra.Command) {
 }
 
 func addDBFlags(cmd *cobra.Command) {
-	cmd.Flags().String("database-url", "data/ui.db", "SQLite path")
+	cmd.Flags().String("database-url", "data/ui.db", "SQLite path or PostgreSQL connection string")
 	cmd.Flags().Bool("show-sql", false, "Log SQL queries")
 }
 
@@ -160,17 +307,19 @@ type runtime struct {
 	regManager *registry.Manager
 	syncSvc    *sync.Service
 	server     *web.Server
+	auth       *web.AuthHandler
+	audit      *web.AuditLog
 	tracker    *progress.Tracker
 }
 
 func newRuntime(cfg *Config) (*runtime, error) {
-	s, err := store.New(context.Background(), cfg.Database.URL)
+	s, err := store.Open(context.Background(), cfg.Database.Connection, cfg.Database.URL)
 	if err != nil {
 		return nil, fmt.Errorf("create store: %w", err)
 	}
 	reg, err := registry.New(cfg.RegistryList, cfg.Scraper.HttpMaxRetries, cfg.App.DisableTagDeletion)
 	if err != nil {
-		s.Close()
+		closeStore(s)
 		return nil, fmt.Errorf("create registry manager: %w", err)
 	}
 	return &runtime{store: s, regManager: reg}, nil
@@ -191,6 +340,10 @@ func (r *runtime) initSync(cfg *Config, showProgress bool) error {
 			Debug:                   cfg.Scraper.Debug,
 			SyncInterval:            cfg.Scraper.SyncInterval,
 			CircuitBreakerThreshold: cfg.Scraper.CircuitBreakerThreshold,
+			PersistBatchSize:        cfg.Scraper.PersistBatchSize,
+			PersistFlushInterval:    cfg.Scraper.PersistFlushInterval,
+			LeaderElection:          cfg.Scraper.LeaderElection,
+			LeaseTTL:                cfg.Scraper.LeaseTTL,
 		},
 	})
 	if err != nil {
@@ -219,6 +372,14 @@ func (r *runtime) initServer(cfg *Config, withSync bool) error {
 	if err != nil {
 		return fmt.Errorf("create auth: %w", err)
 	}
+	auditLog, err := web.NewAuditLog(r.store, web.AuditConfig{
+		File:              cfg.Audit.LogFile,
+		TrustForwardedFor: cfg.Audit.TrustForwardedFor,
+	}, clog.Default())
+	if err != nil {
+		return fmt.Errorf("create audit log: %w", err)
+	}
+	r.audit = auditLog
 
 	srv, err := web.New(web.Options{
 		Store:           r.store,
@@ -228,6 +389,7 @@ func (r *runtime) initServer(cfg *Config, withSync bool) error {
 		Broadcaster:     ws,
 		ManualSyncChan:  manualCh,
 		AuthHandler:     authHandler,
+		AuditLog:        auditLog,
 		Host:            cfg.Server.Host,
 		Port:            cfg.Server.Port,
 		Debug:           cfg.Server.Debug,
@@ -237,6 +399,7 @@ func (r *runtime) initServer(cfg *Config, withSync bool) error {
 		return fmt.Errorf("create server: %w", err)
 	}
 	r.server = srv
+	r.auth = authHandler
 	return nil
 }
 
@@ -247,8 +410,11 @@ func (r *runtime) close() {
 	if r.syncSvc != nil {
 		r.syncSvc.Stop()
 	}
+	if err := r.audit.Close(); err != nil {
+		clog.Warn("Failed to close audit log file", "error", err)
+	}
 	if r.store != nil {
-		r.store.Close()
+		closeStore(r.store)
 	}
 }
 
@@ -282,6 +448,10 @@ func newAuthHandler(cfg *Config) (*web.AuthHandler, error) {
 		AllowedEmailDomains: cfg.OIDC.AllowedEmailDomains,
 		AllowedGroups:       cfg.OIDC.AllowedGroups,
 		AllowedRoles:        cfg.OIDC.AllowedRoles,
+		AdminEmails:         cfg.OIDC.AdminEmails,
+		AdminGroups:         cfg.OIDC.AdminGroups,
+		AdminRoles:          cfg.OIDC.AdminRoles,
+		PolicyFile:          cfg.OIDC.PolicyFile,
 		ClaimEmail:          cfg.OIDC.ClaimEmail,
 		ClaimGroups:         cfg.OIDC.ClaimGroups,
 		ClaimRoles:          cfg.OIDC.ClaimRoles,
@@ -312,12 +482,21 @@ func runStart(cfg *Config) {
 		}
 	}()
 	go r.syncSvc.StartBackground(ctx)
+	if cfg.Database.BackupInterval > 0 {
+		go runScheduledBackups(ctx, r.store, cfg.Database)
+	}
+	if cfg.OIDC.PolicyFile != "" {
+		go reloadPolicyOnHangup(ctx, r.auth, r.audit)
+	}
 
 	waitForSignal()
 	cancel()
 }
 
 func runServe(cfg *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	r, err := newRuntime(cfg)
 	if err != nil {
 		clog.Fatal(err)
@@ -333,7 +512,14 @@ func runServe(cfg *Config) {
 			clog.Error("Server failed", "error", err)
 		}
 	}()
+	if cfg.Database.BackupInterval > 0 {
+		go runScheduledBackups(ctx, r.store, cfg.Database)
+	}
+	if cfg.OIDC.PolicyFile != "" {
+		go reloadPolicyOnHangup(ctx, r.auth, r.audit)
+	}
 	waitForSignal()
+	cancel()
 }
 
 func runSync(cfg *Config) {
@@ -356,11 +542,11 @@ func runSync(cfg *Config) {
 }
 
 func runSeed(cfg *Config) {
-	s, err := store.New(context.Background(), cfg.Database.URL)
+	s, err := store.Open(context.Background(), cfg.Database.Connection, cfg.Database.URL)
 	if err != nil {
 		clog.Fatal("Failed to open database", "error", err)
 	}
-	defer s.Close()
+	defer closeStore(s)
 
 	if err := s.Seed(context.Background()); err != nil {
 		clog.Fatal("Seed failed", "error", err)
@@ -368,6 +554,273 @@ func runSeed(cfg *Config) {
 	clog.Info("Database seeded")
 }
 
+func runDBGC(cfg *Config) {
+	ctx := context.Background()
+	s, err := store.Open(ctx, cfg.Database.Connection, cfg.Database.URL)
+	if err != nil {
+		clog.Fatal("Failed to open database", "error", err)
+	}
+	defer closeStore(s)
+
+	result, err := s.CollectGarbage(ctx, time.Now().Add(-cfg.Database.GCGracePeriod))
+	if err != nil {
+		clog.Fatal("Garbage collection failed", "error", err)
+	}
+	fmt.Printf("Removed %d manifests, %d platforms, %d manifest layers, %d layers and %d config blobs\n",
+		result.Manifests, result.Platforms, result.ManifestLayers, result.Layers, result.ConfigBlobs)
+	fmt.Printf("Reclaimed %d bytes\n", result.ReclaimedBytes)
+}
+
+func runDBCheckStats(cfg *Config, repair bool) {
+	ctx := context.Background()
+	s, err := store.Open(ctx, cfg.Database.Connection, cfg.Database.URL)
+	if err != nil {
+		clog.Fatal("Failed to open database", "error", err)
+	}
+	defer closeStore(s)
+
+	drift, err := s.CheckRepositoryStats(ctx)
+	if err != nil {
+		clog.Fatal("Statistics check failed", "error", err)
+	}
+	for _, d := range drift {
+		fmt.Printf("%s: stored %s, expected %s\n", d.Path, formatStats(d.Stored), formatStats(d.Expected))
+	}
+	if len(drift) == 0 {
+		fmt.Println("Repository statistics are consistent")
+		return
+	}
+	if !repair {
+		fmt.Printf("%d repositories drifted; run with --repair to fix them\n", len(drift))
+		os.Exit(1)
+	}
+	if err := s.RebuildRepositoryStats(ctx); err != nil {
+		clog.Fatal("Failed to rebuild repository stats", "error", err)
+	}
+	fmt.Printf("Repaired %d repositories\n", len(drift))
+}
+
+func closeStore(s *store.Store) {
+	if err := s.Close(); err != nil {
+		clog.Warn("Failed to close database", "error", err)
+	}
+}
+
+func closeMigrator(m *store.Migrator) {
+	if err := m.Close(); err != nil {
+		clog.Warn("Failed to close database", "error", err)
+	}
+}
+
+func openMigrator(cfg *Config) *store.Migrator {
+	m, err := store.OpenMigrator(context.Background(), cfg.Database.Connection, cfg.Database.URL)
+	if err != nil {
+		clog.Fatal("Failed to open database", "error", err)
+	}
+	return m
+}
+
+func runDBStatus(cfg *Config) {
+	m := openMigrator(cfg)
+	defer closeMigrator(m)
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		clog.Fatal("Failed to read migration status", "error", err)
+	}
+	for _, st := range statuses {
+		state := "pending"
+		switch {
+		case st.Unknown:
+			state = "applied by a newer version"
+		case st.Applied && st.AppliedAt != nil:
+			state = "applied " + st.AppliedAt.UTC().Format(time.RFC3339)
+		case st.Applied:
+			state = "applied"
+		}
+		if st.Modified() {
+			state += ", file changed since"
+		}
+		fmt.Printf("%03d %-32s %s\n", st.Version, st.Name, state)
+	}
+}
+
+func runDBMigrate(cfg *Config, dryRun bool) {
+	m := openMigrator(cfg)
+	defer closeMigrator(m)
+
+	ctx := context.Background()
+	if dryRun {
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			clog.Fatal("Failed to list pending migrations", "error", err)
+		}
+		if len(pending) == 0 {
+			fmt.Println("No pending migrations")
+			return
+		}
+		for _, mig := range pending {
+			fmt.Printf("-- %03d %s\n%s\n", mig.Version, mig.Name, strings.TrimSpace(mig.SQL))
+		}
+		return
+	}
+
+	applied, err := m.Migrate(ctx)
+	for _, mig := range applied {
+		fmt.Printf("Applied %03d %s\n", mig.Version, mig.Name)
+	}
+	if err != nil {
+		clog.Fatal("Migration failed", "error", err)
+	}
+	if len(applied) == 0 {
+		fmt.Println("No pending migrations")
+	}
+}
+
+func runDBRollback(cfg *Config, steps int) {
+	m := openMigrator(cfg)
+	defer closeMigrator(m)
+
+	reverted, err := m.Rollback(context.Background(), steps)
+	for _, mig := range reverted {
+		fmt.Printf("Rolled back %03d %s\n", mig.Version, mig.Name)
+	}
+	if err != nil {
+		clog.Fatal("Rollback failed", "error", err)
+	}
+}
+
+func runDBBackup(cfg *Config, path string) {
+	ctx := context.Background()
+	s, err := store.Open(ctx, cfg.Database.Connection, cfg.Database.URL)
+	if err != nil {
+		clog.Fatal("Failed to open database", "error", err)
+	}
+	defer closeStore(s)
+
+	if err := s.Backup(ctx, path); err != nil {
+		clog.Fatal("Backup failed", "error", err)
+	}
+	fmt.Printf("Backed up to %s\n", path)
+}
+
+func runDBExport(cfg *Config, path string) {
+	ctx := context.Background()
+	s, err := store.Open(ctx, cfg.Database.Connection, cfg.Database.URL)
+	if err != nil {
+		clog.Fatal("Failed to open database", "error", err)
+	}
+	defer closeStore(s)
+
+	out := os.Stdout
+	if path != "-" {
+		out, err = os.Create(path)
+		if err != nil {
+			clog.Fatal("Failed to create export file", "error", err)
+		}
+	}
+	w := bufio.NewWriter(out)
+	rows, err := s.Export(ctx, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if out != os.Stdout {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		clog.Fatal("Export failed", "error", err)
+	}
+	clog.Info("Database exported", "rows", rows)
+}
+
+func runDBImport(cfg *Config, path string) {
+	ctx := context.Background()
+	s, err := store.Open(ctx, cfg.Database.Connection, cfg.Database.URL)
+	if err != nil {
+		clog.Fatal("Failed to open database", "error", err)
+	}
+	defer closeStore(s)
+
+	f, err := os.Open(path)
+	if err != nil {
+		clog.Fatal("Failed to open export file", "error", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	result, err := s.Import(ctx, bufio.NewReader(f))
+	if err != nil {
+		clog.Fatal("Import failed", "error", err)
+	}
+	fmt.Printf("Imported %d of %d rows; the rest were already present\n", result.Inserted, result.Rows)
+}
+
+func runDBPruneAudit(cfg *Config, cutoff time.Time, archivePath string) {
+	ctx := context.Background()
+	s, err := store.Open(ctx, cfg.Database.Connection, cfg.Database.URL)
+	if err != nil {
+		clog.Fatal("Failed to open database", "error", err)
+	}
+	defer closeStore(s)
+
+	// The prune is recorded within its transaction, so it reaches the audit
+	// log file only once it has committed.
+	auditLog, err := web.NewAuditLog(s, web.AuditConfig{File: cfg.Audit.LogFile}, clog.Default())
+	if err != nil {
+		clog.Fatal("Failed to open audit log", "error", err)
+	}
+	defer func() {
+		if err := auditLog.Close(); err != nil {
+			clog.Warn("Failed to close audit log", "error", err)
+		}
+	}()
+
+	var archive store.AuditArchive
+	if archivePath != "" {
+		f, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			clog.Fatal("Failed to open audit archive", "error", err)
+		}
+		archive = newAuditArchive(f)
+	}
+	event := store.AuditEvent{ActorName: commandActor()}
+	pruned, err := s.PruneAuditEvents(ctx, cutoff, archive, &event)
+	if err != nil {
+		clog.Fatal("Audit prune failed", "error", err)
+	}
+	auditLog.Append(event)
+	fmt.Printf("Deleted %d audit events from before %s\n", pruned, cutoff.UTC().Format(time.RFC3339))
+}
+
+// commandActor names who runs a command, as user@host, for the audit events
+// it records outside of any request.
+func commandActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return name + "@" + host
+}
+
+func formatStats(st store.RepositoryStats) string {
+	if !st.Present {
+		return "nothing"
+	}
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "never"
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%d tags, %d bytes (%d unique), updated %s, changed %s",
+		st.TagsCount, st.TotalSizeBytes, st.UniqueSizeBytes, formatTime(st.LastUpdatedAt), formatTime(st.LastChangedAt))
+}
+
 func waitForSignal() {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
@@ -375,6 +828,31 @@ func waitForSignal() {
 	clog.Info("Signal received, stopping")
 }
 
+// reloadPolicyOnHangup reloads the access policy on every SIGHUP until ctx
+// is done, auditing each reload.
+func reloadPolicyOnHangup(ctx context.Context, auth *web.AuthHandler, audit *web.AuditLog) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			event := store.AuditEvent{
+				Action:  store.AuditActionConfigReload,
+				Outcome: store.AuditOutcomeSuccess,
+				Detail:  "Access policy reloaded on SIGHUP",
+			}
+			if err := auth.ReloadPolicy(); err != nil {
+				clog.Error("Failed to reload access policy, keeping the current one", "error", err)
+				event.Outcome, event.Detail = store.AuditOutcomeFailure, err.Error()
+			}
+			audit.Record(ctx, event)
+		}
+	}
+}
+
 func startPprof() {
 	go func() {
 		clog.Info("pprof server on http://localhost:6060/debug/pprof/")
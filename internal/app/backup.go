@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+)
+
+// Scheduled backups are named ui-<UTC time>.db, so sorting the names sorts
+// them by age and rotation leaves other files in the directory alone.
+const (
+	backupPrefix     = "ui-"
+	backupExt        = ".db"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+// runScheduledBackups backs the database up every cfg.BackupInterval until ctx
+// is done. Failures are logged and retried at the next interval.
+func runScheduledBackups(ctx context.Context, s *store.Store, cfg DatabaseConfig) {
+	if cfg.Connection == store.ConnectionPostgres {
+		clog.Warn("Scheduled backups only cover SQLite; back up PostgreSQL with pg_dump")
+		return
+	}
+	clog.Info("Starting scheduled backups", "interval", cfg.BackupInterval, "dir", cfg.BackupDir, "keep", cfg.BackupKeep)
+
+	ticker := time.NewTicker(cfg.BackupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			path, err := scheduledBackup(ctx, s, cfg, now)
+			if err != nil {
+				clog.Error("Scheduled backup failed", "error", err)
+				continue
+			}
+			clog.Info("Database backed up", "path", path)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func scheduledBackup(ctx context.Context, s *store.Store, cfg DatabaseConfig, now time.Time) (string, error) {
+	if err := os.MkdirAll(cfg.BackupDir, 0750); err != nil {
+		return "", fmt.Errorf("create backup directory: %w", err)
+	}
+	path := filepath.Join(cfg.BackupDir, backupPrefix+now.UTC().Format(backupTimeLayout)+backupExt)
+	if err := s.Backup(ctx, path); err != nil {
+		return "", err
+	}
+	// The backup just taken is always kept.
+	if err := pruneBackups(cfg.BackupDir, max(cfg.BackupKeep, 1)); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// pruneBackups deletes all but the newest keep scheduled backups in dir.
+func pruneBackups(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("list backups: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupExt)); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names[:max(len(names)-keep, 0)] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			errs = append(errs, fmt.Errorf("remove old backup: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// auditArchive appends audit events to a file as JSON lines, the format of
+// AUDIT_LOG_FILE.
+type auditArchive struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newAuditArchive(f *os.File) *auditArchive {
+	w := bufio.NewWriter(f)
+	return &auditArchive{f: f, w: w, enc: json.NewEncoder(w)}
+}
+
+func (a *auditArchive) Write(event store.AuditEvent) error {
+	return a.enc.Encode(event)
+}
+
+// Close flushes the archive to disk, before the prune deletes the events.
+func (a *auditArchive) Close() error {
+	err := a.w.Flush()
+	if err == nil {
+		err = a.f.Sync()
+	}
+	if closeErr := a.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -26,6 +26,7 @@ type Config struct {
 	Scraper       ScraperConfig
 	Database      DatabaseConfig
 	OIDC          OIDCConfig
+	Audit         AuditConfig
 	SessionSecret string        `env:"SESSION_SECRET"`
 	SessionMaxAge time.Duration `env:"SESSION_MAX_AGE" envDefault:"24h"`
 	RegistryList  []registry.Config
@@ -40,6 +41,10 @@ type OIDCConfig struct {
 	AllowedEmailDomains string `env:"OIDC_ALLOWED_EMAIL_DOMAINS"`
 	AllowedGroups       string `env:"OIDC_ALLOWED_GROUPS"`
 	AllowedRoles        string `env:"OIDC_ALLOWED_ROLES"`
+	AdminEmails         string `env:"OIDC_ADMIN_EMAILS"`
+	AdminGroups         string `env:"OIDC_ADMIN_GROUPS"`
+	AdminRoles          string `env:"OIDC_ADMIN_ROLES"`
+	PolicyFile          string `env:"OIDC_POLICY_FILE"`
 	ClaimEmail          string `env:"OIDC_CLAIM_EMAIL" envDefault:"email"`
 	ClaimGroups         string `env:"OIDC_CLAIM_GROUPS" envDefault:"groups"`
 	ClaimRoles          string `env:"OIDC_CLAIM_ROLES" envDefault:"roles"`
@@ -52,6 +57,9 @@ func (c OIDCConfig) Enabled() bool {
 
 func (c OIDCConfig) Validate() error {
 	if !c.Enabled() {
+		if c.PolicyFile != "" {
+			return errors.New("OIDC_POLICY_FILE requires OIDC_ISSUER_URL")
+		}
 		return nil
 	}
 	if c.ClientID == "" {
@@ -66,6 +74,14 @@ func (c OIDCConfig) Validate() error {
 	return nil
 }
 
+type AuditConfig struct {
+	// LogFile also receives every audit event as a JSON line.
+	LogFile string `env:"AUDIT_LOG_FILE"`
+	// TrustForwardedFor takes client IPs from the X-Forwarded-For header set
+	// by a reverse proxy. Without one in front, clients could forge it.
+	TrustForwardedFor bool `env:"AUDIT_TRUST_FORWARDED_FOR" envDefault:"false"`
+}
+
 type AppConfig struct {
 	VerboseLevel       string `env:"APP_VERBOSE_LEVEL" envDefault:"warn"`
 	VerboseCount       int    `flag:"verbose"`
@@ -88,12 +104,24 @@ type ScraperConfig struct {
 	Debug                   bool          `env:"SCRAPER_DEBUG" envDefault:"false" flag:"scraper-debug"`
 	CircuitBreakerThreshold int           `env:"SCRAPER_CIRCUIT_BREAKER_THRESHOLD" envDefault:"5" flag:"circuit-breaker-threshold"`
 	HttpMaxRetries          int           `env:"SCRAPER_HTTP_MAX_RETRIES" envDefault:"2" flag:"http-max-retries"`
+	PersistBatchSize        int           `env:"SCRAPER_PERSIST_BATCH_SIZE" envDefault:"50"`
+	PersistFlushInterval    time.Duration `env:"SCRAPER_PERSIST_FLUSH_INTERVAL" envDefault:"250ms"`
+	LeaderElection          bool          `env:"SCRAPER_LEADER_ELECTION" envDefault:"false"`
+	LeaseTTL                time.Duration `env:"SCRAPER_LEASE_TTL" envDefault:"15s"`
 }
 
 type DatabaseConfig struct {
 	Connection string `env:"DATABASE_CONNECTION" envDefault:"sqlite" flag:"database-connection"`
 	URL        string `env:"DATABASE_URL" envDefault:"data/ui.db" flag:"database-url"`
 	ShowSQL    bool   `env:"DATABASE_SHOW_SQL" envDefault:"false" flag:"show-sql"`
+	// GCGracePeriod keeps unreferenced manifests seen or named in tag history
+	// this recently out of garbage collection.
+	GCGracePeriod time.Duration `env:"DATABASE_GC_GRACE_PERIOD" envDefault:"720h" flag:"grace-period"`
+	// BackupInterval schedules SQLite backups into BackupDir while the app
+	// runs, keeping the newest BackupKeep. Zero disables them.
+	BackupInterval time.Duration `env:"DATABASE_BACKUP_INTERVAL" envDefault:"0"`
+	BackupDir      string        `env:"DATABASE_BACKUP_DIR" envDefault:"data/backups"`
+	BackupKeep     int           `env:"DATABASE_BACKUP_KEEP" envDefault:"7"`
 }
 
 func LoadConfig(flags *pflag.FlagSet) (*Config, error) {
@@ -177,6 +205,9 @@ func applyFlagOverrides(flags *pflag.FlagSet, cfg *Config) error {
 	if err := applyBoolFlag(flags, "show-sql", &cfg.Database.ShowSQL); err != nil {
 		return err
 	}
+	if err := applyDurationFlag(flags, "grace-period", &cfg.Database.GCGracePeriod); err != nil {
+		return err
+	}
 
 	return nil
 }
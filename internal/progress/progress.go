@@ -95,6 +95,19 @@ func (t *Tracker) Reset() {
 	t.broadcast()
 }
 
+// Apply replaces the tracker state with u. Replicas that do not run the sync
+// use it to mirror the progress reported by the one that does.
+func (t *Tracker) Apply(u Update) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = u.Total
+	t.completed = u.Completed
+	t.latestMessage = u.Message
+	t.latestStep = u.Step
+	t.done = u.Done
+	t.broadcast()
+}
+
 func (t *Tracker) Subscribe() <-chan Update {
 	t.mu.Lock()
 	defer t.mu.Unlock()
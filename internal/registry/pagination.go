@@ -0,0 +1,253 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	clog "github.com/charmbracelet/log"
+	registryclient "github.com/eznix86/registry-client"
+)
+
+const (
+	// PageSize is the page size requested from catalog and tag list endpoints.
+	PageSize = 100
+
+	// DefaultMaxPages bounds a single listing so a registry that keeps handing
+	// out cursors cannot trap discovery in a loop.
+	DefaultMaxPages = 10000
+)
+
+// ErrPaginationTruncated is returned when a listing stopped before the registry
+// reported its last page.
+var ErrPaginationTruncated = errors.New("pagination truncated")
+
+// Pager walks a paginated catalog or tag listing one page at a time.
+//
+// The next page is taken from the RFC 5988 Link header (rel="next") as the
+// distribution spec defines. Once any page of a listing carried a Link header,
+// a page without one is the last. A listing that never sends one falls back to
+// the "exactly a full page means more pages" heuristic; a registry that ignores
+// n and returns more than PageSize items has sent the whole listing. Link state
+// is kept per listing because a registry may send Link headers for its catalog
+// but not its tag lists.
+type Pager struct {
+	client    *Client
+	what      string
+	fetch     func(ctx context.Context, params *registryclient.PaginationParams) ([]string, error)
+	last      string
+	pages     int
+	linked    bool
+	done      bool
+	truncated bool
+}
+
+// CatalogPager returns a pager over the registry catalog.
+func (c *Client) CatalogPager() *Pager {
+	return &Pager{
+		client: c,
+		what:   "catalog",
+		fetch: func(ctx context.Context, params *registryclient.PaginationParams) ([]string, error) {
+			resp, err := c.GetCatalog(ctx, params)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Repositories, nil
+		},
+	}
+}
+
+// TagsPager returns a pager over the tags of repo.
+func (c *Client) TagsPager(repo string) *Pager {
+	return &Pager{
+		client: c,
+		what:   "tags " + repo,
+		fetch: func(ctx context.Context, params *registryclient.PaginationParams) ([]string, error) {
+			resp, err := c.ListTags(ctx, repo, params)
+			if err != nil {
+				return nil, err
+			}
+			return resp.Tags, nil
+		},
+	}
+}
+
+// Next fetches the next page. The boolean result reports whether another page
+// should be requested.
+func (p *Pager) Next(ctx context.Context) ([]string, bool, error) {
+	if p.done {
+		return nil, false, nil
+	}
+
+	rec := &linkRecorder{}
+	items, err := p.fetch(withLinkRecorder(ctx, rec), &registryclient.PaginationParams{N: PageSize, Last: p.last})
+	if err != nil {
+		return nil, false, err
+	}
+	p.pages++
+
+	next, more, followable := p.nextCursor(items, rec)
+	switch {
+	case !more:
+		p.done = true
+	case !followable:
+		clog.Warn("Registry sent a next link without a last cursor, listing truncated", "registry", p.client.name, "listing", p.what)
+		p.truncate()
+	case next == p.last:
+		clog.Warn("Registry repeated a pagination cursor, stopping", "registry", p.client.name, "listing", p.what, "cursor", next)
+		p.truncate()
+	case p.pages >= p.client.maxPages:
+		clog.Warn("Pagination page limit reached, listing truncated", "registry", p.client.name, "listing", p.what, "pages", p.pages)
+		p.truncate()
+	default:
+		p.last = next
+	}
+
+	return items, !p.done, nil
+}
+
+// Truncated reports whether the listing stopped before its last page.
+func (p *Pager) Truncated() bool { return p.truncated }
+
+func (p *Pager) truncate() {
+	p.done = true
+	p.truncated = true
+}
+
+// nextCursor returns the cursor of the next page and whether there is one.
+// followable is false when the registry announced a next page through a Link
+// target the client cannot request, which has no "last" cursor.
+func (p *Pager) nextCursor(items []string, rec *linkRecorder) (next string, more, followable bool) {
+	if link, ok := rec.get(); ok {
+		p.linked = true
+		if last, found := parseNextLink(link); found {
+			return last, true, last != ""
+		}
+		return "", false, true
+	}
+
+	if p.linked || len(items) != PageSize {
+		return "", false, true
+	}
+	return items[len(items)-1], true, true
+}
+
+func (p *Pager) collect(ctx context.Context) ([]string, error) {
+	var all []string
+	for {
+		items, more, err := p.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, items...)
+		if !more {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return all, ctx.Err()
+		default:
+		}
+	}
+	if p.truncated {
+		return all, ErrPaginationTruncated
+	}
+	return all, nil
+}
+
+// parseNextLink extracts the "last" cursor from the rel="next" entry of a Link
+// header value such as `</v2/_catalog?last=b&n=100>; rel="next"`. found reports
+// whether a next entry exists at all, even one without a usable cursor.
+func parseNextLink(header string) (last string, found bool) {
+	for entry := range strings.SplitSeq(header, ",") {
+		target, params, ok := strings.Cut(entry, ";")
+		if !ok {
+			continue
+		}
+		if !hasNextRel(params) {
+			continue
+		}
+		found = true
+		target = strings.TrimSpace(target)
+		target = strings.TrimPrefix(target, "<")
+		target = strings.TrimSuffix(target, ">")
+		u, err := url.Parse(target)
+		if err != nil {
+			continue
+		}
+		if last := u.Query().Get("last"); last != "" {
+			return last, true
+		}
+	}
+	return "", found
+}
+
+func hasNextRel(params string) bool {
+	for param := range strings.SplitSeq(params, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+			continue
+		}
+		for rel := range strings.FieldsSeq(strings.Trim(strings.TrimSpace(value), `"`)) {
+			if strings.EqualFold(rel, "next") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// linkRecorder captures the Link header of the listing response made with its
+// context. The registry client does not expose response headers, so the
+// transport records them on the way back.
+type linkRecorder struct {
+	mu   sync.Mutex
+	link string
+	seen bool
+}
+
+func (r *linkRecorder) record(link string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.link = link
+	r.seen = link != ""
+}
+
+func (r *linkRecorder) get() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.link, r.seen
+}
+
+type linkRecorderKey struct{}
+
+func withLinkRecorder(ctx context.Context, rec *linkRecorder) context.Context {
+	return context.WithValue(ctx, linkRecorderKey{}, rec)
+}
+
+// linkTransport hands the Link header of catalog and tag list responses to the
+// recorder found in the request context, if any. Token and other auxiliary
+// requests made under the same context are ignored.
+type linkTransport struct {
+	base http.RoundTripper
+}
+
+func (t *linkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	rec, ok := req.Context().Value(linkRecorderKey{}).(*linkRecorder)
+	if !ok || !isListingPath(req.URL.Path) || resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	rec.record(resp.Header.Get("Link"))
+	return resp, nil
+}
+
+func isListingPath(p string) bool {
+	return strings.HasSuffix(p, "/_catalog") || strings.HasSuffix(p, "/tags/list")
+}
@@ -0,0 +1,241 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	registryclient "github.com/eznix86/registry-client"
+)
+
+func TestParseNextLink(t *testing.T) {
+	t.Helper()
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		ok     bool
+	}{
+		{name: "distribution", header: `</v2/_catalog?last=b&n=100>; rel="next"`, want: "b", ok: true},
+		{name: "absolute url", header: `<https://r.example.com/v2/app/tags/list?n=50&last=v1.2>; rel=next`, want: "v1.2", ok: true},
+		{name: "multiple relations", header: `</v2/_catalog?last=a>; rel="prev", </v2/_catalog?last=z>; rel="next"`, want: "z", ok: true},
+		{name: "no next", header: `</v2/_catalog?last=a>; rel="prev"`},
+		{name: "missing cursor", header: `</v2/_catalog?n=100>; rel="next"`, ok: true},
+		{name: "opaque cursor", header: `</v2/_catalog?n=100&page=abc>; rel="next"`, ok: true},
+		{name: "empty", header: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseNextLink(test.header)
+			if ok != test.ok || got != test.want {
+				t.Fatalf("parseNextLink(%q) = %q, %v; want %q, %v", test.header, got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+// fakePages serves pages keyed by the requested cursor and records the Link
+// header each page would have carried.
+func fakePages(pages map[string][]string, links map[string]string, calls *int) func(context.Context, *registryclient.PaginationParams) ([]string, error) {
+	return func(ctx context.Context, params *registryclient.PaginationParams) ([]string, error) {
+		*calls++
+		if rec, ok := ctx.Value(linkRecorderKey{}).(*linkRecorder); ok {
+			rec.record(links[params.Last])
+		}
+		items, ok := pages[params.Last]
+		if !ok {
+			return nil, errors.New("unexpected cursor " + params.Last)
+		}
+		return items, nil
+	}
+}
+
+func fullPage(prefix string) []string {
+	items := make([]string, PageSize)
+	for i := range items {
+		items[i] = prefix + strconv.Itoa(1000+i)
+	}
+	return items
+}
+
+func TestPagerFollowsLinkOnShortPages(t *testing.T) {
+	t.Helper()
+
+	var calls int
+	p := &Pager{
+		client: &Client{name: "test", maxPages: DefaultMaxPages},
+		fetch: fakePages(
+			map[string][]string{"": {"a", "b"}, "b": {"c"}},
+			map[string]string{"": `</v2/_catalog?last=b&n=2>; rel="next"`},
+			&calls,
+		),
+	}
+
+	items, err := p.collect(context.Background())
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(items) != 3 || calls != 2 {
+		t.Fatalf("expected 3 items in 2 calls, got %v in %d calls", items, calls)
+	}
+}
+
+func TestPagerStopsWhenLinkDisappears(t *testing.T) {
+	t.Helper()
+
+	first, second := fullPage("a"), fullPage("b")
+	var calls int
+	p := &Pager{
+		client: &Client{name: "test", maxPages: DefaultMaxPages},
+		fetch: fakePages(
+			map[string][]string{"": first, first[PageSize-1]: second, second[PageSize-1]: {"c"}},
+			map[string]string{"": `</v2/_catalog?last=` + first[PageSize-1] + `&n=100>; rel="next"`},
+			&calls,
+		),
+	}
+
+	items, err := p.collect(context.Background())
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(items) != 2*PageSize || calls != 2 || p.Truncated() {
+		t.Fatalf("expected %d items in 2 calls, got %d in %d calls truncated=%v", 2*PageSize, len(items), calls, p.Truncated())
+	}
+}
+
+func TestPagerTakesOversizedPageAsComplete(t *testing.T) {
+	t.Helper()
+
+	all := append(fullPage("a"), "b", "c")
+	var calls int
+	p := &Pager{
+		client: &Client{name: "test", maxPages: DefaultMaxPages},
+		fetch:  fakePages(map[string][]string{"": all}, nil, &calls),
+	}
+
+	items, err := p.collect(context.Background())
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(items) != PageSize+2 || calls != 1 || p.Truncated() {
+		t.Fatalf("expected %d items in 1 call, got %d in %d calls truncated=%v", PageSize+2, len(items), calls, p.Truncated())
+	}
+}
+
+func TestPagerKeepsLinkStatePerListing(t *testing.T) {
+	t.Helper()
+
+	client := &Client{name: "test", maxPages: DefaultMaxPages}
+	var catalogCalls, tagCalls int
+	catalog := &Pager{
+		client: client,
+		fetch: fakePages(
+			map[string][]string{"": {"a"}, "a": {"b"}},
+			map[string]string{"": `</v2/_catalog?last=a&n=100>; rel="next"`},
+			&catalogCalls,
+		),
+	}
+	if _, err := catalog.collect(context.Background()); err != nil {
+		t.Fatalf("collect catalog: %v", err)
+	}
+
+	tags := fullPage("v")
+	tagPager := &Pager{
+		client: client,
+		fetch:  fakePages(map[string][]string{"": tags, tags[PageSize-1]: {"z"}}, nil, &tagCalls),
+	}
+	items, err := tagPager.collect(context.Background())
+	if err != nil {
+		t.Fatalf("collect tags: %v", err)
+	}
+	if len(items) != PageSize+1 || tagCalls != 2 || tagPager.Truncated() {
+		t.Fatalf("expected a full tag page to be followed after the catalog sent links, got %d items in %d calls", len(items), tagCalls)
+	}
+}
+
+func TestPagerTruncatesUnfollowableLink(t *testing.T) {
+	t.Helper()
+
+	var calls int
+	p := &Pager{
+		client: &Client{name: "test", maxPages: DefaultMaxPages},
+		fetch: fakePages(
+			map[string][]string{"": {"a", "b"}},
+			map[string]string{"": `</v2/app/tags/list?n=2&page=opaque>; rel="next"`},
+			&calls,
+		),
+	}
+
+	items, err := p.collect(context.Background())
+	if !errors.Is(err, ErrPaginationTruncated) {
+		t.Fatalf("expected ErrPaginationTruncated, got %v", err)
+	}
+	if len(items) != 2 || calls != 1 || !p.Truncated() {
+		t.Fatalf("expected 2 items from 1 call and a truncated pager, got %v in %d calls truncated=%v", items, calls, p.Truncated())
+	}
+}
+
+func TestPagerFallsBackToPageCount(t *testing.T) {
+	t.Helper()
+
+	first := fullPage("a")
+	var calls int
+	p := &Pager{
+		client: &Client{name: "test", maxPages: DefaultMaxPages},
+		fetch:  fakePages(map[string][]string{"": first, first[PageSize-1]: {}}, nil, &calls),
+	}
+
+	items, err := p.collect(context.Background())
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(items) != PageSize || calls != 2 {
+		t.Fatalf("expected %d items in 2 calls, got %d in %d calls", PageSize, len(items), calls)
+	}
+}
+
+func TestPagerStopsOnRepeatedCursor(t *testing.T) {
+	t.Helper()
+
+	var calls int
+	p := &Pager{
+		client: &Client{name: "test", maxPages: DefaultMaxPages},
+		fetch: fakePages(
+			map[string][]string{"": {"a"}, "a": {"a"}},
+			map[string]string{"": `</v2/_catalog?last=a>; rel="next"`, "a": `</v2/_catalog?last=a>; rel="next"`},
+			&calls,
+		),
+	}
+
+	if _, err := p.collect(context.Background()); !errors.Is(err, ErrPaginationTruncated) {
+		t.Fatalf("expected ErrPaginationTruncated, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls before stopping, got %d", calls)
+	}
+}
+
+func TestPagerHonorsMaxPages(t *testing.T) {
+	t.Helper()
+
+	var calls int
+	p := &Pager{
+		client: &Client{name: "test", maxPages: 2},
+		fetch: fakePages(
+			map[string][]string{"": {"a"}, "a": {"b"}, "b": {"c"}},
+			map[string]string{"": `<?last=a>; rel="next"`, "a": `<?last=b>; rel="next"`, "b": `<?last=c>; rel="next"`},
+			&calls,
+		),
+	}
+
+	items, err := p.collect(context.Background())
+	if !errors.Is(err, ErrPaginationTruncated) {
+		t.Fatalf("expected ErrPaginationTruncated, got %v", err)
+	}
+	if len(items) != 2 || !p.Truncated() {
+		t.Fatalf("expected 2 items and a truncated pager, got %v truncated=%v", items, p.Truncated())
+	}
+}
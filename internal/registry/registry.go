@@ -9,6 +9,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +39,7 @@ type Config struct {
 	IsGitHub    bool
 	IsGitHubOrg bool
 	PublicHost  string
+	MaxPages    int
 }
 
 type Client struct {
@@ -46,6 +48,7 @@ type Client struct {
 	host       string
 	url        string
 	publicHost string
+	maxPages   int
 }
 
 func (c *Client) Name() string       { return c.name }
@@ -100,7 +103,7 @@ func (m *Manager) ListRegistries() []string {
 func (m *Manager) newClient(cfg Config, httpMaxRetries int, disableTagDeletion bool) *Client {
 	hc := &http.Client{
 		Timeout: requestTimeout,
-		Transport: &http.Transport{
+		Transport: &linkTransport{base: &http.Transport{
 			Proxy:                 http.ProxyFromEnvironment,
 			DialContext:           (&net.Dialer{Timeout: dialTimeout, KeepAlive: keepAliveInterval}).DialContext,
 			MaxIdleConns:          256,
@@ -111,7 +114,7 @@ func (m *Manager) newClient(cfg Config, httpMaxRetries int, disableTagDeletion b
 			TLSClientConfig:       &tls.Config{InsecureSkipVerify: cfg.Insecure}, //nolint:gosec // User-controlled insecure registry support is explicit.
 			ResponseHeaderTimeout: responseHeaderTimeout,
 			ExpectContinueTimeout: expectContinueTimeout,
-		},
+		}},
 	}
 
 	var libClient registryclient.RegistryClient
@@ -128,7 +131,19 @@ func (m *Manager) newClient(cfg Config, httpMaxRetries int, disableTagDeletion b
 		libClient = buildBaseClient(cfg, hc, maxAttempts, disableTagDeletion)
 	}
 
-	return &Client{RegistryClient: libClient, name: cfg.Name, host: host, publicHost: publicHost, url: strings.TrimSuffix(cfg.URL, "/")}
+	maxPages := cfg.MaxPages
+	if maxPages <= 0 {
+		maxPages = DefaultMaxPages
+	}
+
+	return &Client{
+		RegistryClient: libClient,
+		name:           cfg.Name,
+		host:           host,
+		publicHost:     publicHost,
+		url:            strings.TrimSuffix(cfg.URL, "/"),
+		maxPages:       maxPages,
+	}
 }
 
 func buildBaseClient(cfg Config, hc *http.Client, maxAttempts int, disableDelete bool) *registryclient.BaseClient {
@@ -204,6 +219,7 @@ func loadDefaultConfig() *Config {
 	}
 	cfg.Insecure = strings.ToLower(os.Getenv("REGISTRY_SETTINGS_INSECURE")) == envTrue
 	cfg.PublicHost = os.Getenv("REGISTRY_SETTINGS_PUBLIC_HOST")
+	cfg.MaxPages = parseIntEnv("REGISTRY_SETTINGS_MAX_PAGES")
 	if isGHCR(url) {
 		cfg.IsGitHub = true
 		cfg.IsGitHubOrg = os.Getenv("REGISTRY_SETTINGS_ORG") == envTrue
@@ -236,6 +252,7 @@ func loadNamedConfigs() []Config {
 		}
 		cfg.Insecure = strings.ToLower(os.Getenv("REGISTRY_SETTINGS_"+suffix+"_INSECURE")) == envTrue
 		cfg.PublicHost = os.Getenv("REGISTRY_SETTINGS_" + suffix + "_PUBLIC_HOST")
+		cfg.MaxPages = parseIntEnv("REGISTRY_SETTINGS_" + suffix + "_MAX_PAGES")
 		if isGHCR(v) {
 			cfg.IsGitHub = true
 			cfg.IsGitHubOrg = strings.ToLower(os.Getenv("REGISTRY_SETTINGS_"+suffix+"_ORG")) == envTrue
@@ -263,6 +280,19 @@ func parseAuthEnv(key string) *authPair {
 	return &authPair{user: parts[0], pass: parts[1]}
 }
 
+func parseIntEnv(key string) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		clog.Warn("Ignoring invalid integer setting", "key", key, "value", raw)
+		return 0
+	}
+	return n
+}
+
 func parseEnvLine(entry string) (key, value string, ok bool) {
 	parts := strings.SplitN(entry, "=", 2)
 	if len(parts) != 2 {
@@ -298,47 +328,15 @@ type ConfigBlob struct {
 }
 
 // Catalog provides paginated repository listing for a registry.
+// A listing cut short by the page limit returns ErrPaginationTruncated
+// alongside the repositories read so far.
 func (c *Client) Catalog(ctx context.Context) ([]string, error) {
-	var repos []string
-	last := ""
-	for {
-		resp, err := c.GetCatalog(ctx, &registryclient.PaginationParams{N: 100, Last: last})
-		if err != nil {
-			return nil, err
-		}
-		repos = append(repos, resp.Repositories...)
-		if len(resp.Repositories) < 100 {
-			break
-		}
-		last = resp.Repositories[len(resp.Repositories)-1]
-		select {
-		case <-ctx.Done():
-			return repos, ctx.Err()
-		default:
-		}
-	}
-	return repos, nil
+	return c.CatalogPager().collect(ctx)
 }
 
 // Tags provides paginated tag listing for a repository.
+// A listing cut short by the page limit returns ErrPaginationTruncated
+// alongside the tags read so far.
 func (c *Client) Tags(ctx context.Context, repo string) ([]string, error) {
-	var tags []string
-	last := ""
-	for {
-		resp, err := c.ListTags(ctx, repo, &registryclient.PaginationParams{N: 100, Last: last})
-		if err != nil {
-			return nil, err
-		}
-		tags = append(tags, resp.Tags...)
-		if len(resp.Tags) < 100 {
-			break
-		}
-		last = resp.Tags[len(resp.Tags)-1]
-		select {
-		case <-ctx.Done():
-			return tags, ctx.Err()
-		default:
-		}
-	}
-	return tags, nil
+	return c.TagsPager(repo).collect(ctx)
 }
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const defaultAuditLimit = 50
+
+const auditEventColumns = `id, occurred_at, action, outcome, actor_subject, actor_email, actor_name,
+	token_id, token_name, registry, repository, tags, digests, detail, request_id, client_ip`
+
+// RecordAuditEvent appends an event and sets its ID. OccurredAt is set to now
+// when zero.
+func (s *Store) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	tags, err := json.Marshal(nonNilStrings(event.Tags))
+	if err != nil {
+		return fmt.Errorf("encode audit tags: %w", err)
+	}
+	digests, err := json.Marshal(nonNilStrings(event.Digests))
+	if err != nil {
+		return fmt.Errorf("encode audit digests: %w", err)
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	event.OccurredAt = event.OccurredAt.UTC()
+
+	err = s.writeRow(ctx,
+		`INSERT INTO audit_events (occurred_at, action, outcome, actor_subject, actor_email, actor_name,
+		 token_id, token_name, registry, repository, tags, digests, detail, request_id, client_ip)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		event.OccurredAt, event.Action, event.Outcome, event.ActorSubject, event.ActorEmail, event.ActorName,
+		event.TokenID, event.TokenName, event.Registry, event.Repository, string(tags), string(digests),
+		event.Detail, event.RequestID, event.ClientIP).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("insert audit event %s: %w", event.Action, err)
+	}
+	return nil
+}
+
+// ListAuditEvents returns the events matching the filter, newest first, and
+// whether older ones match too.
+func (s *Store) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, bool, error) {
+	var where []string
+	var args []any
+	add := func(clause string, values ...any) {
+		where = append(where, clause)
+		args = append(args, values...)
+	}
+	if filter.Action != "" {
+		add("action = ?", filter.Action)
+	}
+	if filter.Outcome != "" {
+		add("outcome = ?", filter.Outcome)
+	}
+	if filter.Actor != "" {
+		pattern := likeContains(filter.Actor)
+		add(`(LOWER(actor_email) LIKE ? ESCAPE '\' OR LOWER(actor_name) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if filter.Target != "" {
+		pattern := likeContains(filter.Target)
+		add(`(LOWER(registry) LIKE ? ESCAPE '\' OR LOWER(repository) LIKE ? ESCAPE '\')`, pattern, pattern)
+	}
+	if filter.Since != nil {
+		add(s.dialect.compareTime("occurred_at", ">="), filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		add(s.dialect.compareTime("occurred_at", "<"), filter.Until.UTC())
+	}
+	if filter.BeforeID > 0 {
+		add("id < ?", filter.BeforeID)
+	}
+
+	query := "SELECT " + auditEventColumns + " FROM audit_events"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	// The event after the limit only tells whether there are more.
+	rows, err := s.query(ctx, query+" ORDER BY id DESC LIMIT ?", append(args, limit+1)...)
+	if err != nil {
+		return nil, false, fmt.Errorf("query audit events: %w", err)
+	}
+	defer closeRows(rows)
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		events = append(events, *event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(events) > limit {
+		return events[:limit], true, nil
+	}
+	return events, false, nil
+}
+
+// likeContains builds a case-insensitive LIKE pattern matching the value
+// anywhere, escaping its wildcards with a backslash.
+func likeContains(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(value))
+	return "%" + value + "%"
+}
+
+// AuditArchive receives the events a prune deletes. Close runs before the
+// prune commits, so events whose archive could not be completed are kept.
+type AuditArchive interface {
+	Write(event AuditEvent) error
+	Close() error
+}
+
+// PruneAuditEvents deletes the events that occurred before the cutoff after
+// writing each, oldest first, to archive when it is not nil, and records the
+// prune as event. The caller sets who pruned; the action, outcome and detail
+// are filled in, and the id once the prune commits. It is the only way to
+// delete events: the delete trigger only lets rows older than a cutoff in
+// audit_prunes go, and the cutoff is only there within this transaction.
+func (s *Store) PruneAuditEvents(ctx context.Context, cutoff time.Time, archive AuditArchive, event *AuditEvent) (int, error) {
+	cutoff = cutoff.UTC()
+	pruned := 0
+	err := s.WithinTx(ctx, func(tx *Store) error {
+		if _, err := tx.exec(ctx, "INSERT INTO audit_prunes (cutoff) VALUES (?)", cutoff); err != nil {
+			return fmt.Errorf("sanction audit prune: %w", err)
+		}
+		older := tx.dialect.compareTime("occurred_at", "<")
+		if archive != nil {
+			if err := tx.archiveAuditEvents(ctx, older, cutoff, archive); err != nil {
+				return err
+			}
+		}
+		n, err := tx.execCount(ctx, "DELETE FROM audit_events WHERE "+older, cutoff)
+		if err != nil {
+			return fmt.Errorf("prune audit events: %w", err)
+		}
+		pruned = int(n)
+		if _, err := tx.exec(ctx, "DELETE FROM audit_prunes"); err != nil {
+			return fmt.Errorf("clear audit prune: %w", err)
+		}
+		event.Action, event.Outcome = AuditActionAuditPrune, AuditOutcomeSuccess
+		event.Detail = fmt.Sprintf("Deleted %d events from before %s", pruned, cutoff.Format(time.RFC3339))
+		return tx.RecordAuditEvent(ctx, event)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
+
+func (s *Store) archiveAuditEvents(ctx context.Context, older string, cutoff time.Time, archive AuditArchive) error {
+	rows, err := s.query(ctx, "SELECT "+auditEventColumns+" FROM audit_events WHERE "+older+" ORDER BY id", cutoff)
+	if err != nil {
+		return fmt.Errorf("query audit events: %w", err)
+	}
+	defer closeRows(rows)
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := archive.Write(*event); err != nil {
+			return fmt.Errorf("archive audit event %d: %w", event.ID, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query audit events: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("close audit archive: %w", err)
+	}
+	return nil
+}
+
+func scanAuditEvent(row interface{ Scan(dest ...any) error }) (*AuditEvent, error) {
+	var event AuditEvent
+	var tags, digests string
+	err := row.Scan(&event.ID, &event.OccurredAt, &event.Action, &event.Outcome,
+		&event.ActorSubject, &event.ActorEmail, &event.ActorName, &event.TokenID, &event.TokenName,
+		&event.Registry, &event.Repository, &tags, &digests, &event.Detail, &event.RequestID, &event.ClientIP)
+	if err != nil {
+		return nil, fmt.Errorf("scan audit event: %w", err)
+	}
+	if err := json.Unmarshal([]byte(tags), &event.Tags); err != nil {
+		return nil, fmt.Errorf("decode audit tags: %w", err)
+	}
+	if err := json.Unmarshal([]byte(digests), &event.Digests); err != nil {
+		return nil, fmt.Errorf("decode audit digests: %w", err)
+	}
+	return &event, nil
+}
@@ -0,0 +1,487 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupRetryInterval is how long Backup waits before retrying a step that
+// found the database locked.
+const backupRetryInterval = 100 * time.Millisecond
+
+// Backup copies the SQLite database to path with the online backup API, so it
+// is safe while syncs write. The copy is written beside path and renamed into
+// place once complete. PostgreSQL databases are backed up with pg_dump.
+func (s *Store) Backup(ctx context.Context, path string) error {
+	if s.dialect.postgres() {
+		return errors.New("backup is only supported on SQLite; use pg_dump for PostgreSQL")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove stale backup %s: %w", tmp, err)
+	}
+	if err := s.backupTo(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("move backup into place: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) backupTo(ctx context.Context, path string) error {
+	src, err := s.reader().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("reserve backup source: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	destDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("open backup %s: %w", path, err)
+	}
+	defer func() { _ = destDB.Close() }()
+	dest, err := destDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("open backup %s: %w", path, err)
+	}
+	defer func() { _ = dest.Close() }()
+
+	return dest.Raw(func(destConn any) error {
+		return src.Raw(func(srcConn any) error {
+			backup, err := destConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return fmt.Errorf("start backup: %w", err)
+			}
+			// Copying every page in one step holds a single read snapshot;
+			// stepping in chunks would restart whenever a sync wrote.
+			for {
+				done, err := backup.Step(-1)
+				if err != nil {
+					_ = backup.Finish()
+					return fmt.Errorf("copy database: %w", err)
+				}
+				if done {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					_ = backup.Finish()
+					return ctx.Err()
+				case <-time.After(backupRetryInterval):
+				}
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// exportFormat names the layout of db export files. It changes when the
+// layout does, independently of the schema version the rows follow.
+const exportFormat = "container-hub-export/1"
+
+// exportTables lists the tables an export holds, each after the tables it
+// references. Leases, sync triggers and progress only matter to a running
+// sync and are left out.
+var exportTables = []string{
+	"registries",
+	"repositories",
+	"config_blobs",
+	"layers",
+	"manifests",
+	"manifest_platforms",
+	"manifest_layers",
+	"tags",
+	"sync_runs",
+	"tag_events",
+	"search_documents",
+	"storage_snapshots",
+	"repository_platforms",
+	"repository_stats",
+	"api_tokens",
+	"audit_events",
+}
+
+// exportSkipColumns are computed from other columns and cannot be inserted.
+var exportSkipColumns = map[string]string{
+	"search_documents": "document",
+}
+
+type exportHeader struct {
+	Format        string    `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	Source        string    `json:"source"`
+	ExportedAt    time.Time `json:"exported_at"`
+}
+
+type exportRow struct {
+	Table string         `json:"table"`
+	Row   map[string]any `json:"row"`
+}
+
+// Export writes the database to w as newline-delimited JSON: a header naming
+// the schema version, then one line per row. Every table is read from the
+// same snapshot. It returns the number of rows written.
+func (s *Store) Export(ctx context.Context, w io.Writer) (int, error) {
+	opts := &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead}
+	if !s.dialect.postgres() {
+		// A SQLite read transaction already sees a single snapshot.
+		opts = nil
+	}
+	tx, err := s.reader().BeginTx(ctx, opts)
+	if err != nil {
+		return 0, fmt.Errorf("begin export: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	header := exportHeader{Format: exportFormat, Source: s.dialect.name, ExportedAt: time.Now().UTC()}
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&header.SchemaVersion); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return 0, fmt.Errorf("write export header: %w", err)
+	}
+
+	total := 0
+	for _, table := range exportTables {
+		n, err := exportTable(ctx, tx, enc, table)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func exportTable(ctx context.Context, tx *sql.Tx, enc *json.Encoder, table string) (int, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT * FROM "+table)
+	if err != nil {
+		return 0, fmt.Errorf("export %s: %w", table, err)
+	}
+	defer closeRows(rows)
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, fmt.Errorf("export %s columns: %w", table, err)
+	}
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	n := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, fmt.Errorf("scan %s: %w", table, err)
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if exportSkipColumns[table] == column {
+				continue
+			}
+			row[column] = exportValue(values[i])
+		}
+		if err := enc.Encode(exportRow{Table: table, Row: row}); err != nil {
+			return n, fmt.Errorf("write %s row: %w", table, err)
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func exportValue(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return v
+	}
+}
+
+// Import loads an Export into the database in one transaction. The export
+// must come from the same schema version. A row whose primary key is already
+// present must match the stored row, so importing the same file again changes
+// nothing. Any other overlap aborts the import: ids are not remapped, and
+// merging an export into a database that holds different rows under the same
+// ids, or the same names under other ids, would point rows at the wrong
+// parents. Import into an empty database to restore an export.
+func (s *Store) Import(ctx context.Context, r io.Reader) (ImportResult, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var header exportHeader
+	if err := dec.Decode(&header); err != nil {
+		return ImportResult{}, fmt.Errorf("read export header: %w", err)
+	}
+	if header.Format != exportFormat {
+		return ImportResult{}, fmt.Errorf("unsupported export format %q", header.Format)
+	}
+
+	var result ImportResult
+	err := s.WithinTx(ctx, func(tx *Store) error {
+		var version int
+		if err := tx.queryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+			return fmt.Errorf("read schema version: %w", err)
+		}
+		if header.SchemaVersion != version {
+			return fmt.Errorf("export has schema version %d but the database has %d; import it with the release that exported it", header.SchemaVersion, version)
+		}
+
+		importer := &rowImporter{tx: tx, columns: map[string]map[string]string{}, keys: map[string][]string{}}
+		for {
+			var row exportRow
+			if err := dec.Decode(&row); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return fmt.Errorf("read export row %d: %w", result.Rows+1, err)
+			}
+			inserted, err := importer.insert(ctx, row)
+			if err != nil {
+				return fmt.Errorf("import row %d: %w", result.Rows+1, err)
+			}
+			result.Rows++
+			if inserted {
+				result.Inserted++
+			}
+		}
+		return tx.resetIdentities(ctx)
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// rowImporter inserts export rows, remembering each table's column types and
+// primary key.
+type rowImporter struct {
+	tx *Store
+	// columns maps a table to its columns and their database types.
+	columns map[string]map[string]string
+	// keys maps a table to the columns of its primary key.
+	keys map[string][]string
+}
+
+func (im *rowImporter) insert(ctx context.Context, row exportRow) (bool, error) {
+	if !slices.Contains(exportTables, row.Table) {
+		return false, fmt.Errorf("unknown table %q", row.Table)
+	}
+	types, err := im.columnTypes(ctx, row.Table)
+	if err != nil {
+		return false, err
+	}
+
+	columns := make([]string, 0, len(row.Row))
+	for column := range row.Row {
+		if _, ok := types[column]; !ok {
+			return false, fmt.Errorf("unknown column %s.%s", row.Table, column)
+		}
+		columns = append(columns, column)
+	}
+	slices.Sort(columns)
+
+	args := make([]any, len(columns))
+	for i, column := range columns {
+		v, err := importValue(row.Row[column], types[column])
+		if err != nil {
+			return false, fmt.Errorf("%s.%s: %w", row.Table, column, err)
+		}
+		args[i] = v
+	}
+
+	present, err := im.present(ctx, row, columns, args)
+	if err != nil || present {
+		return false, err
+	}
+
+	query := "INSERT INTO " + row.Table + " (" + strings.Join(columns, ", ") + ") VALUES (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	if _, err := im.tx.exec(ctx, query, args...); err != nil {
+		return false, fmt.Errorf("insert into %s: %w; import into an empty database", row.Table, err)
+	}
+	return true, nil
+}
+
+// present reports whether the row is already stored under its primary key,
+// and fails when the stored row differs from the exported one.
+func (im *rowImporter) present(ctx context.Context, row exportRow, columns []string, args []any) (bool, error) {
+	key, err := im.primaryKey(ctx, row.Table)
+	if err != nil {
+		return false, err
+	}
+	conditions := make([]string, len(key))
+	keyArgs := make([]any, len(key))
+	for i, column := range key {
+		j := slices.Index(columns, column)
+		if j < 0 {
+			return false, fmt.Errorf("%s row has no %s", row.Table, column)
+		}
+		conditions[i] = column + " = ?"
+		keyArgs[i] = args[j]
+	}
+
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err = im.tx.queryRow(ctx, "SELECT "+strings.Join(columns, ", ")+" FROM "+row.Table+
+		" WHERE "+strings.Join(conditions, " AND "), keyArgs...).Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("look up %s row: %w", row.Table, err)
+	}
+
+	// Compare through the export encoding, so values read back the way
+	// Export wrote them.
+	for i, column := range columns {
+		stored, err := json.Marshal(exportValue(values[i]))
+		if err != nil {
+			return false, fmt.Errorf("encode %s.%s: %w", row.Table, column, err)
+		}
+		exported, err := json.Marshal(row.Row[column])
+		if err != nil {
+			return false, fmt.Errorf("encode %s.%s: %w", row.Table, column, err)
+		}
+		if !bytes.Equal(stored, exported) {
+			return false, fmt.Errorf("the database already holds a different %s row with %s %v; import into an empty database",
+				row.Table, strings.Join(key, ", "), keyArgs)
+		}
+	}
+	return true, nil
+}
+
+func (im *rowImporter) primaryKey(ctx context.Context, table string) ([]string, error) {
+	if key, ok := im.keys[table]; ok {
+		return key, nil
+	}
+	query := "SELECT name FROM pragma_table_info(?) WHERE pk > 0 ORDER BY pk"
+	if im.tx.dialect.postgres() {
+		query = `SELECT a.attname FROM pg_index i
+			 JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+			 WHERE i.indrelid = ?::regclass AND i.indisprimary
+			 ORDER BY array_position(i.indkey, a.attnum)`
+	}
+	rows, err := im.tx.query(ctx, query, table)
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s primary key: %w", table, err)
+	}
+	defer closeRows(rows)
+
+	var key []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, fmt.Errorf("scan %s primary key: %w", table, err)
+		}
+		key = append(key, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("table %s has no primary key", table)
+	}
+	im.keys[table] = key
+	return key, nil
+}
+
+func (im *rowImporter) columnTypes(ctx context.Context, table string) (map[string]string, error) {
+	if types, ok := im.columns[table]; ok {
+		return types, nil
+	}
+	rows, err := im.tx.query(ctx, "SELECT * FROM "+table+" WHERE 1 = 0")
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s: %w", table, err)
+	}
+	defer closeRows(rows)
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+
+	types := make(map[string]string, len(columnTypes))
+	for _, ct := range columnTypes {
+		if exportSkipColumns[table] == ct.Name() {
+			continue
+		}
+		types[ct.Name()] = strings.ToUpper(ct.DatabaseTypeName())
+	}
+	im.columns[table] = types
+	return types, nil
+}
+
+// importValue converts a decoded JSON value to what the column stores:
+// timestamps are parsed, and numbers become integers unless they have a
+// fraction, or booleans for boolean columns.
+func importValue(v any, columnType string) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			if strings.HasPrefix(columnType, "BOOL") {
+				return n != 0, nil
+			}
+			return n, nil
+		}
+		return v.Float64()
+	case string:
+		if strings.Contains(columnType, "TIME") || strings.Contains(columnType, "DATE") {
+			if t, err := parseTime(v); err == nil {
+				return t, nil
+			}
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// resetIdentities moves Postgres identity sequences past the imported ids so
+// new rows do not collide with them. SQLite picks the next rowid from the
+// table itself.
+func (s *Store) resetIdentities(ctx context.Context) error {
+	if !s.dialect.postgres() {
+		return nil
+	}
+	for _, table := range exportTables {
+		var identity bool
+		err := s.queryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+			 WHERE table_schema = current_schema() AND table_name = ? AND column_name = 'id' AND is_identity = 'YES')`,
+			table).Scan(&identity)
+		if err != nil {
+			return fmt.Errorf("inspect %s identity: %w", table, err)
+		}
+		if !identity {
+			continue
+		}
+		if _, err := s.exec(ctx, fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s", table)); err != nil {
+			return fmt.Errorf("reset %s identity: %w", table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// GetTagDetail returns a tag with its manifest and the images it covers: the
+// platforms of an index, or the tag's own image. Images whose manifest was
+// not stored come without config and layers.
+func (s *Store) GetTagDetail(ctx context.Context, repositoryID uint, name string) (*TagDetailView, error) {
+	tag, err := s.GetTagByRepoAndName(ctx, repositoryID, name)
+	if err != nil {
+		return nil, err
+	}
+	detail := &TagDetailView{
+		Name:       tag.Name,
+		Digest:     tag.Digest,
+		Kind:       tag.Kind,
+		MediaType:  tag.MediaType,
+		LastSyncAt: tag.LastSyncAt,
+		SyncStatus: tag.SyncStatus,
+		Images:     []ImageDetailView{},
+	}
+
+	var mediaType string
+	err = s.queryRow(ctx, "SELECT media_type, size_bytes, created FROM manifests WHERE digest = ?", tag.Digest).
+		Scan(&mediaType, &detail.SizeBytes, &detail.Created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return detail, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get tag manifest %s: %w", tag.Digest, err)
+	}
+	if mediaType != "" {
+		detail.MediaType = mediaType
+	}
+
+	images, configDigests, err := s.tagImages(ctx, tag.Digest)
+	if err != nil {
+		return nil, err
+	}
+	for i := range images {
+		if configDigests[i] != "" {
+			if images[i].Config, err = s.imageConfig(ctx, configDigests[i]); err != nil {
+				return nil, err
+			}
+		}
+		if images[i].Layers, err = s.imageLayers(ctx, images[i].Digest); err != nil {
+			return nil, err
+		}
+	}
+	detail.Images = images
+	return detail, nil
+}
+
+// tagImages lists the images of the manifest digest with their config
+// digests, in platform order for an index.
+func (s *Store) tagImages(ctx context.Context, digest string) ([]ImageDetailView, []string, error) {
+	rows, err := s.query(ctx,
+		`SELECT mp.position, mp.platform_digest, COALESCE(m.media_type, ''), mp.os, mp.architecture, mp.variant,
+			CASE WHEN m.size_bytes > 0 THEN m.size_bytes ELSE mp.size_bytes END, m.created, COALESCE(m.config_digest, '')
+		 FROM manifest_platforms mp
+		 LEFT JOIN manifests m ON m.digest = mp.platform_digest
+		 WHERE mp.index_digest = ?
+		 UNION ALL
+		 SELECT 0, digest, media_type, COALESCE(os, ''), COALESCE(architecture, ''), COALESCE(variant, ''),
+			size_bytes, created, COALESCE(config_digest, '')
+		 FROM manifests
+		 WHERE digest = ? AND kind != 'index'
+		 ORDER BY 1`,
+		digest, digest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query tag images %s: %w", digest, err)
+	}
+	defer closeRows(rows)
+
+	var images []ImageDetailView
+	var configDigests []string
+	for rows.Next() {
+		var img ImageDetailView
+		var created sql.NullString
+		var configDigest string
+		var position int
+		if err := rows.Scan(&position, &img.Digest, &img.MediaType, &img.OS, &img.Architecture, &img.Variant,
+			&img.SizeBytes, &created, &configDigest); err != nil {
+			return nil, nil, fmt.Errorf("scan tag image: %w", err)
+		}
+		img.Created = parseNullTime(created)
+		img.Layers = []Layer{}
+		images = append(images, img)
+		configDigests = append(configDigests, configDigest)
+	}
+	return images, configDigests, rows.Err()
+}
+
+func (s *Store) imageConfig(ctx context.Context, digest string) (*ImageConfigView, error) {
+	var cfg ImageConfigView
+	var created sql.NullString
+	var raw string
+	err := s.queryRow(ctx, "SELECT digest, size_bytes, created, config_json FROM config_blobs WHERE digest = ?", digest).
+		Scan(&cfg.Digest, &cfg.SizeBytes, &created, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get image config %s: %w", digest, err)
+	}
+	cfg.Created = parseNullTime(created)
+	if json.Valid([]byte(raw)) {
+		cfg.Config = json.RawMessage(raw)
+	}
+	return &cfg, nil
+}
+
+func (s *Store) imageLayers(ctx context.Context, manifestDigest string) ([]Layer, error) {
+	rows, err := s.query(ctx,
+		`SELECT l.digest, l.size_bytes, l.media_type
+		 FROM manifest_layers ml
+		 JOIN layers l ON l.digest = ml.layer_digest
+		 WHERE ml.manifest_digest = ?
+		 ORDER BY ml.position`, manifestDigest)
+	if err != nil {
+		return nil, fmt.Errorf("query image layers %s: %w", manifestDigest, err)
+	}
+	defer closeRows(rows)
+
+	layers := []Layer{}
+	for rows.Next() {
+		var l Layer
+		if err := rows.Scan(&l.Digest, &l.SizeBytes, &l.MediaType); err != nil {
+			return nil, fmt.Errorf("scan image layer: %w", err)
+		}
+		layers = append(layers, l)
+	}
+	return layers, rows.Err()
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Supported values for DATABASE_CONNECTION.
+const (
+	ConnectionSQLite   = "sqlite"
+	ConnectionPostgres = "postgres"
+)
+
+// dialect covers the SQL that differs between backends. Queries are written
+// with SQLite's ? placeholders and rebound before they reach the driver.
+type dialect struct {
+	name string
+	// fts5 is set when the linked SQLite can maintain the search index.
+	fts5 bool
+}
+
+var (
+	sqliteDialect   = dialect{name: ConnectionSQLite}
+	postgresDialect = dialect{name: ConnectionPostgres}
+)
+
+func (d dialect) postgres() bool {
+	return d.name == ConnectionPostgres
+}
+
+// migrationDir is the embedded directory holding this backend's migrations.
+func (d dialect) migrationDir() string {
+	return "migrations/" + d.name
+}
+
+// rebind rewrites ? placeholders to $1, $2, ... for Postgres. Question marks
+// inside quoted literals and identifiers are left alone.
+func (d dialect) rebind(query string) string {
+	if !d.postgres() || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// like is the case-insensitive pattern operator. SQLite's LIKE already ignores
+// ASCII case; Postgres needs ILIKE for the same behaviour.
+func (d dialect) like() string {
+	if d.postgres() {
+		return "ILIKE"
+	}
+	return "LIKE"
+}
+
+// compareTime compares a timestamp column against a time.Time placeholder.
+// SQLite stores timestamps as text with a zone offset, so both sides are
+// normalised through julianday first.
+func (d dialect) compareTime(column, op string) string {
+	if d.postgres() {
+		return column + " " + op + " ?"
+	}
+	return "julianday(" + column + ") " + op + " julianday(?)"
+}
+
+// jsonText extracts a top-level string field from a JSON text column.
+func (d dialect) jsonText(column, key string) string {
+	if d.postgres() {
+		return "(NULLIF(" + column + ", '')::jsonb ->> '" + key + "')"
+	}
+	return "json_extract(" + column + ", '$." + key + "')"
+}
+
+// configLabel extracts an image config label from a config_json column. The
+// label key is bound as the expression's only placeholder.
+func (d dialect) configLabel(column string) string {
+	if d.postgres() {
+		return "(NULLIF(" + column + ", '')::jsonb -> 'config' -> 'Labels' ->> ?::text)"
+	}
+	return "json_extract(NULLIF(" + column + ", ''), '$.config.Labels.\"' || ? || '\"')"
+}
+
+// truncateTime buckets a timestamp column by "hour" or "day". The result is
+// only meant for grouping and differs in type between backends.
+func (d dialect) truncateTime(column, unit string) string {
+	if d.postgres() {
+		return "date_trunc('" + unit + "', " + column + ")"
+	}
+	if unit == "hour" {
+		return "strftime('%Y-%m-%d %H', " + column + ")"
+	}
+	return "strftime('%Y-%m-%d', " + column + ")"
+}
@@ -0,0 +1,39 @@
+package store
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect dialect
+		query   string
+		want    string
+	}{
+		{
+			name:    "sqlite unchanged",
+			dialect: sqliteDialect,
+			query:   "SELECT * FROM tags WHERE repo_id = ? AND name = ?",
+			want:    "SELECT * FROM tags WHERE repo_id = ? AND name = ?",
+		},
+		{
+			name:    "postgres numbered",
+			dialect: postgresDialect,
+			query:   "SELECT * FROM tags WHERE repo_id = ? AND name IN (?,?)",
+			want:    "SELECT * FROM tags WHERE repo_id = $1 AND name IN ($2,$3)",
+		},
+		{
+			name:    "postgres skips literals",
+			dialect: postgresDialect,
+			query:   `SELECT '?', "a?" FROM tags WHERE name = ?`,
+			want:    `SELECT '?', "a?" FROM tags WHERE name = $1`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.dialect.rebind(test.query); got != test.want {
+				t.Fatalf("rebind(%q) = %q, want %q", test.query, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// gcBatchSize bounds the number of digests in one DELETE ... IN (...).
+const gcBatchSize = 500
+
+// CollectGarbage deletes the manifests no tag references, with their
+// platforms, layer links, and the layers and config blobs left unused. A
+// manifest seen, or named by a tag event, since cutoff is kept so the digest
+// history of recent changes stays complete. The database is vacuumed
+// afterwards to return the freed space.
+func (s *Store) CollectGarbage(ctx context.Context, cutoff time.Time) (*GCResult, error) {
+	before, err := s.databaseSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result GCResult
+	err = s.WithinTx(ctx, func(tx *Store) error {
+		digests, err := tx.unreferencedManifests(ctx, cutoff)
+		if err != nil {
+			return err
+		}
+		for start := 0; start < len(digests); start += gcBatchSize {
+			if err := tx.deleteManifests(ctx, digests[start:min(start+gcBatchSize, len(digests))], &result); err != nil {
+				return err
+			}
+		}
+		result.Layers, result.ConfigBlobs, err = tx.cleanupOrphans(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.vacuum(ctx); err != nil {
+		return nil, err
+	}
+	after, err := s.databaseSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.ReclaimedBytes = max(before-after, 0)
+	return &result, nil
+}
+
+// unreferencedManifests lists the manifests that are neither tagged, named by
+// a tag event since cutoff, seen since cutoff, nor a platform of any of those.
+func (s *Store) unreferencedManifests(ctx context.Context, cutoff time.Time) ([]string, error) {
+	rows, err := s.query(ctx, `
+		WITH roots AS (
+			SELECT digest FROM tags
+			UNION
+			SELECT old_digest FROM tag_events WHERE old_digest != '' AND `+s.dialect.compareTime("occurred_at", ">=")+`
+			UNION
+			SELECT new_digest FROM tag_events WHERE new_digest != '' AND `+s.dialect.compareTime("occurred_at", ">=")+`
+			UNION
+			SELECT digest FROM manifests WHERE seen_at IS NOT NULL AND `+s.dialect.compareTime("seen_at", ">=")+`
+		),
+		live AS (
+			SELECT digest FROM roots
+			UNION
+			SELECT mp.platform_digest FROM manifest_platforms mp
+			JOIN roots r ON r.digest = mp.index_digest
+		)
+		SELECT m.digest FROM manifests m
+		WHERE NOT EXISTS (SELECT 1 FROM live WHERE live.digest = m.digest)
+		ORDER BY m.digest`,
+		cutoff, cutoff, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("find unreferenced manifests: %w", err)
+	}
+	defer closeRows(rows)
+
+	var digests []string
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, fmt.Errorf("scan unreferenced manifest: %w", err)
+		}
+		digests = append(digests, digest)
+	}
+	return digests, rows.Err()
+}
+
+// deleteManifests removes the rows depending on the manifests before the
+// manifests themselves, so each table's count is reported rather than
+// disappearing into a cascade.
+func (s *Store) deleteManifests(ctx context.Context, digests []string, result *GCResult) error {
+	in := "(" + strings.Repeat("?,", len(digests)-1) + "?)"
+	args := make([]any, len(digests))
+	for i, d := range digests {
+		args[i] = d
+	}
+
+	n, err := s.execCount(ctx,
+		"DELETE FROM manifest_platforms WHERE index_digest IN "+in+" OR platform_digest IN "+in,
+		append(args, args...)...)
+	if err != nil {
+		return fmt.Errorf("delete unreferenced platforms: %w", err)
+	}
+	result.Platforms += n
+
+	n, err = s.execCount(ctx, "DELETE FROM manifest_layers WHERE manifest_digest IN "+in, args...)
+	if err != nil {
+		return fmt.Errorf("delete unreferenced manifest layers: %w", err)
+	}
+	result.ManifestLayers += n
+
+	n, err = s.execCount(ctx, "DELETE FROM manifests WHERE digest IN "+in, args...)
+	if err != nil {
+		return fmt.Errorf("delete unreferenced manifests: %w", err)
+	}
+	result.Manifests += n
+	return nil
+}
+
+// vacuum returns free pages to the operating system. A SQLite database
+// created before incremental auto-vacuum was enabled is rebuilt once to
+// switch it over.
+func (s *Store) vacuum(ctx context.Context) error {
+	if s.dialect.postgres() {
+		if _, err := s.exec(ctx,
+			"VACUUM ANALYZE manifests, manifest_platforms, manifest_layers, layers, config_blobs"); err != nil {
+			return fmt.Errorf("vacuum: %w", err)
+		}
+		return nil
+	}
+
+	var mode int
+	if err := s.queryRow(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return fmt.Errorf("read auto_vacuum: %w", err)
+	}
+	if mode == sqliteIncrementalVacuum {
+		// The pragma frees one page per row it returns, so it has to be
+		// stepped to the end.
+		rows, err := s.writeRows(ctx, "PRAGMA incremental_vacuum")
+		if err != nil {
+			return fmt.Errorf("incremental vacuum: %w", err)
+		}
+		defer closeRows(rows)
+		for rows.Next() {
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("incremental vacuum: %w", err)
+		}
+		return nil
+	}
+	if _, err := s.exec(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return fmt.Errorf("enable incremental auto_vacuum: %w", err)
+	}
+	if _, err := s.exec(ctx, "VACUUM"); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
+
+// sqliteIncrementalVacuum is the PRAGMA auto_vacuum value of INCREMENTAL.
+const sqliteIncrementalVacuum = 2
+
+func (s *Store) databaseSize(ctx context.Context) (int64, error) {
+	query := "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+	if s.dialect.postgres() {
+		query = "SELECT pg_database_size(current_database())"
+	}
+	var size int64
+	if err := s.queryRow(ctx, query).Scan(&size); err != nil {
+		return 0, fmt.Errorf("get database size: %w", err)
+	}
+	return size, nil
+}
@@ -0,0 +1,37 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestVacuumFreesEveryPage(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, filepath.Join(t.TempDir(), "ui.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	for _, stmt := range []string{
+		"CREATE TABLE filler (data BLOB)",
+		"WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200) INSERT INTO filler SELECT randomblob(8192) FROM n",
+		"DELETE FROM filler",
+	} {
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	var free int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free); err != nil || free < 100 {
+		t.Fatalf("expected the deleted rows to leave many free pages, got %d, %v", free, err)
+	}
+
+	if err := s.vacuum(ctx); err != nil {
+		t.Fatalf("vacuum: %v", err)
+	}
+	if err := s.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free); err != nil || free != 0 {
+		t.Fatalf("expected every free page returned, got %d, %v", free, err)
+	}
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for a page cursor the listing did not issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// keyKind is how a sort key's value is carried in a cursor.
+type keyKind int
+
+const (
+	keyInt keyKind = iota
+	keyText
+	// keyTime values come from dialect.timeSortKey.
+	keyTime
+)
+
+// sortKey is one expression of a keyset order. The keys of an order end in
+// one that is unique within the listing, so every row has its own position.
+type sortKey struct {
+	expr string
+	desc bool
+	kind keyKind
+	// nullable keys follow a key that sorts their NULLs apart from the
+	// rest, so rows that are NULL on them tie and the keys after decide.
+	nullable bool
+}
+
+// orderBy is the ORDER BY list of keys.
+func orderBy(keys []sortKey) string {
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		terms[i] = key.expr + " ASC"
+		if key.desc {
+			terms[i] = key.expr + " DESC"
+		}
+	}
+	return strings.Join(terms, ", ")
+}
+
+// keysetAfter is a condition matching the rows that sort after the row whose
+// keys hold values.
+func keysetAfter(keys []sortKey, values []any) (string, []any) {
+	var alternatives []string
+	var args []any
+	for i, key := range keys {
+		if values[i] == nil {
+			continue
+		}
+		var terms []string
+		var termArgs []any
+		for j := range i {
+			if values[j] == nil {
+				terms = append(terms, keys[j].expr+" IS NULL")
+				continue
+			}
+			terms = append(terms, keys[j].expr+" = ?")
+			termArgs = append(termArgs, values[j])
+		}
+		op := " > ?"
+		if key.desc {
+			op = " < ?"
+		}
+		terms = append(terms, key.expr+op)
+		termArgs = append(termArgs, values[i])
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+		args = append(args, termArgs...)
+	}
+	if len(alternatives) == 0 {
+		return "1 = 0", nil
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// encodeCursor packs the sort keys of the last row of a page.
+func encodeCursor(values []any) (string, error) {
+	for i, v := range values {
+		if b, ok := v.([]byte); ok {
+			values[i] = string(b)
+		}
+	}
+	raw, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodeCursor unpacks a cursor made by encodeCursor for keys, converting each
+// value to what its key compares against.
+func (d dialect) decodeCursor(cursor string, keys []sortKey) ([]any, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var values []any
+	if err := dec.Decode(&values); err != nil || len(values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+	for i, key := range keys {
+		v, err := d.cursorValue(values[i], key)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (d dialect) cursorValue(v any, key sortKey) (any, error) {
+	if v == nil {
+		if !key.nullable {
+			return nil, ErrInvalidCursor
+		}
+		return nil, nil
+	}
+	switch key.kind {
+	case keyInt:
+		if n, ok := v.(json.Number); ok {
+			return n.Int64()
+		}
+	case keyText:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case keyTime:
+		if s, ok := v.(string); ok && d.postgres() {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+		if n, ok := v.(json.Number); ok && !d.postgres() {
+			return n.Float64()
+		}
+	}
+	return nil, ErrInvalidCursor
+}
+
+// timeSortKey orders a timestamp column. SQLite stores timestamps as text
+// whose zone offsets may differ, so it orders by julian day instead.
+func (d dialect) timeSortKey(column string) string {
+	if d.postgres() {
+		return column
+	}
+	return "julianday(" + column + ")"
+}
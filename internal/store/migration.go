@@ -2,63 +2,289 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/hex"
 	"fmt"
 	"path"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-//go:embed migrations/*.sql
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
 var migrationFiles embed.FS
 
 type migration struct {
 	version int
 	name    string
 	up      string
+	// down reverts up. It is empty for migrations that cannot be rolled back.
+	down string
 }
 
-func migrate(ctx context.Context, db *sql.DB) error {
-	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
-		return fmt.Errorf("create schema_version table: %w", err)
+// migrationDownMarker starts the optional section of a migration file that
+// reverts it.
+const migrationDownMarker = "-- migrate:down"
+
+// checksum identifies the up section as applied, so status can tell when a
+// migration file changed after it ran.
+func (m migration) checksum() string {
+	sum := sha256.Sum256([]byte(m.up))
+	return hex.EncodeToString(sum[:])
+}
+
+// foreignKeysPragma matches the statements of a migration script that turn
+// SQLite's foreign key checks on or off.
+var foreignKeysPragma = regexp.MustCompile(`(?im)^\s*PRAGMA\s+foreign_keys\s*=\s*\w+\s*;\s*$`)
+
+// togglesForeignKeys reports whether a migration script switches SQLite's
+// foreign key checks, as scripts that rebuild tables do. SQLite ignores the
+// pragma inside a transaction, so runMigration sets it on the connection
+// around the transaction instead.
+func togglesForeignKeys(d dialect, script string) bool {
+	return !d.postgres() && foreignKeysPragma.MatchString(script)
+}
+
+func (m migration) info() Migration {
+	return Migration{
+		Version:    m.version,
+		Name:       m.name,
+		Checksum:   m.checksum(),
+		SQL:        m.up,
+		Reversible: m.down != "",
 	}
+}
 
-	migrations, err := loadMigrations()
-	if err != nil {
+// migrationLockID keys the Postgres advisory lock that keeps replicas starting
+// at the same time from applying a migration twice.
+const migrationLockID = 4170301
+
+func migrate(ctx context.Context, db *sql.DB, d dialect) ([]Migration, error) {
+	var applied []Migration
+	err := withMigrationLock(ctx, db, d, func(conn migrationConn) error {
+		var err error
+		applied, err = applyMigrations(ctx, conn, d)
 		return err
+	})
+	return applied, err
+}
+
+// withMigrationLock runs fn on a connection holding the migration lock. Only
+// Postgres needs the lock: SQLite's single writer already serialises
+// migrations. Both run on one connection, so pragmas a migration sets apply to
+// the statements that follow them.
+func withMigrationLock(ctx context.Context, db *sql.DB, d dialect, fn func(conn migrationConn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("reserve migration connection: %w", err)
 	}
+	defer func() { _ = conn.Close() }()
 
-	sort.Slice(migrations, func(i, j int) bool {
-		return migrations[i].version < migrations[j].version
-	})
+	if !d.postgres() {
+		return fn(conn)
+	}
 
-	if err := validateMigrations(migrations); err != nil {
-		return err
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
 	}
+	defer func() {
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}()
+	return fn(conn)
+}
+
+// migrationConn is satisfied by both *sql.DB and *sql.Conn.
+type migrationConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
 
-	var currentVersion int
-	row := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
-	if err := row.Scan(&currentVersion); err != nil {
-		return fmt.Errorf("read schema version: %w", err)
+func applyMigrations(ctx context.Context, db migrationConn, d dialect) ([]Migration, error) {
+	migrations, currentVersion, err := prepareMigrations(ctx, db, d)
+	if err != nil {
+		return nil, err
 	}
 
+	var applied []Migration
 	for _, m := range migrations {
 		if m.version <= currentVersion {
 			continue
 		}
-		if _, err := db.ExecContext(ctx, m.up); err != nil {
-			return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		err := runMigration(ctx, db, d, m, m.up, func(exec execer) error {
+			_, err := exec.ExecContext(ctx, d.rebind("INSERT INTO schema_version (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+				m.version, m.name, m.checksum(), time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, m.info())
+	}
+
+	return applied, nil
+}
+
+// prepareMigrations brings schema_version up to date and returns the embedded
+// migrations with the version the database is at. It refuses a database
+// migrated by a newer binary, whose schema this one would misread.
+func prepareMigrations(ctx context.Context, db migrationConn, d dialect) ([]migration, int, error) {
+	migrations, err := loadMigrations(d.migrationDir())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := ensureSchemaVersion(ctx, db, d, migrations); err != nil {
+		return nil, 0, err
+	}
+
+	currentVersion, err := schemaVersion(ctx, db)
+	if err != nil {
+		return nil, 0, err
+	}
+	if latest := latestVersion(migrations); currentVersion > latest {
+		return nil, 0, fmt.Errorf("database schema version %d is newer than version %d this binary supports", currentVersion, latest)
+	}
+	return migrations, currentVersion, nil
+}
+
+func schemaVersion(ctx context.Context, db migrationConn) (int, error) {
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+func latestVersion(migrations []migration) int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// ensureSchemaVersion creates schema_version, adds the columns older releases
+// did not record, and fills in the name and checksum of migrations they
+// applied from the embedded files.
+func ensureSchemaVersion(ctx context.Context, db migrationConn, d dialect, migrations []migration) error {
+	timestamp := "DATETIME"
+	if d.postgres() {
+		timestamp = "TIMESTAMPTZ"
+	}
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER NOT NULL,
+		name TEXT NOT NULL DEFAULT '',
+		checksum TEXT NOT NULL DEFAULT '',
+		applied_at `+timestamp+`
+	)`); err != nil {
+		return fmt.Errorf("create schema_version table: %w", err)
+	}
+
+	for _, column := range []string{"name TEXT NOT NULL DEFAULT ''", "checksum TEXT NOT NULL DEFAULT ''", "applied_at " + timestamp} {
+		name, _, _ := strings.Cut(column, " ")
+		exists, err := columnExists(ctx, db, d, "schema_version", name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "ALTER TABLE schema_version ADD COLUMN "+column); err != nil {
+			return fmt.Errorf("add schema_version %s column: %w", name, err)
+		}
+	}
+
+	update := d.rebind("UPDATE schema_version SET name = ?, checksum = ? WHERE version = ? AND checksum = ''")
+	for _, m := range migrations {
+		if _, err := db.ExecContext(ctx, update, m.name, m.checksum(), m.version); err != nil {
+			return fmt.Errorf("record migration %d checksum: %w", m.version, err)
 		}
-		if _, err := db.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES (?)", m.version); err != nil {
-			return fmt.Errorf("record migration %d %s: %w", m.version, m.name, err)
+	}
+	return nil
+}
+
+func columnExists(ctx context.Context, db migrationConn, d dialect, table, column string) (bool, error) {
+	query := "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?"
+	if d.postgres() {
+		query = "SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?"
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, d.rebind(query), table, column).Scan(&n); err != nil {
+		return false, fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// execer runs the statements of one migration, in or out of a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// runMigration executes script, one of m's sections, and then record, which
+// updates schema_version. Both share a transaction, so a failing statement
+// leaves neither the schema nor its version half changed. SQLite scripts that
+// toggle foreign keys run with the checks off on the connection, and must
+// leave every reference intact before they commit.
+func runMigration(ctx context.Context, db migrationConn, d dialect, m migration, script string, record func(exec execer) error) error {
+	checkForeignKeys := togglesForeignKeys(d, script)
+	if checkForeignKeys {
+		script = foreignKeysPragma.ReplaceAllString(script, "")
+		if _, err := db.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+			return fmt.Errorf("disable foreign keys for migration %d %s: %w", m.version, m.name, err)
 		}
+		defer func() { _, _ = db.ExecContext(context.WithoutCancel(ctx), "PRAGMA foreign_keys=ON") }()
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin migration %d %s: %w", m.version, m.name, err)
 	}
+	defer func() { _ = tx.Rollback() }()
 
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+	}
+	if checkForeignKeys {
+		if err := foreignKeyViolations(ctx, tx); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+		}
+	}
+	if err := record(tx); err != nil {
+		return fmt.Errorf("record migration %d %s: %w", m.version, m.name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %d %s: %w", m.version, m.name, err)
+	}
 	return nil
 }
 
+// foreignKeyViolations fails when a reference no longer resolves, which SQLite
+// does not notice while its foreign key checks are off.
+func foreignKeyViolations(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("check foreign keys: %w", err)
+	}
+	defer closeRows(rows)
+
+	if rows.Next() {
+		var table string
+		var rowID sql.NullInt64
+		var parent string
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return fmt.Errorf("scan foreign key violation: %w", err)
+		}
+		return fmt.Errorf("row %d of %s references a missing %s", rowID.Int64, table, parent)
+	}
+	return rows.Err()
+}
+
 func validateMigrations(migrations []migration) error {
 	seen := make(map[int]bool, len(migrations))
 	for _, m := range migrations {
@@ -71,8 +297,9 @@ func validateMigrations(migrations []migration) error {
 	return nil
 }
 
-func loadMigrations() ([]migration, error) {
-	entries, err := migrationFiles.ReadDir("migrations")
+// loadMigrations reads the embedded migrations of dir in version order.
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := migrationFiles.ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("read embedded migrations: %w", err)
 	}
@@ -83,18 +310,25 @@ func loadMigrations() ([]migration, error) {
 			continue
 		}
 
-		migration, err := parseMigrationFile(entry.Name())
+		migration, err := parseMigrationFile(dir, entry.Name())
 		if err != nil {
 			return nil, err
 		}
 		migrations = append(migrations, migration)
 	}
 
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	if err := validateMigrations(migrations); err != nil {
+		return nil, err
+	}
 	return migrations, nil
 }
 
-func parseMigrationFile(fileName string) (migration, error) {
-	content, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+func parseMigrationFile(dir, fileName string) (migration, error) {
+	content, err := migrationFiles.ReadFile(path.Join(dir, fileName))
 	if err != nil {
 		return migration{}, fmt.Errorf("read migration %s: %w", fileName, err)
 	}
@@ -110,9 +344,71 @@ func parseMigrationFile(fileName string) (migration, error) {
 		return migration{}, fmt.Errorf("parse migration version %s: %w", fileName, err)
 	}
 
+	up, down := splitMigration(string(content))
 	return migration{
 		version: version,
 		name:    parts[1],
-		up:      string(content),
+		up:      up,
+		down:    down,
 	}, nil
 }
+
+// splitMigration separates a migration file into the statements before the
+// down marker line and those after it.
+func splitMigration(content string) (up, down string) {
+	offset := 0
+	for line := range strings.SplitAfterSeq(content, "\n") {
+		if strings.TrimSpace(line) == migrationDownMarker {
+			return content[:offset], strings.TrimSpace(content[offset+len(line):])
+		}
+		offset += len(line)
+	}
+	return content, ""
+}
+
+// backfillVersionKeys computes the version key of tags and tag events stored
+// before the key existed. Later writes set it as they go, so after the first
+// start this finds nothing to do.
+func backfillVersionKeys(ctx context.Context, db *sql.DB, d dialect) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin version key backfill: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, table := range []struct{ name, column string }{{"tags", "name"}, {"tag_events", "tag_name"}} {
+		names, err := namesWithoutVersionKey(ctx, tx, table.name, table.column)
+		if err != nil {
+			return err
+		}
+		update := d.rebind("UPDATE " + table.name + " SET version_key = ? WHERE " + table.column + " = ? AND version_key IS NULL")
+		for _, name := range names {
+			if _, err := tx.ExecContext(ctx, update, tagVersionKey(name), name); err != nil {
+				return fmt.Errorf("backfill %s version key: %w", table.name, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit version key backfill: %w", err)
+	}
+	return nil
+}
+
+func namesWithoutVersionKey(ctx context.Context, tx *sql.Tx, table, column string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT "+column+" FROM "+table+" WHERE version_key IS NULL")
+	if err != nil {
+		return nil, fmt.Errorf("list %s without version key: %w", table, err)
+	}
+	defer closeRows(rows)
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scan %s name: %w", table, err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
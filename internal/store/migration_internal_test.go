@@ -0,0 +1,344 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestMigrator(t *testing.T) (*Migrator, string, context.Context) {
+	t.Helper()
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "ui.db")
+	m, err := OpenMigrator(ctx, ConnectionSQLite, dsn)
+	if err != nil {
+		t.Fatalf("OpenMigrator: %v", err)
+	}
+	t.Cleanup(func() { _ = m.Close() })
+	return m, dsn, ctx
+}
+
+func TestSplitMigration(t *testing.T) {
+	up, down := splitMigration("CREATE TABLE a (id INTEGER);\n\n-- migrate:down\nDROP TABLE a;\n")
+	if up != "CREATE TABLE a (id INTEGER);\n\n" || down != "DROP TABLE a;" {
+		t.Fatalf("unexpected split: %q / %q", up, down)
+	}
+	if up, down := splitMigration("SELECT 1; -- migrate:down\n"); down != "" || !strings.HasPrefix(up, "SELECT 1;") {
+		t.Fatalf("expected the marker to count only on its own line, got %q / %q", up, down)
+	}
+}
+
+func TestMigratorRollsBackAndReapplies(t *testing.T) {
+	m, dsn, ctx := openTestMigrator(t)
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	applied, err := m.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(applied) == 0 || len(applied) != len(pending) {
+		t.Fatalf("expected the dry run to list the %d applied migrations, got %d", len(applied), len(pending))
+	}
+	latest := applied[len(applied)-1].Version
+	// The newest migrations all have down sections, back to the first one
+	// without.
+	reversible := 0
+	for i := len(applied) - 1; i >= 0 && applied[i].Reversible; i-- {
+		reversible++
+	}
+	if reversible < 3 {
+		t.Fatalf("expected at least three reversible migrations, got %d", reversible)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, st := range statuses {
+		if !st.Applied || st.AppliedAt == nil || st.Modified() || st.Unknown {
+			t.Fatalf("expected migration %d applied with a matching checksum, got %+v", st.Version, st)
+		}
+	}
+
+	reverted, err := m.Rollback(ctx, reversible)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if len(reverted) != reversible || reverted[0].Version != latest || reverted[reversible-1].Version != latest-reversible+1 {
+		t.Fatalf("expected the last %d migrations reverted newest first, got %+v", reversible, reverted)
+	}
+	var tables int
+	if err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name IN ('repository_stats', 'repository_platforms', 'api_tokens', 'audit_events')").Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("expected the rolled back tables dropped, got %d, %v", tables, err)
+	}
+
+	if _, err := m.Rollback(ctx, 1); err == nil || !strings.Contains(err.Error(), "cannot be rolled back") {
+		t.Fatalf("expected a migration without a down section to refuse, got %v", err)
+	}
+	if pending, err := m.Pending(ctx); err != nil || len(pending) != reversible {
+		t.Fatalf("expected %d pending migrations, got %d, %v", reversible, len(pending), err)
+	}
+	if _, err := m.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate after rollback: %v", err)
+	}
+	_ = m.Close()
+
+	s, err := New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("New after rollback: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.GetRepositoriesView(ctx); err != nil {
+		t.Fatalf("GetRepositoriesView: %v", err)
+	}
+	if err := s.RecordAuditEvent(ctx, &AuditEvent{Action: AuditActionLogin, Outcome: AuditOutcomeSuccess}); err != nil {
+		t.Fatalf("RecordAuditEvent: %v", err)
+	}
+	for _, stmt := range []string{"UPDATE audit_events SET outcome = 'denied'", "DELETE FROM audit_events"} {
+		if _, err := s.db.ExecContext(ctx, stmt); err == nil || !strings.Contains(err.Error(), "append-only") {
+			t.Fatalf("expected %q to be rejected, got %v", stmt, err)
+		}
+	}
+	// A prune cutoff only lets older events go.
+	if _, err := s.db.ExecContext(ctx, "INSERT INTO audit_prunes (cutoff) VALUES (?)", time.Now().Add(-time.Hour).UTC()); err != nil {
+		t.Fatalf("insert prune cutoff: %v", err)
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM audit_events"); err == nil || !strings.Contains(err.Error(), "append-only") {
+		t.Fatalf("expected a newer event to stay, got %v", err)
+	}
+}
+
+func TestMigrateRecordsChecksumsForLegacyVersions(t *testing.T) {
+	m, _, ctx := openTestMigrator(t)
+	applied, err := m.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// Older releases recorded only the version.
+	for _, stmt := range []string{
+		"DROP TABLE schema_version",
+		"CREATE TABLE schema_version (version INTEGER NOT NULL)",
+		"WITH RECURSIVE v(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM v WHERE n < ?) INSERT INTO schema_version SELECT n FROM v",
+	} {
+		if _, err := m.db.ExecContext(ctx, stmt, len(applied)); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	if again, err := m.Migrate(ctx); err != nil || len(again) != 0 {
+		t.Fatalf("expected nothing to apply, got %+v, %v", again, err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, st := range statuses {
+		if !st.Applied || st.Name == "" || st.Modified() || st.AppliedAt != nil {
+			t.Fatalf("expected legacy migration %d to get its name and checksum, got %+v", st.Version, st)
+		}
+	}
+}
+
+func TestOpenRefusesNewerSchema(t *testing.T) {
+	m, dsn, ctx := openTestMigrator(t)
+	if _, err := m.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, "INSERT INTO schema_version (version, name) VALUES (9999, 'from_the_future')"); err != nil {
+		t.Fatalf("insert version: %v", err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != 9999 || !last.Unknown {
+		t.Fatalf("expected the unknown version listed last, got %+v", last)
+	}
+	_ = m.Close()
+
+	if _, err := New(ctx, dsn); err == nil || !strings.Contains(err.Error(), "newer than") {
+		t.Fatalf("expected a newer schema to be refused, got %v", err)
+	}
+}
+
+func TestStatusOnlyReads(t *testing.T) {
+	m, _, ctx := openTestMigrator(t)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, st := range statuses {
+		if st.Applied {
+			t.Fatalf("expected migration %d pending on an empty database, got %+v", st.Version, st)
+		}
+	}
+	var tables int
+	if err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_version'").Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("expected Status not to create schema_version, got %d, %v", tables, err)
+	}
+
+	// A schema_version left by an older release stays as it is.
+	for _, stmt := range []string{
+		"CREATE TABLE schema_version (version INTEGER NOT NULL)",
+		"INSERT INTO schema_version VALUES (1)",
+	} {
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	statuses, err = m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if first := statuses[0]; !first.Applied || first.Modified() || statuses[1].Applied {
+		t.Fatalf("expected only migration 1 applied, got %+v", statuses[:2])
+	}
+	for _, column := range []string{"name", "checksum", "applied_at"} {
+		if exists, err := columnExists(ctx, m.db, m.dialect, "schema_version", column); err != nil || exists {
+			t.Fatalf("expected Status not to add the %s column, got %v, %v", column, exists, err)
+		}
+	}
+}
+
+func TestPendingOnlyReads(t *testing.T) {
+	m, _, ctx := openTestMigrator(t)
+
+	snapshot := func() string {
+		t.Helper()
+		var b strings.Builder
+		for _, query := range []string{
+			"SELECT type || ' ' || name || ' ' || COALESCE(sql, '') FROM sqlite_master ORDER BY type, name",
+			"SELECT CAST(version AS TEXT) FROM schema_version ORDER BY version",
+		} {
+			rows, err := m.db.QueryContext(ctx, query)
+			if err != nil {
+				if strings.Contains(err.Error(), "no such table") {
+					continue
+				}
+				t.Fatalf("%s: %v", query, err)
+			}
+			for rows.Next() {
+				var line string
+				if err := rows.Scan(&line); err != nil {
+					t.Fatalf("scan: %v", err)
+				}
+				b.WriteString(line + "\n")
+			}
+			if err := rows.Err(); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+			_ = rows.Close()
+		}
+		return b.String()
+	}
+
+	before := snapshot()
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	if len(pending) == 0 || pending[0].Version != 1 {
+		t.Fatalf("expected every migration pending on an empty database, got %+v", pending)
+	}
+	if after := snapshot(); after != before {
+		t.Fatalf("expected Pending not to change an empty database, got:\n%s", after)
+	}
+
+	// A schema_version left by an older release, without the name, checksum
+	// and applied_at columns, is neither altered nor backfilled.
+	for _, stmt := range []string{
+		"CREATE TABLE schema_version (version INTEGER NOT NULL)",
+		"INSERT INTO schema_version VALUES (1)",
+	} {
+		if _, err := m.db.ExecContext(ctx, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	before = snapshot()
+	pending, err = m.Pending(ctx)
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	if len(pending) == 0 || pending[0].Version != 2 {
+		t.Fatalf("expected migrations after version 1 pending, got %+v", pending)
+	}
+	if after := snapshot(); after != before {
+		t.Fatalf("expected Pending not to change schema_version, got:\n%s\nwant:\n%s", after, before)
+	}
+}
+
+func TestForeignKeyMigrationRunsInOneTransaction(t *testing.T) {
+	m, _, ctx := openTestMigrator(t)
+	if _, err := m.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	run := func(script string) error {
+		return withMigrationLock(ctx, m.db, m.dialect, func(conn migrationConn) error {
+			mig := migration{version: 9999, name: "rebuild"}
+			return runMigration(ctx, conn, m.dialect, mig, script, func(exec execer) error {
+				_, err := exec.ExecContext(ctx, "INSERT INTO schema_version (version, name) VALUES (9999, 'rebuild')")
+				return err
+			})
+		})
+	}
+	version := func() int {
+		t.Helper()
+		v, err := schemaVersion(ctx, m.db)
+		if err != nil {
+			t.Fatalf("schemaVersion: %v", err)
+		}
+		return v
+	}
+	before := version()
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "failing statement",
+			script: "PRAGMA foreign_keys=OFF;\nCREATE TABLE rebuilt (id INTEGER);\nSELECT * FROM missing_table;\nPRAGMA foreign_keys=ON;\n",
+			want:   "no such table",
+		},
+		{
+			name:   "dangling reference",
+			script: "PRAGMA foreign_keys=OFF;\nCREATE TABLE rebuilt (id INTEGER);\nINSERT INTO repositories (registry_id, namespace, name) VALUES (4242, '', 'orphan');\nPRAGMA foreign_keys=ON;\n",
+			want:   "references a missing registries",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := run(test.script); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("expected the migration to fail with %q, got %v", test.want, err)
+			}
+			var tables int
+			if err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'rebuilt'").Scan(&tables); err != nil || tables != 0 {
+				t.Fatalf("expected the whole script rolled back, got %d tables, %v", tables, err)
+			}
+			if got := version(); got != before {
+				t.Fatalf("expected schema version %d, got %d", before, got)
+			}
+			var enabled int
+			if err := m.db.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enabled); err != nil || enabled != 1 {
+				t.Fatalf("expected foreign keys back on, got %d, %v", enabled, err)
+			}
+		})
+	}
+
+	if err := run("PRAGMA foreign_keys=OFF;\nCREATE TABLE rebuilt (id INTEGER);\nPRAGMA foreign_keys=ON;\n"); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := version(); got != 9999 {
+		t.Fatalf("expected the migration recorded, got version %d", got)
+	}
+}
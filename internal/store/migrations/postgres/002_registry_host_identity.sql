@@ -0,0 +1,2 @@
+-- Registries are identified by host; several may share a display name.
+ALTER TABLE registries DROP CONSTRAINT IF EXISTS registries_name_key;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS leases (
+	name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	acquired_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sync_triggers (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	reason TEXT NOT NULL DEFAULT '',
+	requested_by TEXT NOT NULL DEFAULT '',
+	requested_at TIMESTAMPTZ NOT NULL,
+	claimed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_sync_triggers_pending ON sync_triggers(claimed_at);
+
+CREATE TABLE IF NOT EXISTS sync_progress (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	holder TEXT NOT NULL DEFAULT '',
+	completed INTEGER NOT NULL DEFAULT 0,
+	total INTEGER NOT NULL DEFAULT 0,
+	message TEXT NOT NULL DEFAULT '',
+	step TEXT NOT NULL DEFAULT '',
+	done BOOLEAN NOT NULL DEFAULT FALSE,
+	updated_at TIMESTAMPTZ NOT NULL
+);
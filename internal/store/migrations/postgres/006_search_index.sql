@@ -0,0 +1,71 @@
+-- One document per tag, plus one per repository (tag_id NULL) so repositories
+-- are found by path before their tags have synced. Punctuation is folded to
+-- spaces so paths, URLs and versions split into words the way FTS5 splits them.
+CREATE TABLE IF NOT EXISTS search_documents (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	repo_id BIGINT NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	tag_id BIGINT REFERENCES tags(id) ON DELETE CASCADE,
+	path TEXT NOT NULL DEFAULT '',
+	tag TEXT NOT NULL DEFAULT '',
+	title TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT '',
+	vendor TEXT NOT NULL DEFAULT '',
+	chart_name TEXT NOT NULL DEFAULT '',
+	chart_desc TEXT NOT NULL DEFAULT '',
+	document TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', regexp_replace(path, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+		setweight(to_tsvector('simple', regexp_replace(tag, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+		setweight(to_tsvector('simple', regexp_replace(chart_name, '[^[:alnum:]]+', ' ', 'g')), 'A') ||
+		setweight(to_tsvector('simple', regexp_replace(title, '[^[:alnum:]]+', ' ', 'g')), 'B') ||
+		setweight(to_tsvector('simple', regexp_replace(description, '[^[:alnum:]]+', ' ', 'g')), 'C') ||
+		setweight(to_tsvector('simple', regexp_replace(chart_desc, '[^[:alnum:]]+', ' ', 'g')), 'C') ||
+		setweight(to_tsvector('simple', regexp_replace(source, '[^[:alnum:]]+', ' ', 'g')), 'D') ||
+		setweight(to_tsvector('simple', regexp_replace(vendor, '[^[:alnum:]]+', ' ', 'g')), 'D')
+	) STORED
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_search_documents_tag ON search_documents(tag_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_search_documents_repo ON search_documents(repo_id) WHERE tag_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_search_documents_document ON search_documents USING GIN (document);
+
+INSERT INTO search_documents (repo_id, path)
+SELECT r.id, reg.host || '/' || CASE WHEN r.namespace = '' THEN r.name ELSE r.namespace || '/' || r.name END
+FROM repositories r
+JOIN registries reg ON reg.id = r.registry_id;
+
+-- Backfill from what is already stored: annotations on the tag's manifest,
+-- then labels on its image config.
+INSERT INTO search_documents (repo_id, tag_id, path, tag, title, description, source, vendor, chart_name, chart_desc)
+SELECT
+	t.repo_id,
+	t.id,
+	reg.host || '/' || CASE WHEN r.namespace = '' THEN r.name ELSE r.namespace || '/' || r.name END,
+	t.name,
+	COALESCE(
+		NULLIF(m.raw_json, '')::jsonb -> 'annotations' ->> 'org.opencontainers.image.title',
+		NULLIF(cb.config_json, '')::jsonb -> 'config' -> 'Labels' ->> 'org.opencontainers.image.title',
+		''
+	),
+	COALESCE(
+		NULLIF(m.raw_json, '')::jsonb -> 'annotations' ->> 'org.opencontainers.image.description',
+		NULLIF(cb.config_json, '')::jsonb -> 'config' -> 'Labels' ->> 'org.opencontainers.image.description',
+		''
+	),
+	COALESCE(
+		NULLIF(m.raw_json, '')::jsonb -> 'annotations' ->> 'org.opencontainers.image.source',
+		NULLIF(cb.config_json, '')::jsonb -> 'config' -> 'Labels' ->> 'org.opencontainers.image.source',
+		''
+	),
+	COALESCE(
+		NULLIF(m.raw_json, '')::jsonb -> 'annotations' ->> 'org.opencontainers.image.vendor',
+		NULLIF(cb.config_json, '')::jsonb -> 'config' -> 'Labels' ->> 'org.opencontainers.image.vendor',
+		''
+	),
+	COALESCE(tv.chart_name, ''),
+	COALESCE(tv.chart_desc, '')
+FROM tags t
+JOIN tags_view tv ON tv.id = t.id
+JOIN repositories r ON r.id = t.repo_id
+JOIN registries reg ON reg.id = r.registry_id
+LEFT JOIN manifests m ON m.digest = t.digest
+LEFT JOIN config_blobs cb ON cb.digest = m.config_digest;
@@ -0,0 +1,87 @@
+-- Storage is counted from the distinct layer and config blobs each repository
+-- references, index children included, instead of summing manifest sizes.
+DROP VIEW IF EXISTS repositories_view;
+DROP VIEW IF EXISTS repository_blobs;
+
+CREATE VIEW repository_blobs AS
+WITH repo_images AS (
+	SELECT t.repo_id, t.digest AS manifest_digest
+	FROM tags t
+	UNION
+	SELECT t.repo_id, mp.platform_digest
+	FROM tags t
+	JOIN manifest_platforms mp ON mp.index_digest = t.digest
+)
+SELECT ri.repo_id, l.digest, l.size_bytes
+FROM repo_images ri
+JOIN manifest_layers ml ON ml.manifest_digest = ri.manifest_digest
+JOIN layers l ON l.digest = ml.layer_digest
+UNION
+SELECT ri.repo_id, cb.digest, cb.size_bytes
+FROM repo_images ri
+JOIN manifests m ON m.digest = ri.manifest_digest
+JOIN config_blobs cb ON cb.digest = m.config_digest
+UNION
+-- Platforms stored without their manifest body have no layer rows; their
+-- recorded size stands in for their blobs.
+SELECT ri.repo_id, m.digest, m.size_bytes
+FROM repo_images ri
+JOIN manifests m ON m.digest = ri.manifest_digest
+WHERE m.kind != 'index'
+	AND NOT EXISTS (SELECT 1 FROM manifest_layers ml WHERE ml.manifest_digest = m.digest);
+
+CREATE VIEW repositories_view AS
+WITH repo_tags AS (
+	SELECT repo_id AS repository_id, COUNT(*) AS tags_count
+	FROM tags
+	GROUP BY repo_id
+),
+blob_repos AS (
+	SELECT r.registry_id, rb.digest, COUNT(*) AS repo_count
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	GROUP BY r.registry_id, rb.digest
+),
+repo_size AS (
+	SELECT
+		rb.repo_id AS repository_id,
+		SUM(rb.size_bytes)::BIGINT AS total_size_bytes,
+		SUM(CASE WHEN br.repo_count = 1 THEN rb.size_bytes ELSE 0 END)::BIGINT AS unique_size_bytes
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	JOIN blob_repos br ON br.registry_id = r.registry_id AND br.digest = rb.digest
+	GROUP BY rb.repo_id
+),
+repo_archs AS (
+	SELECT
+		t.repo_id AS repository_id,
+		json_agg(DISTINCT
+			CASE
+				WHEN t.kind = 'index' AND mp.variant != '' THEN mp.architecture || '/' || mp.variant
+				WHEN t.kind = 'index' THEN mp.architecture
+				WHEN m.variant != '' THEN m.architecture || '/' || m.variant
+				ELSE m.architecture
+			END
+		)::TEXT AS architectures
+	FROM tags t
+	LEFT JOIN manifest_platforms mp ON t.digest = mp.index_digest
+	LEFT JOIN manifests m ON m.digest = t.digest
+	WHERE (t.kind = 'index' AND mp.architecture != '' OR t.kind = 'image' AND m.architecture != '')
+	GROUP BY t.repo_id
+)
+SELECT
+	r.id,
+	r.name,
+	r.namespace,
+	reg.name AS registry,
+	reg.host AS registry_host,
+	COALESCE(rt.tags_count, 0) AS tags_count,
+	COALESCE(ra.architectures, '[]') AS architectures,
+	COALESCE(rs.total_size_bytes, 0) AS total_size_bytes,
+	COALESCE(rs.unique_size_bytes, 0) AS unique_size_bytes,
+	COALESCE(rs.total_size_bytes - rs.unique_size_bytes, 0) AS shared_size_bytes
+FROM repositories r
+JOIN registries reg ON r.registry_id = reg.id
+LEFT JOIN repo_tags rt ON r.id = rt.repository_id
+LEFT JOIN repo_archs ra ON r.id = ra.repository_id
+LEFT JOIN repo_size rs ON r.id = rs.repository_id;
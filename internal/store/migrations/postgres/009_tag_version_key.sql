@@ -0,0 +1,12 @@
+-- A byte-ordered encoding of the tag name's semantic version, so tag pages can
+-- be sorted by version in SQL. Empty for names that are not versions; NULL
+-- until computed, which the store does at startup for rows from before this
+-- migration. The C collation keeps the comparison bytewise.
+ALTER TABLE tags ADD COLUMN IF NOT EXISTS version_key TEXT COLLATE "C";
+ALTER TABLE tag_events ADD COLUMN IF NOT EXISTS version_key TEXT COLLATE "C";
+CREATE INDEX IF NOT EXISTS idx_tags_version_key ON tags(repo_id, version_key);
+
+-- migrate:down
+DROP INDEX IF EXISTS idx_tags_version_key;
+ALTER TABLE tag_events DROP COLUMN version_key;
+ALTER TABLE tags DROP COLUMN version_key;
@@ -0,0 +1,141 @@
+-- The platforms each repository's tags provide, kept up to date as tags are
+-- saved and pruned, so architecture and OS filters no longer rebuild them from
+-- manifests on every request. tag_count is how many of the repository's tags
+-- include the platform.
+CREATE TABLE IF NOT EXISTS repository_platforms (
+	repo_id BIGINT NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	os TEXT NOT NULL DEFAULT '',
+	architecture TEXT NOT NULL,
+	variant TEXT NOT NULL DEFAULT '',
+	tag_count INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (repo_id, os, architecture, variant)
+);
+CREATE INDEX IF NOT EXISTS idx_repository_platforms_architecture ON repository_platforms(architecture, variant);
+CREATE INDEX IF NOT EXISTS idx_repository_platforms_os ON repository_platforms(os);
+
+INSERT INTO repository_platforms (repo_id, os, architecture, variant, tag_count)
+SELECT repo_id, os, architecture, variant, COUNT(DISTINCT tag_id)
+FROM (
+	SELECT t.repo_id, t.id AS tag_id, mp.os, mp.architecture, mp.variant
+	FROM tags t
+	JOIN manifest_platforms mp ON mp.index_digest = t.digest
+	WHERE t.kind = 'index'
+	UNION ALL
+	SELECT t.repo_id, t.id, COALESCE(m.os, ''), COALESCE(m.architecture, ''), COALESCE(m.variant, '')
+	FROM tags t
+	JOIN manifests m ON m.digest = t.digest
+	WHERE t.kind = 'image'
+) tag_platforms
+WHERE architecture != ''
+GROUP BY repo_id, os, architecture, variant;
+
+DROP VIEW IF EXISTS repositories_view;
+
+CREATE VIEW repositories_view AS
+WITH repo_tags AS (
+	SELECT repo_id AS repository_id, COUNT(*) AS tags_count
+	FROM tags
+	GROUP BY repo_id
+),
+blob_repos AS (
+	SELECT r.registry_id, rb.digest, COUNT(*) AS repo_count
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	GROUP BY r.registry_id, rb.digest
+),
+repo_size AS (
+	SELECT
+		rb.repo_id AS repository_id,
+		SUM(rb.size_bytes)::BIGINT AS total_size_bytes,
+		SUM(CASE WHEN br.repo_count = 1 THEN rb.size_bytes ELSE 0 END)::BIGINT AS unique_size_bytes
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	JOIN blob_repos br ON br.registry_id = r.registry_id AND br.digest = rb.digest
+	GROUP BY rb.repo_id
+),
+repo_archs AS (
+	SELECT
+		repo_id AS repository_id,
+		json_agg(DISTINCT
+			CASE WHEN variant != '' THEN architecture || '/' || variant ELSE architecture END
+		)::TEXT AS architectures
+	FROM repository_platforms
+	GROUP BY repo_id
+)
+SELECT
+	r.id,
+	r.name,
+	r.namespace,
+	reg.name AS registry,
+	reg.host AS registry_host,
+	COALESCE(rt.tags_count, 0) AS tags_count,
+	COALESCE(ra.architectures, '[]') AS architectures,
+	COALESCE(rs.total_size_bytes, 0) AS total_size_bytes,
+	COALESCE(rs.unique_size_bytes, 0) AS unique_size_bytes,
+	COALESCE(rs.total_size_bytes - rs.unique_size_bytes, 0) AS shared_size_bytes
+FROM repositories r
+JOIN registries reg ON r.registry_id = reg.id
+LEFT JOIN repo_tags rt ON r.id = rt.repository_id
+LEFT JOIN repo_archs ra ON r.id = ra.repository_id
+LEFT JOIN repo_size rs ON r.id = rs.repository_id;
+
+-- migrate:down
+DROP VIEW IF EXISTS repositories_view;
+
+CREATE VIEW repositories_view AS
+WITH repo_tags AS (
+	SELECT repo_id AS repository_id, COUNT(*) AS tags_count
+	FROM tags
+	GROUP BY repo_id
+),
+blob_repos AS (
+	SELECT r.registry_id, rb.digest, COUNT(*) AS repo_count
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	GROUP BY r.registry_id, rb.digest
+),
+repo_size AS (
+	SELECT
+		rb.repo_id AS repository_id,
+		SUM(rb.size_bytes)::BIGINT AS total_size_bytes,
+		SUM(CASE WHEN br.repo_count = 1 THEN rb.size_bytes ELSE 0 END)::BIGINT AS unique_size_bytes
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	JOIN blob_repos br ON br.registry_id = r.registry_id AND br.digest = rb.digest
+	GROUP BY rb.repo_id
+),
+repo_archs AS (
+	SELECT
+		t.repo_id AS repository_id,
+		json_agg(DISTINCT
+			CASE
+				WHEN t.kind = 'index' AND mp.variant != '' THEN mp.architecture || '/' || mp.variant
+				WHEN t.kind = 'index' THEN mp.architecture
+				WHEN m.variant != '' THEN m.architecture || '/' || m.variant
+				ELSE m.architecture
+			END
+		)::TEXT AS architectures
+	FROM tags t
+	LEFT JOIN manifest_platforms mp ON t.digest = mp.index_digest
+	LEFT JOIN manifests m ON m.digest = t.digest
+	WHERE (t.kind = 'index' AND mp.architecture != '' OR t.kind = 'image' AND m.architecture != '')
+	GROUP BY t.repo_id
+)
+SELECT
+	r.id,
+	r.name,
+	r.namespace,
+	reg.name AS registry,
+	reg.host AS registry_host,
+	COALESCE(rt.tags_count, 0) AS tags_count,
+	COALESCE(ra.architectures, '[]') AS architectures,
+	COALESCE(rs.total_size_bytes, 0) AS total_size_bytes,
+	COALESCE(rs.unique_size_bytes, 0) AS unique_size_bytes,
+	COALESCE(rs.total_size_bytes - rs.unique_size_bytes, 0) AS shared_size_bytes
+FROM repositories r
+JOIN registries reg ON r.registry_id = reg.id
+LEFT JOIN repo_tags rt ON r.id = rt.repository_id
+LEFT JOIN repo_archs ra ON r.id = ra.repository_id
+LEFT JOIN repo_size rs ON r.id = rs.repository_id;
+
+DROP TABLE IF EXISTS repository_platforms;
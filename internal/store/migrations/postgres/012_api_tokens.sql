@@ -0,0 +1,26 @@
+-- API tokens let scripts call the server without a browser session. Only a
+-- hash of each token is kept. A token acts for the user who created it, with
+-- the groups and roles they had then, limited to its scopes.
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	-- prefix is the start of the token, shown to tell tokens apart.
+	prefix TEXT NOT NULL,
+	name TEXT NOT NULL,
+	-- scopes is a comma-separated list of read, sync and delete.
+	scopes TEXT NOT NULL,
+	user_subject TEXT NOT NULL,
+	user_email TEXT NOT NULL DEFAULT '',
+	user_name TEXT NOT NULL DEFAULT '',
+	-- user_groups and user_roles are JSON arrays.
+	user_groups TEXT NOT NULL DEFAULT '[]',
+	user_roles TEXT NOT NULL DEFAULT '[]',
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_api_tokens_user ON api_tokens(user_subject, created_at);
+
+-- migrate:down
+DROP TABLE IF EXISTS api_tokens;
@@ -0,0 +1,40 @@
+-- audit_events records who deleted tags, triggered syncs, signed in or out,
+-- managed API tokens or reloaded configuration, and whether it worked. Rows
+-- are only ever inserted; the trigger rejects changes to them.
+CREATE TABLE IF NOT EXISTS audit_events (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	action TEXT NOT NULL,
+	-- outcome is success, failure or denied.
+	outcome TEXT NOT NULL,
+	actor_subject TEXT NOT NULL DEFAULT '',
+	actor_email TEXT NOT NULL DEFAULT '',
+	actor_name TEXT NOT NULL DEFAULT '',
+	-- token_id and token_name are set when the actor used an API token.
+	token_id BIGINT,
+	token_name TEXT NOT NULL DEFAULT '',
+	registry TEXT NOT NULL DEFAULT '',
+	repository TEXT NOT NULL DEFAULT '',
+	-- tags and digests are JSON arrays.
+	tags TEXT NOT NULL DEFAULT '[]',
+	digests TEXT NOT NULL DEFAULT '[]',
+	detail TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	client_ip TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred ON audit_events(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action, occurred_at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+-- migrate:down
+DROP TABLE IF EXISTS audit_events;
+DROP FUNCTION IF EXISTS audit_events_append_only();
@@ -0,0 +1,31 @@
+-- Tag history outlives its repository. Each event names the registry host,
+-- namespace and repository it belongs to, and repo_id is cleared rather than
+-- cascaded when the repository is deleted, so pruning keeps the timeline and a
+-- repository that comes back picks its history up again.
+ALTER TABLE tag_events ADD COLUMN IF NOT EXISTS registry_host TEXT NOT NULL DEFAULT '';
+ALTER TABLE tag_events ADD COLUMN IF NOT EXISTS namespace TEXT NOT NULL DEFAULT '';
+ALTER TABLE tag_events ADD COLUMN IF NOT EXISTS repo_name TEXT NOT NULL DEFAULT '';
+
+UPDATE tag_events e
+SET registry_host = reg.host, namespace = r.namespace, repo_name = r.name
+FROM repositories r
+JOIN registries reg ON reg.id = r.registry_id
+WHERE r.id = e.repo_id;
+
+ALTER TABLE tag_events DROP CONSTRAINT IF EXISTS tag_events_repo_id_fkey;
+ALTER TABLE tag_events ALTER COLUMN repo_id DROP NOT NULL;
+ALTER TABLE tag_events ADD CONSTRAINT tag_events_repo_id_fkey
+	FOREIGN KEY (repo_id) REFERENCES repositories(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_tag_events_repository ON tag_events(registry_host, namespace, repo_name);
+
+-- migrate:down
+DROP INDEX IF EXISTS idx_tag_events_repository;
+DELETE FROM tag_events WHERE repo_id IS NULL;
+ALTER TABLE tag_events DROP CONSTRAINT IF EXISTS tag_events_repo_id_fkey;
+ALTER TABLE tag_events ALTER COLUMN repo_id SET NOT NULL;
+ALTER TABLE tag_events ADD CONSTRAINT tag_events_repo_id_fkey
+	FOREIGN KEY (repo_id) REFERENCES repositories(id) ON DELETE CASCADE;
+ALTER TABLE tag_events DROP COLUMN repo_name;
+ALTER TABLE tag_events DROP COLUMN namespace;
+ALTER TABLE tag_events DROP COLUMN registry_host;
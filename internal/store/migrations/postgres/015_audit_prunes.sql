@@ -0,0 +1,24 @@
+-- Audit events can be pruned, but only by `container-hub db prune-audit`.
+-- It inserts its cutoff into audit_prunes and deletes in the same
+-- transaction, and the trigger lets through only deletes of events older than
+-- a cutoff it can see. Updates stay rejected.
+CREATE TABLE IF NOT EXISTS audit_prunes (
+	cutoff TIMESTAMPTZ NOT NULL
+);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'DELETE' AND EXISTS (SELECT 1 FROM audit_prunes p WHERE OLD.occurred_at < p.cutoff) THEN
+		RETURN OLD;
+	END IF;
+	RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+
+-- migrate:down
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END
+$$ LANGUAGE plpgsql;
+DROP TABLE IF EXISTS audit_prunes;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS sync_runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at DATETIME NOT NULL,
+	finished_at DATETIME,
+	status TEXT NOT NULL DEFAULT 'running',
+	error TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS tag_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	tag_name TEXT NOT NULL,
+	event TEXT NOT NULL CHECK (event IN ('created', 'moved', 'deleted')),
+	old_digest TEXT NOT NULL DEFAULT '',
+	new_digest TEXT NOT NULL DEFAULT '',
+	sync_run_id INTEGER REFERENCES sync_runs(id) ON DELETE SET NULL,
+	occurred_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_tag_events_tag ON tag_events(repo_id, tag_name, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_tag_events_old_digest ON tag_events(old_digest);
+CREATE INDEX IF NOT EXISTS idx_tag_events_new_digest ON tag_events(new_digest);
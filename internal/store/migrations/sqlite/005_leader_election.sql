@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS leases (
+	name TEXT PRIMARY KEY,
+	holder TEXT NOT NULL,
+	acquired_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sync_triggers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	reason TEXT NOT NULL DEFAULT '',
+	requested_by TEXT NOT NULL DEFAULT '',
+	requested_at DATETIME NOT NULL,
+	claimed_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_sync_triggers_pending ON sync_triggers(claimed_at);
+
+CREATE TABLE IF NOT EXISTS sync_progress (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	holder TEXT NOT NULL DEFAULT '',
+	completed INTEGER NOT NULL DEFAULT 0,
+	total INTEGER NOT NULL DEFAULT 0,
+	message TEXT NOT NULL DEFAULT '',
+	step TEXT NOT NULL DEFAULT '',
+	done INTEGER NOT NULL DEFAULT 0,
+	updated_at DATETIME NOT NULL
+);
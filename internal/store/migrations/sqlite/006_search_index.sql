@@ -0,0 +1,60 @@
+-- One document per tag, plus one per repository (tag_id NULL) so repositories
+-- are found by path before their tags have synced. The FTS5 index over this
+-- table is created at startup when SQLite was built with FTS5.
+CREATE TABLE IF NOT EXISTS search_documents (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	tag_id INTEGER REFERENCES tags(id) ON DELETE CASCADE,
+	path TEXT NOT NULL DEFAULT '',
+	tag TEXT NOT NULL DEFAULT '',
+	title TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT '',
+	vendor TEXT NOT NULL DEFAULT '',
+	chart_name TEXT NOT NULL DEFAULT '',
+	chart_desc TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_search_documents_tag ON search_documents(tag_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_search_documents_repo ON search_documents(repo_id) WHERE tag_id IS NULL;
+
+INSERT INTO search_documents (repo_id, path)
+SELECT r.id, reg.host || '/' || CASE WHEN r.namespace = '' THEN r.name ELSE r.namespace || '/' || r.name END
+FROM repositories r
+JOIN registries reg ON reg.id = r.registry_id;
+
+-- Backfill from what is already stored: annotations on the tag's manifest,
+-- then labels on its image config.
+INSERT INTO search_documents (repo_id, tag_id, path, tag, title, description, source, vendor, chart_name, chart_desc)
+SELECT
+	t.repo_id,
+	t.id,
+	reg.host || '/' || CASE WHEN r.namespace = '' THEN r.name ELSE r.namespace || '/' || r.name END,
+	t.name,
+	COALESCE(
+		json_extract(NULLIF(m.raw_json, ''), '$.annotations."org.opencontainers.image.title"'),
+		json_extract(NULLIF(cb.config_json, ''), '$.config.Labels."org.opencontainers.image.title"'),
+		''
+	),
+	COALESCE(
+		json_extract(NULLIF(m.raw_json, ''), '$.annotations."org.opencontainers.image.description"'),
+		json_extract(NULLIF(cb.config_json, ''), '$.config.Labels."org.opencontainers.image.description"'),
+		''
+	),
+	COALESCE(
+		json_extract(NULLIF(m.raw_json, ''), '$.annotations."org.opencontainers.image.source"'),
+		json_extract(NULLIF(cb.config_json, ''), '$.config.Labels."org.opencontainers.image.source"'),
+		''
+	),
+	COALESCE(
+		json_extract(NULLIF(m.raw_json, ''), '$.annotations."org.opencontainers.image.vendor"'),
+		json_extract(NULLIF(cb.config_json, ''), '$.config.Labels."org.opencontainers.image.vendor"'),
+		''
+	),
+	COALESCE(tv.chart_name, ''),
+	COALESCE(tv.chart_desc, '')
+FROM tags t
+JOIN tags_view tv ON tv.id = t.id
+JOIN repositories r ON r.id = t.repo_id
+JOIN registries reg ON reg.id = r.registry_id
+LEFT JOIN manifests m ON m.digest = t.digest
+LEFT JOIN config_blobs cb ON cb.digest = m.config_digest;
@@ -0,0 +1,13 @@
+-- One row per registry (namespace = NULL) and per namespace after each sync
+-- run. Older rows are thinned to hourly, then daily resolution.
+CREATE TABLE IF NOT EXISTS storage_snapshots (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	registry_id INTEGER NOT NULL REFERENCES registries(id) ON DELETE CASCADE,
+	namespace TEXT,
+	sync_run_id INTEGER REFERENCES sync_runs(id) ON DELETE SET NULL,
+	taken_at DATETIME NOT NULL,
+	size_bytes INTEGER NOT NULL DEFAULT 0,
+	repository_count INTEGER NOT NULL DEFAULT 0,
+	tag_count INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS idx_storage_snapshots_series ON storage_snapshots(registry_id, namespace, taken_at);
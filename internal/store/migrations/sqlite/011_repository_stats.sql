@@ -0,0 +1,142 @@
+-- Per-repository statistics kept by the store as tags change, so
+-- repositories_view no longer aggregates every tag and blob when it is read.
+-- Unique sizes depend on the other repositories of the registry and are
+-- recomputed for all of them after each sync and tag deletion.
+CREATE TABLE IF NOT EXISTS repository_stats (
+	repo_id INTEGER PRIMARY KEY REFERENCES repositories(id) ON DELETE CASCADE,
+	tags_count INTEGER NOT NULL DEFAULT 0,
+	total_size_bytes BIGINT NOT NULL DEFAULT 0,
+	unique_size_bytes BIGINT NOT NULL DEFAULT 0,
+	-- last_updated_at is the newest image creation time among the tags;
+	-- last_changed_at is the latest tag creation, move or deletion.
+	last_updated_at DATETIME,
+	last_changed_at DATETIME
+);
+
+WITH blob_repos AS (
+	SELECT r.registry_id, rb.digest, COUNT(*) AS repo_count
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	GROUP BY r.registry_id, rb.digest
+),
+repo_size AS (
+	SELECT
+		rb.repo_id,
+		SUM(rb.size_bytes) AS total_size_bytes,
+		SUM(CASE WHEN br.repo_count = 1 THEN rb.size_bytes ELSE 0 END) AS unique_size_bytes
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	JOIN blob_repos br ON br.registry_id = r.registry_id AND br.digest = rb.digest
+	GROUP BY rb.repo_id
+),
+repo_tags AS (
+	SELECT repo_id, COUNT(*) AS tags_count FROM tags GROUP BY repo_id
+),
+repo_updated AS (
+	SELECT t.repo_id, MAX(m.created) AS last_updated_at
+	FROM tags t
+	JOIN manifests m ON m.digest = t.digest
+	GROUP BY t.repo_id
+),
+repo_changed AS (
+	SELECT repo_id, MAX(occurred_at) AS last_changed_at FROM tag_events GROUP BY repo_id
+)
+INSERT INTO repository_stats (repo_id, tags_count, total_size_bytes, unique_size_bytes, last_updated_at, last_changed_at)
+SELECT
+	r.id,
+	COALESCE(rt.tags_count, 0),
+	COALESCE(rs.total_size_bytes, 0),
+	COALESCE(rs.unique_size_bytes, 0),
+	ru.last_updated_at,
+	rc.last_changed_at
+FROM repositories r
+LEFT JOIN repo_tags rt ON rt.repo_id = r.id
+LEFT JOIN repo_size rs ON rs.repo_id = r.id
+LEFT JOIN repo_updated ru ON ru.repo_id = r.id
+LEFT JOIN repo_changed rc ON rc.repo_id = r.id;
+
+DROP VIEW IF EXISTS repositories_view;
+
+CREATE VIEW IF NOT EXISTS repositories_view AS
+WITH repo_archs AS (
+	SELECT
+		repo_id AS repository_id,
+		json_group_array(DISTINCT
+			CASE WHEN variant != '' THEN architecture || '/' || variant ELSE architecture END
+		) AS architectures
+	FROM repository_platforms
+	GROUP BY repo_id
+)
+SELECT
+	r.id,
+	r.name,
+	r.namespace,
+	reg.name AS registry,
+	reg.host AS registry_host,
+	COALESCE(st.tags_count, 0) AS tags_count,
+	COALESCE(ra.architectures, '[]') AS architectures,
+	COALESCE(st.total_size_bytes, 0) AS total_size_bytes,
+	-- Between a change and the next recount the stored unique size can
+	-- exceed the total.
+	CASE WHEN st.unique_size_bytes > st.total_size_bytes THEN st.total_size_bytes ELSE COALESCE(st.unique_size_bytes, 0) END AS unique_size_bytes,
+	CASE WHEN st.unique_size_bytes > st.total_size_bytes THEN 0 ELSE COALESCE(st.total_size_bytes - st.unique_size_bytes, 0) END AS shared_size_bytes,
+	st.last_updated_at,
+	st.last_changed_at
+FROM repositories r
+JOIN registries reg ON r.registry_id = reg.id
+LEFT JOIN repository_stats st ON st.repo_id = r.id
+LEFT JOIN repo_archs ra ON r.id = ra.repository_id;
+
+-- migrate:down
+DROP VIEW IF EXISTS repositories_view;
+
+CREATE VIEW IF NOT EXISTS repositories_view AS
+WITH repo_tags AS (
+	SELECT repo_id AS repository_id, COUNT(*) AS tags_count
+	FROM tags
+	GROUP BY repo_id
+),
+blob_repos AS (
+	SELECT r.registry_id, rb.digest, COUNT(*) AS repo_count
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	GROUP BY r.registry_id, rb.digest
+),
+repo_size AS (
+	SELECT
+		rb.repo_id AS repository_id,
+		SUM(rb.size_bytes) AS total_size_bytes,
+		SUM(CASE WHEN br.repo_count = 1 THEN rb.size_bytes ELSE 0 END) AS unique_size_bytes
+	FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	JOIN blob_repos br ON br.registry_id = r.registry_id AND br.digest = rb.digest
+	GROUP BY rb.repo_id
+),
+repo_archs AS (
+	SELECT
+		repo_id AS repository_id,
+		json_group_array(DISTINCT
+			CASE WHEN variant != '' THEN architecture || '/' || variant ELSE architecture END
+		) AS architectures
+	FROM repository_platforms
+	GROUP BY repo_id
+)
+SELECT
+	r.id,
+	r.name,
+	r.namespace,
+	reg.name AS registry,
+	reg.host AS registry_host,
+	COALESCE(rt.tags_count, 0) AS tags_count,
+	COALESCE(ra.architectures, '[]') AS architectures,
+	COALESCE(rs.total_size_bytes, 0) AS total_size_bytes,
+	COALESCE(rs.unique_size_bytes, 0) AS unique_size_bytes,
+	COALESCE(rs.total_size_bytes - rs.unique_size_bytes, 0) AS shared_size_bytes
+FROM repositories r
+JOIN registries reg ON r.registry_id = reg.id
+LEFT JOIN repo_tags rt ON r.id = rt.repository_id
+LEFT JOIN repo_archs ra ON r.id = ra.repository_id
+LEFT JOIN repo_size rs ON r.id = rs.repository_id
+GROUP BY r.id;
+
+DROP TABLE IF EXISTS repository_stats;
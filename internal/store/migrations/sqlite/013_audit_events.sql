@@ -0,0 +1,38 @@
+-- audit_events records who deleted tags, triggered syncs, signed in or out,
+-- managed API tokens or reloaded configuration, and whether it worked. Rows
+-- are only ever inserted; the triggers reject changes to them.
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	occurred_at DATETIME NOT NULL,
+	action TEXT NOT NULL,
+	-- outcome is success, failure or denied.
+	outcome TEXT NOT NULL,
+	actor_subject TEXT NOT NULL DEFAULT '',
+	actor_email TEXT NOT NULL DEFAULT '',
+	actor_name TEXT NOT NULL DEFAULT '',
+	-- token_id and token_name are set when the actor used an API token.
+	token_id INTEGER,
+	token_name TEXT NOT NULL DEFAULT '',
+	registry TEXT NOT NULL DEFAULT '',
+	repository TEXT NOT NULL DEFAULT '',
+	-- tags and digests are JSON arrays.
+	tags TEXT NOT NULL DEFAULT '[]',
+	digests TEXT NOT NULL DEFAULT '[]',
+	detail TEXT NOT NULL DEFAULT '',
+	request_id TEXT NOT NULL DEFAULT '',
+	client_ip TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred ON audit_events(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_action ON audit_events(action, occurred_at);
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+-- migrate:down
+DROP TABLE IF EXISTS audit_events;
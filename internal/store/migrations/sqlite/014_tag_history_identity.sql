@@ -0,0 +1,56 @@
+-- Tag history outlives its repository. Each event names the registry host,
+-- namespace and repository it belongs to, and repo_id is cleared rather than
+-- cascaded when the repository is deleted, so pruning keeps the timeline and a
+-- repository that comes back picks its history up again.
+CREATE TABLE tag_events_new (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	repo_id INTEGER REFERENCES repositories(id) ON DELETE SET NULL,
+	registry_host TEXT NOT NULL DEFAULT '',
+	namespace TEXT NOT NULL DEFAULT '',
+	repo_name TEXT NOT NULL DEFAULT '',
+	tag_name TEXT NOT NULL,
+	event TEXT NOT NULL CHECK (event IN ('created', 'moved', 'deleted')),
+	old_digest TEXT NOT NULL DEFAULT '',
+	new_digest TEXT NOT NULL DEFAULT '',
+	sync_run_id INTEGER REFERENCES sync_runs(id) ON DELETE SET NULL,
+	occurred_at DATETIME NOT NULL,
+	version_key TEXT
+);
+
+INSERT INTO tag_events_new (id, repo_id, registry_host, namespace, repo_name, tag_name, event, old_digest, new_digest, sync_run_id, occurred_at, version_key)
+SELECT e.id, e.repo_id, reg.host, r.namespace, r.name, e.tag_name, e.event, e.old_digest, e.new_digest, e.sync_run_id, e.occurred_at, e.version_key
+FROM tag_events e
+JOIN repositories r ON r.id = e.repo_id
+JOIN registries reg ON reg.id = r.registry_id;
+
+DROP TABLE tag_events;
+ALTER TABLE tag_events_new RENAME TO tag_events;
+
+CREATE INDEX IF NOT EXISTS idx_tag_events_tag ON tag_events(repo_id, tag_name, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_tag_events_old_digest ON tag_events(old_digest);
+CREATE INDEX IF NOT EXISTS idx_tag_events_new_digest ON tag_events(new_digest);
+CREATE INDEX IF NOT EXISTS idx_tag_events_repository ON tag_events(registry_host, namespace, repo_name);
+
+-- migrate:down
+CREATE TABLE tag_events_old (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	tag_name TEXT NOT NULL,
+	event TEXT NOT NULL CHECK (event IN ('created', 'moved', 'deleted')),
+	old_digest TEXT NOT NULL DEFAULT '',
+	new_digest TEXT NOT NULL DEFAULT '',
+	sync_run_id INTEGER REFERENCES sync_runs(id) ON DELETE SET NULL,
+	occurred_at DATETIME NOT NULL,
+	version_key TEXT
+);
+
+INSERT INTO tag_events_old (id, repo_id, tag_name, event, old_digest, new_digest, sync_run_id, occurred_at, version_key)
+SELECT id, repo_id, tag_name, event, old_digest, new_digest, sync_run_id, occurred_at, version_key
+FROM tag_events WHERE repo_id IS NOT NULL;
+
+DROP TABLE tag_events;
+ALTER TABLE tag_events_old RENAME TO tag_events;
+
+CREATE INDEX IF NOT EXISTS idx_tag_events_tag ON tag_events(repo_id, tag_name, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_tag_events_old_digest ON tag_events(old_digest);
+CREATE INDEX IF NOT EXISTS idx_tag_events_new_digest ON tag_events(new_digest);
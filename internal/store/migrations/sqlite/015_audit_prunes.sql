@@ -0,0 +1,22 @@
+-- Audit events can be pruned, but only by `container-hub db prune-audit`.
+-- It inserts its cutoff into audit_prunes and deletes in the same
+-- transaction, and the trigger lets through only deletes of events older than
+-- a cutoff it can see. Updates stay rejected.
+CREATE TABLE IF NOT EXISTS audit_prunes (
+	cutoff DATETIME NOT NULL
+);
+
+DROP TRIGGER IF EXISTS audit_events_no_delete;
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+WHEN NOT EXISTS (SELECT 1 FROM audit_prunes p WHERE julianday(OLD.occurred_at) < julianday(p.cutoff))
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+-- migrate:down
+DROP TRIGGER IF EXISTS audit_events_no_delete;
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+DROP TABLE IF EXISTS audit_prunes;
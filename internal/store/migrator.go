@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Migrator inspects and changes the schema of a database without opening a
+// Store, which would apply pending migrations first.
+type Migrator struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// OpenMigrator connects to the database like Open, leaving its schema as it
+// is.
+func OpenMigrator(ctx context.Context, connection, dsn string) (*Migrator, error) {
+	switch connection {
+	case "", ConnectionSQLite:
+		db, err := openSQLite(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &Migrator{db: db, dialect: sqliteDialect}, nil
+	case ConnectionPostgres:
+		db, err := openPostgres(ctx, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &Migrator{db: db, dialect: postgresDialect}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database connection %q", connection)
+	}
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+// Status lists the embedded migrations in version order with whether each is
+// applied, followed by applied versions this binary does not know. It only
+// reads the database.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(m.dialect.migrationDir())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := recordedMigrations(ctx, m.db, m.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations)+len(applied))
+	for _, mig := range migrations {
+		status := MigrationStatus{Migration: mig.info()}
+		if record, ok := applied[mig.version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+			status.AppliedChecksum = record.AppliedChecksum
+			// Older releases did not record checksums; the next migration
+			// fills in the embedded one, so compare against that.
+			if status.AppliedChecksum == "" {
+				status.AppliedChecksum = status.Checksum
+			}
+			delete(applied, mig.version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, version := range slices.Sorted(maps.Keys(applied)) {
+		statuses = append(statuses, applied[version])
+	}
+	return statuses, nil
+}
+
+// recordedMigrations reads schema_version as an older release may have left
+// it: a missing table means nothing is applied, and columns it did not record
+// read as empty. Unlike ensureSchemaVersion it changes nothing.
+func recordedMigrations(ctx context.Context, db migrationConn, d dialect) (map[int]MigrationStatus, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if d.postgres() {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, d.rebind(query), "schema_version").Scan(&n); err != nil {
+		return nil, fmt.Errorf("inspect schema_version: %w", err)
+	}
+	if n == 0 {
+		return map[int]MigrationStatus{}, nil
+	}
+
+	columns := []string{"version"}
+	for _, column := range []struct{ name, missing string }{{"name", "''"}, {"checksum", "''"}, {"applied_at", "NULL"}} {
+		exists, err := columnExists(ctx, db, d, "schema_version", column.name)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			columns = append(columns, column.name)
+		} else {
+			columns = append(columns, column.missing)
+		}
+	}
+	return selectAppliedMigrations(ctx, db, "SELECT "+strings.Join(columns, ", ")+" FROM schema_version")
+}
+
+// Pending returns the migrations Migrate would apply, without applying them.
+// Like Status it only reads the database, so a schema_version left by an older
+// release stays as it is.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	migrations, err := loadMigrations(m.dialect.migrationDir())
+	if err != nil {
+		return nil, err
+	}
+	applied, err := recordedMigrations(ctx, m.db, m.dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	currentVersion := 0
+	if len(applied) > 0 {
+		currentVersion = slices.Max(slices.Collect(maps.Keys(applied)))
+	}
+	if latest := latestVersion(migrations); currentVersion > latest {
+		return nil, fmt.Errorf("database schema version %d is newer than version %d this binary supports", currentVersion, latest)
+	}
+
+	var pending []Migration
+	for _, mig := range migrations {
+		if mig.version > currentVersion {
+			pending = append(pending, mig.info())
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations and returns them. Data backfills
+// that follow migrations run the next time the database is opened with Open.
+func (m *Migrator) Migrate(ctx context.Context) ([]Migration, error) {
+	return migrate(ctx, m.db, m.dialect)
+}
+
+// Rollback reverts the last steps applied migrations, newest first, and
+// returns them. It checks that every one of them has a down section before
+// reverting any.
+func (m *Migrator) Rollback(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("rollback needs at least one step, got %d", steps)
+	}
+
+	var reverted []Migration
+	err := withMigrationLock(ctx, m.db, m.dialect, func(conn migrationConn) error {
+		migrations, _, err := prepareMigrations(ctx, conn, m.dialect)
+		if err != nil {
+			return err
+		}
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := slices.Sorted(maps.Keys(applied))
+		if steps > len(versions) {
+			return fmt.Errorf("cannot roll back %d migrations, only %d are applied", steps, len(versions))
+		}
+
+		byVersion := make(map[int]migration, len(migrations))
+		for _, mig := range migrations {
+			byVersion[mig.version] = mig
+		}
+		var targets []migration
+		for _, version := range slices.Backward(versions[len(versions)-steps:]) {
+			mig, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("migration %d is not known to this binary", version)
+			}
+			if mig.down == "" {
+				return fmt.Errorf("migration %d %s cannot be rolled back", mig.version, mig.name)
+			}
+			targets = append(targets, mig)
+		}
+
+		for _, mig := range targets {
+			err := runMigration(ctx, conn, m.dialect, mig, mig.down, func(exec execer) error {
+				_, err := exec.ExecContext(ctx, m.dialect.rebind("DELETE FROM schema_version WHERE version = ?"), mig.version)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			reverted = append(reverted, mig.info())
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// appliedMigrations reads schema_version, keyed by version.
+func appliedMigrations(ctx context.Context, db migrationConn) (map[int]MigrationStatus, error) {
+	return selectAppliedMigrations(ctx, db, "SELECT version, name, checksum, applied_at FROM schema_version")
+}
+
+// selectAppliedMigrations runs query, which selects the version, name,
+// checksum and applied_at of schema_version rows.
+func selectAppliedMigrations(ctx context.Context, db migrationConn, query string) (map[int]MigrationStatus, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("list applied migrations: %w", err)
+	}
+	defer closeRows(rows)
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt sql.NullString
+		if err := rows.Scan(&status.Version, &status.Name, &status.AppliedChecksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan applied migration: %w", err)
+		}
+		status.Applied = true
+		status.Unknown = true
+		status.AppliedAt = parseNullTime(appliedAt)
+		applied[status.Version] = status
+	}
+	return applied, rows.Err()
+}
@@ -1,6 +1,10 @@
 package store
 
-import "time"
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
 
 type Registry struct {
 	ID         uint       `json:"id"`
@@ -79,6 +83,40 @@ type ManifestLayer struct {
 	Position       int    `json:"position"`
 }
 
+type SyncRun struct {
+	ID         int64      `json:"id"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error"`
+}
+
+const (
+	SyncRunRunning   = "running"
+	SyncRunSucceeded = "succeeded"
+	SyncRunFailed    = "failed"
+)
+
+// SyncProgress is the sync progress the leader shares with other replicas.
+type SyncProgress struct {
+	Holder    string    `json:"holder"`
+	Completed int       `json:"completed"`
+	Total     int       `json:"total"`
+	Message   string    `json:"message"`
+	Step      string    `json:"step"`
+	Done      bool      `json:"done"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// TagEventKind is the kind of change recorded in a tag's history.
+type TagEventKind string
+
+const (
+	TagEventCreated TagEventKind = "created"
+	TagEventMoved   TagEventKind = "moved"
+	TagEventDeleted TagEventKind = "deleted"
+)
+
 // View types for page rendering.
 
 type RepositoryView struct {
@@ -91,8 +129,15 @@ type RepositoryView struct {
 	TagsCount          int      `json:"tagsCount"`
 	Architectures      []string `json:"architectures"`
 	TotalSizeInBytes   int64    `json:"totalSizeInBytes"`
+	// UniqueSizeInBytes counts blobs no other repository of the registry
+	// references; the rest of the total is shared.
+	UniqueSizeInBytes int64        `json:"uniqueSizeInBytes"`
+	SharedSizeInBytes int64        `json:"sharedSizeInBytes"`
+	Match             *SearchMatch `json:"match,omitempty"`
 }
 
+// TagView is a tag as listed on the repository page. On point-in-time views
+// ID is zero for tags that no longer exist as they were then.
 type TagView struct {
 	ID                uint        `json:"id"`
 	Name              string      `json:"name"`
@@ -107,6 +152,11 @@ type TagView struct {
 	ChartDesc         string      `json:"chartDesc"`
 	ChartAPIVersion   string      `json:"chartApiVersion"`
 	ChartType         string      `json:"chartType"`
+	// ManifestNotRetained is set on point-in-time views for tags whose
+	// manifest is no longer stored.
+	ManifestNotRetained bool `json:"manifestNotRetained,omitempty"`
+	// ReadOnly marks tags of a point-in-time view, which cannot be deleted.
+	ReadOnly bool `json:"readOnly,omitempty"`
 }
 
 type ImageView struct {
@@ -119,6 +169,67 @@ type ImageView struct {
 	Stub         bool      `json:"stub"`
 }
 
+// TagDetailView is a tag with its manifest and the images it covers, each
+// with its config and layers.
+type TagDetailView struct {
+	Name       string            `json:"name"`
+	Digest     string            `json:"digest"`
+	Kind       string            `json:"kind"`
+	MediaType  string            `json:"mediaType"`
+	SizeBytes  int64             `json:"sizeBytes"`
+	Created    *time.Time        `json:"created"`
+	LastSyncAt *time.Time        `json:"lastSyncAt"`
+	SyncStatus string            `json:"syncStatus"`
+	Images     []ImageDetailView `json:"images"`
+}
+
+// ImageDetailView is one platform image of a tag. Config is nil and Layers
+// empty when its manifest was not stored.
+type ImageDetailView struct {
+	Digest       string           `json:"digest"`
+	MediaType    string           `json:"mediaType"`
+	OS           string           `json:"os"`
+	Architecture string           `json:"architecture"`
+	Variant      string           `json:"variant"`
+	SizeBytes    int64            `json:"sizeBytes"`
+	Created      *time.Time       `json:"created"`
+	Config       *ImageConfigView `json:"config"`
+	Layers       []Layer          `json:"layers"`
+}
+
+// ImageConfigView is an image config blob. Config holds the blob itself.
+type ImageConfigView struct {
+	Digest    string          `json:"digest"`
+	SizeBytes int64           `json:"sizeBytes"`
+	Created   *time.Time      `json:"created"`
+	Config    json.RawMessage `json:"config,omitempty"`
+}
+
+// TagEventView is one entry of a tag timeline. Old and New describe the
+// manifests the tag pointed to before and after the event, when known.
+type TagEventView struct {
+	ID         int64          `json:"id"`
+	Event      TagEventKind   `json:"event"`
+	OldDigest  string         `json:"oldDigest"`
+	NewDigest  string         `json:"newDigest"`
+	SyncRunID  *int64         `json:"syncRunId"`
+	OccurredAt time.Time      `json:"occurredAt"`
+	Old        *DigestSummary `json:"old"`
+	New        *DigestSummary `json:"new"`
+}
+
+// DigestSummary describes a manifest referenced from a tag timeline.
+// Available is false when the manifest was never synced or has been removed.
+type DigestSummary struct {
+	Digest    string     `json:"digest"`
+	Kind      string     `json:"kind"`
+	Size      int64      `json:"size"`
+	CreatedAt *time.Time `json:"createdAt"`
+	Platforms []string   `json:"platforms"`
+	Available bool       `json:"available"`
+	Current   bool       `json:"current"`
+}
+
 type RegistryStatsView struct {
 	RepositoryCount       int   `json:"repositoryCount"`
 	TagCount              int   `json:"tagCount"`
@@ -126,10 +237,14 @@ type RegistryStatsView struct {
 	ArchitectureCount     int   `json:"architectureCount"`
 }
 
+// NamespaceStorageView counts each blob of a namespace once. Unique blobs are
+// not referenced from any other namespace of the registry.
 type NamespaceStorageView struct {
-	Namespace      string `json:"namespace"`
-	DisplayName    string `json:"displayName"`
-	TotalSizeBytes int64  `json:"totalSizeBytes"`
+	Namespace       string `json:"namespace"`
+	DisplayName     string `json:"displayName"`
+	TotalSizeBytes  int64  `json:"totalSizeBytes"`
+	UniqueSizeBytes int64  `json:"uniqueSizeBytes"`
+	SharedSizeBytes int64  `json:"sharedSizeBytes"`
 }
 
 type ArchitectureCoverageView struct {
@@ -138,12 +253,14 @@ type ArchitectureCoverageView struct {
 }
 
 type RegistryRepositoryRow struct {
-	ID               uint   `json:"id"`
-	Name             string `json:"name"`
-	Namespace        string `json:"namespace"`
-	DisplayName      string `json:"displayName"`
-	TagsCount        int    `json:"tagsCount"`
-	TotalSizeInBytes int64  `json:"totalSizeInBytes"`
+	ID                uint   `json:"id"`
+	Name              string `json:"name"`
+	Namespace         string `json:"namespace"`
+	DisplayName       string `json:"displayName"`
+	TagsCount         int    `json:"tagsCount"`
+	TotalSizeInBytes  int64  `json:"totalSizeInBytes"`
+	UniqueSizeInBytes int64  `json:"uniqueSizeInBytes"`
+	SharedSizeInBytes int64  `json:"sharedSizeInBytes"`
 }
 
 type RegistryStorageUsageView struct {
@@ -152,18 +269,273 @@ type RegistryStorageUsageView struct {
 	TotalSizeBytes int64  `json:"totalSizeBytes"`
 }
 
+// DigestRelation says how a tag references a looked-up digest.
+type DigestRelation string
+
+const (
+	// DigestRelationTag: the tag points at the digest.
+	DigestRelationTag DigestRelation = "tag"
+	// DigestRelationPlatform: the digest is a child manifest of the tag's index.
+	DigestRelationPlatform DigestRelation = "platform"
+	// DigestRelationConfig: the digest is the config blob of one of the tag's images.
+	DigestRelationConfig DigestRelation = "config"
+	// DigestRelationLayer: the digest is a layer of one of the tag's images.
+	DigestRelationLayer DigestRelation = "layer"
+)
+
+// DigestUsage is a tag referencing a looked-up digest. Digest is the full
+// matched digest, which differs from the lookup when it was a prefix.
+// Platform is that of the image holding the match, when known.
+type DigestUsage struct {
+	Namespace  string         `json:"namespace"`
+	Repository string         `json:"repository"`
+	Tag        string         `json:"tag"`
+	TagDigest  string         `json:"tagDigest"`
+	Digest     string         `json:"digest"`
+	Relation   DigestRelation `json:"relation"`
+	Platform   string         `json:"platform,omitempty"`
+}
+
+// DigestUsageGroup lists the usages of a digest within one registry.
+type DigestUsageGroup struct {
+	Registry     string        `json:"registry"`
+	RegistryHost string        `json:"registryHost"`
+	Usages       []DigestUsage `json:"usages"`
+}
+
+// StorageSnapshotPoint is a registry or namespace total at one point in time.
+type StorageSnapshotPoint struct {
+	TakenAt         time.Time `json:"takenAt"`
+	SizeBytes       int64     `json:"sizeBytes"`
+	RepositoryCount int       `json:"repositoryCount"`
+	TagCount        int       `json:"tagCount"`
+}
+
+// NamespaceGrowthView compares a namespace's storage at the start and end of a
+// period. A namespace absent at either end counts as empty there.
+type NamespaceGrowthView struct {
+	Namespace   string `json:"namespace"`
+	DisplayName string `json:"displayName"`
+	StartBytes  int64  `json:"startBytes"`
+	EndBytes    int64  `json:"endBytes"`
+	GrowthBytes int64  `json:"growthBytes"`
+}
+
+// ReclaimEstimate is the storage a registry garbage collection would free once
+// some manifests are no longer referenced by any tag.
+type ReclaimEstimate struct {
+	// Manifests counts the manifests, index children included, that no
+	// remaining tag in the registry references.
+	Manifests int `json:"manifests"`
+	// Blobs counts the layer and config blobs that become unreferenced.
+	Blobs            int   `json:"blobs"`
+	ReclaimableBytes int64 `json:"reclaimableBytes"`
+	// RetainedBytes is the size of the blobs of those manifests that other
+	// tags still use.
+	RetainedBytes int64 `json:"retainedBytes"`
+}
+
+// GCResult counts the rows a database garbage collection removed.
+type GCResult struct {
+	Manifests      int64
+	Platforms      int64
+	ManifestLayers int64
+	Layers         int64
+	ConfigBlobs    int64
+	// ReclaimedBytes is how much smaller the database is after vacuuming.
+	ReclaimedBytes int64
+}
+
+// RepositoryStats is a repository_stats row. Present is false when the
+// repository has none.
+type RepositoryStats struct {
+	Present         bool
+	TagsCount       int
+	TotalSizeBytes  int64
+	UniqueSizeBytes int64
+	LastUpdatedAt   *time.Time
+	LastChangedAt   *time.Time
+}
+
+// RepositoryStatsDrift is a repository whose stored statistics differ from
+// a recount.
+type RepositoryStatsDrift struct {
+	RepositoryID uint
+	Path         string
+	Stored       RepositoryStats
+	Expected     RepositoryStats
+}
+
+// ImportResult counts the rows an import read and how many of them were new.
+type ImportResult struct {
+	Rows     int
+	Inserted int
+}
+
+// API token scopes. Read covers every GET request, sync the sync trigger, and
+// delete tag deletion.
+const (
+	APITokenScopeRead   = "read"
+	APITokenScopeSync   = "sync"
+	APITokenScopeDelete = "delete"
+)
+
+// APIToken is a token for non-browser access. The token itself is only shown
+// when it is created; the store keeps its hash. It acts for the user who
+// created it, with the groups and roles they had then and still had at their
+// latest sign-in: see NarrowAPITokenClaims.
+type APIToken struct {
+	ID          uint       `json:"id"`
+	TokenHash   string     `json:"-"`
+	Prefix      string     `json:"prefix"`
+	Name        string     `json:"name"`
+	Scopes      []string   `json:"scopes"`
+	UserSubject string     `json:"userSubject"`
+	UserEmail   string     `json:"userEmail"`
+	UserName    string     `json:"userName"`
+	UserGroups  []string   `json:"userGroups"`
+	UserRoles   []string   `json:"userRoles"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	LastUsedAt  *time.Time `json:"lastUsedAt"`
+	RevokedAt   *time.Time `json:"revokedAt"`
+}
+
+// Active reports whether the token can be used at the time.
+func (t *APIToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// HasScope reports whether the token grants the scope.
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// Audited actions.
+const (
+	AuditActionTagsDelete   = "tags.delete"
+	AuditActionSyncTrigger  = "sync.trigger"
+	AuditActionLogin        = "auth.login"
+	AuditActionLogout       = "auth.logout"
+	AuditActionTokenCreate  = "token.create"
+	AuditActionTokenRevoke  = "token.revoke"
+	AuditActionConfigReload = "config.reload"
+	AuditActionAuditPrune   = "audit.prune"
+)
+
+// Outcomes of audited actions. Denied actions were refused by the access
+// policy or an allowlist; failed ones were allowed but did not work.
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeDenied  = "denied"
+)
+
+// AuditEvent records who did what to which target, and how it went. Events
+// are never changed once recorded.
+type AuditEvent struct {
+	ID           uint      `json:"id"`
+	OccurredAt   time.Time `json:"occurredAt"`
+	Action       string    `json:"action"`
+	Outcome      string    `json:"outcome"`
+	ActorSubject string    `json:"actorSubject"`
+	ActorEmail   string    `json:"actorEmail"`
+	ActorName    string    `json:"actorName"`
+	// TokenID and TokenName identify the API token the actor used, if any.
+	TokenID    *uint    `json:"tokenId"`
+	TokenName  string   `json:"tokenName"`
+	Registry   string   `json:"registry"`
+	Repository string   `json:"repository"`
+	Tags       []string `json:"tags"`
+	Digests    []string `json:"digests"`
+	Detail     string   `json:"detail"`
+	RequestID  string   `json:"requestId"`
+	ClientIP   string   `json:"clientIp"`
+}
+
+// AuditFilter selects audit events, newest first. Actor matches part of the
+// actor's email or name, and Target part of the registry or repository.
+// BeforeID pages back from an event.
+type AuditFilter struct {
+	Action   string
+	Outcome  string
+	Actor    string
+	Target   string
+	Since    *time.Time
+	Until    *time.Time
+	BeforeID uint
+	Limit    int
+}
+
+// Migration is a schema migration embedded in the binary.
+type Migration struct {
+	Version  int
+	Name     string
+	Checksum string
+	// SQL is the statements that apply the migration.
+	SQL string
+	// Reversible is set when the migration has a down section.
+	Reversible bool
+}
+
+// MigrationStatus pairs an embedded migration with its record in the
+// database. Applied versions this binary does not embed have only Version and
+// the applied fields set.
+type MigrationStatus struct {
+	Migration
+	Applied         bool
+	AppliedAt       *time.Time
+	AppliedChecksum string
+	Unknown         bool
+}
+
+// Modified reports whether the migration file changed after it was applied.
+func (m MigrationStatus) Modified() bool {
+	return m.Applied && !m.Unknown && m.AppliedChecksum != m.Checksum
+}
+
 // Filter and pagination types.
 
 type RepositoryFilters struct {
 	Registries    []string
 	Architectures []string
-	Search        string
-	ShowUntagged  bool
+	// OperatingSystems matches repositories with images for any platform of
+	// the operating system, such as linux or windows.
+	OperatingSystems []string
+	Search           string
+	ShowUntagged     bool
+	// Scope, when not nil, limits the listing to the namespaces it names.
+	Scope NamespaceScope
+
+	// Namespaces matches a namespace or any namespace nested below it.
+	Namespaces []string
+	// Kind is one of the tag kinds; "image" also matches multi-platform indexes.
+	Kind         string
+	MinSizeBytes *int64
+	MaxSizeBytes *int64
+	// UpdatedAfter keeps repositories with an image created at or after the
+	// time; UpdatedBefore keeps those whose images are all older.
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	Labels        []LabelFilter
+}
+
+// NamespaceScope lists, by registry host, the namespaces a query may read.
+// A nil scope reads everything and an empty one nothing.
+type NamespaceScope map[string][]string
+
+// LabelFilter matches an image config label. An empty Value only requires
+// the label to be set.
+type LabelFilter struct {
+	Key   string
+	Value string
 }
 
 type TagFilter struct {
 	SortBy string
 	Name   string
+	// AsOf rebuilds the tag set from tag history at the given time.
+	AsOf *time.Time
 }
 
 type ScrollPagination struct {
@@ -178,3 +550,54 @@ type ScrollResult struct {
 	NextPage     *int
 	PreviousPage *int
 }
+
+// TagPage is a page of tags listed by keyset. Next is the cursor of the
+// following page, empty on the last one.
+type TagPage struct {
+	Tags       []TagView
+	TotalCount int
+	Next       string
+}
+
+// RepositoryPage is a page of repositories listed by keyset. Next is the
+// cursor of the following page, empty on the last one.
+type RepositoryPage struct {
+	Repositories []RepositoryView
+	TotalCount   int
+	Next         string
+}
+
+// SearchDocument is the searchable text of a tag besides its name and path,
+// taken from OCI labels and annotations or Helm chart metadata.
+type SearchDocument struct {
+	Title       string
+	Description string
+	Source      string
+	Vendor      string
+	ChartName   string
+	ChartDesc   string
+}
+
+// SnippetPart is a piece of a search snippet; Match marks highlighted words.
+type SnippetPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// SearchMatch explains why a repository matched: the tag (empty for the
+// repository itself) and field that matched, with the matching words marked.
+type SearchMatch struct {
+	Tag     string        `json:"tag,omitempty"`
+	Field   string        `json:"field"`
+	Snippet []SnippetPart `json:"snippet"`
+}
+
+type SearchHit struct {
+	RepositoryID uint    `json:"repositoryId"`
+	Registry     string  `json:"registry"`
+	RegistryHost string  `json:"registryHost"`
+	Namespace    string  `json:"namespace"`
+	Name         string  `json:"name"`
+	Rank         float64 `json:"rank"`
+	SearchMatch
+}
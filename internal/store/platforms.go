@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// RefreshRepositoryPlatforms rebuilds the platforms a repository's tags
+// provide. It runs after tags are saved or deleted, once their manifests and
+// index links are in place.
+func (s *Store) RefreshRepositoryPlatforms(ctx context.Context, repoID uint) error {
+	if _, err := s.exec(ctx, "DELETE FROM repository_platforms WHERE repo_id = ?", repoID); err != nil {
+		return fmt.Errorf("clear repository platforms %d: %w", repoID, err)
+	}
+	_, err := s.exec(ctx,
+		`INSERT INTO repository_platforms (repo_id, os, architecture, variant, tag_count)
+		SELECT repo_id, os, architecture, variant, COUNT(DISTINCT tag_id)
+		FROM (
+			SELECT t.repo_id, t.id AS tag_id, mp.os, mp.architecture, mp.variant
+			FROM tags t
+			JOIN manifest_platforms mp ON mp.index_digest = t.digest
+			WHERE t.repo_id = ? AND t.kind = 'index'
+			UNION ALL
+			SELECT t.repo_id, t.id, COALESCE(m.os, ''), COALESCE(m.architecture, ''), COALESCE(m.variant, '')
+			FROM tags t
+			JOIN manifests m ON m.digest = t.digest
+			WHERE t.repo_id = ? AND t.kind = 'image'
+		) tag_platforms
+		WHERE architecture != ''
+		GROUP BY repo_id, os, architecture, variant`,
+		repoID, repoID)
+	if err != nil {
+		return fmt.Errorf("refresh repository platforms %d: %w", repoID, err)
+	}
+	return nil
+}
+
+// platformCondition matches repositories_view rows providing the platform
+// named by value, an architecture with an optional "/variant" as shown in the
+// architecture filter. An architecture alone only matches images without a
+// variant.
+func platformCondition(value string) (string, []any) {
+	arch, variant, _ := strings.Cut(value, "/")
+	return "EXISTS (SELECT 1 FROM repository_platforms rp WHERE rp.repo_id = repositories_view.id AND rp.architecture = ? AND rp.variant = ?)",
+		[]any{arch, variant}
+}
+
+// platformLabel is the SQL for an architecture with its variant, as the
+// filters and charts show it.
+const platformLabel = "CASE WHEN variant != '' THEN architecture || '/' || variant ELSE architecture END"
@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadPoolSeesCommittedWrites(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, filepath.Join(t.TempDir(), "ui.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	if s.readDB == nil {
+		t.Fatal("expected a file database to get a read pool")
+	}
+
+	reg, err := s.UpsertRegistryByFields(ctx, "test", "https://test.io", "test.io", 200)
+	if err != nil {
+		t.Fatalf("UpsertRegistryByFields: %v", err)
+	}
+	if _, err := s.readDB.ExecContext(ctx, "DELETE FROM registries"); err == nil {
+		t.Fatal("expected the read pool to reject writes")
+	}
+	got, err := s.GetRegistryByHost(ctx, "test.io")
+	if err != nil || got.ID != reg.ID {
+		t.Fatalf("expected the read pool to see registry %d, got %+v, %v", reg.ID, got, err)
+	}
+}
+
+// BenchmarkTagPageDuringSync measures a repository page query while sync
+// workers keep writing, with reads sharing the writer connection as they did
+// before the read pool, and with the pool.
+func BenchmarkTagPageDuringSync(b *testing.B) {
+	for _, shared := range []bool{true, false} {
+		name := "read-pool"
+		if shared {
+			name = "shared-writer"
+		}
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			s, err := New(ctx, filepath.Join(b.TempDir(), "ui.db"))
+			if err != nil {
+				b.Fatalf("New: %v", err)
+			}
+			defer s.Close()
+			if shared {
+				_ = s.readDB.Close()
+				s.readDB = nil
+			}
+
+			repoID := seedBenchmarkRepository(b, s, 200)
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for worker := range 20 {
+				wg.Go(func() {
+					for i := 0; ; i++ {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						digest := fmt.Sprintf("sha256:sync-%d-%d", worker, i)
+						_ = s.WithinTx(ctx, func(tx *Store) error {
+							if _, err := tx.UpsertManifestByFields(ctx, digest, "application/json", "image", "{}", "", "linux", "amd64", "", 1, nil); err != nil {
+								return err
+							}
+							// A persisted tag spends a moment on several statements.
+							time.Sleep(time.Millisecond)
+							_, err := tx.UpsertTagWithSync(ctx, repoID, fmt.Sprintf("sync-%d", worker), digest, "image", "application/json", 1)
+							return err
+						})
+					}
+				})
+			}
+
+			for b.Loop() {
+				if _, err := s.GetTagsForRepository(ctx, repoID, TagFilter{SortBy: "newest"}, ScrollPagination{Page: 1, PageSize: 30}); err != nil {
+					b.Fatalf("GetTagsForRepository: %v", err)
+				}
+			}
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+func seedBenchmarkRepository(b *testing.B, s *Store, tags int) uint {
+	b.Helper()
+	ctx := context.Background()
+	reg, err := s.UpsertRegistryByFields(ctx, "bench", "https://bench.io", "bench.io", 200)
+	if err != nil {
+		b.Fatalf("UpsertRegistryByFields: %v", err)
+	}
+	repo, err := s.UpsertRepositoryByFields(ctx, reg.ID, "team", "app")
+	if err != nil {
+		b.Fatalf("UpsertRepositoryByFields: %v", err)
+	}
+	err = s.WithinTx(ctx, func(tx *Store) error {
+		for i := range tags {
+			digest := fmt.Sprintf("sha256:seed-%d", i)
+			if _, err := tx.UpsertManifestByFields(ctx, digest, "application/json", "image", "{}", "", "linux", "amd64", "", int64(i), nil); err != nil {
+				return err
+			}
+			if _, err := tx.UpsertTagWithSync(ctx, repo.ID, fmt.Sprintf("v%d", i), digest, "image", "application/json", 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatalf("seed: %v", err)
+	}
+	return repo.ID
+}
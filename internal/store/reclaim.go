@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// imageBlobsFrom selects the distinct blobs of the manifests listed in the
+// manifest_digest column of images, the same way repository_blobs does.
+func imageBlobsFrom(images string) string {
+	return `
+		SELECT l.digest, l.size_bytes
+		FROM ` + images + ` i
+		JOIN manifest_layers ml ON ml.manifest_digest = i.manifest_digest
+		JOIN layers l ON l.digest = ml.layer_digest
+		UNION
+		SELECT cb.digest, cb.size_bytes
+		FROM ` + images + ` i
+		JOIN manifests m ON m.digest = i.manifest_digest
+		JOIN config_blobs cb ON cb.digest = m.config_digest
+		UNION
+		SELECT m.digest, m.size_bytes
+		FROM ` + images + ` i
+		JOIN manifests m ON m.digest = i.manifest_digest
+		WHERE m.kind != 'index'
+			AND NOT EXISTS (SELECT 1 FROM manifest_layers ml WHERE ml.manifest_digest = m.digest)`
+}
+
+// reclaimEstimateQuery compares the blobs of the candidate manifests no kept
+// tag references with those of the tags that stay in the registry. It expects candidate_manifests
+// (digest) and kept_tags (digest) CTEs to precede it.
+var reclaimEstimateQuery = `
+	candidate_images AS (
+		SELECT digest AS manifest_digest FROM candidate_manifests
+		UNION
+		SELECT mp.platform_digest FROM candidate_manifests cm
+		JOIN manifest_platforms mp ON mp.index_digest = cm.digest
+	),
+	kept_images AS (
+		SELECT digest AS manifest_digest FROM kept_tags
+		UNION
+		SELECT mp.platform_digest FROM kept_tags kt
+		JOIN manifest_platforms mp ON mp.index_digest = kt.digest
+	),
+	freed_images AS (
+		SELECT ci.manifest_digest FROM candidate_images ci
+		JOIN manifests m ON m.digest = ci.manifest_digest
+		WHERE NOT EXISTS (SELECT 1 FROM kept_images ki WHERE ki.manifest_digest = ci.manifest_digest)
+	),
+	candidate_blobs AS (` + imageBlobsFrom("freed_images") + `
+	),
+	kept_blobs AS (` + imageBlobsFrom("kept_images") + `
+	),
+	freed_blobs AS (
+		SELECT cb.digest, cb.size_bytes,
+			CASE WHEN EXISTS (SELECT 1 FROM kept_blobs kb WHERE kb.digest = cb.digest) THEN 0 ELSE 1 END AS freed
+		FROM candidate_blobs cb
+	)
+	SELECT
+		(SELECT COUNT(*) FROM freed_images),
+		COALESCE(SUM(freed), 0),
+		COALESCE(SUM(CASE WHEN freed = 1 THEN size_bytes ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN freed = 0 THEN size_bytes ELSE 0 END), 0)
+	FROM freed_blobs`
+
+// EstimateTagDeletion reports what a garbage collection would free after the
+// named tags of a repository are deleted. Deleting a tag deletes its manifest,
+// so every tag of the repository sharing that digest goes with it; blobs still
+// referenced by any other tag in the registry are retained.
+func (s *Store) EstimateTagDeletion(ctx context.Context, repoID uint, tagNames []string) (*ReclaimEstimate, error) {
+	if len(tagNames) == 0 {
+		return &ReclaimEstimate{}, nil
+	}
+
+	args := []any{repoID}
+	for _, name := range tagNames {
+		args = append(args, name)
+	}
+	args = append(args, repoID, repoID)
+
+	var est ReclaimEstimate
+	err := s.queryRow(ctx, `
+		WITH candidate_manifests AS (
+			SELECT DISTINCT digest FROM tags
+			WHERE repo_id = ? AND name IN (`+strings.Repeat("?,", len(tagNames)-1)+`?)
+		),
+		kept_tags AS (
+			SELECT t.digest FROM tags t
+			JOIN repositories r ON r.id = t.repo_id
+			WHERE r.registry_id = (SELECT registry_id FROM repositories WHERE id = ?)
+				AND NOT (t.repo_id = ? AND t.digest IN (SELECT digest FROM candidate_manifests))
+		),`+reclaimEstimateQuery, args...).
+		Scan(&est.Manifests, &est.Blobs, &est.ReclaimableBytes, &est.RetainedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("estimate tag deletion: %w", err)
+	}
+	return &est, nil
+}
+
+// GetReclaimableStorage reports what a garbage collection of the registry
+// would free now: manifests its tags pointed to in the past, deleted or moved
+// away from, that no current tag references, and the blobs only they use.
+func (s *Store) GetReclaimableStorage(ctx context.Context, host string) (*ReclaimEstimate, error) {
+	var est ReclaimEstimate
+	err := s.queryRow(ctx, `
+		WITH registry_repos AS (
+			SELECT r.id FROM repositories r
+			JOIN registries reg ON reg.id = r.registry_id
+			WHERE reg.host = ?
+		),
+		candidate_manifests AS (
+			SELECT te.old_digest AS digest FROM tag_events te
+			WHERE te.repo_id IN (SELECT id FROM registry_repos) AND te.old_digest != ''
+			UNION
+			SELECT te.new_digest FROM tag_events te
+			WHERE te.repo_id IN (SELECT id FROM registry_repos) AND te.new_digest != ''
+		),
+		kept_tags AS (
+			SELECT t.digest FROM tags t WHERE t.repo_id IN (SELECT id FROM registry_repos)
+		),`+reclaimEstimateQuery, host).
+		Scan(&est.Manifests, &est.Blobs, &est.ReclaimableBytes, &est.RetainedBytes)
+	if err != nil {
+		return nil, fmt.Errorf("get reclaimable storage %s: %w", host, err)
+	}
+	return &est, nil
+}
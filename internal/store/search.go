@@ -0,0 +1,412 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxSearchTerms bounds how many words of a query are matched.
+	maxSearchTerms = 8
+	// repositorySearchLimit caps the documents considered when the explore
+	// page narrows repositories by a search.
+	repositorySearchLimit = 500
+	// fallbackSearchScan caps the documents ranked in Go when SQLite lacks FTS5.
+	fallbackSearchScan = 1000
+
+	snippetWordsBefore = 4
+	snippetWords       = 16
+)
+
+// Fields a search can match on, as reported in SearchMatch.Field.
+const (
+	SearchFieldPath             = "path"
+	SearchFieldTag              = "tag"
+	SearchFieldChartName        = "chartName"
+	SearchFieldTitle            = "title"
+	SearchFieldDescription      = "description"
+	SearchFieldChartDescription = "chartDescription"
+	SearchFieldSource           = "source"
+	SearchFieldVendor           = "vendor"
+)
+
+// searchPathExpr renders a repository as registry-host/namespace/name.
+const searchPathExpr = `reg.host || '/' || CASE WHEN r.namespace = '' THEN r.name ELSE r.namespace || '/' || r.name END`
+
+// searchIndexDDL builds an FTS5 index over search_documents and keeps it in
+// step through triggers. bm25 weights in searchFTS5 follow this column order.
+const searchIndexDDL = `
+CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+	path, tag, title, description, source, vendor, chart_name, chart_desc,
+	content='search_documents', content_rowid='id', prefix='2 3'
+);
+CREATE TRIGGER IF NOT EXISTS search_documents_ai AFTER INSERT ON search_documents BEGIN
+	INSERT INTO search_index (rowid, path, tag, title, description, source, vendor, chart_name, chart_desc)
+	VALUES (new.id, new.path, new.tag, new.title, new.description, new.source, new.vendor, new.chart_name, new.chart_desc);
+END;
+CREATE TRIGGER IF NOT EXISTS search_documents_ad AFTER DELETE ON search_documents BEGIN
+	INSERT INTO search_index (search_index, rowid, path, tag, title, description, source, vendor, chart_name, chart_desc)
+	VALUES ('delete', old.id, old.path, old.tag, old.title, old.description, old.source, old.vendor, old.chart_name, old.chart_desc);
+END;
+CREATE TRIGGER IF NOT EXISTS search_documents_au AFTER UPDATE ON search_documents BEGIN
+	INSERT INTO search_index (search_index, rowid, path, tag, title, description, source, vendor, chart_name, chart_desc)
+	VALUES ('delete', old.id, old.path, old.tag, old.title, old.description, old.source, old.vendor, old.chart_name, old.chart_desc);
+	INSERT INTO search_index (rowid, path, tag, title, description, source, vendor, chart_name, chart_desc)
+	VALUES (new.id, new.path, new.tag, new.title, new.description, new.source, new.vendor, new.chart_name, new.chart_desc);
+END;`
+
+// ensureSearchIndex sets up the FTS5 index when SQLite was compiled with it
+// (go-sqlite3's sqlite_fts5 build tag) and reports whether it is available.
+// Without FTS5 the triggers are dropped so writes keep working, and search
+// falls back to LIKE over the same documents; the index is rebuilt the next
+// time a binary with FTS5 opens the database.
+func ensureSearchIndex(ctx context.Context, db *sql.DB) (bool, error) {
+	var enabled bool
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return false, fmt.Errorf("detect fts5: %w", err)
+	}
+	if !enabled {
+		for _, trigger := range []string{"search_documents_ai", "search_documents_ad", "search_documents_au"} {
+			if _, err := db.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger); err != nil {
+				return false, fmt.Errorf("drop search trigger %s: %w", trigger, err)
+			}
+		}
+		return false, nil
+	}
+
+	var maintained int
+	if err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'search_documents_ai'").Scan(&maintained); err != nil {
+		return false, fmt.Errorf("check search index: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, searchIndexDDL); err != nil {
+		return false, fmt.Errorf("create search index: %w", err)
+	}
+	if maintained == 0 {
+		if _, err := db.ExecContext(ctx, "INSERT INTO search_index (search_index) VALUES ('rebuild')"); err != nil {
+			return false, fmt.Errorf("rebuild search index: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// indexRepositoryPath keeps the repository's own search document, which finds
+// it by path before any of its tags are indexed.
+func (s *Store) indexRepositoryPath(ctx context.Context, repoID uint) error {
+	_, err := s.exec(ctx,
+		`INSERT INTO search_documents (repo_id, path)
+		 SELECT r.id, `+searchPathExpr+`
+		 FROM repositories r JOIN registries reg ON reg.id = r.registry_id
+		 WHERE r.id = ?
+		 ON CONFLICT(repo_id) WHERE tag_id IS NULL DO UPDATE SET path=excluded.path`,
+		repoID)
+	if err != nil {
+		return fmt.Errorf("index repository %d for search: %w", repoID, err)
+	}
+	return nil
+}
+
+// IndexTagSearch stores the searchable text of a tag. The document goes away
+// with the tag.
+func (s *Store) IndexTagSearch(ctx context.Context, repoID uint, tagName string, doc SearchDocument) error {
+	_, err := s.exec(ctx,
+		`INSERT INTO search_documents (repo_id, tag_id, path, tag, title, description, source, vendor, chart_name, chart_desc)
+		 SELECT t.repo_id, t.id, `+searchPathExpr+`, t.name, ?, ?, ?, ?, ?, ?
+		 FROM tags t
+		 JOIN repositories r ON r.id = t.repo_id
+		 JOIN registries reg ON reg.id = r.registry_id
+		 WHERE t.repo_id = ? AND t.name = ?
+		 ON CONFLICT(tag_id) DO UPDATE SET
+			path=excluded.path, tag=excluded.tag, title=excluded.title, description=excluded.description,
+			source=excluded.source, vendor=excluded.vendor, chart_name=excluded.chart_name, chart_desc=excluded.chart_desc`,
+		doc.Title, doc.Description, doc.Source, doc.Vendor, doc.ChartName, doc.ChartDesc, repoID, tagName)
+	if err != nil {
+		return fmt.Errorf("index tag %d/%s for search: %w", repoID, tagName, err)
+	}
+	return nil
+}
+
+// searchRow is a matched document with the repository it belongs to.
+type searchRow struct {
+	hit    SearchHit
+	fields [8]string
+}
+
+// searchFieldOrder lists document fields from most to least telling; the
+// snippet comes from the first one that matches. Indexes refer to searchRow.fields.
+var searchFieldOrder = []struct {
+	name   string
+	index  int
+	weight float64
+}{
+	{SearchFieldPath, 0, 10},
+	{SearchFieldTag, 1, 6},
+	{SearchFieldChartName, 6, 8},
+	{SearchFieldTitle, 2, 4},
+	{SearchFieldDescription, 3, 2},
+	{SearchFieldChartDescription, 7, 2},
+	{SearchFieldSource, 4, 1},
+	{SearchFieldVendor, 5, 1},
+}
+
+// Search finds repositories and tags whose path, name, labels or chart
+// metadata contain every word of query, each matched as a prefix. Hits are
+// ranked best first and carry a highlighted snippet.
+func (s *Store) Search(ctx context.Context, query string, limit int) ([]SearchHit, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	var rows []searchRow
+	var err error
+	switch {
+	case s.dialect.postgres():
+		rows, err = s.searchPostgres(ctx, terms, limit)
+	case s.dialect.fts5:
+		rows, err = s.searchFTS5(ctx, terms, limit)
+	default:
+		rows, err = s.searchLike(ctx, terms, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]SearchHit, 0, len(rows))
+	for _, row := range rows {
+		row.hit.SearchMatch = matchDocument(row.fields, row.hit.Tag, terms)
+		hits = append(hits, row.hit)
+	}
+	return hits, nil
+}
+
+const searchSelect = `SELECT d.repo_id, reg.name, reg.host, r.namespace, r.name,
+	d.path, d.tag, d.title, d.description, d.source, d.vendor, d.chart_name, d.chart_desc, %s AS rank
+	FROM %s
+	JOIN repositories r ON r.id = d.repo_id
+	JOIN registries reg ON reg.id = r.registry_id
+	WHERE %s`
+
+func (s *Store) searchFTS5(ctx context.Context, terms []string, limit int) ([]searchRow, error) {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+	query := fmt.Sprintf(searchSelect,
+		"-bm25(search_index, 10.0, 6.0, 4.0, 2.0, 1.0, 1.0, 8.0, 2.0)",
+		"search_index JOIN search_documents d ON d.id = search_index.rowid",
+		"search_index MATCH ?") + " ORDER BY rank DESC, d.path, d.tag LIMIT ?"
+	return s.querySearchRows(ctx, query, strings.Join(quoted, " "), limit)
+}
+
+func (s *Store) searchPostgres(ctx context.Context, terms []string, limit int) ([]searchRow, error) {
+	prefixed := make([]string, len(terms))
+	for i, term := range terms {
+		prefixed[i] = term + ":*"
+	}
+	tsquery := strings.Join(prefixed, " & ")
+	query := fmt.Sprintf(searchSelect,
+		"ts_rank(d.document, to_tsquery('simple', ?))",
+		"search_documents d",
+		"d.document @@ to_tsquery('simple', ?)") + " ORDER BY rank DESC, d.path, d.tag LIMIT ?"
+	return s.querySearchRows(ctx, query, tsquery, tsquery, limit)
+}
+
+// searchLike serves SQLite builds without FTS5. Every term must appear in some
+// field; ranking happens in Go with the same field weights.
+func (s *Store) searchLike(ctx context.Context, terms []string, limit int) ([]searchRow, error) {
+	conds := make([]string, len(terms))
+	var args []any
+	for i, term := range terms {
+		conds[i] = `(d.path LIKE ? OR d.tag LIKE ? OR d.title LIKE ? OR d.description LIKE ?
+			OR d.source LIKE ? OR d.vendor LIKE ? OR d.chart_name LIKE ? OR d.chart_desc LIKE ?)`
+		pattern := "%" + term + "%"
+		for range 8 {
+			args = append(args, pattern)
+		}
+	}
+	args = append(args, fallbackSearchScan)
+	query := fmt.Sprintf(searchSelect, "0.0", "search_documents d", strings.Join(conds, " AND ")) +
+		" ORDER BY d.path, d.tag LIMIT ?"
+
+	rows, err := s.querySearchRows(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		rows[i].hit.Rank = likeRank(rows[i].fields, terms)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].hit.Rank > rows[j].hit.Rank })
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+func (s *Store) querySearchRows(ctx context.Context, query string, args ...any) ([]searchRow, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search documents: %w", err)
+	}
+	defer closeRows(rows)
+
+	var out []searchRow
+	for rows.Next() {
+		var row searchRow
+		h := &row.hit
+		f := &row.fields
+		if err := rows.Scan(&h.RepositoryID, &h.Registry, &h.RegistryHost, &h.Namespace, &h.Name,
+			&f[0], &f[1], &f[2], &f[3], &f[4], &f[5], &f[6], &f[7], &h.Rank); err != nil {
+			return nil, fmt.Errorf("scan search document: %w", err)
+		}
+		h.Tag = f[1]
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// searchRepositories runs a search for the explore page and keeps the best
+// hit of each repository, in rank order.
+func (s *Store) searchRepositories(ctx context.Context, query string) ([]uint, map[uint]*SearchMatch, error) {
+	hits, err := s.Search(ctx, query, repositorySearchLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	order := make([]uint, 0, len(hits))
+	matches := make(map[uint]*SearchMatch, len(hits))
+	for i := range hits {
+		id := hits[i].RepositoryID
+		if _, seen := matches[id]; seen {
+			continue
+		}
+		order = append(order, id)
+		matches[id] = &hits[i].SearchMatch
+	}
+	return order, matches, nil
+}
+
+// searchTerms lowercases query and splits it into words the way the FTS5
+// unicode61 tokenizer does, so only letters and digits reach the index.
+func searchTerms(query string) []string {
+	terms := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+func likeRank(fields [8]string, terms []string) float64 {
+	var rank float64
+	for _, f := range searchFieldOrder {
+		text := strings.ToLower(fields[f.index])
+		for _, term := range terms {
+			if strings.Contains(text, term) {
+				rank += f.weight
+			}
+		}
+	}
+	return rank
+}
+
+// matchDocument picks the field to show for a hit and highlights it.
+func matchDocument(fields [8]string, tag string, terms []string) SearchMatch {
+	for _, f := range searchFieldOrder {
+		if parts, ok := snippet(fields[f.index], terms); ok {
+			return SearchMatch{Tag: tag, Field: f.name, Snippet: parts}
+		}
+	}
+	return SearchMatch{Tag: tag, Field: SearchFieldPath, Snippet: []SnippetPart{{Text: fields[0]}}}
+}
+
+type snippetToken struct {
+	text  string
+	word  bool
+	match bool
+}
+
+// snippet cuts text to a window of words around its first match and marks
+// every word starting with one of terms. It reports false when nothing matches.
+func snippet(text string, terms []string) ([]SnippetPart, bool) {
+	tokens := tokenizeSnippet(text, terms)
+
+	var words []int
+	first := -1
+	for i, tok := range tokens {
+		if !tok.word {
+			continue
+		}
+		if tok.match && first < 0 {
+			first = len(words)
+		}
+		words = append(words, i)
+	}
+	if first < 0 {
+		return nil, false
+	}
+
+	startWord := max(first-snippetWordsBefore, 0)
+	endWord := min(startWord+snippetWords, len(words))
+	from := words[startWord]
+	to := len(tokens)
+	if endWord < len(words) {
+		to = words[endWord]
+	}
+
+	var parts []SnippetPart
+	add := func(s string, match bool) {
+		if n := len(parts); n > 0 && !match && !parts[n-1].Match {
+			parts[n-1].Text += s
+			return
+		}
+		parts = append(parts, SnippetPart{Text: s, Match: match})
+	}
+	if startWord > 0 {
+		add("…", false)
+	}
+	for _, tok := range tokens[from:to] {
+		add(tok.text, tok.match)
+	}
+	if to < len(tokens) {
+		parts[len(parts)-1].Text = strings.TrimRightFunc(parts[len(parts)-1].Text, unicode.IsSpace)
+		add("…", false)
+	}
+	return parts, true
+}
+
+func tokenizeSnippet(text string, terms []string) []snippetToken {
+	var tokens []snippetToken
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	start := 0
+	for start < len(text) {
+		end := start
+		first, _ := utf8.DecodeRuneInString(text[start:])
+		word := isWord(first)
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if isWord(r) != word {
+				break
+			}
+			end += size
+		}
+		tok := snippetToken{text: text[start:end], word: word}
+		if word {
+			lower := strings.ToLower(tok.text)
+			for _, term := range terms {
+				if strings.HasPrefix(lower, term) {
+					tok.match = true
+					break
+				}
+			}
+		}
+		tokens = append(tokens, tok)
+		start = end
+	}
+	return tokens
+}
@@ -0,0 +1,59 @@
+//go:build sqlite_fts5
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestSearchFTS5Ranking runs only in builds with FTS5 (task test:fts5), where
+// search goes through the bm25 ranked query rather than the LIKE fallback.
+func TestSearchFTS5Ranking(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, filepath.Join(t.TempDir(), "ui.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	if !s.dialect.fts5 {
+		t.Fatal("expected the sqlite_fts5 build to maintain the search index")
+	}
+
+	reg, err := s.UpsertRegistryByFields(ctx, "test", "https://test.io", "test.io", 200)
+	if err != nil {
+		t.Fatalf("UpsertRegistryByFields: %v", err)
+	}
+	index := func(name, title, description string) uint {
+		t.Helper()
+		repo, err := s.UpsertRepositoryByFields(ctx, reg.ID, "lib", name)
+		if err != nil {
+			t.Fatalf("UpsertRepositoryByFields: %v", err)
+		}
+		if _, err := s.UpsertTagWithSync(ctx, repo.ID, "v1", "sha256:"+name, "image", "app/json", 1.0); err != nil {
+			t.Fatalf("UpsertTagWithSync: %v", err)
+		}
+		if err := s.IndexTagSearch(ctx, repo.ID, "v1", SearchDocument{Title: title, Description: description}); err != nil {
+			t.Fatalf("IndexTagSearch: %v", err)
+		}
+		return repo.ID
+	}
+	// aardvark sorts first by path, so only ranking puts the title match ahead.
+	described := index("aardvark", "Proxy", "A proxy in front of a widget store")
+	titled := index("zebra", "Widget", "Serves things")
+
+	hits, err := s.Search(ctx, "widg", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("expected 2 hits, got %+v", hits)
+	}
+	if hits[0].RepositoryID != titled || hits[0].Field != SearchFieldTitle || hits[1].RepositoryID != described {
+		t.Fatalf("expected the title match to outrank the description match, got %+v", hits)
+	}
+	if hits[0].Rank <= hits[1].Rank {
+		t.Fatalf("expected a higher bm25 rank first, got %v then %v", hits[0].Rank, hits[1].Rank)
+	}
+}
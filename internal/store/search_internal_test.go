@@ -0,0 +1,58 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenizeSnippet(t *testing.T) {
+	t.Helper()
+
+	tests := []struct {
+		name    string
+		text    string
+		words   []string
+		matched []string
+	}{
+		{name: "ascii", text: "In front of Postgres.", words: []string{"In", "front", "of", "Postgres"}, matched: []string{"Postgres"}},
+		{name: "multibyte", text: "café über postgres", words: []string{"café", "über", "postgres"}, matched: []string{"postgres"}},
+		{name: "invalid utf-8", text: "caf\xe9 postgres\xff", words: []string{"caf", "postgres"}, matched: []string{"postgres"}},
+		{name: "trailing invalid byte", text: "postgres\xc3", words: []string{"postgres"}, matched: []string{"postgres"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens := tokenizeSnippet(test.text, []string{"postg"})
+			var joined strings.Builder
+			var words, matched []string
+			for _, tok := range tokens {
+				joined.WriteString(tok.text)
+				if tok.word {
+					words = append(words, tok.text)
+				}
+				if tok.match {
+					matched = append(matched, tok.text)
+				}
+			}
+			if joined.String() != test.text {
+				t.Fatalf("expected tokens to cover %q, got %q", test.text, joined.String())
+			}
+			if strings.Join(words, "|") != strings.Join(test.words, "|") {
+				t.Fatalf("expected words %q, got %q", test.words, words)
+			}
+			if strings.Join(matched, "|") != strings.Join(test.matched, "|") {
+				t.Fatalf("expected matches %q, got %q", test.matched, matched)
+			}
+		})
+	}
+}
+
+func BenchmarkSnippetLongDescription(b *testing.B) {
+	text := strings.Repeat("A chart that deploys a highly available cluster. ", 2000) + "Backed by Postgres."
+	terms := []string{"postg"}
+	for b.Loop() {
+		if _, ok := snippet(text, terms); !ok {
+			b.Fatal("expected a match")
+		}
+	}
+}
@@ -18,11 +18,11 @@ func (s *Store) Seed(ctx context.Context) error {
 func seedData(s *Store) error {
 	ctx := context.Background()
 	if _, err := s.exec(ctx,
-		"INSERT OR IGNORE INTO registries (name, host, url, status) VALUES ('dockerhub', 'registry-1.docker.io', 'https://registry-1.docker.io', 1)"); err != nil {
+		"INSERT INTO registries (name, host, url, status) VALUES ('dockerhub', 'registry-1.docker.io', 'https://registry-1.docker.io', 1) ON CONFLICT DO NOTHING"); err != nil {
 		return err
 	}
 	if _, err := s.exec(ctx,
-		"INSERT OR IGNORE INTO registries (name, host, url, status) VALUES ('ghcr', 'ghcr.io', 'https://ghcr.io', 1)"); err != nil {
+		"INSERT INTO registries (name, host, url, status) VALUES ('ghcr', 'ghcr.io', 'https://ghcr.io', 1) ON CONFLICT DO NOTHING"); err != nil {
 		return err
 	}
 
@@ -41,8 +41,8 @@ func seedData(s *Store) error {
 			return err
 		}
 		if _, err := s.exec(ctx,
-			`INSERT OR IGNORE INTO repositories (registry_id, namespace, name, last_sync_at)
-			 VALUES (?, ?, ?, ?)`, regID, repo.ns, repo.name, now); err != nil {
+			`INSERT INTO repositories (registry_id, namespace, name, last_sync_at)
+			 VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`, regID, repo.ns, repo.name, now); err != nil {
 			return err
 		}
 	}
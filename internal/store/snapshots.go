@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Snapshot retention: every snapshot of the current hour, then the last one
+// of each hour for a week, then the last one of each day for a year.
+const (
+	snapshotHourlyRetention = 7 * 24 * time.Hour
+	snapshotDailyRetention  = 365 * 24 * time.Hour
+)
+
+// snapshotTotalsQuery sums the distinct blobs, repositories and tags of each
+// registry, or of each namespace when %[1]s names the namespace column.
+const snapshotTotalsQuery = `
+	WITH blobs AS (
+		SELECT DISTINCT r.registry_id%[1]s, rb.digest, rb.size_bytes
+		FROM repository_blobs rb
+		JOIN repositories r ON r.id = rb.repo_id
+	),
+	sizes AS (
+		SELECT r.registry_id%[1]s, SUM(r.size_bytes) AS size_bytes
+		FROM blobs r GROUP BY r.registry_id%[1]s
+	),
+	repo_counts AS (
+		SELECT r.registry_id%[1]s, COUNT(*) AS repository_count
+		FROM repositories r GROUP BY r.registry_id%[1]s
+	),
+	tag_counts AS (
+		SELECT r.registry_id%[1]s, COUNT(*) AS tag_count
+		FROM tags t JOIN repositories r ON r.id = t.repo_id
+		GROUP BY r.registry_id%[1]s
+	)
+	SELECT r.registry_id%[1]s, COALESCE(sz.size_bytes, 0), r.repository_count, COALESCE(tc.tag_count, 0)
+	FROM repo_counts r
+	LEFT JOIN sizes sz ON sz.registry_id = r.registry_id%[2]s
+	LEFT JOIN tag_counts tc ON tc.registry_id = r.registry_id%[3]s`
+
+type storageSnapshot struct {
+	registryID uint
+	// namespace is nil for registry totals.
+	namespace       *string
+	sizeBytes       int64
+	repositoryCount int
+	tagCount        int
+}
+
+// RecordStorageSnapshots stores the current size, repository and tag counts
+// of every registry and of every namespace in it.
+func (s *Store) RecordStorageSnapshots(ctx context.Context, syncRunID int64, takenAt time.Time) error {
+	var runID *int64
+	if syncRunID != 0 {
+		runID = &syncRunID
+	}
+
+	registries, err := s.storageSnapshotTotals(ctx, fmt.Sprintf(snapshotTotalsQuery, "", "", ""), false)
+	if err != nil {
+		return fmt.Errorf("total registry storage: %w", err)
+	}
+	namespaces, err := s.storageSnapshotTotals(ctx, fmt.Sprintf(snapshotTotalsQuery,
+		", r.namespace", " AND sz.namespace = r.namespace", " AND tc.namespace = r.namespace"), true)
+	if err != nil {
+		return fmt.Errorf("total namespace storage: %w", err)
+	}
+
+	for _, snap := range append(registries, namespaces...) {
+		if _, err := s.exec(ctx,
+			`INSERT INTO storage_snapshots (registry_id, namespace, sync_run_id, taken_at, size_bytes, repository_count, tag_count)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			snap.registryID, snap.namespace, runID, takenAt, snap.sizeBytes, snap.repositoryCount, snap.tagCount); err != nil {
+			return fmt.Errorf("insert storage snapshot for registry %d: %w", snap.registryID, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) storageSnapshotTotals(ctx context.Context, query string, byNamespace bool) ([]storageSnapshot, error) {
+	rows, err := s.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows)
+
+	var result []storageSnapshot
+	for rows.Next() {
+		var snap storageSnapshot
+		dest := []any{&snap.registryID}
+		var namespace string
+		if byNamespace {
+			dest = append(dest, &namespace)
+		}
+		dest = append(dest, &snap.sizeBytes, &snap.repositoryCount, &snap.tagCount)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if byNamespace {
+			snap.namespace = &namespace
+		}
+		result = append(result, snap)
+	}
+	return result, rows.Err()
+}
+
+// PruneStorageSnapshots thins snapshots taken before the current hour down to
+// the retention resolution and drops those older than a year.
+func (s *Store) PruneStorageSnapshots(ctx context.Context, now time.Time) (int64, error) {
+	expired, err := s.execCount(ctx,
+		"DELETE FROM storage_snapshots WHERE "+s.dialect.compareTime("taken_at", "<"),
+		now.Add(-snapshotDailyRetention))
+	if err != nil {
+		return 0, fmt.Errorf("drop expired storage snapshots: %w", err)
+	}
+
+	bucket := "CASE WHEN " + s.dialect.compareTime("taken_at", ">=") + " THEN " +
+		s.dialect.truncateTime("taken_at", "hour") + " ELSE " + s.dialect.truncateTime("taken_at", "day") + " END"
+	thinned, err := s.execCount(ctx, `
+		DELETE FROM storage_snapshots WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY registry_id, namespace, `+bucket+`
+					ORDER BY taken_at DESC, id DESC
+				) AS position
+				FROM storage_snapshots
+				WHERE `+s.dialect.compareTime("taken_at", "<")+`
+			) ranked
+			WHERE position > 1
+		)`,
+		now.Add(-snapshotHourlyRetention), now.Truncate(time.Hour))
+	if err != nil {
+		return 0, fmt.Errorf("downsample storage snapshots: %w", err)
+	}
+	return expired + thinned, nil
+}
+
+// GetStorageTrend returns the registry's snapshots since the given time,
+// oldest first.
+func (s *Store) GetStorageTrend(ctx context.Context, host string, since time.Time) ([]StorageSnapshotPoint, error) {
+	rows, err := s.query(ctx,
+		`SELECT ss.taken_at, ss.size_bytes, ss.repository_count, ss.tag_count
+		 FROM storage_snapshots ss
+		 JOIN registries reg ON reg.id = ss.registry_id
+		 WHERE reg.host = ? AND ss.namespace IS NULL AND `+s.dialect.compareTime("ss.taken_at", ">=")+`
+		 ORDER BY ss.taken_at, ss.id`,
+		host, since)
+	if err != nil {
+		return nil, fmt.Errorf("get storage trend %s: %w", host, err)
+	}
+	defer closeRows(rows)
+
+	var points []StorageSnapshotPoint
+	for rows.Next() {
+		var p StorageSnapshotPoint
+		if err := rows.Scan(&p.TakenAt, &p.SizeBytes, &p.RepositoryCount, &p.TagCount); err != nil {
+			return nil, fmt.Errorf("scan storage snapshot: %w", err)
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// GetNamespaceGrowth compares each namespace's size in the registry's first
+// and latest snapshots since the given time, largest growth first. A namespace
+// missing from either snapshot counts as empty there.
+func (s *Store) GetNamespaceGrowth(ctx context.Context, host string, since time.Time) ([]NamespaceGrowthView, error) {
+	rows, err := s.query(ctx,
+		`WITH window_snapshots AS (
+			SELECT ss.namespace, ss.taken_at, ss.size_bytes
+			FROM storage_snapshots ss
+			JOIN registries reg ON reg.id = ss.registry_id
+			WHERE reg.host = ? AND `+s.dialect.compareTime("ss.taken_at", ">=")+`
+		),
+		bounds AS (
+			SELECT MIN(taken_at) AS first_at, MAX(taken_at) AS last_at
+			FROM window_snapshots WHERE namespace IS NULL
+		)
+		SELECT ws.namespace,
+			COALESCE(SUM(CASE WHEN ws.taken_at = b.first_at THEN ws.size_bytes END), 0),
+			COALESCE(SUM(CASE WHEN ws.taken_at = b.last_at THEN ws.size_bytes END), 0)
+		FROM window_snapshots ws
+		CROSS JOIN bounds b
+		WHERE ws.namespace IS NOT NULL
+		GROUP BY ws.namespace`,
+		host, since)
+	if err != nil {
+		return nil, fmt.Errorf("get namespace growth %s: %w", host, err)
+	}
+	defer closeRows(rows)
+
+	var result []NamespaceGrowthView
+	for rows.Next() {
+		var g NamespaceGrowthView
+		if err := rows.Scan(&g.Namespace, &g.StartBytes, &g.EndBytes); err != nil {
+			return nil, fmt.Errorf("scan namespace growth: %w", err)
+		}
+		g.DisplayName = g.Namespace
+		if g.DisplayName == "" {
+			g.DisplayName = "library"
+		}
+		g.GrowthBytes = g.EndBytes - g.StartBytes
+		result = append(result, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].GrowthBytes != result[j].GrowthBytes {
+			return result[i].GrowthBytes > result[j].GrowthBytes
+		}
+		return result[i].Namespace < result[j].Namespace
+	})
+	return result, nil
+}
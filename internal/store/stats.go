@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// repositoryStatsCTEs and repositoryStatsFrom compute repository_stats from
+// scratch for the repositories matching repoFilter, a WHERE clause on
+// repositories r or "" for all of them. The filter must keep whole registries,
+// which unique sizes are counted over. repositoryStatsColumns lists the result
+// in the table's column order.
+func repositoryStatsCTEs(repoFilter string) string {
+	return `
+	WITH stats_repos AS (
+		SELECT r.* FROM repositories r` + repoFilter + `
+	),
+	blob_repos AS (
+		SELECT r.registry_id, rb.digest, COUNT(*) AS repo_count
+		FROM repository_blobs rb
+		JOIN stats_repos r ON r.id = rb.repo_id
+		GROUP BY r.registry_id, rb.digest
+	),
+	repo_size AS (
+		SELECT
+			rb.repo_id,
+			CAST(SUM(rb.size_bytes) AS BIGINT) AS total_size_bytes,
+			CAST(SUM(CASE WHEN br.repo_count = 1 THEN rb.size_bytes ELSE 0 END) AS BIGINT) AS unique_size_bytes
+		FROM repository_blobs rb
+		JOIN stats_repos r ON r.id = rb.repo_id
+		JOIN blob_repos br ON br.registry_id = r.registry_id AND br.digest = rb.digest
+		GROUP BY rb.repo_id
+	),
+	repo_tags AS (
+		SELECT t.repo_id, COUNT(*) AS tags_count
+		FROM tags t
+		JOIN stats_repos r ON r.id = t.repo_id
+		GROUP BY t.repo_id
+	),
+	repo_updated AS (
+		SELECT t.repo_id, MAX(m.created) AS last_updated_at
+		FROM tags t
+		JOIN stats_repos r ON r.id = t.repo_id
+		JOIN manifests m ON m.digest = t.digest
+		GROUP BY t.repo_id
+	),
+	repo_changed AS (
+		SELECT e.repo_id, MAX(e.occurred_at) AS last_changed_at
+		FROM tag_events e
+		JOIN stats_repos r ON r.id = e.repo_id
+		GROUP BY e.repo_id
+	)`
+}
+
+const (
+	repositoryStatsColumns = `
+		r.id,
+		COALESCE(rt.tags_count, 0),
+		COALESCE(rs.total_size_bytes, 0),
+		COALESCE(rs.unique_size_bytes, 0),
+		ru.last_updated_at,
+		rc.last_changed_at`
+	repositoryStatsFrom = `
+	FROM stats_repos r
+	LEFT JOIN repo_tags rt ON rt.repo_id = r.id
+	LEFT JOIN repo_size rs ON rs.repo_id = r.id
+	LEFT JOIN repo_updated ru ON ru.repo_id = r.id
+	LEFT JOIN repo_changed rc ON rc.repo_id = r.id`
+)
+
+// RefreshRepository brings the platforms and statistics of a repository up
+// to date after its tags changed.
+func (s *Store) RefreshRepository(ctx context.Context, repoID uint) error {
+	if err := s.RefreshRepositoryPlatforms(ctx, repoID); err != nil {
+		return err
+	}
+	return s.RefreshRepositoryStats(ctx, repoID)
+}
+
+// RefreshRepositoryStats recounts a repository's tags, total size and
+// timestamps. Its unique size also depends on the other repositories of the
+// registry and is left to RefreshRegistryStats.
+func (s *Store) RefreshRepositoryStats(ctx context.Context, repoID uint) error {
+	var tagsCount int
+	if err := s.queryRow(ctx, "SELECT COUNT(*) FROM tags WHERE repo_id = ?", repoID).Scan(&tagsCount); err != nil {
+		return fmt.Errorf("count repository tags %d: %w", repoID, err)
+	}
+
+	var totalSize int64
+	err := s.queryRow(ctx, `
+		WITH repo_images AS (
+			SELECT digest AS manifest_digest FROM tags WHERE repo_id = ?
+			UNION
+			SELECT mp.platform_digest FROM tags t
+			JOIN manifest_platforms mp ON mp.index_digest = t.digest
+			WHERE t.repo_id = ?
+		)
+		SELECT CAST(COALESCE(SUM(size_bytes), 0) AS BIGINT) FROM (`+imageBlobsFrom("repo_images")+`) blobs`,
+		repoID, repoID).Scan(&totalSize)
+	if err != nil {
+		return fmt.Errorf("sum repository size %d: %w", repoID, err)
+	}
+
+	if _, err := s.exec(ctx,
+		`INSERT INTO repository_stats (repo_id, tags_count, total_size_bytes) VALUES (?, ?, ?)
+		 ON CONFLICT(repo_id) DO UPDATE SET tags_count = excluded.tags_count, total_size_bytes = excluded.total_size_bytes`,
+		repoID, tagsCount, totalSize); err != nil {
+		return fmt.Errorf("upsert repository stats %d: %w", repoID, err)
+	}
+	if _, err := s.exec(ctx,
+		`UPDATE repository_stats SET
+			last_updated_at = (
+				SELECT MAX(m.created) FROM tags t
+				JOIN manifests m ON m.digest = t.digest
+				WHERE t.repo_id = repository_stats.repo_id
+			),
+			last_changed_at = (
+				SELECT MAX(occurred_at) FROM tag_events e WHERE e.repo_id = repository_stats.repo_id
+			)
+		 WHERE repo_id = ?`, repoID); err != nil {
+		return fmt.Errorf("update repository stats times %d: %w", repoID, err)
+	}
+	return nil
+}
+
+// RebuildRepositoryStats recomputes the statistics of every repository,
+// unique sizes included.
+func (s *Store) RebuildRepositoryStats(ctx context.Context) error {
+	return s.WithinTx(ctx, func(tx *Store) error {
+		if _, err := tx.exec(ctx, "DELETE FROM repository_stats"); err != nil {
+			return fmt.Errorf("clear repository stats: %w", err)
+		}
+		_, err := tx.exec(ctx, repositoryStatsCTEs("")+`
+			INSERT INTO repository_stats (repo_id, tags_count, total_size_bytes, unique_size_bytes, last_updated_at, last_changed_at)
+			SELECT`+repositoryStatsColumns+repositoryStatsFrom)
+		if err != nil {
+			return fmt.Errorf("rebuild repository stats: %w", err)
+		}
+		return nil
+	})
+}
+
+// RefreshRegistryStats recomputes the statistics of the repositories of a
+// registry after tags were deleted or moved, which can leave a blob to a
+// single repository and change its unique size. Only rows whose statistics
+// differ are written: the changed repositories and those sharing blobs with
+// them.
+func (s *Store) RefreshRegistryStats(ctx context.Context, registryID uint) error {
+	_, err := s.exec(ctx, repositoryStatsCTEs(" WHERE r.registry_id = ?")+`
+		INSERT INTO repository_stats (repo_id, tags_count, total_size_bytes, unique_size_bytes, last_updated_at, last_changed_at)
+		SELECT`+repositoryStatsColumns+repositoryStatsFrom+`
+		WHERE true
+		ON CONFLICT(repo_id) DO UPDATE SET
+			tags_count = excluded.tags_count,
+			total_size_bytes = excluded.total_size_bytes,
+			unique_size_bytes = excluded.unique_size_bytes,
+			last_updated_at = excluded.last_updated_at,
+			last_changed_at = excluded.last_changed_at
+		WHERE repository_stats.tags_count <> excluded.tags_count
+			OR repository_stats.total_size_bytes <> excluded.total_size_bytes
+			OR repository_stats.unique_size_bytes <> excluded.unique_size_bytes
+			OR repository_stats.last_updated_at IS DISTINCT FROM excluded.last_updated_at
+			OR repository_stats.last_changed_at IS DISTINCT FROM excluded.last_changed_at`,
+		registryID)
+	if err != nil {
+		return fmt.Errorf("refresh registry stats %d: %w", registryID, err)
+	}
+	return nil
+}
+
+// CheckRepositoryStats recomputes the statistics of every repository and
+// returns those that differ from the stored ones.
+func (s *Store) CheckRepositoryStats(ctx context.Context) ([]RepositoryStatsDrift, error) {
+	rows, err := s.query(ctx, repositoryStatsCTEs("")+`
+		SELECT`+repositoryStatsColumns+`,
+			reg.host, r.namespace, r.name, st.repo_id IS NOT NULL,
+			COALESCE(st.tags_count, 0), COALESCE(st.total_size_bytes, 0), COALESCE(st.unique_size_bytes, 0),
+			st.last_updated_at, st.last_changed_at`+repositoryStatsFrom+`
+		JOIN registries reg ON reg.id = r.registry_id
+		LEFT JOIN repository_stats st ON st.repo_id = r.id
+		ORDER BY reg.host, r.namespace, r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("check repository stats: %w", err)
+	}
+	defer closeRows(rows)
+
+	var drift []RepositoryStatsDrift
+	for rows.Next() {
+		var d RepositoryStatsDrift
+		var expectedUpdated, expectedChanged, storedUpdated, storedChanged sql.NullString
+		var host, namespace, name string
+		if err := rows.Scan(&d.RepositoryID,
+			&d.Expected.TagsCount, &d.Expected.TotalSizeBytes, &d.Expected.UniqueSizeBytes, &expectedUpdated, &expectedChanged,
+			&host, &namespace, &name, &d.Stored.Present,
+			&d.Stored.TagsCount, &d.Stored.TotalSizeBytes, &d.Stored.UniqueSizeBytes, &storedUpdated, &storedChanged); err != nil {
+			return nil, fmt.Errorf("scan repository stats: %w", err)
+		}
+		d.Expected.Present = true
+		d.Expected.LastUpdatedAt = parseNullTime(expectedUpdated)
+		d.Expected.LastChangedAt = parseNullTime(expectedChanged)
+		d.Stored.LastUpdatedAt = parseNullTime(storedUpdated)
+		d.Stored.LastChangedAt = parseNullTime(storedChanged)
+		if d.Stored.equal(d.Expected) {
+			continue
+		}
+		d.Path = host + "/" + name
+		if namespace != "" {
+			d.Path = host + "/" + namespace + "/" + name
+		}
+		drift = append(drift, d)
+	}
+	return drift, rows.Err()
+}
+
+func parseNullTime(v sql.NullString) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t, err := parseTime(v.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func (a RepositoryStats) equal(b RepositoryStats) bool {
+	sameTime := func(x, y *time.Time) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return x.Equal(*y)
+	}
+	return a.Present == b.Present && a.TagsCount == b.TagsCount &&
+		a.TotalSizeBytes == b.TotalSizeBytes && a.UniqueSizeBytes == b.UniqueSizeBytes &&
+		sameTime(a.LastUpdatedAt, b.LastUpdatedAt) && sameTime(a.LastChangedAt, b.LastChangedAt)
+}
@@ -1,14 +1,20 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -17,39 +23,154 @@ const (
 	tagErrorRecheckInterval   = 5 * time.Minute
 )
 
+// SQLite allows one writer at a time, while WAL lets readers proceed
+// alongside it. Reads get their own pool so page renders do not queue behind
+// sync writes.
+const (
+	sqliteMaxReaders = 8
+	// sqliteBusyTimeout is how long a connection waits for a lock, such as
+	// a reader during a WAL checkpoint, before failing with SQLITE_BUSY.
+	sqliteBusyTimeout = 10 * time.Second
+)
+
 type Store struct {
+	// db is the writer: a single connection on SQLite.
 	db *sql.DB
-	tx *sql.Tx
+	// readDB serves queries outside transactions. It is nil when reads share
+	// db, as on PostgreSQL or an in-memory SQLite database.
+	readDB  *sql.DB
+	tx      *sql.Tx
+	dialect dialect
+}
+
+// Open connects to the database selected by connection, either "sqlite" or
+// "postgres", and applies any pending migrations.
+func Open(ctx context.Context, connection, dsn string) (*Store, error) {
+	switch connection {
+	case "", ConnectionSQLite:
+		return New(ctx, dsn)
+	case ConnectionPostgres:
+		return newPostgres(ctx, dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database connection %q", connection)
+	}
 }
 
 func New(ctx context.Context, dsn string) (*Store, error) {
+	db, err := openSQLite(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := openStore(ctx, db, sqliteDialect)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every connection to an in-memory database opens a new, empty one.
+	if isSQLiteMemory(dsn) {
+		return s, nil
+	}
+	readDB, err := sql.Open("sqlite3", dsn+"?_foreign_keys=on&_query_only=true"+sqliteBusyTimeoutParam())
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("open read pool: %w", err), s.Close())
+	}
+	readDB.SetMaxOpenConns(sqliteMaxReaders)
+	readDB.SetMaxIdleConns(sqliteMaxReaders)
+	s.readDB = readDB
+	return s, nil
+}
+
+// openSQLite opens the single writer connection to a SQLite database.
+func openSQLite(dsn string) (*sql.DB, error) {
 	if err := os.MkdirAll(filepath.Dir(dsn), 0750); err != nil {
 		return nil, fmt.Errorf("create data directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", dsn+"?_journal_mode=WAL&_foreign_keys=on")
+	db, err := sql.Open("sqlite3", dsn+"?_journal_mode=WAL&_foreign_keys=on&_auto_vacuum=incremental"+sqliteBusyTimeoutParam())
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
 
 	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+func sqliteBusyTimeoutParam() string {
+	return fmt.Sprintf("&_busy_timeout=%d", sqliteBusyTimeout.Milliseconds())
+}
+
+func isSQLiteMemory(dsn string) bool {
+	return dsn == ":memory:" || strings.Contains(dsn, "mode=memory")
+}
 
-	if err := migrate(ctx, db); err != nil {
+func newPostgres(ctx context.Context, dsn string) (*Store, error) {
+	db, err := openPostgres(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(ctx, db, postgresDialect)
+}
+
+func openPostgres(ctx context.Context, dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			return nil, fmt.Errorf("close database after ping failure: %w", closeErr)
+		}
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+	return db, nil
+}
+
+func openStore(ctx context.Context, db *sql.DB, d dialect) (*Store, error) {
+	if _, err := migrate(ctx, db, d); err != nil {
 		if closeErr := db.Close(); closeErr != nil {
 			return nil, fmt.Errorf("close database after migration failure: %w", closeErr)
 		}
 		return nil, fmt.Errorf("run migration: %w", err)
 	}
 
-	return &Store{db: db}, nil
+	if err := backfillVersionKeys(ctx, db, d); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			return nil, fmt.Errorf("close database after version key backfill failure: %w", closeErr)
+		}
+		return nil, err
+	}
+
+	if !d.postgres() {
+		fts5, err := ensureSearchIndex(ctx, db)
+		if err != nil {
+			if closeErr := db.Close(); closeErr != nil {
+				return nil, fmt.Errorf("close database after search index failure: %w", closeErr)
+			}
+			return nil, err
+		}
+		d.fts5 = fts5
+	}
+
+	return &Store{db: db, dialect: d}, nil
 }
 
-func (s *Store) Close() {
+// Close closes the read pool and the writer. Both are closed even when one
+// fails, so the writer always gets to checkpoint the WAL.
+func (s *Store) Close() error {
+	var readErr, writeErr error
+	if s.readDB != nil {
+		if err := s.readDB.Close(); err != nil {
+			readErr = fmt.Errorf("close read pool: %w", err)
+		}
+	}
 	if s.db != nil {
 		if err := s.db.Close(); err != nil {
-			return
+			writeErr = fmt.Errorf("close database: %w", err)
 		}
 	}
+	return errors.Join(readErr, writeErr)
 }
 
 func (s *Store) WithinTx(ctx context.Context, fn func(tx *Store) error) error {
@@ -58,7 +179,7 @@ func (s *Store) WithinTx(ctx context.Context, fn func(tx *Store) error) error {
 		return fmt.Errorf("begin transaction: %w", err)
 	}
 
-	txStore := &Store{tx: tx}
+	txStore := &Store{tx: tx, dialect: s.dialect}
 	if err := fn(txStore); err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
 			return fmt.Errorf("rollback after %w: %w", err, rbErr)
@@ -73,27 +194,92 @@ func (s *Store) WithinTx(ctx context.Context, fn func(tx *Store) error) error {
 	return nil
 }
 
+// WithinSavepoint runs fn inside a savepoint of the current transaction. When
+// fn fails only its own statements are rolled back, and the transaction stays
+// usable for the rest of its work. It must be called on a Store handed out by
+// WithinTx.
+func (s *Store) WithinSavepoint(ctx context.Context, fn func() error) error {
+	if s.tx == nil {
+		return errors.New("savepoint outside a transaction")
+	}
+	if _, err := s.tx.ExecContext(ctx, "SAVEPOINT item"); err != nil {
+		return fmt.Errorf("create savepoint: %w", err)
+	}
+	if err := fn(); err != nil {
+		if _, rbErr := s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT item"); rbErr != nil {
+			return fmt.Errorf("rollback to savepoint after %w: %w", err, rbErr)
+		}
+		if _, relErr := s.tx.ExecContext(ctx, "RELEASE SAVEPOINT item"); relErr != nil {
+			return fmt.Errorf("release savepoint after %w: %w", err, relErr)
+		}
+		return err
+	}
+	if _, err := s.tx.ExecContext(ctx, "RELEASE SAVEPOINT item"); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+	return nil
+}
+
 func (s *Store) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = s.dialect.rebind(query)
 	if s.tx != nil {
 		return s.tx.ExecContext(ctx, query, args...)
 	}
 	return s.db.ExecContext(ctx, query, args...)
 }
 
+// execCount runs a statement and returns the number of rows it affected.
+func (s *Store) execCount(ctx context.Context, query string, args ...any) (int64, error) {
+	res, err := s.exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func (s *Store) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = s.dialect.rebind(query)
 	if s.tx != nil {
 		return s.tx.QueryContext(ctx, query, args...)
 	}
-	return s.db.QueryContext(ctx, query, args...)
+	return s.reader().QueryContext(ctx, query, args...)
 }
 
 func (s *Store) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	query = s.dialect.rebind(query)
+	if s.tx != nil {
+		return s.tx.QueryRowContext(ctx, query, args...)
+	}
+	return s.reader().QueryRowContext(ctx, query, args...)
+}
+
+// writeRow runs a statement returning a row, such as INSERT ... RETURNING,
+// on the writer.
+func (s *Store) writeRow(ctx context.Context, query string, args ...any) *sql.Row {
+	query = s.dialect.rebind(query)
 	if s.tx != nil {
 		return s.tx.QueryRowContext(ctx, query, args...)
 	}
 	return s.db.QueryRowContext(ctx, query, args...)
 }
 
+// writeRows runs a statement returning rows, such as a PRAGMA that changes
+// the database, on the writer.
+func (s *Store) writeRows(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = s.dialect.rebind(query)
+	if s.tx != nil {
+		return s.tx.QueryContext(ctx, query, args...)
+	}
+	return s.db.QueryContext(ctx, query, args...)
+}
+
+func (s *Store) reader() *sql.DB {
+	if s.readDB != nil {
+		return s.readDB
+	}
+	return s.db
+}
+
 // Registry operations.
 
 func (s *Store) GetAllRegistries(ctx context.Context) ([]Registry, error) {
@@ -170,9 +356,128 @@ func (s *Store) GetRepositoriesView(ctx context.Context) ([]RepositoryView, erro
 }
 
 func (s *Store) GetRepositoriesViewFiltered(ctx context.Context, filters RepositoryFilters) ([]RepositoryView, error) {
-	var b strings.Builder
-	b.WriteString("SELECT id, name, namespace, registry, registry_host, tags_count, architectures, total_size_bytes FROM repositories_view")
+	conditions, args, ranked, matches, err := s.repositoryConditions(ctx, filters)
+	if err != nil || (ranked != nil && len(ranked) == 0) {
+		return nil, err
+	}
+	repos, err := s.queryRepositoryViews(ctx, conditions, args, "", matches)
+	if err != nil {
+		return nil, err
+	}
+
+	if ranked != nil {
+		position := make(map[uint]int, len(ranked))
+		for i, id := range ranked {
+			position[id] = i
+		}
+		sort.SliceStable(repos, func(i, j int) bool { return position[repos[i].ID] < position[repos[j].ID] })
+	}
+	return repos, nil
+}
+
+// repositoryPathKeys order repositories by registry host, namespace and name.
+var repositoryPathKeys = []sortKey{
+	{expr: "registry_host", kind: keyText},
+	{expr: "namespace", kind: keyText},
+	{expr: "name", kind: keyText},
+	{expr: "id"},
+}
+
+// repositoryRankKeys order searched repositories by relevance: their position
+// in the search results, then id. Search results are bounded, so their pages
+// are cut in Go and these keys only describe the cursor.
+var repositoryRankKeys = []sortKey{{expr: "position"}, {expr: "id"}}
+
+// GetRepositoriesPage returns up to limit repositories matching filters,
+// following the repository whose cursor is after, or from the first one when
+// after is empty. Without a search they are ordered by path in SQL; a search
+// orders its bounded results by relevance. The page's Next cursor is empty on
+// the last page.
+func (s *Store) GetRepositoriesPage(ctx context.Context, filters RepositoryFilters, after string, limit int) (RepositoryPage, error) {
+	conditions, args, ranked, matches, err := s.repositoryConditions(ctx, filters)
+	if err != nil {
+		return RepositoryPage{}, err
+	}
+	if ranked != nil {
+		return s.rankedRepositoriesPage(ctx, conditions, args, ranked, matches, after, limit)
+	}
 
+	var page RepositoryPage
+	count := "SELECT COUNT(*) FROM repositories_view"
+	if len(conditions) > 0 {
+		count += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if err := s.queryRow(ctx, count, args...).Scan(&page.TotalCount); err != nil {
+		return RepositoryPage{}, fmt.Errorf("count repositories: %w", err)
+	}
+	if after != "" {
+		values, err := s.dialect.decodeCursor(after, repositoryPathKeys)
+		if err != nil {
+			return RepositoryPage{}, err
+		}
+		keyCond, keyArgs := keysetAfter(repositoryPathKeys, values)
+		conditions = append(conditions, keyCond)
+		args = append(args, keyArgs...)
+	}
+
+	// One repository past the page tells whether another page follows.
+	args = append(args, limit+1)
+	repos, err := s.queryRepositoryViews(ctx, conditions, args, " ORDER BY "+orderBy(repositoryPathKeys)+" LIMIT ?", matches)
+	if err != nil {
+		return RepositoryPage{}, err
+	}
+	page.Repositories = repos
+	if len(repos) > limit {
+		page.Repositories = repos[:limit]
+		last := repos[limit-1]
+		if page.Next, err = encodeCursor([]any{last.RegistryHost, last.Namespace, last.Name, last.ID}); err != nil {
+			return RepositoryPage{}, err
+		}
+	}
+	return page, nil
+}
+
+func (s *Store) rankedRepositoriesPage(ctx context.Context, conditions []string, args []any, ranked []uint, matches map[uint]*SearchMatch, after string, limit int) (RepositoryPage, error) {
+	if len(ranked) == 0 {
+		return RepositoryPage{}, nil
+	}
+	from := -1
+	if after != "" {
+		values, err := s.dialect.decodeCursor(after, repositoryRankKeys)
+		if err != nil {
+			return RepositoryPage{}, err
+		}
+		from = int(values[0].(int64))
+	}
+
+	repos, err := s.queryRepositoryViews(ctx, conditions, args, "", matches)
+	if err != nil {
+		return RepositoryPage{}, err
+	}
+	position := make(map[uint]int, len(ranked))
+	for i, id := range ranked {
+		position[id] = i
+	}
+	slices.SortFunc(repos, func(a, b RepositoryView) int { return cmp.Compare(position[a.ID], position[b.ID]) })
+
+	page := RepositoryPage{TotalCount: len(repos)}
+	start, _ := slices.BinarySearchFunc(repos, from+1, func(repo RepositoryView, pos int) int {
+		return cmp.Compare(position[repo.ID], pos)
+	})
+	page.Repositories = repos[start:min(start+limit, len(repos))]
+	if start+limit < len(repos) {
+		last := page.Repositories[len(page.Repositories)-1]
+		if page.Next, err = encodeCursor([]any{position[last.ID], last.ID}); err != nil {
+			return RepositoryPage{}, err
+		}
+	}
+	return page, nil
+}
+
+// repositoryConditions compiles filters against repositories_view. With a
+// search it also returns the matching repository ids by relevance, empty
+// when nothing matches, and their matches.
+func (s *Store) repositoryConditions(ctx context.Context, filters RepositoryFilters) ([]string, []any, []uint, map[uint]*SearchMatch, error) {
 	var conditions []string
 	var args []any
 
@@ -182,23 +487,57 @@ func (s *Store) GetRepositoriesViewFiltered(ctx context.Context, filters Reposit
 			args = append(args, r)
 		}
 	}
-	for _, arch := range filters.Architectures {
-		conditions = append(conditions, "architectures LIKE ?")
-		args = append(args, "%\""+arch+"\"%")
+	if filters.Scope != nil {
+		cond, condArgs := filters.Scope.condition("registry_host", "namespace")
+		conditions = append(conditions, cond)
+		args = append(args, condArgs...)
 	}
-	if filters.Search != "" {
-		conditions = append(conditions, "(name LIKE ? OR namespace LIKE ?)")
-		s := "%" + filters.Search + "%"
-		args = append(args, s, s)
+	for _, arch := range filters.Architectures {
+		cond, condArgs := platformCondition(arch)
+		conditions = append(conditions, cond)
+		args = append(args, condArgs...)
+	}
+	for _, osName := range filters.OperatingSystems {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM repository_platforms rp WHERE rp.repo_id = repositories_view.id AND rp.os = ?)")
+		args = append(args, osName)
+	}
+	var ranked []uint
+	var matches map[uint]*SearchMatch
+	if len(searchTerms(filters.Search)) > 0 {
+		var err error
+		ranked, matches, err = s.searchRepositories(ctx, filters.Search)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if ranked == nil {
+			ranked = []uint{}
+		}
+		if len(ranked) > 0 {
+			conditions = append(conditions, "id IN ("+strings.Repeat("?,", len(ranked)-1)+"?)")
+			for _, id := range ranked {
+				args = append(args, id)
+			}
+		}
 	}
 	if !filters.ShowUntagged {
 		conditions = append(conditions, "tags_count > 0")
 	}
+	queryConds, queryArgs := s.repositoryQueryConditions(filters)
+	conditions = append(conditions, queryConds...)
+	args = append(args, queryArgs...)
+	return conditions, args, ranked, matches, nil
+}
 
+// queryRepositoryViews reads the repositories_view rows matching conditions.
+// suffix follows the WHERE clause, for ordering and limits.
+func (s *Store) queryRepositoryViews(ctx context.Context, conditions []string, args []any, suffix string, matches map[uint]*SearchMatch) ([]RepositoryView, error) {
+	var b strings.Builder
+	b.WriteString("SELECT id, name, namespace, registry, registry_host, tags_count, architectures, total_size_bytes, unique_size_bytes, shared_size_bytes FROM repositories_view")
 	if len(conditions) > 0 {
 		b.WriteString(" WHERE ")
 		b.WriteString(strings.Join(conditions, " AND "))
 	}
+	b.WriteString(suffix)
 
 	rows, err := s.query(ctx, b.String(), args...)
 	if err != nil {
@@ -210,18 +549,135 @@ func (s *Store) GetRepositoriesViewFiltered(ctx context.Context, filters Reposit
 	for rows.Next() {
 		var rv RepositoryView
 		var archJSON string
-		if err := rows.Scan(&rv.ID, &rv.Name, &rv.Namespace, &rv.Registry, &rv.RegistryHost, &rv.TagsCount, &archJSON, &rv.TotalSizeInBytes); err != nil {
+		if err := rows.Scan(&rv.ID, &rv.Name, &rv.Namespace, &rv.Registry, &rv.RegistryHost, &rv.TagsCount, &archJSON, &rv.TotalSizeInBytes, &rv.UniqueSizeInBytes, &rv.SharedSizeInBytes); err != nil {
 			return nil, fmt.Errorf("scan repository view: %w", err)
 		}
 		rv.Architectures = parseArchitectures(archJSON)
+		rv.Match = matches[rv.ID]
 		repos = append(repos, rv)
 	}
-	return repos, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// condition limits the host and namespace columns of a query to the scope.
+func (scope NamespaceScope) condition(hostColumn, namespaceColumn string) (string, []any) {
+	var alternatives []string
+	var args []any
+	for _, host := range slices.Sorted(maps.Keys(scope)) {
+		namespaces := scope[host]
+		if len(namespaces) == 0 {
+			continue
+		}
+		alternatives = append(alternatives, "("+hostColumn+" = ? AND "+namespaceColumn+" IN ("+strings.Repeat("?,", len(namespaces)-1)+"?))")
+		args = append(args, host)
+		for _, ns := range namespaces {
+			args = append(args, ns)
+		}
+	}
+	if len(alternatives) == 0 {
+		return "1 = 0", nil
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// and is the scope's condition on the columns to append to a WHERE clause,
+// or nothing for a nil scope.
+func (scope NamespaceScope) and(hostColumn, namespaceColumn string) (string, []any) {
+	if scope == nil {
+		return "", nil
+	}
+	cond, args := scope.condition(hostColumn, namespaceColumn)
+	return " AND " + cond, args
+}
+
+// GetNamespaces lists the namespaces holding repositories, by registry host.
+func (s *Store) GetNamespaces(ctx context.Context) (NamespaceScope, error) {
+	rows, err := s.query(ctx,
+		`SELECT DISTINCT rg.host, r.namespace FROM repositories r
+		 JOIN registries rg ON rg.id = r.registry_id ORDER BY rg.host, r.namespace`)
+	if err != nil {
+		return nil, fmt.Errorf("list namespaces: %w", err)
+	}
+	defer closeRows(rows)
+
+	namespaces := NamespaceScope{}
+	for rows.Next() {
+		var host, namespace string
+		if err := rows.Scan(&host, &namespace); err != nil {
+			return nil, fmt.Errorf("scan namespace: %w", err)
+		}
+		namespaces[host] = append(namespaces[host], namespace)
+	}
+	return namespaces, rows.Err()
+}
+
+// repositoryTagImages joins each tag of a repositories_view row to its
+// manifest and, for indexes, to the manifests of its platforms.
+const repositoryTagImages = `FROM tags t
+	JOIN manifests m ON m.digest = t.digest
+	LEFT JOIN manifest_platforms mp ON mp.index_digest = m.digest
+	LEFT JOIN manifests pm ON pm.digest = mp.platform_digest
+	WHERE t.repo_id = repositories_view.id`
+
+// repositoryQueryConditions compiles the query language filters against
+// repositories_view.
+func (s *Store) repositoryQueryConditions(filters RepositoryFilters) ([]string, []any) {
+	var conditions []string
+	var args []any
+
+	if len(filters.Namespaces) > 0 {
+		var alternatives []string
+		for _, ns := range filters.Namespaces {
+			alternatives = append(alternatives, "namespace = ? OR namespace LIKE ?")
+			args = append(args, ns, ns+"/%")
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	switch filters.Kind {
+	case "":
+	case "image":
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM tags t WHERE t.repo_id = repositories_view.id AND t.kind IN ('image', 'index'))")
+	default:
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM tags t WHERE t.repo_id = repositories_view.id AND t.kind = ?)")
+		args = append(args, filters.Kind)
+	}
+	if filters.MinSizeBytes != nil {
+		conditions = append(conditions, "total_size_bytes >= ?")
+		args = append(args, *filters.MinSizeBytes)
+	}
+	if filters.MaxSizeBytes != nil {
+		conditions = append(conditions, "total_size_bytes <= ?")
+		args = append(args, *filters.MaxSizeBytes)
+	}
+	if filters.UpdatedAfter != nil {
+		conditions = append(conditions, s.dialect.compareTime("last_updated_at", ">="))
+		args = append(args, *filters.UpdatedAfter)
+	}
+	if filters.UpdatedBefore != nil {
+		conditions = append(conditions, "last_updated_at IS NOT NULL", s.dialect.compareTime("last_updated_at", "<"))
+		args = append(args, *filters.UpdatedBefore)
+	}
+	for _, label := range filters.Labels {
+		cond := "EXISTS (SELECT 1 " + repositoryTagImages + " AND EXISTS (SELECT 1 FROM config_blobs cb" +
+			" WHERE cb.digest = COALESCE(pm.config_digest, m.config_digest) AND " + s.dialect.configLabel("cb.config_json")
+		args = append(args, label.Key)
+		if label.Value == "" {
+			cond += " IS NOT NULL))"
+		} else {
+			cond += " = ?))"
+			args = append(args, label.Value)
+		}
+		conditions = append(conditions, cond)
+	}
+	return conditions, args
 }
 
 func (s *Store) GetRepositoryByPath(ctx context.Context, registryHost, namespace, name string) (*RepositoryView, error) {
 	rows, err := s.query(ctx,
-		`SELECT id, name, namespace, registry, registry_host, tags_count, architectures, total_size_bytes
+		`SELECT id, name, namespace, registry, registry_host, tags_count, architectures, total_size_bytes, unique_size_bytes, shared_size_bytes
 		 FROM repositories_view WHERE registry_host = ? AND namespace = ? AND name = ?`,
 		registryHost, namespace, name)
 	if err != nil {
@@ -234,7 +690,7 @@ func (s *Store) GetRepositoryByPath(ctx context.Context, registryHost, namespace
 	}
 	var rv RepositoryView
 	var archJSON string
-	if err := rows.Scan(&rv.ID, &rv.Name, &rv.Namespace, &rv.Registry, &rv.RegistryHost, &rv.TagsCount, &archJSON, &rv.TotalSizeInBytes); err != nil {
+	if err := rows.Scan(&rv.ID, &rv.Name, &rv.Namespace, &rv.Registry, &rv.RegistryHost, &rv.TagsCount, &archJSON, &rv.TotalSizeInBytes, &rv.UniqueSizeInBytes, &rv.SharedSizeInBytes); err != nil {
 		return nil, fmt.Errorf("scan repository view: %w", err)
 	}
 	rv.Architectures = parseArchitectures(archJSON)
@@ -258,9 +714,29 @@ func (s *Store) UpsertRepositoryByFields(ctx context.Context, registryID uint, n
 	if err := r.Scan(&repo.ID, &repo.RegistryID, &repo.Namespace, &repo.Name, &repo.LastSyncAt); err != nil {
 		return nil, fmt.Errorf("scan upserted repository %s/%s: %w", namespace, name, err)
 	}
+	if err := s.indexRepositoryPath(ctx, repo.ID); err != nil {
+		return nil, err
+	}
+	if err := s.reattachTagHistory(ctx, &repo); err != nil {
+		return nil, err
+	}
 	return &repo, nil
 }
 
+// reattachTagHistory links the history a deleted repository left behind to the
+// repository of the same name, once it is discovered again.
+func (s *Store) reattachTagHistory(ctx context.Context, repo *Repository) error {
+	_, err := s.exec(ctx,
+		`UPDATE tag_events SET repo_id = ?
+		 WHERE repo_id IS NULL AND namespace = ? AND repo_name = ?
+		 AND registry_host = (SELECT host FROM registries WHERE id = ?)`,
+		repo.ID, repo.Namespace, repo.Name, repo.RegistryID)
+	if err != nil {
+		return fmt.Errorf("reattach tag history %s/%s: %w", repo.Namespace, repo.Name, err)
+	}
+	return nil
+}
+
 func (s *Store) UpdateRepositorySyncTime(ctx context.Context, repositoryID uint) error {
 	_, err := s.exec(ctx, "UPDATE repositories SET last_sync_at = ? WHERE id = ?", time.Now(), repositoryID)
 	if err != nil {
@@ -357,13 +833,13 @@ func (s *Store) GetTagsByRepoAndDigests(ctx context.Context, repositoryID uint,
 func (s *Store) UpsertTag(ctx context.Context, tag *Tag) error {
 	_, err := s.exec(ctx,
 		`INSERT INTO tags (repo_id, name, digest, kind, media_type,
-		 last_sync_at, next_check_at, priority, sync_status, last_error)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 last_sync_at, next_check_at, priority, sync_status, last_error, version_key)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		 ON CONFLICT(repo_id, name) DO UPDATE SET
 			digest=excluded.digest, kind=excluded.kind, media_type=excluded.media_type,
 			last_sync_at=excluded.last_sync_at, sync_status=excluded.sync_status`,
 		tag.RepositoryID, tag.Name, tag.Digest, tag.Kind, tag.MediaType,
-		tag.LastSyncAt, tag.NextCheckAt, tag.Priority, tag.SyncStatus, tag.LastError)
+		tag.LastSyncAt, tag.NextCheckAt, tag.Priority, tag.SyncStatus, tag.LastError, tagVersionKey(tag.Name))
 	if err != nil {
 		return fmt.Errorf("upsert tag %d/%s: %w", tag.RepositoryID, tag.Name, err)
 	}
@@ -371,17 +847,23 @@ func (s *Store) UpsertTag(ctx context.Context, tag *Tag) error {
 }
 
 func (s *Store) DeleteTag(ctx context.Context, tag *Tag) error {
-	_, err := s.exec(ctx, "DELETE FROM tags WHERE id = ?", tag.ID)
+	var repoID uint
+	err := s.writeRow(ctx, "DELETE FROM tags WHERE id = ? RETURNING repo_id", tag.ID).Scan(&repoID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("delete tag %d/%s: %w", tag.RepositoryID, tag.Name, err)
 	}
-	return nil
+	return s.RefreshRepository(ctx, repoID)
 }
 
 func (s *Store) DeleteStaleTags(ctx context.Context, repoID uint, keepNames []string) error {
 	if len(keepNames) == 0 {
-		_, err := s.exec(ctx, "DELETE FROM tags WHERE repo_id = ?", repoID)
-		return err
+		if _, err := s.exec(ctx, "DELETE FROM tags WHERE repo_id = ?", repoID); err != nil {
+			return fmt.Errorf("delete stale tags: %w", err)
+		}
+		return s.RefreshRepository(ctx, repoID)
 	}
 	phs := make([]string, len(keepNames))
 	args := []any{repoID}
@@ -396,37 +878,40 @@ func (s *Store) DeleteStaleTags(ctx context.Context, repoID uint, keepNames []st
 	if err != nil {
 		return fmt.Errorf("delete stale tags: %w", err)
 	}
-	return nil
+	return s.RefreshRepository(ctx, repoID)
 }
 
 func (s *Store) UpsertTagWithSync(ctx context.Context, repoID uint, tagName, digest, kind, mediaType string, priorityScore float64) (*Tag, error) {
 	now := time.Now()
 	nextCheck := now.Add(tagSuccessRecheckInterval)
 	_, err := s.exec(ctx,
-		`INSERT INTO tags (repo_id, name, digest, kind, media_type, last_sync_at, next_check_at, priority, sync_status, last_error)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'ok', '')
+		`INSERT INTO tags (repo_id, name, digest, kind, media_type, last_sync_at, next_check_at, priority, sync_status, last_error, version_key)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'ok', '', ?)
 		 ON CONFLICT(repo_id, name) DO UPDATE SET
 			digest=excluded.digest, kind=excluded.kind, media_type=excluded.media_type,
 			priority=excluded.priority, last_sync_at=excluded.last_sync_at,
 			next_check_at=excluded.next_check_at, sync_status=excluded.sync_status,
 			last_error=excluded.last_error`,
-		repoID, tagName, digest, kind, mediaType, now, nextCheck, priorityScore)
+		repoID, tagName, digest, kind, mediaType, now, nextCheck, priorityScore, tagVersionKey(tagName))
 	if err != nil {
 		return nil, fmt.Errorf("upsert tag with sync %d/%s: %w", repoID, tagName, err)
 	}
 	return s.GetTagByRepoAndName(ctx, repoID, tagName)
 }
 
+// MarkTagSyncError records why a tag could not be synced, creating the tag if
+// its first sync failed. The caller refreshes the repository's statistics, as
+// the sync persister does once per batch.
 func (s *Store) MarkTagSyncError(ctx context.Context, repoID uint, tagName, errorMsg string) error {
 	now := time.Now()
 	nextCheck := now.Add(tagErrorRecheckInterval)
 	_, err := s.exec(ctx,
-		`INSERT INTO tags (repo_id, name, digest, kind, media_type, last_sync_at, next_check_at, priority, sync_status, last_error)
-		 VALUES (?, ?, '', '', '', ?, ?, 1.0, 'error', ?)
+		`INSERT INTO tags (repo_id, name, digest, kind, media_type, last_sync_at, next_check_at, priority, sync_status, last_error, version_key)
+		 VALUES (?, ?, '', '', '', ?, ?, 1.0, 'error', ?, ?)
 		 ON CONFLICT(repo_id, name) DO UPDATE SET
 			last_sync_at=excluded.last_sync_at, next_check_at=excluded.next_check_at,
 			sync_status='error', last_error=excluded.last_error`,
-		repoID, tagName, now, nextCheck, errorMsg)
+		repoID, tagName, now, nextCheck, errorMsg, tagVersionKey(tagName))
 	if err != nil {
 		return fmt.Errorf("mark tag sync error %d/%s: %w", repoID, tagName, err)
 	}
@@ -446,6 +931,164 @@ func (s *Store) UpdateTagSyncMetadata(ctx context.Context, repoID uint, tagName
 	return nil
 }
 
+// Tag history.
+
+// StartSyncRun records the start of a sync run and returns its id.
+func (s *Store) StartSyncRun(ctx context.Context) (int64, error) {
+	var id int64
+	r := s.writeRow(ctx, "INSERT INTO sync_runs (started_at, status) VALUES (?, ?) RETURNING id", time.Now(), SyncRunRunning)
+	if err := r.Scan(&id); err != nil {
+		return 0, fmt.Errorf("start sync run: %w", err)
+	}
+	return id, nil
+}
+
+// FinishSyncRun marks a sync run as finished. A non-empty errMsg marks it failed.
+func (s *Store) FinishSyncRun(ctx context.Context, id int64, errMsg string) error {
+	status := SyncRunSucceeded
+	if errMsg != "" {
+		status = SyncRunFailed
+	}
+	_, err := s.exec(ctx,
+		"UPDATE sync_runs SET finished_at = ?, status = ?, error = ? WHERE id = ?",
+		time.Now(), status, errMsg, id)
+	if err != nil {
+		return fmt.Errorf("finish sync run %d: %w", id, err)
+	}
+	return nil
+}
+
+// GetSyncRunRegistries returns the ids of the registries whose tags a sync run
+// created, moved or deleted, found through the tag events it recorded.
+func (s *Store) GetSyncRunRegistries(ctx context.Context, id int64) ([]uint, error) {
+	rows, err := s.query(ctx,
+		`SELECT DISTINCT reg.id FROM tag_events e
+		 JOIN registries reg ON reg.host = e.registry_host
+		 WHERE e.sync_run_id = ?
+		 ORDER BY reg.id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("get sync run %d registries: %w", id, err)
+	}
+	defer closeRows(rows)
+
+	var ids []uint
+	for rows.Next() {
+		var regID uint
+		if err := rows.Scan(&regID); err != nil {
+			return nil, fmt.Errorf("scan sync run registry: %w", err)
+		}
+		ids = append(ids, regID)
+	}
+	return ids, rows.Err()
+}
+
+// RecordTagEvent appends an entry to a tag's history. A zero syncRunID records
+// an event made outside of a sync, such as a deletion from the UI. The event
+// keeps the repository's registry host, namespace and name, so it survives the
+// repository being deleted.
+func (s *Store) RecordTagEvent(ctx context.Context, repoID uint, tagName string, event TagEventKind, oldDigest, newDigest string, syncRunID int64) error {
+	var runID *int64
+	if syncRunID != 0 {
+		runID = &syncRunID
+	}
+	_, err := s.exec(ctx,
+		`INSERT INTO tag_events (repo_id, registry_host, namespace, repo_name, tag_name, event, old_digest, new_digest, sync_run_id, occurred_at, version_key)
+		 SELECT r.id, reg.host, r.namespace, r.name, ?, ?, ?, ?, ?, ?, ?
+		 FROM repositories r JOIN registries reg ON reg.id = r.registry_id
+		 WHERE r.id = ?`,
+		tagName, event, oldDigest, newDigest, runID, time.Now(), tagVersionKey(tagName), repoID)
+	if err != nil {
+		return fmt.Errorf("record tag event %d/%s: %w", repoID, tagName, err)
+	}
+	return nil
+}
+
+// Leader election.
+
+// AcquireLease takes or renews the named lease for holder until ttl from now.
+// It reports false while another holder's lease is still valid.
+func (s *Store) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	res, err := s.exec(ctx,
+		`INSERT INTO leases (name, holder, acquired_at, expires_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(name) DO UPDATE SET
+			holder=excluded.holder, expires_at=excluded.expires_at,
+			acquired_at=CASE WHEN leases.holder = excluded.holder THEN leases.acquired_at ELSE excluded.acquired_at END
+		 WHERE leases.holder = excluded.holder OR `+s.dialect.compareTime("leases.expires_at", "<"),
+		name, holder, now, now.Add(ttl), now)
+	if err != nil {
+		return false, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("acquire lease %s: %w", name, err)
+	}
+	return n > 0, nil
+}
+
+// ReleaseLease gives up the named lease if holder still owns it.
+func (s *Store) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := s.exec(ctx, "DELETE FROM leases WHERE name = ? AND holder = ?", name, holder)
+	if err != nil {
+		return fmt.Errorf("release lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// EnqueueSyncTrigger asks whichever process leads syncing to run a sync.
+func (s *Store) EnqueueSyncTrigger(ctx context.Context, reason, requestedBy string) error {
+	_, err := s.exec(ctx,
+		"INSERT INTO sync_triggers (reason, requested_by, requested_at) VALUES (?, ?, ?)",
+		reason, requestedBy, time.Now())
+	if err != nil {
+		return fmt.Errorf("enqueue sync trigger: %w", err)
+	}
+	return nil
+}
+
+// ClaimSyncTriggers marks every pending trigger as handled and reports whether
+// there were any.
+func (s *Store) ClaimSyncTriggers(ctx context.Context) (bool, error) {
+	res, err := s.exec(ctx, "UPDATE sync_triggers SET claimed_at = ? WHERE claimed_at IS NULL", time.Now())
+	if err != nil {
+		return false, fmt.Errorf("claim sync triggers: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("claim sync triggers: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SaveSyncProgress stores the leader's latest progress for other replicas.
+func (s *Store) SaveSyncProgress(ctx context.Context, p SyncProgress) error {
+	_, err := s.exec(ctx,
+		`INSERT INTO sync_progress (id, holder, completed, total, message, step, done, updated_at)
+		 VALUES (1, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET
+			holder=excluded.holder, completed=excluded.completed, total=excluded.total,
+			message=excluded.message, step=excluded.step, done=excluded.done, updated_at=excluded.updated_at`,
+		p.Holder, p.Completed, p.Total, p.Message, p.Step, p.Done, time.Now())
+	if err != nil {
+		return fmt.Errorf("save sync progress: %w", err)
+	}
+	return nil
+}
+
+// GetSyncProgress returns the last progress saved by a leader, or nil if none.
+func (s *Store) GetSyncProgress(ctx context.Context) (*SyncProgress, error) {
+	r := s.queryRow(ctx,
+		"SELECT holder, completed, total, message, step, done, updated_at FROM sync_progress WHERE id = 1")
+	var p SyncProgress
+	if err := r.Scan(&p.Holder, &p.Completed, &p.Total, &p.Message, &p.Step, &p.Done, &p.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get sync progress: %w", err)
+	}
+	return &p, nil
+}
+
 // Manifest operations.
 
 func (s *Store) UpsertManifestByFields(ctx context.Context, digest, mediaType, kind, rawJSON, configDigest, os, arch, variant string, sizeBytes int64, created *time.Time) (*Manifest, error) {
@@ -524,8 +1167,11 @@ func (s *Store) LinkManifestLayers(ctx context.Context, manifestDigest string, l
 
 func (s *Store) LinkManifestPlatform(ctx context.Context, indexDigest, platformDigest, os, arch, variant string, position int, sizeBytes int64) error {
 	_, err := s.exec(ctx,
-		`INSERT OR REPLACE INTO manifest_platforms (index_digest, platform_digest, os, architecture, variant, position, size_bytes)
-		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO manifest_platforms (index_digest, platform_digest, os, architecture, variant, position, size_bytes)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(index_digest, platform_digest) DO UPDATE SET
+			os=excluded.os, architecture=excluded.architecture, variant=excluded.variant,
+			position=excluded.position, size_bytes=excluded.size_bytes`,
 		indexDigest, platformDigest, os, arch, variant, position, sizeBytes)
 	if err != nil {
 		return fmt.Errorf("link manifest platform %s -> %s: %w", indexDigest, platformDigest, err)
@@ -533,16 +1179,26 @@ func (s *Store) LinkManifestPlatform(ctx context.Context, indexDigest, platformD
 	return nil
 }
 
+// CleanupOrphans removes layers and config blobs no manifest references.
+// Manifests themselves are kept, so digests a tag pointed to in the past still
+// have their size and platforms available to the tag timeline.
 func (s *Store) CleanupOrphans(ctx context.Context) error {
-	if _, err := s.exec(ctx,
-		"DELETE FROM layers WHERE digest NOT IN (SELECT DISTINCT layer_digest FROM manifest_layers)"); err != nil {
-		return fmt.Errorf("cleanup orphan layers: %w", err)
+	_, _, err := s.cleanupOrphans(ctx)
+	return err
+}
+
+func (s *Store) cleanupOrphans(ctx context.Context) (layers, configBlobs int64, err error) {
+	layers, err = s.execCount(ctx,
+		"DELETE FROM layers WHERE digest NOT IN (SELECT DISTINCT layer_digest FROM manifest_layers)")
+	if err != nil {
+		return 0, 0, fmt.Errorf("cleanup orphan layers: %w", err)
 	}
-	if _, err := s.exec(ctx,
-		"DELETE FROM config_blobs WHERE digest NOT IN (SELECT DISTINCT config_digest FROM manifests WHERE config_digest IS NOT NULL)"); err != nil {
-		return fmt.Errorf("cleanup orphan config blobs: %w", err)
+	configBlobs, err = s.execCount(ctx,
+		"DELETE FROM config_blobs WHERE digest NOT IN (SELECT DISTINCT config_digest FROM manifests WHERE config_digest IS NOT NULL)")
+	if err != nil {
+		return 0, 0, fmt.Errorf("cleanup orphan config blobs: %w", err)
 	}
-	return nil
+	return layers, configBlobs, nil
 }
 
 // Helpers.
@@ -1,24 +1,56 @@
 package store_test
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/eznix86/docker-registry-ui/internal/store"
 )
 
+// setupStore opens an empty store. The suite runs against in-memory SQLite
+// unless TEST_DATABASE_CONNECTION=postgres and TEST_DATABASE_URL point it at a
+// PostgreSQL server, whose public schema is recreated for every test.
 func setupStore(t *testing.T) (*store.Store, context.Context) {
 	t.Helper()
 	ctx := context.Background()
-	s, err := store.New(ctx, ":memory:")
+	connection := os.Getenv("TEST_DATABASE_CONNECTION")
+	dsn := ":memory:"
+	if connection == store.ConnectionPostgres {
+		dsn = os.Getenv("TEST_DATABASE_URL")
+		if dsn == "" {
+			t.Fatal("TEST_DATABASE_URL is required when TEST_DATABASE_CONNECTION=postgres")
+		}
+		resetPostgres(t, ctx, dsn)
+	}
+	s, err := store.Open(ctx, connection, dsn)
 	if err != nil {
-		t.Fatalf("Failed to create in-memory store: %v", err)
+		t.Fatalf("Failed to create store: %v", err)
 	}
 	t.Cleanup(func() { s.Close() })
 	return s, ctx
 }
 
+func resetPostgres(t *testing.T, ctx context.Context, dsn string) {
+	t.Helper()
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	if _, err := db.ExecContext(ctx, "DROP SCHEMA IF EXISTS public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatalf("reset postgres schema: %v", err)
+	}
+}
+
 func mustRegistry(t *testing.T, s *store.Store, ctx context.Context, name, url, host string) *store.Registry {
 	t.Helper()
 	reg, err := s.UpsertRegistryByFields(ctx, name, url, host, 1)
@@ -46,6 +78,15 @@ func mustTag(t *testing.T, s *store.Store, ctx context.Context, repoID uint, nam
 	return tag
 }
 
+// mustRebuildStats recomputes repository statistics, as the end of a sync
+// does, for tests that write tags without going through the persister.
+func mustRebuildStats(t *testing.T, s *store.Store, ctx context.Context) {
+	t.Helper()
+	if err := s.RebuildRepositoryStats(ctx); err != nil {
+		t.Fatalf("RebuildRepositoryStats: %v", err)
+	}
+}
+
 func mustManifest(
 	t *testing.T,
 	s *store.Store,
@@ -290,6 +331,7 @@ func TestRepositoryViewIncludesTagCount(t *testing.T) {
 	repo := mustRepository(t, s, ctx, reg.ID, "lib", "busybox")
 	mustTag(t, s, ctx, repo.ID, "v1", "sha256:aaa")
 	mustTag(t, s, ctx, repo.ID, "v2", "sha256:bbb")
+	mustRebuildStats(t, s, ctx)
 
 	views, err := s.GetRepositoriesView(ctx)
 	if err != nil {
@@ -309,3 +351,1579 @@ func TestRepositoryViewIncludesTagCount(t *testing.T) {
 		t.Fatalf("expected 2 tags, got %d", found.TagsCount)
 	}
 }
+
+func TestSearch(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	operator := mustRepository(t, s, ctx, reg.ID, "infra", "postgres-operator")
+	cache := mustRepository(t, s, ctx, reg.ID, "lib", "cache")
+	tag := mustTag(t, s, ctx, cache.ID, "v1", "sha256:aaa")
+	if err := s.IndexTagSearch(ctx, cache.ID, "v1", store.SearchDocument{
+		Title:       "Cache",
+		Description: "In-memory cache in front of Postgres databases",
+	}); err != nil {
+		t.Fatalf("IndexTagSearch: %v", err)
+	}
+
+	hits, err := s.Search(ctx, "postg", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("expected 2 hits, got %+v", hits)
+	}
+	if hits[0].RepositoryID != operator.ID || hits[0].Field != store.SearchFieldPath {
+		t.Fatalf("expected path match on the operator first, got %+v", hits[0])
+	}
+	if hits[1].RepositoryID != cache.ID || hits[1].Tag != "v1" || hits[1].Field != store.SearchFieldDescription {
+		t.Fatalf("expected description match on cache:v1, got %+v", hits[1])
+	}
+	var marked []string
+	for _, part := range hits[1].Snippet {
+		if part.Match {
+			marked = append(marked, part.Text)
+		}
+	}
+	if len(marked) != 1 || marked[0] != "Postgres" {
+		t.Fatalf("expected Postgres to be highlighted, got %+v", hits[1].Snippet)
+	}
+
+	views, err := s.GetRepositoriesViewFiltered(ctx, store.RepositoryFilters{Search: "memory cache", ShowUntagged: true})
+	if err != nil {
+		t.Fatalf("GetRepositoriesViewFiltered: %v", err)
+	}
+	if len(views) != 1 || views[0].ID != cache.ID || views[0].Match == nil {
+		t.Fatalf("expected only cache with a match, got %+v", views)
+	}
+
+	if err := s.DeleteTag(ctx, tag); err != nil {
+		t.Fatalf("DeleteTag: %v", err)
+	}
+	hits, err = s.Search(ctx, "memory", 10)
+	if err != nil {
+		t.Fatalf("Search after delete: %v", err)
+	}
+	if len(hits) != 0 {
+		t.Fatalf("expected no hits after deleting the tag, got %+v", hits)
+	}
+}
+
+func TestRepositoryQueryFilters(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	now := time.Now().UTC()
+	recent := now.Add(-48 * time.Hour)
+	old := now.Add(-30 * 24 * time.Hour)
+
+	api := mustRepository(t, s, ctx, reg.ID, "platform/core", "api")
+	if _, err := s.UpsertConfigBlobByFields(ctx, "sha256:cfg-api", 100,
+		`{"config":{"Labels":{"team":"payments","org.opencontainers.image.vendor":"acme"}}}`, "linux", "arm64", &recent); err != nil {
+		t.Fatalf("UpsertConfigBlobByFields: %v", err)
+	}
+	if _, err := s.UpsertManifestByFields(ctx, "sha256:api", "application/vnd.oci.image.manifest.v1+json", "image",
+		`{}`, "sha256:cfg-api", "linux", "arm64", "", 600<<20, &recent); err != nil {
+		t.Fatalf("UpsertManifestByFields: %v", err)
+	}
+	mustTag(t, s, ctx, api.ID, "v1", "sha256:api")
+
+	web := mustRepository(t, s, ctx, reg.ID, "platform", "web")
+	if _, err := s.UpsertManifestByFields(ctx, "sha256:web-index", "application/vnd.oci.image.index.v1+json", "index",
+		`{}`, "", "", "", "", 10<<20, &old); err != nil {
+		t.Fatalf("UpsertManifestByFields: %v", err)
+	}
+	if _, err := s.UpsertManifestByFields(ctx, "sha256:web", "application/vnd.oci.image.manifest.v1+json", "image",
+		`{}`, "", "linux", "amd64", "", 10<<20, &old); err != nil {
+		t.Fatalf("UpsertManifestByFields: %v", err)
+	}
+	if err := s.LinkManifestPlatform(ctx, "sha256:web-index", "sha256:web", "linux", "amd64", "", 0, 10<<20); err != nil {
+		t.Fatalf("LinkManifestPlatform: %v", err)
+	}
+	if _, err := s.UpsertTagWithSync(ctx, web.ID, "v1", "sha256:web-index", "index", "app/json", 1.0); err != nil {
+		t.Fatalf("UpsertTagWithSync: %v", err)
+	}
+
+	chart := mustRepository(t, s, ctx, reg.ID, "charts", "ingress")
+	mustManifest(t, s, ctx, "sha256:chart", "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "", "", "", 1<<10)
+	if _, err := s.UpsertTagWithSync(ctx, chart.ID, "1.0.0", "sha256:chart", "helm", "app/json", 1.0); err != nil {
+		t.Fatalf("UpsertTagWithSync: %v", err)
+	}
+
+	mustRebuildStats(t, s, ctx)
+	size := func(n int64) *int64 { return &n }
+	weekAgo := now.Add(-7 * 24 * time.Hour)
+	tests := []struct {
+		name    string
+		filters store.RepositoryFilters
+		want    []string
+	}{
+		{name: "namespace and children", filters: store.RepositoryFilters{Namespaces: []string{"platform"}}, want: []string{"api", "web"}},
+		{name: "image kind includes indexes", filters: store.RepositoryFilters{Kind: "image"}, want: []string{"api", "web"}},
+		{name: "helm kind", filters: store.RepositoryFilters{Kind: "helm"}, want: []string{"ingress"}},
+		{name: "minimum size", filters: store.RepositoryFilters{MinSizeBytes: size(500 << 20)}, want: []string{"api"}},
+		{name: "maximum size", filters: store.RepositoryFilters{MaxSizeBytes: size(1 << 20)}, want: []string{"ingress"}},
+		{name: "updated recently", filters: store.RepositoryFilters{UpdatedAfter: &weekAgo}, want: []string{"api"}},
+		{name: "not updated recently", filters: store.RepositoryFilters{UpdatedBefore: &weekAgo}, want: []string{"web"}},
+		{name: "label value", filters: store.RepositoryFilters{Labels: []store.LabelFilter{{Key: "team", Value: "payments"}}}, want: []string{"api"}},
+		{name: "dotted label present", filters: store.RepositoryFilters{Labels: []store.LabelFilter{{Key: "org.opencontainers.image.vendor"}}}, want: []string{"api"}},
+		{name: "label mismatch", filters: store.RepositoryFilters{Labels: []store.LabelFilter{{Key: "team", Value: "search"}}}, want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			views, err := s.GetRepositoriesViewFiltered(ctx, test.filters)
+			if err != nil {
+				t.Fatalf("GetRepositoriesViewFiltered: %v", err)
+			}
+			var got []string
+			for _, v := range views {
+				got = append(got, v.Name)
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestFindDigestUsage(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	hub := mustRegistry(t, s, ctx, "hub", "https://hub.io", "hub.io")
+	ghcr := mustRegistry(t, s, ctx, "ghcr", "https://ghcr.io", "ghcr.io")
+	app := mustRepository(t, s, ctx, hub.ID, "lib", "app")
+	mirror := mustRepository(t, s, ctx, ghcr.ID, "", "app")
+
+	mustLayer(t, s, ctx, "sha256:shared-layer", 100)
+	mustManifest(t, s, ctx, "sha256:amd64", "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "sha256:cfg", "linux", "amd64", 200)
+	if err := s.LinkManifestLayers(ctx, "sha256:amd64", []string{"sha256:shared-layer"}); err != nil {
+		t.Fatalf("LinkManifestLayers: %v", err)
+	}
+	mustManifest(t, s, ctx, "sha256:index", "application/vnd.oci.image.index.v1+json", "index", `{}`, "", "", "", 200)
+	if err := s.LinkManifestPlatform(ctx, "sha256:index", "sha256:amd64", "linux", "amd64", "", 0, 200); err != nil {
+		t.Fatalf("LinkManifestPlatform: %v", err)
+	}
+	mustTag(t, s, ctx, app.ID, "v1", "sha256:index")
+	mustTag(t, s, ctx, mirror.ID, "v1-amd64", "sha256:amd64")
+
+	groups, err := s.FindDigestUsage(ctx, "sha256:amd64")
+	if err != nil {
+		t.Fatalf("FindDigestUsage: %v", err)
+	}
+	if len(groups) != 2 || groups[0].Registry != "ghcr" || groups[1].Registry != "hub" {
+		t.Fatalf("expected usages grouped under ghcr and hub, got %+v", groups)
+	}
+	if u := groups[0].Usages; len(u) != 1 || u[0].Tag != "v1-amd64" || u[0].Relation != store.DigestRelationTag {
+		t.Fatalf("expected the mirror tag to point at the digest, got %+v", u)
+	}
+	if u := groups[1].Usages; len(u) != 1 || u[0].Tag != "v1" || u[0].Relation != store.DigestRelationPlatform || u[0].Platform != "linux/amd64" {
+		t.Fatalf("expected the index tag to hold the digest as a platform, got %+v", u)
+	}
+
+	groups, err = s.FindDigestUsage(ctx, "sha256:shared")
+	if err != nil {
+		t.Fatalf("FindDigestUsage by prefix: %v", err)
+	}
+	var layers int
+	for _, g := range groups {
+		for _, u := range g.Usages {
+			if u.Relation != store.DigestRelationLayer || u.Digest != "sha256:shared-layer" {
+				t.Fatalf("expected a shared layer usage, got %+v", u)
+			}
+			layers++
+		}
+	}
+	if layers != 2 {
+		t.Fatalf("expected the layer to be used by both tags, got %+v", groups)
+	}
+}
+
+func TestStorageAccountingCountsDistinctBlobs(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	app := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	worker := mustRepository(t, s, ctx, reg.ID, "team", "worker")
+
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	mustLayer(t, s, ctx, "sha256:app-amd64", 100)
+	mustLayer(t, s, ctx, "sha256:app-arm64", 100)
+	mustLayer(t, s, ctx, "sha256:worker", 50)
+	image := func(digest string, layers ...string) {
+		t.Helper()
+		mustManifest(t, s, ctx, digest, "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "", "linux", "amd64", 0)
+		if err := s.LinkManifestLayers(ctx, digest, layers); err != nil {
+			t.Fatalf("LinkManifestLayers: %v", err)
+		}
+	}
+	// Two platforms of the same size must both count, and the shared base once.
+	image("sha256:app-amd64-manifest", "sha256:base", "sha256:app-amd64")
+	image("sha256:app-arm64-manifest", "sha256:base", "sha256:app-arm64")
+	mustManifest(t, s, ctx, "sha256:app-index", "application/vnd.oci.image.index.v1+json", "index", `{}`, "", "", "", 0)
+	for i, platform := range []string{"sha256:app-amd64-manifest", "sha256:app-arm64-manifest"} {
+		if err := s.LinkManifestPlatform(ctx, "sha256:app-index", platform, "linux", "amd64", "", i, 0); err != nil {
+			t.Fatalf("LinkManifestPlatform: %v", err)
+		}
+	}
+	if _, err := s.UpsertTagWithSync(ctx, app.ID, "v1", "sha256:app-index", "index", "app/json", 1.0); err != nil {
+		t.Fatalf("UpsertTagWithSync: %v", err)
+	}
+	mustTag(t, s, ctx, app.ID, "latest-amd64", "sha256:app-amd64-manifest")
+	image("sha256:worker-manifest", "sha256:base", "sha256:worker")
+	mustTag(t, s, ctx, worker.ID, "v1", "sha256:worker-manifest")
+	mustRebuildStats(t, s, ctx)
+
+	views, err := s.GetRepositoriesView(ctx)
+	if err != nil {
+		t.Fatalf("GetRepositoriesView: %v", err)
+	}
+	sizes := map[string][3]int64{}
+	for _, v := range views {
+		sizes[v.Name] = [3]int64{v.TotalSizeInBytes, v.UniqueSizeInBytes, v.SharedSizeInBytes}
+	}
+	if got := sizes["app"]; got != [3]int64{1200, 200, 1000} {
+		t.Fatalf("expected app total/unique/shared 1200/200/1000, got %v", got)
+	}
+	if got := sizes["worker"]; got != [3]int64{1050, 50, 1000} {
+		t.Fatalf("expected worker total/unique/shared 1050/50/1000, got %v", got)
+	}
+
+	stats, err := s.GetRegistryStats(ctx, "test.io", nil)
+	if err != nil {
+		t.Fatalf("GetRegistryStats: %v", err)
+	}
+	if stats.EstimatedStorageBytes != 1250 {
+		t.Fatalf("expected 1250 bytes stored in the registry, got %d", stats.EstimatedStorageBytes)
+	}
+
+	namespaces, err := s.GetRegistryStorageByNamespace(ctx, "test.io")
+	if err != nil {
+		t.Fatalf("GetRegistryStorageByNamespace: %v", err)
+	}
+	if len(namespaces) != 1 || namespaces[0].TotalSizeBytes != 1250 || namespaces[0].UniqueSizeBytes != 1250 {
+		t.Fatalf("expected one namespace holding 1250 unique bytes, got %+v", namespaces)
+	}
+
+	usage, err := s.GetStorageUsageByRegistry(ctx)
+	if err != nil {
+		t.Fatalf("GetStorageUsageByRegistry: %v", err)
+	}
+	if len(usage) != 1 || usage[0].TotalSizeBytes != 1250 {
+		t.Fatalf("expected 1250 bytes for the registry, got %+v", usage)
+	}
+}
+
+func TestTagTimeline(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "lib", "app")
+	mustManifest(t, s, ctx, "sha256:old", "app/json", "image", "{}", "", "linux", "amd64", 100)
+	mustManifest(t, s, ctx, "sha256:new", "app/json", "index", "{}", "", "", "", 300)
+	mustManifest(t, s, ctx, "sha256:arm", "app/json", "image", "{}", "", "linux", "arm64", 150)
+	if err := s.LinkManifestPlatform(ctx, "sha256:new", "sha256:arm", "linux", "arm64", "v8", 0, 150); err != nil {
+		t.Fatalf("LinkManifestPlatform: %v", err)
+	}
+	mustTag(t, s, ctx, repo.ID, "prod", "sha256:new")
+
+	runID, err := s.StartSyncRun(ctx)
+	if err != nil {
+		t.Fatalf("StartSyncRun: %v", err)
+	}
+	if err := s.RecordTagEvent(ctx, repo.ID, "prod", store.TagEventCreated, "", "sha256:old", runID); err != nil {
+		t.Fatalf("RecordTagEvent created: %v", err)
+	}
+	if err := s.RecordTagEvent(ctx, repo.ID, "prod", store.TagEventMoved, "sha256:old", "sha256:new", runID); err != nil {
+		t.Fatalf("RecordTagEvent moved: %v", err)
+	}
+	if err := s.RecordTagEvent(ctx, repo.ID, "other", store.TagEventCreated, "", "sha256:old", 0); err != nil {
+		t.Fatalf("RecordTagEvent other: %v", err)
+	}
+	if err := s.FinishSyncRun(ctx, runID, ""); err != nil {
+		t.Fatalf("FinishSyncRun: %v", err)
+	}
+
+	events, err := s.GetTagTimeline(ctx, repo.ID, "prod")
+	if err != nil {
+		t.Fatalf("GetTagTimeline: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	moved := events[0]
+	if moved.Event != store.TagEventMoved {
+		t.Fatalf("expected newest event to be moved, got %s", moved.Event)
+	}
+	if moved.SyncRunID == nil || *moved.SyncRunID != runID {
+		t.Fatalf("expected sync run %d, got %v", runID, moved.SyncRunID)
+	}
+	if moved.Old == nil || !moved.Old.Available || moved.Old.Size != 100 || moved.Old.Current {
+		t.Fatalf("unexpected old digest summary: %+v", moved.Old)
+	}
+	if len(moved.Old.Platforms) != 1 || moved.Old.Platforms[0] != "linux/amd64" {
+		t.Fatalf("expected old platforms [linux/amd64], got %v", moved.Old.Platforms)
+	}
+	if moved.New == nil || !moved.New.Current || moved.New.Size != 300 {
+		t.Fatalf("unexpected new digest summary: %+v", moved.New)
+	}
+	if len(moved.New.Platforms) != 1 || moved.New.Platforms[0] != "linux/arm64/v8" {
+		t.Fatalf("expected new platforms [linux/arm64/v8], got %v", moved.New.Platforms)
+	}
+
+	if events[1].Event != store.TagEventCreated || events[1].Old != nil {
+		t.Fatalf("unexpected oldest event: %+v", events[1])
+	}
+}
+
+func TestTagTimelineOutlivesTagAndRepository(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "lib", "app")
+	tag := mustTag(t, s, ctx, repo.ID, "prod", "sha256:gone")
+	if err := s.RecordTagEvent(ctx, repo.ID, "prod", store.TagEventDeleted, tag.Digest, "", 0); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+	if err := s.DeleteTag(ctx, tag); err != nil {
+		t.Fatalf("DeleteTag: %v", err)
+	}
+
+	events, err := s.GetTagTimeline(ctx, repo.ID, "prod")
+	if err != nil {
+		t.Fatalf("GetTagTimeline: %v", err)
+	}
+	if len(events) != 1 || events[0].Old == nil || events[0].Old.Available {
+		t.Fatalf("expected one deleted event with unavailable manifest, got %+v", events)
+	}
+
+	if err := s.DeleteRepository(ctx, repo); err != nil {
+		t.Fatalf("DeleteRepository: %v", err)
+	}
+	events, err = s.GetTagTimeline(ctx, repo.ID, "prod")
+	if err != nil {
+		t.Fatalf("GetTagTimeline after repository delete: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no repository to own the history while it is gone, got %d events", len(events))
+	}
+
+	again := mustRepository(t, s, ctx, reg.ID, "lib", "app")
+	if again.ID == repo.ID {
+		t.Fatalf("expected the rediscovered repository to get a new id")
+	}
+	events, err = s.GetTagTimeline(ctx, again.ID, "prod")
+	if err != nil {
+		t.Fatalf("GetTagTimeline after rediscovery: %v", err)
+	}
+	if len(events) != 1 || events[0].Event != store.TagEventDeleted {
+		t.Fatalf("expected the rediscovered repository to pick up its history, got %+v", events)
+	}
+}
+
+func TestGetTagsForRepositoryAsOf(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "lib", "app")
+	mustManifest(t, s, ctx, "sha256:aaa", "app/json", "image", "{}", "", "linux", "amd64", 100)
+	mustManifest(t, s, ctx, "sha256:bbb", "app/json", "image", "{}", "", "linux", "amd64", 200)
+	legacy := mustTag(t, s, ctx, repo.ID, "legacy", "sha256:aaa")
+	prod := mustTag(t, s, ctx, repo.ID, "prod", "sha256:bbb")
+
+	record := func(tag string, event store.TagEventKind, oldDigest, newDigest string) time.Time {
+		t.Helper()
+		if err := s.RecordTagEvent(ctx, repo.ID, tag, event, oldDigest, newDigest, 0); err != nil {
+			t.Fatalf("RecordTagEvent: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		mark := time.Now()
+		time.Sleep(5 * time.Millisecond)
+		return mark
+	}
+
+	afterCreate := record("prod", store.TagEventCreated, "", "sha256:aaa")
+	record("prod", store.TagEventMoved, "sha256:aaa", "sha256:bbb")
+	afterRelease := record("release", store.TagEventCreated, "", "sha256:ccc")
+	record("release", store.TagEventDeleted, "sha256:ccc", "")
+
+	tagsAt := func(asOf time.Time) map[string]store.TagView {
+		t.Helper()
+		result, err := s.GetTagsForRepository(ctx, repo.ID,
+			store.TagFilter{SortBy: "name-asc", AsOf: &asOf}, store.ScrollPagination{Page: 1, PageSize: 10})
+		if err != nil {
+			t.Fatalf("GetTagsForRepository as of %s: %v", asOf, err)
+		}
+		if result.TotalCount != len(result.Tags) {
+			t.Fatalf("expected total count %d to match page size %d", result.TotalCount, len(result.Tags))
+		}
+		byName := make(map[string]store.TagView, len(result.Tags))
+		for _, tv := range result.Tags {
+			byName[tv.Name] = tv
+		}
+		return byName
+	}
+
+	early := tagsAt(afterCreate)
+	if len(early) != 2 || early["prod"].Digest != "sha256:aaa" || early["legacy"].Digest != "sha256:aaa" {
+		t.Fatalf("unexpected tags after create: %+v", early)
+	}
+	if len(early["prod"].Alias) != 1 || early["prod"].Alias[0] != "legacy" {
+		t.Fatalf("expected prod to alias legacy at that time, got %v", early["prod"].Alias)
+	}
+	if early["legacy"].ID != legacy.ID || early["prod"].ID != 0 {
+		t.Fatalf("expected only the unchanged tag to keep its id, got legacy=%d prod=%d", early["legacy"].ID, early["prod"].ID)
+	}
+	if !early["legacy"].ReadOnly || !early["prod"].ReadOnly {
+		t.Fatalf("expected point-in-time tags to be read-only: %+v", early)
+	}
+
+	mid := tagsAt(afterRelease)
+	if len(mid) != 3 || mid["prod"].Digest != "sha256:bbb" {
+		t.Fatalf("unexpected tags after release: %+v", mid)
+	}
+	if !mid["release"].ManifestNotRetained || mid["prod"].ManifestNotRetained {
+		t.Fatalf("expected only release to be marked as not retained: %+v", mid)
+	}
+	if mid["prod"].ID != prod.ID || mid["release"].ID != 0 {
+		t.Fatalf("expected real ids for live tags and none for deleted ones, got prod=%d release=%d", mid["prod"].ID, mid["release"].ID)
+	}
+
+	now := tagsAt(time.Now())
+	if _, ok := now["release"]; ok || len(now) != 2 {
+		t.Fatalf("expected deleted release tag to be gone: %+v", now)
+	}
+}
+
+func TestGetTagsForRepositorySortsByVersionInPages(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "lib", "app")
+	mustManifest(t, s, ctx, "sha256:aaa", "app/json", "image", "{}", "", "linux", "amd64", 100)
+	for _, name := range []string{"latest", "1.9.0", "1.0.0-rc.1", "v2.0.0", "1.10.0", "1.0.0"} {
+		mustTag(t, s, ctx, repo.ID, name, "sha256:aaa")
+	}
+
+	pages := func(sortBy string, asOf *time.Time) []string {
+		t.Helper()
+		var names []string
+		for page := 1; ; page++ {
+			result, err := s.GetTagsForRepository(ctx, repo.ID,
+				store.TagFilter{SortBy: sortBy, AsOf: asOf}, store.ScrollPagination{Page: page, PageSize: 2})
+			if err != nil {
+				t.Fatalf("GetTagsForRepository page %d: %v", page, err)
+			}
+			if len(result.Tags) > 2 {
+				t.Fatalf("expected at most 2 tags on page %d, got %d", page, len(result.Tags))
+			}
+			for _, tv := range result.Tags {
+				names = append(names, tv.Name)
+			}
+			if result.NextPage == nil {
+				return names
+			}
+		}
+	}
+
+	newest := []string{"v2.0.0", "1.10.0", "1.9.0", "1.0.0", "1.0.0-rc.1", "latest"}
+	if got := pages("", nil); !slices.Equal(got, newest) {
+		t.Fatalf("expected %v, got %v", newest, got)
+	}
+	oldest := []string{"1.0.0-rc.1", "1.0.0", "1.9.0", "1.10.0", "v2.0.0", "latest"}
+	if got := pages("oldest", nil); !slices.Equal(got, oldest) {
+		t.Fatalf("expected %v, got %v", oldest, got)
+	}
+
+	// A tag deleted since still sorts by version in the past.
+	if err := s.RecordTagEvent(ctx, repo.ID, "3.0.0", store.TagEventCreated, "", "sha256:aaa", 0); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	asOf := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	if err := s.RecordTagEvent(ctx, repo.ID, "3.0.0", store.TagEventDeleted, "sha256:aaa", "", 0); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+	if got := pages("", &asOf); len(got) != 7 || got[0] != "3.0.0" || got[6] != "latest" {
+		t.Fatalf("expected the deleted 3.0.0 first as of before its deletion, got %v", got)
+	}
+}
+
+func TestGetTagsPageMatchesSortOrder(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "lib", "app")
+	day := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	tags := []struct {
+		name    string
+		size    int64
+		created *time.Time
+	}{
+		{"latest", 300, new(day.Add(48 * time.Hour))},
+		{"edge", 300, nil},
+		{"nightly", 100, new(day)},
+		{"canary", 200, new(day)},
+		{"1.0.0", 100, new(day)},
+		{"1.1.0", 200, nil},
+		{"v1.1.0", 300, new(day.Add(time.Hour))},
+	}
+	for _, tag := range tags {
+		digest := "sha256:" + tag.name
+		if _, err := s.UpsertManifestByFields(ctx, digest, "app/json", "image", "{}", "", "linux", "amd64", "", tag.size, tag.created); err != nil {
+			t.Fatalf("UpsertManifestByFields: %v", err)
+		}
+		mustTag(t, s, ctx, repo.ID, tag.name, digest)
+		if err := s.RecordTagEvent(ctx, repo.ID, tag.name, store.TagEventCreated, "", digest, 0); err != nil {
+			t.Fatalf("RecordTagEvent: %v", err)
+		}
+	}
+	asOf := time.Now()
+
+	for _, sortBy := range []string{"newest", "oldest", "size-asc", "size-desc", "name-asc", "name-desc"} {
+		for _, at := range []*time.Time{nil, &asOf} {
+			filter := store.TagFilter{SortBy: sortBy, AsOf: at}
+			all, err := s.GetTagsForRepository(ctx, repo.ID, filter, store.ScrollPagination{Page: 1, PageSize: 100})
+			if err != nil {
+				t.Fatalf("GetTagsForRepository %s: %v", sortBy, err)
+			}
+			var want, got []string
+			for _, tv := range all.Tags {
+				want = append(want, tv.Name)
+			}
+
+			var after string
+			for range len(tags) {
+				page, err := s.GetTagsPage(ctx, repo.ID, filter, after, 2)
+				if err != nil {
+					t.Fatalf("GetTagsPage %s: %v", sortBy, err)
+				}
+				if page.TotalCount != len(tags) {
+					t.Fatalf("expected a total of %d, got %d", len(tags), page.TotalCount)
+				}
+				for _, tv := range page.Tags {
+					got = append(got, tv.Name)
+				}
+				if after = page.Next; after == "" {
+					break
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("sorted by %s (as of %v): expected pages %v, got %v", sortBy, at != nil, want, got)
+			}
+		}
+	}
+
+	if _, err := s.GetTagsPage(ctx, repo.ID, store.TagFilter{SortBy: "name-asc"}, "bm90LWtleXM", 2); !errors.Is(err, store.ErrInvalidCursor) {
+		t.Fatalf("expected an invalid cursor error, got %v", err)
+	}
+}
+
+func TestLeaseIsExclusiveUntilExpiry(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	ok, err := s.AcquireLease(ctx, "sync", "a", 50*time.Millisecond)
+	if err != nil || !ok {
+		t.Fatalf("expected a to acquire the lease, got %v, %v", ok, err)
+	}
+	ok, err = s.AcquireLease(ctx, "sync", "b", time.Second)
+	if err != nil || ok {
+		t.Fatalf("expected b to be refused while a holds the lease, got %v, %v", ok, err)
+	}
+	ok, err = s.AcquireLease(ctx, "sync", "a", 50*time.Millisecond)
+	if err != nil || !ok {
+		t.Fatalf("expected a to renew its lease, got %v, %v", ok, err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	ok, err = s.AcquireLease(ctx, "sync", "b", time.Second)
+	if err != nil || !ok {
+		t.Fatalf("expected b to take over the expired lease, got %v, %v", ok, err)
+	}
+
+	if err := s.ReleaseLease(ctx, "sync", "a"); err != nil {
+		t.Fatalf("ReleaseLease by former holder: %v", err)
+	}
+	ok, err = s.AcquireLease(ctx, "sync", "a", time.Second)
+	if err != nil || ok {
+		t.Fatalf("expected release by a former holder to leave b's lease intact, got %v, %v", ok, err)
+	}
+}
+
+func TestClaimSyncTriggers(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	claimed, err := s.ClaimSyncTriggers(ctx)
+	if err != nil || claimed {
+		t.Fatalf("expected nothing to claim, got %v, %v", claimed, err)
+	}
+	if err := s.EnqueueSyncTrigger(ctx, "manual", "replica-2"); err != nil {
+		t.Fatalf("EnqueueSyncTrigger: %v", err)
+	}
+	if err := s.EnqueueSyncTrigger(ctx, "manual", "replica-3"); err != nil {
+		t.Fatalf("EnqueueSyncTrigger: %v", err)
+	}
+	claimed, err = s.ClaimSyncTriggers(ctx)
+	if err != nil || !claimed {
+		t.Fatalf("expected pending triggers to be claimed, got %v, %v", claimed, err)
+	}
+	claimed, err = s.ClaimSyncTriggers(ctx)
+	if err != nil || claimed {
+		t.Fatalf("expected triggers to be claimed only once, got %v, %v", claimed, err)
+	}
+}
+
+func TestStorageSnapshots(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	app := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	mustLayer(t, s, ctx, "sha256:db", 500)
+	image := func(digest string, layers ...string) {
+		t.Helper()
+		mustManifest(t, s, ctx, digest, "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "", "linux", "amd64", 0)
+		if err := s.LinkManifestLayers(ctx, digest, layers); err != nil {
+			t.Fatalf("LinkManifestLayers: %v", err)
+		}
+	}
+	image("sha256:app-manifest", "sha256:base")
+	mustTag(t, s, ctx, app.ID, "v1", "sha256:app-manifest")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	threeDaysAgo := now.Add(-72 * time.Hour).Truncate(time.Hour)
+	for _, at := range []time.Time{threeDaysAgo, threeDaysAgo.Add(10 * time.Minute), threeDaysAgo.Add(20 * time.Minute)} {
+		if err := s.RecordStorageSnapshots(ctx, 0, at); err != nil {
+			t.Fatalf("RecordStorageSnapshots: %v", err)
+		}
+	}
+
+	db := mustRepository(t, s, ctx, reg.ID, "ops", "db")
+	image("sha256:db-manifest", "sha256:base", "sha256:db")
+	mustTag(t, s, ctx, db.ID, "v1", "sha256:db-manifest")
+	if err := s.RecordStorageSnapshots(ctx, 0, now); err != nil {
+		t.Fatalf("RecordStorageSnapshots: %v", err)
+	}
+
+	weekAgo := now.Add(-7 * 24 * time.Hour)
+	trend, err := s.GetStorageTrend(ctx, "test.io", weekAgo)
+	if err != nil {
+		t.Fatalf("GetStorageTrend: %v", err)
+	}
+	if len(trend) != 4 {
+		t.Fatalf("expected 4 registry snapshots, got %+v", trend)
+	}
+	if last := trend[3]; last.SizeBytes != 1500 || last.RepositoryCount != 2 || last.TagCount != 2 {
+		t.Fatalf("expected 1500 bytes, 2 repositories and 2 tags, got %+v", last)
+	}
+	if trend[0].SizeBytes != 1000 {
+		t.Fatalf("expected the first snapshot to hold 1000 bytes, got %d", trend[0].SizeBytes)
+	}
+
+	growth, err := s.GetNamespaceGrowth(ctx, "test.io", weekAgo)
+	if err != nil {
+		t.Fatalf("GetNamespaceGrowth: %v", err)
+	}
+	if len(growth) != 2 || growth[0].Namespace != "ops" || growth[0].GrowthBytes != 1500 || growth[1].GrowthBytes != 0 {
+		t.Fatalf("expected ops to grow by 1500 bytes and team to stay flat, got %+v", growth)
+	}
+
+	// The three snapshots of the same hour collapse into the last one.
+	removed, err := s.PruneStorageSnapshots(ctx, now)
+	if err != nil {
+		t.Fatalf("PruneStorageSnapshots: %v", err)
+	}
+	if removed != 4 {
+		t.Fatalf("expected 4 snapshots thinned out, got %d", removed)
+	}
+	trend, err = s.GetStorageTrend(ctx, "test.io", weekAgo)
+	if err != nil {
+		t.Fatalf("GetStorageTrend: %v", err)
+	}
+	if len(trend) != 2 || !trend[0].TakenAt.Equal(threeDaysAgo.Add(20*time.Minute)) {
+		t.Fatalf("expected the last snapshot of the hour to remain, got %+v", trend)
+	}
+
+	// Past a week, one snapshot per day remains.
+	tenDaysAgo := now.Add(-10 * 24 * time.Hour).Truncate(24 * time.Hour)
+	for _, at := range []time.Time{tenDaysAgo.Add(time.Hour), tenDaysAgo.Add(5 * time.Hour)} {
+		if err := s.RecordStorageSnapshots(ctx, 0, at); err != nil {
+			t.Fatalf("RecordStorageSnapshots: %v", err)
+		}
+	}
+	if _, err := s.PruneStorageSnapshots(ctx, now); err != nil {
+		t.Fatalf("PruneStorageSnapshots: %v", err)
+	}
+	trend, err = s.GetStorageTrend(ctx, "test.io", now.Add(-30*24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetStorageTrend: %v", err)
+	}
+	if len(trend) != 3 || !trend[0].TakenAt.Equal(tenDaysAgo.Add(5*time.Hour)) {
+		t.Fatalf("expected one daily snapshot ten days ago, got %+v", trend)
+	}
+
+	// Snapshots older than a year are dropped.
+	if _, err := s.PruneStorageSnapshots(ctx, now.Add(400*24*time.Hour)); err != nil {
+		t.Fatalf("PruneStorageSnapshots: %v", err)
+	}
+	trend, err = s.GetStorageTrend(ctx, "test.io", weekAgo)
+	if err != nil {
+		t.Fatalf("GetStorageTrend: %v", err)
+	}
+	if len(trend) != 0 {
+		t.Fatalf("expected expired snapshots to be dropped, got %+v", trend)
+	}
+}
+
+func TestReclaimEstimate(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	app := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	worker := mustRepository(t, s, ctx, reg.ID, "team", "worker")
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	mustLayer(t, s, ctx, "sha256:v1", 100)
+	mustLayer(t, s, ctx, "sha256:v2", 200)
+	mustLayer(t, s, ctx, "sha256:old", 300)
+	image := func(digest string, layers ...string) {
+		t.Helper()
+		mustManifest(t, s, ctx, digest, "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "", "linux", "amd64", 0)
+		if err := s.LinkManifestLayers(ctx, digest, layers); err != nil {
+			t.Fatalf("LinkManifestLayers: %v", err)
+		}
+	}
+	image("sha256:v1-manifest", "sha256:base", "sha256:v1")
+	image("sha256:v2-manifest", "sha256:base", "sha256:v2")
+	image("sha256:old-manifest", "sha256:base", "sha256:old")
+	mustTag(t, s, ctx, app.ID, "v1", "sha256:v1-manifest")
+	mustTag(t, s, ctx, app.ID, "latest", "sha256:v1-manifest")
+	mustTag(t, s, ctx, app.ID, "v2", "sha256:v2-manifest")
+	mustTag(t, s, ctx, worker.ID, "v2", "sha256:v2-manifest")
+
+	// v1 and its alias go together; the base layer stays for v2.
+	est, err := s.EstimateTagDeletion(ctx, app.ID, []string{"v1"})
+	if err != nil {
+		t.Fatalf("EstimateTagDeletion: %v", err)
+	}
+	if *est != (store.ReclaimEstimate{Manifests: 1, Blobs: 1, ReclaimableBytes: 100, RetainedBytes: 1000}) {
+		t.Fatalf("expected v1 to free its own 100-byte layer, got %+v", est)
+	}
+
+	// The worker repository still references the v2 manifest.
+	est, err = s.EstimateTagDeletion(ctx, app.ID, []string{"v1", "v2"})
+	if err != nil {
+		t.Fatalf("EstimateTagDeletion: %v", err)
+	}
+	if *est != (store.ReclaimEstimate{Manifests: 1, Blobs: 1, ReclaimableBytes: 100, RetainedBytes: 1000}) {
+		t.Fatalf("expected v2 to be retained by worker, got %+v", est)
+	}
+
+	// A tag moved away from old-manifest leaves it to garbage collection.
+	if err := s.RecordTagEvent(ctx, app.ID, "v2", store.TagEventMoved, "sha256:old-manifest", "sha256:v2-manifest", 0); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+	est, err = s.GetReclaimableStorage(ctx, "test.io")
+	if err != nil {
+		t.Fatalf("GetReclaimableStorage: %v", err)
+	}
+	if *est != (store.ReclaimEstimate{Manifests: 1, Blobs: 1, ReclaimableBytes: 300, RetainedBytes: 1000}) {
+		t.Fatalf("expected the untagged manifest to free 300 bytes, got %+v", est)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	mustLayer(t, s, ctx, "sha256:old", 300)
+	if _, err := s.UpsertConfigBlobByFields(ctx, "sha256:old-config", 10, `{}`, "linux", "amd64", nil); err != nil {
+		t.Fatalf("UpsertConfigBlobByFields: %v", err)
+	}
+	image := func(digest, config string, layers ...string) {
+		t.Helper()
+		mustManifest(t, s, ctx, digest, "application/vnd.oci.image.manifest.v1+json", "image", `{}`, config, "linux", "amd64", 0)
+		if err := s.LinkManifestLayers(ctx, digest, layers); err != nil {
+			t.Fatalf("LinkManifestLayers: %v", err)
+		}
+	}
+	index := func(digest string, platforms ...string) {
+		t.Helper()
+		mustManifest(t, s, ctx, digest, "application/vnd.oci.image.index.v1+json", "index", `{}`, "", "", "", 0)
+		for i, platform := range platforms {
+			if err := s.LinkManifestPlatform(ctx, digest, platform, "linux", "amd64", "", i, 0); err != nil {
+				t.Fatalf("LinkManifestPlatform: %v", err)
+			}
+		}
+	}
+	image("sha256:live-amd64", "", "sha256:base")
+	index("sha256:live-index", "sha256:live-amd64")
+	image("sha256:old-amd64", "sha256:old-config", "sha256:base", "sha256:old")
+	index("sha256:old-index", "sha256:old-amd64")
+	if _, err := s.UpsertTagWithSync(ctx, repo.ID, "v2", "sha256:live-index", "index", "app/json", 1.0); err != nil {
+		t.Fatalf("UpsertTagWithSync: %v", err)
+	}
+	if err := s.RecordTagEvent(ctx, repo.ID, "v2", store.TagEventMoved, "sha256:old-index", "sha256:live-index", 0); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+
+	// Within the grace period the old index is still part of v2's history.
+	result, err := s.CollectGarbage(ctx, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("CollectGarbage: %v", err)
+	}
+	if result.Manifests != 0 || result.Layers != 0 {
+		t.Fatalf("expected nothing collected within the grace period, got %+v", result)
+	}
+
+	result, err = s.CollectGarbage(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CollectGarbage: %v", err)
+	}
+	want := store.GCResult{Manifests: 2, Platforms: 1, ManifestLayers: 2, Layers: 1, ConfigBlobs: 1}
+	result.ReclaimedBytes = 0
+	if *result != want {
+		t.Fatalf("expected %+v collected, got %+v", want, result)
+	}
+	mustRebuildStats(t, s, ctx)
+
+	views, err := s.GetRepositoriesView(ctx)
+	if err != nil {
+		t.Fatalf("GetRepositoriesView: %v", err)
+	}
+	if len(views) != 1 || views[0].TotalSizeInBytes != 1000 {
+		t.Fatalf("expected the tagged index to keep its 1000-byte layer, got %+v", views)
+	}
+}
+
+func TestWithinSavepointRollsBackOnlyItsStatements(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	err := s.WithinTx(ctx, func(tx *store.Store) error {
+		if err := tx.WithinSavepoint(ctx, func() error {
+			_, err := tx.UpsertRegistryByFields(ctx, "kept", "https://kept.io", "kept.io", 200)
+			return err
+		}); err != nil {
+			return err
+		}
+		failed := errors.New("tag failed")
+		if err := tx.WithinSavepoint(ctx, func() error {
+			if _, err := tx.UpsertRegistryByFields(ctx, "dropped", "https://dropped.io", "dropped.io", 200); err != nil {
+				return err
+			}
+			return failed
+		}); !errors.Is(err, failed) {
+			t.Fatalf("expected the savepoint to return its error, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithinTx: %v", err)
+	}
+
+	for host, want := range map[string]bool{"kept.io": true, "dropped.io": false} {
+		_, err := s.GetRegistryByHost(ctx, host)
+		if got := err == nil; got != want {
+			t.Fatalf("registry %s present = %v, want %v (err %v)", host, got, want, err)
+		}
+	}
+	if err := s.WithinSavepoint(ctx, func() error { return nil }); err == nil {
+		t.Fatal("expected a savepoint outside a transaction to fail")
+	}
+}
+
+func TestRepositoryPlatforms(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	api := mustRepository(t, s, ctx, reg.ID, "team", "api")
+	mustManifest(t, s, ctx, "sha256:api", "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "", "linux", "arm64", 100)
+	apiTag := mustTag(t, s, ctx, api.ID, "v1", "sha256:api")
+
+	agent := mustRepository(t, s, ctx, reg.ID, "team", "agent")
+	mustManifest(t, s, ctx, "sha256:agent", "application/vnd.oci.image.index.v1+json", "index", `{}`, "", "", "", 0)
+	for i, p := range []struct{ digest, os, arch, variant string }{
+		{"sha256:agent-linux", "linux", "amd64", ""},
+		{"sha256:agent-arm", "linux", "arm", "v7"},
+		{"sha256:agent-windows", "windows", "amd64", ""},
+	} {
+		mustManifest(t, s, ctx, p.digest, "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "", p.os, p.arch, 100)
+		if err := s.LinkManifestPlatform(ctx, "sha256:agent", p.digest, p.os, p.arch, p.variant, i, 100); err != nil {
+			t.Fatalf("LinkManifestPlatform: %v", err)
+		}
+	}
+	if _, err := s.UpsertTagWithSync(ctx, agent.ID, "v1", "sha256:agent", "index", "app/json", 1.0); err != nil {
+		t.Fatalf("UpsertTagWithSync: %v", err)
+	}
+	for _, id := range []uint{api.ID, agent.ID} {
+		if err := s.RefreshRepositoryPlatforms(ctx, id); err != nil {
+			t.Fatalf("RefreshRepositoryPlatforms: %v", err)
+		}
+	}
+
+	names := func(filters store.RepositoryFilters) []string {
+		t.Helper()
+		filters.ShowUntagged = true
+		repos, err := s.GetRepositoriesViewFiltered(ctx, filters)
+		if err != nil {
+			t.Fatalf("GetRepositoriesViewFiltered: %v", err)
+		}
+		var out []string
+		for _, r := range repos {
+			out = append(out, r.Name)
+		}
+		sort.Strings(out)
+		return out
+	}
+	cases := []struct {
+		name    string
+		filters store.RepositoryFilters
+		want    []string
+	}{
+		{"architecture", store.RepositoryFilters{Architectures: []string{"amd64"}}, []string{"agent"}},
+		{"variant", store.RepositoryFilters{Architectures: []string{"arm/v7"}}, []string{"agent"}},
+		{"architecture without its variant", store.RepositoryFilters{Architectures: []string{"arm"}}, nil},
+		{"os", store.RepositoryFilters{OperatingSystems: []string{"windows"}}, []string{"agent"}},
+		{"shared os", store.RepositoryFilters{OperatingSystems: []string{"linux"}}, []string{"agent", "api"}},
+	}
+	for _, c := range cases {
+		if got := names(c.filters); !slices.Equal(got, c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	archs, err := s.GetUniqueArchitectures(ctx)
+	if err != nil {
+		t.Fatalf("GetUniqueArchitectures: %v", err)
+	}
+	if !slices.Equal(archs, []string{"amd64", "arm/v7", "arm64"}) {
+		t.Fatalf("unexpected architectures %v", archs)
+	}
+	coverage, err := s.GetRegistryArchitectureCoverage(ctx, "test.io", nil)
+	if err != nil {
+		t.Fatalf("GetRegistryArchitectureCoverage: %v", err)
+	}
+	if len(coverage) != 3 || coverage[0].Architecture != "amd64" || coverage[0].RepositoryCount != 1 {
+		t.Fatalf("unexpected coverage %+v", coverage)
+	}
+
+	if err := s.DeleteTag(ctx, apiTag); err != nil {
+		t.Fatalf("DeleteTag: %v", err)
+	}
+	if got := names(store.RepositoryFilters{Architectures: []string{"arm64"}}); got != nil {
+		t.Fatalf("expected the deleted tag's platform to be gone, got %v", got)
+	}
+}
+
+func TestRepositoryStats(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	app := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	worker := mustRepository(t, s, ctx, reg.ID, "team", "worker")
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	mustLayer(t, s, ctx, "sha256:app", 200)
+	created := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for _, img := range []struct{ digest, layer string }{{"sha256:app-manifest", "sha256:app"}, {"sha256:worker-manifest", ""}} {
+		if _, err := s.UpsertManifestByFields(ctx, img.digest, "application/vnd.oci.image.manifest.v1+json", "image",
+			`{}`, "", "linux", "amd64", "", 0, &created); err != nil {
+			t.Fatalf("UpsertManifestByFields: %v", err)
+		}
+		layers := []string{"sha256:base"}
+		if img.layer != "" {
+			layers = append(layers, img.layer)
+		}
+		if err := s.LinkManifestLayers(ctx, img.digest, layers); err != nil {
+			t.Fatalf("LinkManifestLayers: %v", err)
+		}
+	}
+	mustTag(t, s, ctx, app.ID, "v1", "sha256:app-manifest")
+	mustTag(t, s, ctx, worker.ID, "v1", "sha256:worker-manifest")
+	if err := s.RecordTagEvent(ctx, app.ID, "v1", store.TagEventCreated, "", "sha256:app-manifest", 0); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+	for _, id := range []uint{app.ID, worker.ID} {
+		if err := s.RefreshRepository(ctx, id); err != nil {
+			t.Fatalf("RefreshRepository: %v", err)
+		}
+	}
+
+	repo, err := s.GetRepositoryByPath(ctx, "test.io", "team", "app")
+	if err != nil {
+		t.Fatalf("GetRepositoryByPath: %v", err)
+	}
+	if repo.TagsCount != 1 || repo.TotalSizeInBytes != 1200 {
+		t.Fatalf("expected a refresh to count 1 tag of 1200 bytes, got %+v", repo)
+	}
+	hourAgo := time.Now().Add(-2 * time.Hour)
+	updated, err := s.GetRepositoriesViewFiltered(ctx, store.RepositoryFilters{UpdatedAfter: &hourAgo})
+	if err != nil || len(updated) != 2 {
+		t.Fatalf("expected both repositories updated in the last two hours, got %+v, %v", updated, err)
+	}
+
+	// Unique sizes wait for a rebuild, which the check reports as drift.
+	drift, err := s.CheckRepositoryStats(ctx)
+	if err != nil {
+		t.Fatalf("CheckRepositoryStats: %v", err)
+	}
+	if len(drift) != 1 || drift[0].Path != "test.io/team/app" || drift[0].Expected.UniqueSizeBytes != 200 {
+		t.Fatalf("expected app's unique size to drift, got %+v", drift)
+	}
+	if drift[0].Stored.LastChangedAt == nil || !drift[0].Stored.LastChangedAt.Equal(*drift[0].Expected.LastChangedAt) {
+		t.Fatalf("expected the last change to be stored, got %+v", drift[0])
+	}
+
+	mustRebuildStats(t, s, ctx)
+	if drift, err := s.CheckRepositoryStats(ctx); err != nil || len(drift) != 0 {
+		t.Fatalf("expected no drift after a rebuild, got %+v, %v", drift, err)
+	}
+}
+
+func TestRefreshRegistryStats(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	mustManifest(t, s, ctx, "sha256:base-manifest", "application/vnd.oci.image.manifest.v1+json", "image", `{}`, "", "linux", "amd64", 0)
+	if err := s.LinkManifestLayers(ctx, "sha256:base-manifest", []string{"sha256:base"}); err != nil {
+		t.Fatalf("LinkManifestLayers: %v", err)
+	}
+	// Two registries, each with two repositories sharing the base layer.
+	deleted := map[string]*store.Tag{}
+	registries := map[string]uint{}
+	for _, host := range []string{"test.io", "other.io"} {
+		reg := mustRegistry(t, s, ctx, host, "https://"+host, host)
+		registries[host] = reg.ID
+		for _, name := range []string{"app", "worker"} {
+			repo := mustRepository(t, s, ctx, reg.ID, "team", name)
+			tag := mustTag(t, s, ctx, repo.ID, "v1", "sha256:base-manifest")
+			if name == "app" {
+				deleted[host] = tag
+			}
+		}
+	}
+	mustRebuildStats(t, s, ctx)
+
+	// A sync run that deleted the test.io tag changed only that registry.
+	runID, err := s.StartSyncRun(ctx)
+	if err != nil {
+		t.Fatalf("StartSyncRun: %v", err)
+	}
+	if err := s.RecordTagEvent(ctx, deleted["test.io"].RepositoryID, "v1", store.TagEventDeleted, "sha256:base-manifest", "", runID); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+	changed, err := s.GetSyncRunRegistries(ctx, runID)
+	if err != nil || len(changed) != 1 || changed[0] != registries["test.io"] {
+		t.Fatalf("expected the run to have changed test.io only, got %v, %v", changed, err)
+	}
+
+	for _, tag := range deleted {
+		if err := s.DeleteTag(ctx, tag); err != nil {
+			t.Fatalf("DeleteTag: %v", err)
+		}
+	}
+	if err := s.RefreshRegistryStats(ctx, registries["test.io"]); err != nil {
+		t.Fatalf("RefreshRegistryStats: %v", err)
+	}
+
+	worker, err := s.GetRepositoryByPath(ctx, "test.io", "team", "worker")
+	if err != nil {
+		t.Fatalf("GetRepositoryByPath: %v", err)
+	}
+	if worker.UniqueSizeInBytes != 1000 {
+		t.Fatalf("expected the base layer to become unique to worker, got %+v", worker)
+	}
+	// The other registry is left alone until it is refreshed itself.
+	drift, err := s.CheckRepositoryStats(ctx)
+	if err != nil {
+		t.Fatalf("CheckRepositoryStats: %v", err)
+	}
+	if len(drift) != 1 || drift[0].Path != "other.io/team/worker" {
+		t.Fatalf("expected only other.io/team/worker to drift, got %+v", drift)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if _, err := s.UpsertManifestByFields(ctx, "sha256:app", "application/vnd.oci.image.manifest.v1+json", "image",
+		`{"schemaVersion":2}`, "", "linux", "amd64", "", 0, &created); err != nil {
+		t.Fatalf("UpsertManifestByFields: %v", err)
+	}
+	if err := s.LinkManifestLayers(ctx, "sha256:app", []string{"sha256:base"}); err != nil {
+		t.Fatalf("LinkManifestLayers: %v", err)
+	}
+	mustTag(t, s, ctx, repo.ID, "v1.2.0", "sha256:app")
+	if err := s.RecordTagEvent(ctx, repo.ID, "v1.2.0", store.TagEventCreated, "", "sha256:app", 0); err != nil {
+		t.Fatalf("RecordTagEvent: %v", err)
+	}
+	if err := s.RefreshRepository(ctx, repo.ID); err != nil {
+		t.Fatalf("RefreshRepository: %v", err)
+	}
+	mustRebuildStats(t, s, ctx)
+
+	var export bytes.Buffer
+	rows, err := s.Export(ctx, &export)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	// Importing into the source finds every row already present.
+	if result, err := s.Import(ctx, bytes.NewReader(export.Bytes())); err != nil || result.Rows != rows || result.Inserted != 0 {
+		t.Fatalf("expected re-importing %d rows to insert nothing, got %+v, %v", rows, result, err)
+	}
+
+	dest, err := store.New(ctx, ":memory:")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = dest.Close() })
+	result, err := dest.Import(ctx, bytes.NewReader(export.Bytes()))
+	if err != nil || result.Inserted != rows {
+		t.Fatalf("expected all %d rows inserted, got %+v, %v", rows, result, err)
+	}
+
+	want, err := s.GetRepositoriesView(ctx)
+	if err != nil {
+		t.Fatalf("GetRepositoriesView: %v", err)
+	}
+	got, err := dest.GetRepositoriesView(ctx)
+	if err != nil {
+		t.Fatalf("GetRepositoriesView: %v", err)
+	}
+	if len(got) != 1 || got[0].TagsCount != want[0].TagsCount || got[0].TotalSizeInBytes != want[0].TotalSizeInBytes {
+		t.Fatalf("expected the imported repository to match %+v, got %+v", want, got)
+	}
+	timeline, err := dest.GetTagTimeline(ctx, repo.ID, "v1.2.0")
+	if err != nil || len(timeline) != 1 || timeline[0].NewDigest != "sha256:app" {
+		t.Fatalf("expected the tag history imported, got %+v, %v", timeline, err)
+	}
+
+	// New rows get ids past the imported ones.
+	other := mustRepository(t, dest, ctx, reg.ID, "team", "worker")
+	if other.ID == repo.ID {
+		t.Fatalf("expected a new repository id, got %d", other.ID)
+	}
+
+	header, _, _ := bytes.Cut(export.Bytes(), []byte("\n"))
+	stale := strings.Replace(string(header), `"schema_version":`, `"schema_version":1`, 1)
+	if _, err := dest.Import(ctx, strings.NewReader(stale+"\n")); err == nil || !strings.Contains(err.Error(), "schema version") {
+		t.Fatalf("expected an export from another schema version to be refused, got %v", err)
+	}
+}
+
+func TestImportRefusesOverlappingRows(t *testing.T) {
+	s, ctx := setupStore(t)
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	mustTag(t, s, ctx, repo.ID, "latest", "sha256:app")
+	var export bytes.Buffer
+	if _, err := s.Export(ctx, &export); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		populate func(t *testing.T, dest *store.Store)
+	}{
+		{
+			// Ids the export uses belong to other rows here.
+			name: "same ids",
+			populate: func(t *testing.T, dest *store.Store) {
+				other := mustRegistry(t, dest, ctx, "other", "https://other.io", "other.io")
+				mustRepository(t, dest, ctx, other.ID, "ops", "tool")
+			},
+		},
+		{
+			// The exported registry exists here under another id, and its
+			// own id is free.
+			name: "same names",
+			populate: func(t *testing.T, dest *store.Store) {
+				placeholder := mustRegistry(t, dest, ctx, "placeholder", "https://placeholder.io", "placeholder.io")
+				mustRegistry(t, dest, ctx, "test", "https://test.io", "test.io")
+				if err := dest.DeleteRegistry(ctx, placeholder.ID); err != nil {
+					t.Fatalf("DeleteRegistry: %v", err)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dest, err := store.New(ctx, ":memory:")
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			t.Cleanup(func() { _ = dest.Close() })
+			test.populate(t, dest)
+			before, err := dest.GetRepositoriesView(ctx)
+			if err != nil {
+				t.Fatalf("GetRepositoriesView: %v", err)
+			}
+
+			if _, err := dest.Import(ctx, bytes.NewReader(export.Bytes())); err == nil || !strings.Contains(err.Error(), "empty database") {
+				t.Fatalf("expected the import to be refused, got %v", err)
+			}
+			after, err := dest.GetRepositoriesView(ctx)
+			if err != nil {
+				t.Fatalf("GetRepositoriesView: %v", err)
+			}
+			if len(after) != len(before) {
+				t.Fatalf("expected the refused import to change nothing, had %d repositories, now %d", len(before), len(after))
+			}
+			if tag, err := dest.GetTagByRepoAndName(ctx, repo.ID, "latest"); err == nil {
+				t.Fatalf("expected no tag imported, got %+v", tag)
+			}
+		})
+	}
+}
+
+func TestBackupCopiesOpenDatabase(t *testing.T) {
+	if os.Getenv("TEST_DATABASE_CONNECTION") == store.ConnectionPostgres {
+		t.Skip("backup is SQLite only")
+	}
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := store.New(ctx, filepath.Join(dir, "ui.db"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+
+	path := filepath.Join(dir, "backup.db")
+	if err := s.Backup(ctx, path); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the temporary copy renamed away, got %v", err)
+	}
+
+	restored, err := store.New(ctx, path)
+	if err != nil {
+		t.Fatalf("New from backup: %v", err)
+	}
+	t.Cleanup(func() { _ = restored.Close() })
+	if reg, err := restored.GetRegistryByHost(ctx, "test.io"); err != nil || reg.Name != "test" {
+		t.Fatalf("expected the backup to hold the registry, got %+v, %v", reg, err)
+	}
+}
+
+func TestGetTagDetail(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	reg := mustRegistry(t, s, ctx, "test", "https://test.io", "test.io")
+	repo := mustRepository(t, s, ctx, reg.ID, "team", "app")
+	created := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	if _, err := s.UpsertConfigBlobByFields(ctx, "sha256:config", 300, `{"config":{"Labels":{"team":"payments"}}}`, "linux", "amd64", &created); err != nil {
+		t.Fatalf("UpsertConfigBlobByFields: %v", err)
+	}
+	mustLayer(t, s, ctx, "sha256:base", 1000)
+	mustLayer(t, s, ctx, "sha256:app", 200)
+	for _, m := range []struct{ digest, kind, config, arch string }{
+		{"sha256:index", "index", "", ""},
+		{"sha256:amd64", "image", "sha256:config", "amd64"},
+		{"sha256:arm64", "image", "", "arm64"},
+	} {
+		if _, err := s.UpsertManifestByFields(ctx, m.digest, "application/vnd.oci.image.manifest.v1+json", m.kind,
+			`{}`, m.config, "linux", m.arch, "", 0, &created); err != nil {
+			t.Fatalf("UpsertManifestByFields: %v", err)
+		}
+	}
+	if err := s.LinkManifestLayers(ctx, "sha256:amd64", []string{"sha256:base", "sha256:app"}); err != nil {
+		t.Fatalf("LinkManifestLayers: %v", err)
+	}
+	for i, platform := range []struct{ digest, arch string }{{"sha256:arm64", "arm64"}, {"sha256:amd64", "amd64"}} {
+		if err := s.LinkManifestPlatform(ctx, "sha256:index", platform.digest, "linux", platform.arch, "", i, 500); err != nil {
+			t.Fatalf("LinkManifestPlatform: %v", err)
+		}
+	}
+	if _, err := s.UpsertTagWithSync(ctx, repo.ID, "v1", "sha256:index", "index", "application/vnd.oci.image.index.v1+json", 1.0); err != nil {
+		t.Fatalf("UpsertTagWithSync: %v", err)
+	}
+
+	detail, err := s.GetTagDetail(ctx, repo.ID, "v1")
+	if err != nil {
+		t.Fatalf("GetTagDetail: %v", err)
+	}
+	if detail.Kind != "index" || len(detail.Images) != 2 {
+		t.Fatalf("expected an index with two images, got %+v", detail)
+	}
+	arm, amd := detail.Images[0], detail.Images[1]
+	if arm.Architecture != "arm64" || arm.Config != nil || len(arm.Layers) != 0 || arm.SizeBytes != 500 {
+		t.Fatalf("expected the arm64 platform first, without config or layers, got %+v", arm)
+	}
+	if amd.Config == nil || amd.Config.Digest != "sha256:config" || !strings.Contains(string(amd.Config.Config), "payments") {
+		t.Fatalf("expected the amd64 config, got %+v", amd.Config)
+	}
+	if len(amd.Layers) != 2 || amd.Layers[0].Digest != "sha256:base" || amd.Layers[1].SizeBytes != 200 {
+		t.Fatalf("expected amd64 layers in order, got %+v", amd.Layers)
+	}
+	if amd.Created == nil || !amd.Created.Equal(created) {
+		t.Fatalf("expected the image creation time, got %v", amd.Created)
+	}
+
+	if _, err := s.GetTagDetail(ctx, repo.ID, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected a missing tag to report no rows, got %v", err)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	expires := time.Now().Add(time.Hour).UTC()
+	token := &store.APIToken{
+		TokenHash:   "hash-ci",
+		Prefix:      "chub_abc",
+		Name:        "ci",
+		Scopes:      []string{store.APITokenScopeRead, store.APITokenScopeSync},
+		UserSubject: "alice",
+		UserEmail:   "alice@example.com",
+		UserGroups:  []string{"platform"},
+		ExpiresAt:   &expires,
+	}
+	if err := s.CreateAPIToken(ctx, token); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if err := s.CreateAPIToken(ctx, &store.APIToken{TokenHash: "hash-bob", Prefix: "chub_def", Name: "bob",
+		Scopes: []string{store.APITokenScopeRead}, UserSubject: "bob"}); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+
+	got, err := s.GetAPITokenByHash(ctx, "hash-ci")
+	if err != nil {
+		t.Fatalf("GetAPITokenByHash: %v", err)
+	}
+	if got.ID != token.ID || !got.HasScope(store.APITokenScopeSync) || got.HasScope(store.APITokenScopeDelete) ||
+		!slices.Equal(got.UserGroups, []string{"platform"}) || got.UserRoles == nil || !got.Active(time.Now()) {
+		t.Fatalf("unexpected token %+v", got)
+	}
+	if got.Active(expires.Add(time.Second)) {
+		t.Fatal("expected the token to expire")
+	}
+	if _, err := s.GetAPITokenByHash(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected no rows for an unknown hash, got %v", err)
+	}
+
+	used := time.Now()
+	if err := s.TouchAPIToken(ctx, token.ID, used); err != nil {
+		t.Fatalf("TouchAPIToken: %v", err)
+	}
+	if err := s.TouchAPIToken(ctx, token.ID, used.Add(time.Second)); err != nil {
+		t.Fatalf("TouchAPIToken: %v", err)
+	}
+	got, _ = s.GetAPITokenByHash(ctx, "hash-ci")
+	if got.LastUsedAt == nil || !got.LastUsedAt.Equal(used) {
+		t.Fatalf("expected the first use to be kept within a minute, got %v", got.LastUsedAt)
+	}
+
+	if mine, err := s.ListAPITokens(ctx, "alice"); err != nil || len(mine) != 1 {
+		t.Fatalf("expected one token for alice, got %d, %v", len(mine), err)
+	}
+	if all, err := s.ListAPITokens(ctx, ""); err != nil || len(all) != 2 {
+		t.Fatalf("expected two tokens in total, got %d, %v", len(all), err)
+	}
+
+	if err := s.RevokeAPIToken(ctx, token.ID, "bob", time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected bob not to revoke alice's token, got %v", err)
+	}
+	if err := s.RevokeAPIToken(ctx, token.ID, "alice", time.Now()); err != nil {
+		t.Fatalf("RevokeAPIToken: %v", err)
+	}
+	if err := s.RevokeAPIToken(ctx, token.ID, "", time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected a revoked token not to be revoked again, got %v", err)
+	}
+	got, _ = s.GetAPITokenByHash(ctx, "hash-ci")
+	if got.Active(time.Now()) {
+		t.Fatal("expected the revoked token to be inactive")
+	}
+}
+
+func TestNarrowAPITokenClaims(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	for _, token := range []*store.APIToken{
+		{TokenHash: "hash-ci", Prefix: "chub_abc", Name: "ci", Scopes: []string{store.APITokenScopeRead},
+			UserSubject: "alice", UserGroups: []string{"platform", "team-a"}, UserRoles: []string{"ops"}},
+		{TokenHash: "hash-bob", Prefix: "chub_def", Name: "bob", Scopes: []string{store.APITokenScopeRead},
+			UserSubject: "bob", UserGroups: []string{"platform"}},
+	} {
+		if err := s.CreateAPIToken(ctx, token); err != nil {
+			t.Fatalf("CreateAPIToken: %v", err)
+		}
+	}
+
+	n, err := s.NarrowAPITokenClaims(ctx, "alice", []string{"team-a", "team-b"}, []string{"ops"})
+	if err != nil || n != 1 {
+		t.Fatalf("expected one token narrowed, got %d, %v", n, err)
+	}
+	got, _ := s.GetAPITokenByHash(ctx, "hash-ci")
+	if !slices.Equal(got.UserGroups, []string{"team-a"}) || !slices.Equal(got.UserRoles, []string{"ops"}) {
+		t.Fatalf("expected the token to keep only team-a and ops, not gain team-b, got %v %v", got.UserGroups, got.UserRoles)
+	}
+	if n, err := s.NarrowAPITokenClaims(ctx, "alice", []string{"team-a"}, []string{"ops"}); err != nil || n != 0 {
+		t.Fatalf("expected nothing left to narrow, got %d, %v", n, err)
+	}
+	if bob, _ := s.GetAPITokenByHash(ctx, "hash-bob"); !slices.Equal(bob.UserGroups, []string{"platform"}) {
+		t.Fatalf("expected another user's token to be left alone, got %v", bob.UserGroups)
+	}
+
+	if n, err := s.RevokeUserAPITokens(ctx, "alice", time.Now()); err != nil || n != 1 {
+		t.Fatalf("expected alice's token revoked, got %d, %v", n, err)
+	}
+	if got, _ := s.GetAPITokenByHash(ctx, "hash-ci"); got.Active(time.Now()) {
+		t.Fatal("expected the revoked token to be inactive")
+	}
+	if bob, _ := s.GetAPITokenByHash(ctx, "hash-bob"); !bob.Active(time.Now()) {
+		t.Fatal("expected bob's token to stay active")
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	start := time.Now().Add(-time.Hour)
+	tokenID := uint(7)
+	events := []store.AuditEvent{
+		{OccurredAt: start, Action: store.AuditActionLogin, Outcome: store.AuditOutcomeSuccess,
+			ActorSubject: "alice", ActorEmail: "alice@example.com", ClientIP: "10.0.0.1"},
+		{OccurredAt: start.Add(time.Minute), Action: store.AuditActionTagsDelete, Outcome: store.AuditOutcomeSuccess,
+			ActorSubject: "alice", ActorEmail: "alice@example.com", TokenID: &tokenID, TokenName: "ci",
+			Registry: "registry.example.com", Repository: "team/api", Tags: []string{"v1", "v2"},
+			Digests: []string{"sha256:one"}, RequestID: "req-1"},
+		{OccurredAt: start.Add(2 * time.Minute), Action: store.AuditActionTagsDelete, Outcome: store.AuditOutcomeDenied,
+			ActorSubject: "bob", ActorName: "Bob 100%", Registry: "registry.example.com", Repository: "team/web"},
+		{Action: store.AuditActionConfigReload, Outcome: store.AuditOutcomeFailure, Detail: "invalid policy"},
+	}
+	for i := range events {
+		if err := s.RecordAuditEvent(ctx, &events[i]); err != nil {
+			t.Fatalf("RecordAuditEvent: %v", err)
+		}
+	}
+	if events[3].ID <= events[2].ID || events[3].OccurredAt.IsZero() {
+		t.Fatalf("expected increasing ids and a default time, got %+v", events[3])
+	}
+
+	since, until := start.Add(30*time.Second), start.Add(90*time.Second)
+	all, more, err := s.ListAuditEvents(ctx, store.AuditFilter{})
+	if err != nil || more || len(all) != 4 || all[0].ID != events[3].ID {
+		t.Fatalf("expected all four events newest first, got %d, %v, %v", len(all), more, err)
+	}
+	deleted := all[2]
+	if deleted.TokenID == nil || *deleted.TokenID != tokenID || !slices.Equal(deleted.Tags, []string{"v1", "v2"}) ||
+		!slices.Equal(deleted.Digests, []string{"sha256:one"}) || deleted.RequestID != "req-1" {
+		t.Fatalf("unexpected deletion event %+v", deleted)
+	}
+	if all[0].TokenID != nil || all[0].Tags == nil {
+		t.Fatalf("expected no token and empty tags, got %+v", all[0])
+	}
+
+	filters := []struct {
+		name   string
+		filter store.AuditFilter
+		want   []uint
+	}{
+		{"action", store.AuditFilter{Action: store.AuditActionTagsDelete}, []uint{events[2].ID, events[1].ID}},
+		{"outcome", store.AuditFilter{Outcome: store.AuditOutcomeDenied}, []uint{events[2].ID}},
+		{"actor email", store.AuditFilter{Actor: "ALICE@"}, []uint{events[1].ID, events[0].ID}},
+		{"actor wildcard is literal", store.AuditFilter{Actor: "100%"}, []uint{events[2].ID}},
+		{"target", store.AuditFilter{Target: "team/web"}, []uint{events[2].ID}},
+		{"time range", store.AuditFilter{Since: &since, Until: &until}, []uint{events[1].ID}},
+	}
+	for _, tt := range filters {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := s.ListAuditEvents(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListAuditEvents: %v", err)
+			}
+			ids := make([]uint, len(got))
+			for i, e := range got {
+				ids[i] = e.ID
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, ids)
+			}
+		})
+	}
+
+	page, more, err := s.ListAuditEvents(ctx, store.AuditFilter{Limit: 3})
+	if err != nil || !more || len(page) != 3 {
+		t.Fatalf("expected a full first page with more, got %d, %v, %v", len(page), more, err)
+	}
+	page, more, err = s.ListAuditEvents(ctx, store.AuditFilter{Limit: 3, BeforeID: page[2].ID})
+	if err != nil || more || len(page) != 1 || page[0].ID != events[0].ID {
+		t.Fatalf("expected the oldest event on the last page, got %+v, %v, %v", page, more, err)
+	}
+}
+
+// auditArchive collects pruned events, failing on the first when err is set.
+type auditArchive struct {
+	events []store.AuditEvent
+	closed bool
+	err    error
+}
+
+func (a *auditArchive) Write(event store.AuditEvent) error {
+	if a.err != nil {
+		return a.err
+	}
+	a.events = append(a.events, event)
+	return nil
+}
+
+func (a *auditArchive) Close() error {
+	a.closed = true
+	return nil
+}
+
+func TestPruneAuditEvents(t *testing.T) {
+	s, ctx := setupStore(t)
+
+	now := time.Now()
+	for _, age := range []time.Duration{400 * 24 * time.Hour, 380 * 24 * time.Hour, time.Hour} {
+		event := &store.AuditEvent{OccurredAt: now.Add(-age), Action: store.AuditActionLogin, Outcome: store.AuditOutcomeFailure}
+		if err := s.RecordAuditEvent(ctx, event); err != nil {
+			t.Fatalf("RecordAuditEvent: %v", err)
+		}
+	}
+	cutoff := now.Add(-365 * 24 * time.Hour)
+
+	failing := &auditArchive{err: errors.New("disk full")}
+	if _, err := s.PruneAuditEvents(ctx, cutoff, failing, &store.AuditEvent{}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected the archive failure, got %v", err)
+	}
+	if events, _, _ := s.ListAuditEvents(ctx, store.AuditFilter{}); len(events) != 3 {
+		t.Fatalf("expected a failed archive to keep every event, got %d", len(events))
+	}
+
+	archive := &auditArchive{}
+	prune := store.AuditEvent{ActorName: "ops@db-host"}
+	pruned, err := s.PruneAuditEvents(ctx, cutoff, archive, &prune)
+	if err != nil || pruned != 2 {
+		t.Fatalf("expected the two old events pruned, got %d, %v", pruned, err)
+	}
+	if prune.ID == 0 || prune.Action != store.AuditActionAuditPrune || !strings.HasPrefix(prune.Detail, "Deleted 2 events") {
+		t.Fatalf("expected the recorded prune event back, got %+v", prune)
+	}
+	if len(archive.events) != 2 || !archive.closed || archive.events[0].ID > archive.events[1].ID {
+		t.Fatalf("expected both events archived oldest first, got %+v", archive)
+	}
+
+	events, _, err := s.ListAuditEvents(ctx, store.AuditFilter{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].ID != prune.ID || events[0].ActorName != "ops@db-host" || events[1].Action != store.AuditActionLogin {
+		t.Fatalf("expected the recent event and a record of the prune, got %+v", events)
+	}
+
+	if pruned, err := s.PruneAuditEvents(ctx, cutoff, nil, &store.AuditEvent{}); err != nil || pruned != 0 {
+		t.Fatalf("expected nothing left to prune, got %d, %v", pruned, err)
+	}
+}
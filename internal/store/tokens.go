@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// apiTokenTouchInterval limits how often using a token rewrites its
+// last_used_at, so scripts polling the API do not write on every request.
+const apiTokenTouchInterval = time.Minute
+
+const apiTokenColumns = `id, token_hash, prefix, name, scopes, user_subject, user_email, user_name,
+	user_groups, user_roles, created_at, expires_at, last_used_at, revoked_at`
+
+// CreateAPIToken stores a new token and sets its ID and creation time.
+func (s *Store) CreateAPIToken(ctx context.Context, token *APIToken) error {
+	groups, err := json.Marshal(nonNilStrings(token.UserGroups))
+	if err != nil {
+		return fmt.Errorf("encode token groups: %w", err)
+	}
+	roles, err := json.Marshal(nonNilStrings(token.UserRoles))
+	if err != nil {
+		return fmt.Errorf("encode token roles: %w", err)
+	}
+
+	token.CreatedAt = time.Now().UTC()
+	err = s.writeRow(ctx,
+		`INSERT INTO api_tokens (token_hash, prefix, name, scopes, user_subject, user_email, user_name,
+		 user_groups, user_roles, created_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		token.TokenHash, token.Prefix, token.Name, strings.Join(token.Scopes, ","),
+		token.UserSubject, token.UserEmail, token.UserName, string(groups), string(roles),
+		token.CreatedAt, token.ExpiresAt).Scan(&token.ID)
+	if err != nil {
+		return fmt.Errorf("insert api token %q: %w", token.Name, err)
+	}
+	return nil
+}
+
+// GetAPITokenByHash returns the token with the hash, revoked or expired ones
+// included.
+func (s *Store) GetAPITokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	token, err := scanAPIToken(s.queryRow(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hash))
+	if err != nil {
+		return nil, fmt.Errorf("get api token: %w", err)
+	}
+	return token, nil
+}
+
+// ListAPITokens returns the tokens of the user with the subject, newest
+// first, or the tokens of every user when subject is empty.
+func (s *Store) ListAPITokens(ctx context.Context, subject string) ([]APIToken, error) {
+	query := "SELECT " + apiTokenColumns + " FROM api_tokens"
+	var args []any
+	if subject != "" {
+		query += " WHERE user_subject = ?"
+		args = append(args, subject)
+	}
+	rows, err := s.query(ctx, query+" ORDER BY created_at DESC, id DESC", args...)
+	if err != nil {
+		return nil, fmt.Errorf("query api tokens: %w", err)
+	}
+	defer closeRows(rows)
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes a token of the user with the subject, or of any user
+// when subject is empty. It returns sql.ErrNoRows when there is no such
+// unrevoked token.
+func (s *Store) RevokeAPIToken(ctx context.Context, id uint, subject string, at time.Time) error {
+	query := "UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+	args := []any{at.UTC(), id}
+	if subject != "" {
+		query += " AND user_subject = ?"
+		args = append(args, subject)
+	}
+	n, err := s.execCount(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("revoke api token %d: %w", id, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("revoke api token %d: %w", id, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// NarrowAPITokenClaims limits the groups and roles of the user's unrevoked
+// tokens to the ones they signed in with now, so a token loses what its user
+// has lost. Tokens never gain groups or roles, and it returns how many
+// tokens it narrowed.
+func (s *Store) NarrowAPITokenClaims(ctx context.Context, subject string, groups, roles []string) (int, error) {
+	narrowed := 0
+	err := s.WithinTx(ctx, func(tx *Store) error {
+		rows, err := tx.query(ctx,
+			"SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_subject = ? AND revoked_at IS NULL", subject)
+		if err != nil {
+			return fmt.Errorf("query api tokens: %w", err)
+		}
+		var tokens []*APIToken
+		for rows.Next() {
+			token, err := scanAPIToken(rows)
+			if err != nil {
+				closeRows(rows)
+				return fmt.Errorf("scan api token: %w", err)
+			}
+			tokens = append(tokens, token)
+		}
+		closeRows(rows)
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("query api tokens: %w", err)
+		}
+
+		for _, token := range tokens {
+			keptGroups := intersectStrings(token.UserGroups, groups)
+			keptRoles := intersectStrings(token.UserRoles, roles)
+			if len(keptGroups) == len(token.UserGroups) && len(keptRoles) == len(token.UserRoles) {
+				continue
+			}
+			encodedGroups, err := json.Marshal(keptGroups)
+			if err != nil {
+				return fmt.Errorf("encode token groups: %w", err)
+			}
+			encodedRoles, err := json.Marshal(keptRoles)
+			if err != nil {
+				return fmt.Errorf("encode token roles: %w", err)
+			}
+			if _, err := tx.exec(ctx, "UPDATE api_tokens SET user_groups = ?, user_roles = ? WHERE id = ?",
+				string(encodedGroups), string(encodedRoles), token.ID); err != nil {
+				return fmt.Errorf("narrow api token %d: %w", token.ID, err)
+			}
+			narrowed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return narrowed, nil
+}
+
+// RevokeUserAPITokens revokes every unrevoked token of the user with the
+// subject and returns how many there were.
+func (s *Store) RevokeUserAPITokens(ctx context.Context, subject string, at time.Time) (int, error) {
+	n, err := s.execCount(ctx, "UPDATE api_tokens SET revoked_at = ? WHERE user_subject = ? AND revoked_at IS NULL",
+		at.UTC(), subject)
+	if err != nil {
+		return 0, fmt.Errorf("revoke api tokens of %q: %w", subject, err)
+	}
+	return int(n), nil
+}
+
+// TouchAPIToken records that a token was used at the time, unless it was
+// already recorded within apiTokenTouchInterval. Times are stored in UTC so
+// SQLite compares them in order.
+func (s *Store) TouchAPIToken(ctx context.Context, id uint, at time.Time) error {
+	if _, err := s.exec(ctx,
+		"UPDATE api_tokens SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)",
+		at.UTC(), id, at.UTC().Add(-apiTokenTouchInterval)); err != nil {
+		return fmt.Errorf("touch api token %d: %w", id, err)
+	}
+	return nil
+}
+
+func scanAPIToken(row interface{ Scan(dest ...any) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes, groups, roles string
+	err := row.Scan(&token.ID, &token.TokenHash, &token.Prefix, &token.Name, &scopes,
+		&token.UserSubject, &token.UserEmail, &token.UserName, &groups, &roles,
+		&token.CreatedAt, &token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	if err := json.Unmarshal([]byte(groups), &token.UserGroups); err != nil {
+		return nil, fmt.Errorf("decode token groups: %w", err)
+	}
+	if err := json.Unmarshal([]byte(roles), &token.UserRoles); err != nil {
+		return nil, fmt.Errorf("decode token roles: %w", err)
+	}
+	return &token, nil
+}
+
+// intersectStrings returns the values that are also in keep, in their order.
+func intersectStrings(values, keep []string) []string {
+	kept := []string{}
+	for _, v := range values {
+		if slices.Contains(keep, v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -4,30 +4,27 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"sort"
 	"strings"
 	"time"
 
 	"golang.org/x/mod/semver"
 )
 
+// GetTagsForRepository returns a page of tags. When filter.AsOf is set the tag
+// set is rebuilt from tag history as it stood at that time.
 func (s *Store) GetTagsForRepository(ctx context.Context, repositoryID uint, filter TagFilter, pagination ScrollPagination) (ScrollResult, error) {
-	var countArgs []any
-	countQuery := "SELECT COUNT(*) FROM tags_view WHERE repository_id = ?"
-	countArgs = append(countArgs, repositoryID)
-
-	if filter.Name != "" {
-		countQuery += " AND name LIKE ?"
-		countArgs = append(countArgs, "%"+filter.Name+"%")
+	src := currentTagSource(repositoryID)
+	if filter.AsOf != nil {
+		src = s.asOfTagSource(repositoryID, *filter.AsOf)
 	}
 
-	r := s.queryRow(ctx, countQuery, countArgs...)
-	var totalCount int64
-	if err := r.Scan(&totalCount); err != nil {
-		return ScrollResult{}, fmt.Errorf("count tags: %w", err)
+	nameCond, nameArgs := s.tagNameCondition(filter.Name)
+	totalCount, err := s.countTags(ctx, src, nameCond, nameArgs)
+	if err != nil {
+		return ScrollResult{}, err
 	}
 
-	totalPages := int((totalCount + int64(pagination.PageSize) - 1) / int64(pagination.PageSize))
+	totalPages := (totalCount + pagination.PageSize - 1) / pagination.PageSize
 	var nextPage, prevPage *int
 	if pagination.Page < totalPages {
 		np := pagination.Page + 1
@@ -38,52 +35,117 @@ func (s *Store) GetTagsForRepository(ctx context.Context, repositoryID uint, fil
 		prevPage = &pp
 	}
 
-	orderClause := "created_at DESC"
-	applyVersionSort := false
-	switch filter.SortBy {
-	case "size-asc":
-		orderClause = "total_size ASC"
-	case "size-desc":
-		orderClause = "total_size DESC"
-	case "oldest":
-		applyVersionSort = true
-	case "name-asc":
-		orderClause = "name ASC"
-	case "name-desc":
-		orderClause = "name DESC"
-	default:
-		applyVersionSort = true
-	}
-
 	offset := (pagination.Page - 1) * pagination.PageSize
-	limit := pagination.PageSize
-	queryOffset := offset
-	if applyVersionSort {
-		limit = 0
-		queryOffset = 0
-	}
-
-	rows, err := s.queryTagData(ctx, repositoryID, filter.Name, orderClause, limit, queryOffset)
+	rows, err := s.queryTagData(ctx, src, nameCond, nameArgs, orderBy(s.tagSortKeys(filter.SortBy)), pagination.PageSize, offset)
 	if err != nil {
 		return ScrollResult{}, err
 	}
 
 	tagViews := s.buildTagViews(rows)
-	s.populateAliases(ctx, repositoryID, tagViews)
-	if applyVersionSort {
-		s.sortTagViewsByVersion(tagViews, filter.SortBy == "oldest")
-		tagViews = paginateTagViews(tagViews, offset, pagination.PageSize)
+	if err := s.completeTagViews(ctx, src, filter, tagViews); err != nil {
+		return ScrollResult{}, err
 	}
 
 	return ScrollResult{
 		Tags:         tagViews,
-		TotalCount:   int(totalCount),
+		TotalCount:   totalCount,
 		CurrentPage:  pagination.Page,
 		NextPage:     nextPage,
 		PreviousPage: prevPage,
 	}, nil
 }
 
+// GetTagsPage returns up to limit tags in the order of filter.SortBy,
+// following the tag whose cursor is after, or from the first one when after
+// is empty. The page's Next cursor is empty on the last page. Cursors hold
+// the sort keys of a tag, so tags added or removed between requests do not
+// shift the pages after them.
+func (s *Store) GetTagsPage(ctx context.Context, repositoryID uint, filter TagFilter, after string, limit int) (TagPage, error) {
+	src := currentTagSource(repositoryID)
+	if filter.AsOf != nil {
+		src = s.asOfTagSource(repositoryID, *filter.AsOf)
+	}
+	keys := s.tagSortKeys(filter.SortBy)
+
+	cond, args := s.tagNameCondition(filter.Name)
+	totalCount, err := s.countTags(ctx, src, cond, args)
+	if err != nil {
+		return TagPage{}, err
+	}
+	if after != "" {
+		values, err := s.dialect.decodeCursor(after, keys)
+		if err != nil {
+			return TagPage{}, err
+		}
+		keyCond, keyArgs := keysetAfter(keys, values)
+		cond += " AND " + keyCond
+		args = append(args, keyArgs...)
+	}
+
+	// One tag past the page tells whether another page follows.
+	rows, err := s.queryTagData(ctx, src, cond, args, orderBy(keys), limit+1, 0)
+	if err != nil {
+		return TagPage{}, err
+	}
+	page := TagPage{Tags: s.buildTagViews(rows), TotalCount: totalCount}
+	if len(page.Tags) > limit {
+		page.Tags = page.Tags[:limit]
+		if page.Next, err = s.tagCursor(ctx, src, keys, page.Tags[limit-1].Name); err != nil {
+			return TagPage{}, err
+		}
+	}
+	if err := s.completeTagViews(ctx, src, filter, page.Tags); err != nil {
+		return TagPage{}, err
+	}
+	return page, nil
+}
+
+// tagNameCondition narrows source_tags to names containing name.
+func (s *Store) tagNameCondition(name string) (string, []any) {
+	if name == "" {
+		return "", nil
+	}
+	return " AND name " + s.dialect.like() + " ?", []any{"%" + name + "%"}
+}
+
+func (s *Store) countTags(ctx context.Context, src tagSource, cond string, condArgs []any) (int, error) {
+	args := append(append([]any{}, src.args...), condArgs...)
+	var n int
+	if err := s.queryRow(ctx, src.with+" SELECT COUNT(*) FROM source_tags WHERE 1 = 1"+cond, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count tags: %w", err)
+	}
+	return n, nil
+}
+
+// tagCursor reads the sort keys of the named tag into a cursor.
+func (s *Store) tagCursor(ctx context.Context, src tagSource, keys []sortKey, name string) (string, error) {
+	exprs := make([]string, len(keys))
+	values := make([]any, len(keys))
+	dest := make([]any, len(keys))
+	for i, key := range keys {
+		exprs[i] = key.expr
+		dest[i] = &values[i]
+	}
+	args := append(append([]any{}, src.args...), name)
+	if err := s.queryRow(ctx, src.with+" SELECT "+strings.Join(exprs, ", ")+" FROM source_tags WHERE name = ?", args...).Scan(dest...); err != nil {
+		return "", fmt.Errorf("read tag cursor: %w", err)
+	}
+	return encodeCursor(values)
+}
+
+// completeTagViews adds the aliases of a page of tags and, for tags rebuilt
+// from history, marks them read-only and flags manifests no longer stored.
+func (s *Store) completeTagViews(ctx context.Context, src tagSource, filter TagFilter, tagViews []TagView) error {
+	s.populateAliases(ctx, src, tagViews)
+	if filter.AsOf == nil {
+		return nil
+	}
+	for i := range tagViews {
+		tagViews[i].ReadOnly = true
+	}
+	return s.markUnretainedManifests(ctx, tagViews)
+}
+
 type tagDataRow struct {
 	tagID         uint
 	tagName       string
@@ -104,15 +166,103 @@ type tagDataRow struct {
 	chartType     sql.NullString
 }
 
-func (s *Store) queryTagData(ctx context.Context, repositoryID uint, nameFilter, order string, limit, offset int) ([]tagDataRow, error) {
-	var nameCond string
-	var args []any
-	args = append(args, repositoryID)
+// tagSource is a CTE list ending in a source_tags relation shaped like
+// tags_view, which the tag page queries read from.
+type tagSource struct {
+	with string
+	args []any
+}
+
+func currentTagSource(repositoryID uint) tagSource {
+	return tagSource{
+		with: `WITH source_tags AS (
+			SELECT tv.*, t.version_key FROM tags_view tv
+			JOIN tags t ON t.id = tv.id
+			WHERE tv.repository_id = ?
+		)`,
+		args: []any{repositoryID},
+	}
+}
 
-	if nameFilter != "" {
-		nameCond = "AND name LIKE ?"
-		args = append(args, "%"+nameFilter+"%")
+// asOfTagSource rebuilds the tags of a repository at a point in time. A tag's
+// state is taken from its last event before asOf; failing that, a later move
+// or deletion means it already existed with the old digest. Tags without any
+// history predate it and are used as they are now if they were created by then.
+// A rebuilt tag carries the id of the current tag only while that still points
+// at the same digest; otherwise its id is zero.
+func (s *Store) asOfTagSource(repositoryID uint, asOf time.Time) tagSource {
+	d := s.dialect
+	return tagSource{
+		with: `WITH history AS (
+			SELECT id, tag_name, event, old_digest, new_digest, occurred_at, version_key
+			FROM tag_events WHERE repo_id = ?
+		),
+		last_seen AS (
+			SELECT tag_name, event, new_digest, version_key FROM (
+				SELECT tag_name, event, new_digest, version_key,
+					ROW_NUMBER() OVER (PARTITION BY tag_name ORDER BY occurred_at DESC, id DESC) AS rn
+				FROM history WHERE ` + d.compareTime("occurred_at", "<=") + `
+			) ranked WHERE rn = 1
+		),
+		next_seen AS (
+			SELECT tag_name, event, old_digest, version_key FROM (
+				SELECT tag_name, event, old_digest, version_key,
+					ROW_NUMBER() OVER (PARTITION BY tag_name ORDER BY occurred_at ASC, id ASC) AS rn
+				FROM history WHERE ` + d.compareTime("occurred_at", ">") + `
+			) ranked WHERE rn = 1
+		),
+		asof_tags AS (
+			SELECT tag_name AS name, new_digest AS digest, version_key FROM last_seen WHERE event != 'deleted'
+			UNION ALL
+			SELECT tag_name, old_digest, version_key FROM next_seen
+			WHERE event != 'created' AND old_digest != '' AND tag_name NOT IN (SELECT tag_name FROM last_seen)
+			UNION ALL
+			SELECT name, digest, version_key FROM tags
+			WHERE repo_id = ? AND digest != '' AND ` + d.compareTime("created_at", "<=") + `
+				AND name NOT IN (SELECT tag_name FROM history)
+		),
+		asof_kinds AS (
+			SELECT
+				a.name,
+				a.digest,
+				a.version_key,
+				COALESCE(
+					(SELECT t.kind FROM tags t WHERE t.repo_id = ? AND t.digest = a.digest AND t.kind != '' LIMIT 1),
+					CASE
+						WHEN m.kind = 'index' THEN 'index'
+						WHEN EXISTS (SELECT 1 FROM tags t WHERE t.repo_id = ? AND t.kind = 'helm') THEN 'helm'
+						ELSE 'image'
+					END
+				) AS kind
+			FROM asof_tags a
+			LEFT JOIN manifests m ON m.digest = a.digest
+		),
+		source_tags AS (
+			SELECT
+				COALESCE((SELECT t.id FROM tags t WHERE t.repo_id = ? AND t.name = k.name AND t.digest = k.digest), 0) AS id,
+				k.name,
+				k.digest,
+				k.kind,
+				k.version_key,
+				COALESCE(m.size_bytes, 0) AS total_size,
+				COALESCE(m.created, cb.created) AS created_at,
+				CASE WHEN k.kind = 'helm' THEN ` + d.jsonText("cb.config_json", "name") + ` END AS chart_name,
+				CASE WHEN k.kind = 'helm' THEN ` + d.jsonText("cb.config_json", "version") + ` END AS chart_version,
+				CASE WHEN k.kind = 'helm' THEN ` + d.jsonText("cb.config_json", "description") + ` END AS chart_desc,
+				CASE WHEN k.kind = 'helm' THEN ` + d.jsonText("cb.config_json", "apiVersion") + ` END AS chart_api_version,
+				CASE WHEN k.kind = 'helm' THEN ` + d.jsonText("cb.config_json", "type") + ` END AS chart_type
+			FROM asof_kinds k
+			LEFT JOIN manifests m ON m.digest = k.digest
+			LEFT JOIN config_blobs cb ON cb.digest = m.config_digest
+		)`,
+		args: []any{repositoryID, asOf, asOf, repositoryID, asOf, repositoryID, repositoryID, repositoryID},
 	}
+}
+
+// queryTagData reads the tags of src matching cond, which starts with AND,
+// in order, with their images.
+func (s *Store) queryTagData(ctx context.Context, src tagSource, cond string, condArgs []any, order string, limit, offset int) ([]tagDataRow, error) {
+	args := append(append([]any{}, src.args...), condArgs...)
 
 	limitClause := ""
 	if limit > 0 {
@@ -121,13 +271,14 @@ func (s *Store) queryTagData(ctx context.Context, repositoryID uint, nameFilter,
 	}
 
 	query := fmt.Sprintf(`
-		WITH filtered_tags AS (
+		%s,
+		filtered_tags AS (
 			SELECT
 				id, name, digest, kind, created_at, chart_name, chart_version, chart_desc,
 				chart_api_version, chart_type,
 				ROW_NUMBER() OVER (ORDER BY %s) AS sort_order
-			FROM tags_view
-			WHERE repository_id = ? %s
+			FROM source_tags
+			WHERE 1 = 1%s
 			ORDER BY %s
 			%s
 		)
@@ -139,7 +290,7 @@ func (s *Store) queryTagData(ctx context.Context, repositoryID uint, nameFilter,
 			ft.chart_api_version, ft.chart_type
 		FROM filtered_tags ft
 		LEFT JOIN manifests m ON m.digest = ft.digest AND ft.kind IN ('image', 'helm')
-		ORDER BY ft.sort_order, m.digest`, order, nameCond, order, limitClause)
+		ORDER BY ft.sort_order, m.digest`, src.with, order, cond, order, limitClause)
 
 	rows, err := s.query(ctx, query, args...)
 	if err != nil {
@@ -171,53 +322,19 @@ func (s *Store) queryTagData(ctx context.Context, repositoryID uint, nameFilter,
 }
 
 func (s *Store) appendIndexChildren(ctx context.Context, rows []tagDataRow) ([]tagDataRow, error) {
-	var indexIDs []uint
-	indexRows := make(map[uint]int)
+	var indexDigests []string
+	indexRows := make(map[string]int)
+	seen := make(map[string]bool)
 	for i, row := range rows {
-		if row.mDigest.Valid {
+		if row.mDigest.Valid || row.tagKind != "index" {
 			continue
 		}
-		indexIDs = append(indexIDs, row.tagID)
-		indexRows[row.tagID] = i
-	}
-	if len(indexIDs) == 0 {
-		return rows, nil
-	}
-
-	phs := make([]string, len(indexIDs))
-	args := make([]any, len(indexIDs))
-	for i, id := range indexIDs {
-		phs[i] = "?"
-		args[i] = id
-	}
-
-	kindRows, err := s.query(ctx,
-		fmt.Sprintf("SELECT id, kind, digest, last_sync_at, priority FROM tags WHERE id IN (%s)", strings.Join(phs, ",")),
-		args...)
-	if err != nil {
-		return nil, fmt.Errorf("query index tag metadata: %w", err)
-	}
-	defer closeRows(kindRows)
-
-	var indexDigests []string
-	indexMeta := make(map[uint]tagMeta)
-	for kindRows.Next() {
-		var id uint
-		var kind, digest string
-		var lastSync sql.NullString
-		var priority sql.NullFloat64
-		if err := kindRows.Scan(&id, &kind, &digest, &lastSync, &priority); err != nil {
-			return nil, fmt.Errorf("scan index tag metadata: %w", err)
-		}
-		if kind == "index" {
-			indexDigests = append(indexDigests, digest)
-			indexMeta[id] = tagMeta{digest: digest}
+		indexRows[row.tagName] = i
+		if !seen[row.tagDigest] {
+			seen[row.tagDigest] = true
+			indexDigests = append(indexDigests, row.tagDigest)
 		}
 	}
-	if err := kindRows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate index tag metadata: %w", err)
-	}
-
 	if len(indexDigests) == 0 {
 		return rows, nil
 	}
@@ -263,18 +380,14 @@ func (s *Store) appendIndexChildren(ctx context.Context, rows []tagDataRow) ([]t
 		return nil, fmt.Errorf("iterate index child manifests: %w", err)
 	}
 
-	for tagID, rowIdx := range indexRows {
-		meta, ok := indexMeta[tagID]
-		if !ok {
-			continue
-		}
-		children, ok := childrenByIndex[meta.digest]
+	for _, rowIdx := range indexRows {
+		children, ok := childrenByIndex[rows[rowIdx].tagDigest]
 		if !ok {
 			continue
 		}
 		for _, cr := range children {
 			childRow := tagDataRow{
-				tagID:         tagID,
+				tagID:         rows[rowIdx].tagID,
 				tagName:       rows[rowIdx].tagName,
 				tagDigest:     rows[rowIdx].tagDigest,
 				tagCreatedAt:  rows[rowIdx].tagCreatedAt,
@@ -294,16 +407,14 @@ func (s *Store) appendIndexChildren(ctx context.Context, rows []tagDataRow) ([]t
 	return rows, nil
 }
 
-type tagMeta struct {
-	digest string
-}
-
+// buildTagViews folds the rows of each tag into one view. Tags are keyed by
+// name, which point-in-time views keep unique where ids may be zero.
 func (s *Store) buildTagViews(rows []tagDataRow) []TagView {
-	tagMap := make(map[uint]*TagView)
-	var tagOrder []uint
+	tagMap := make(map[string]*TagView)
+	var tagOrder []string
 
 	for _, row := range rows {
-		tv, exists := tagMap[row.tagID]
+		tv, exists := tagMap[row.tagName]
 		if !exists {
 			tv = &TagView{
 				ID:              row.tagID,
@@ -321,8 +432,8 @@ func (s *Store) buildTagViews(rows []tagDataRow) []TagView {
 					tv.CreatedAt = t
 				}
 			}
-			tagMap[row.tagID] = tv
-			tagOrder = append(tagOrder, row.tagID)
+			tagMap[row.tagName] = tv
+			tagOrder = append(tagOrder, row.tagName)
 		}
 
 		if row.mDigest.Valid && row.configSize != nil {
@@ -344,15 +455,15 @@ func (s *Store) buildTagViews(rows []tagDataRow) []TagView {
 	}
 
 	result := make([]TagView, 0, len(tagOrder))
-	for _, id := range tagOrder {
-		tv := tagMap[id]
+	for _, name := range tagOrder {
+		tv := tagMap[name]
 		tv.MetadataAvailable = len(tv.Images) > 0
 		result = append(result, *tv)
 	}
 	return result
 }
 
-func (s *Store) populateAliases(ctx context.Context, repositoryID uint, tagViews []TagView) {
+func (s *Store) populateAliases(ctx context.Context, src tagSource, tagViews []TagView) {
 	if len(tagViews) == 0 {
 		return
 	}
@@ -368,15 +479,15 @@ func (s *Store) populateAliases(ctx context.Context, repositoryID uint, tagViews
 
 	digestToNames := make(map[string][]string, len(digests))
 	phs := make([]string, len(digests))
-	args := []any{repositoryID}
+	args := append([]any{}, src.args...)
 	for i, d := range digests {
 		phs[i] = "?"
 		args = append(args, d)
 	}
 
 	rows, err := s.query(ctx,
-		fmt.Sprintf("SELECT digest, name FROM tags WHERE repo_id = ? AND digest IN (%s)",
-			strings.Join(phs, ",")),
+		fmt.Sprintf("%s SELECT digest, name FROM source_tags WHERE digest IN (%s)",
+			src.with, strings.Join(phs, ",")),
 		args...)
 	if err != nil {
 		return
@@ -407,88 +518,160 @@ func (s *Store) populateAliases(ctx context.Context, repositoryID uint, tagViews
 	}
 }
 
-func (s *Store) sortTagViewsByVersion(tagViews []TagView, ascending bool) {
-	sort.SliceStable(tagViews, func(i, j int) bool {
-		leftVersion, leftOK := canonicalSemver(tagViews[i].Name)
-		rightVersion, rightOK := canonicalSemver(tagViews[j].Name)
+// markUnretainedManifests flags tags whose digest has no stored manifest, which
+// happens when browsing history past what the database kept.
+func (s *Store) markUnretainedManifests(ctx context.Context, tagViews []TagView) error {
+	if len(tagViews) == 0 {
+		return nil
+	}
+	phs := make([]string, len(tagViews))
+	args := make([]any, len(tagViews))
+	for i, tv := range tagViews {
+		phs[i] = "?"
+		args[i] = tv.Digest
+	}
+	rows, err := s.query(ctx,
+		fmt.Sprintf("SELECT digest FROM manifests WHERE digest IN (%s)", strings.Join(phs, ",")),
+		args...)
+	if err != nil {
+		return fmt.Errorf("query retained manifests: %w", err)
+	}
+	defer closeRows(rows)
 
-		switch {
-		case leftOK && rightOK:
-			cmp := semver.Compare(leftVersion, rightVersion)
-			if cmp == 0 {
-				return compareTagViewFallback(tagViews[i], tagViews[j], ascending)
-			}
-			if ascending {
-				return cmp < 0
-			}
-			return cmp > 0
-		case leftOK != rightOK:
-			return leftOK
-		default:
-			return compareTagViewFallback(tagViews[i], tagViews[j], ascending)
+	retained := make(map[string]bool, len(tagViews))
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return fmt.Errorf("scan retained manifest: %w", err)
 		}
-	})
+		retained[digest] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate retained manifests: %w", err)
+	}
+	for i := range tagViews {
+		tagViews[i].ManifestNotRetained = !retained[tagViews[i].Digest]
+	}
+	return nil
 }
 
-func paginateTagViews(tagViews []TagView, offset, pageSize int) []TagView {
-	if offset >= len(tagViews) {
-		return []TagView{}
+// tagSortKeys is the keyset order of a tag listing sorted by sortBy, newest
+// by default. Tag names are unique within a listing, so the name settles
+// every tie.
+func (s *Store) tagSortKeys(sortBy string) []sortKey {
+	name := sortKey{expr: "name", kind: keyText}
+	switch sortBy {
+	case "size-asc":
+		return []sortKey{{expr: "total_size"}, name}
+	case "size-desc":
+		return []sortKey{{expr: "total_size", desc: true}, name}
+	case "oldest":
+		return s.versionSortKeys(false)
+	case "name-asc":
+		return []sortKey{name}
+	case "name-desc":
+		name.desc = true
+		return []sortKey{name}
+	default:
+		return s.versionSortKeys(true)
 	}
+}
 
-	end := min(offset+pageSize, len(tagViews))
-
-	return tagViews[offset:end]
+// versionSortKeys sorts tags by version key, with tags that are not versions
+// after the rest in either direction. Ties fall back to creation time, tags
+// without one counting as the oldest, and then to name.
+func (s *Store) versionSortKeys(newest bool) []sortKey {
+	undated := "CASE WHEN created_at IS NULL THEN 0 ELSE 1 END"
+	if newest {
+		undated = "CASE WHEN created_at IS NULL THEN 1 ELSE 0 END"
+	}
+	return []sortKey{
+		{expr: "CASE WHEN COALESCE(version_key, '') = '' THEN 1 ELSE 0 END"},
+		{expr: "COALESCE(version_key, '')", desc: newest, kind: keyText},
+		{expr: undated},
+		{expr: s.dialect.timeSortKey("created_at"), desc: newest, kind: keyTime, nullable: true},
+		{expr: "name", desc: newest, kind: keyText},
+	}
 }
 
-func canonicalSemver(tagName string) (string, bool) {
-	version := tagName
+// tagVersionKey encodes the semantic version in a tag name so that comparing
+// keys byte by byte orders tags as semver.Compare does. As there, the "v"
+// prefix is optional and build metadata is ignored. Names that are not
+// versions get an empty key.
+//
+// Numbers are written with a two-digit length prefix so shorter numbers sort
+// first. A release ends in '~', above the '-' that starts a prerelease. Each
+// prerelease identifier is tagged '0' when numeric and '1' otherwise, so
+// numeric ones rank lower, and ends in '!', below every identifier character,
+// so an identifier sorts before its extensions and fewer identifiers sort
+// first.
+func tagVersionKey(name string) string {
+	version := name
 	if !strings.HasPrefix(version, "v") {
 		version = "v" + version
 	}
-
 	if !semver.IsValid(version) {
-		return "", false
+		return ""
 	}
 
-	return version, true
-}
-
-func compareTagViewFallback(left, right TagView, ascending bool) bool {
-	if !left.CreatedAt.Equal(right.CreatedAt) {
-		if ascending {
-			return left.CreatedAt.Before(right.CreatedAt)
+	core, prerelease, hasPrerelease := strings.Cut(strings.TrimPrefix(semver.Canonical(version), "v"), "-")
+	var b strings.Builder
+	for part := range strings.SplitSeq(core, ".") {
+		writeVersionNumber(&b, part)
+	}
+	if !hasPrerelease {
+		b.WriteByte('~')
+		return b.String()
+	}
+	b.WriteByte('-')
+	for id := range strings.SplitSeq(prerelease, ".") {
+		if isVersionNumber(id) {
+			b.WriteByte('0')
+			writeVersionNumber(&b, id)
+		} else {
+			b.WriteByte('1')
+			b.WriteString(id)
 		}
-		return left.CreatedAt.After(right.CreatedAt)
+		b.WriteByte('!')
 	}
+	return b.String()
+}
 
-	if ascending {
-		return left.Name < right.Name
-	}
+func writeVersionNumber(b *strings.Builder, digits string) {
+	fmt.Fprintf(b, "%02d%s", min(len(digits), 99), digits)
+}
 
-	return left.Name > right.Name
+func isVersionNumber(id string) bool {
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return id != ""
 }
 
-func (s *Store) GetRegistryStats(ctx context.Context, host string) (*RegistryStatsView, error) {
+// GetRegistryStats sums up the registry's repositories in the namespaces of
+// the scope, or all of them for a nil scope.
+func (s *Store) GetRegistryStats(ctx context.Context, host string, scope NamespaceScope) (*RegistryStatsView, error) {
 	var stats RegistryStatsView
+	cond, condArgs := scope.and("registry_host", "namespace")
 	r := s.queryRow(ctx,
-		`SELECT COUNT(*) FROM repositories_view WHERE registry_host = ?`, host)
-	if err := r.Scan(&stats.RepositoryCount); err != nil {
+		`SELECT COUNT(*), COALESCE(SUM(tags_count), 0) FROM repositories_view WHERE registry_host = ?`+cond,
+		append([]any{host}, condArgs...)...)
+	if err := r.Scan(&stats.RepositoryCount, &stats.TagCount); err != nil {
 		return nil, fmt.Errorf("get registry stats: %w", err)
 	}
 
+	cond, condArgs = scope.and("reg.host", "r.namespace")
 	r = s.queryRow(ctx,
-		`SELECT COALESCE(SUM(tags_count), 0) FROM repositories_view WHERE registry_host = ?`, host)
-	if err := r.Scan(&stats.TagCount); err != nil {
-		return nil, fmt.Errorf("get tag count: %w", err)
-	}
-
-	r = s.queryRow(ctx,
-		`SELECT COALESCE(SUM(total_size_bytes), 0) FROM repositories_view WHERE registry_host = ?`, host)
+		`SELECT COALESCE(SUM(size_bytes), 0) FROM (
+			SELECT DISTINCT rb.digest, rb.size_bytes `+registryBlobsFrom+` WHERE reg.host = ?`+cond+`
+		 ) blobs`, append([]any{host}, condArgs...)...)
 	if err := r.Scan(&stats.EstimatedStorageBytes); err != nil {
 		return nil, fmt.Errorf("get storage: %w", err)
 	}
 
-	coverage, err := s.GetRegistryArchitectureCoverage(ctx, host)
+	coverage, err := s.GetRegistryArchitectureCoverage(ctx, host, scope)
 	if err != nil {
 		return nil, err
 	}
@@ -497,12 +680,26 @@ func (s *Store) GetRegistryStats(ctx context.Context, host string) (*RegistrySta
 	return &stats, nil
 }
 
+// registryBlobsFrom joins the blobs of every repository to its registry.
+const registryBlobsFrom = `FROM repository_blobs rb
+	JOIN repositories r ON r.id = rb.repo_id
+	JOIN registries reg ON reg.id = r.registry_id`
+
 func (s *Store) GetRegistryStorageByNamespace(ctx context.Context, host string) ([]NamespaceStorageView, error) {
 	rows, err := s.query(ctx,
-		`SELECT CASE WHEN namespace = '' THEN 'library' ELSE namespace END,
-		 COALESCE(SUM(total_size_bytes), 0)
-		 FROM repositories_view WHERE registry_host = ? GROUP BY namespace
-		 ORDER BY SUM(total_size_bytes) DESC`, host)
+		`WITH namespace_blobs AS (
+			SELECT DISTINCT r.namespace, rb.digest, rb.size_bytes `+registryBlobsFrom+` WHERE reg.host = ?
+		),
+		blob_namespaces AS (
+			SELECT digest, COUNT(*) AS namespace_count FROM namespace_blobs GROUP BY digest
+		)
+		SELECT CASE WHEN nb.namespace = '' THEN 'library' ELSE nb.namespace END,
+			COALESCE(SUM(nb.size_bytes), 0),
+			COALESCE(SUM(CASE WHEN bn.namespace_count = 1 THEN nb.size_bytes ELSE 0 END), 0)
+		FROM namespace_blobs nb
+		JOIN blob_namespaces bn ON bn.digest = nb.digest
+		GROUP BY nb.namespace
+		ORDER BY COALESCE(SUM(nb.size_bytes), 0) DESC, nb.namespace`, host)
 	if err != nil {
 		return nil, fmt.Errorf("get storage by namespace: %w", err)
 	}
@@ -511,10 +708,11 @@ func (s *Store) GetRegistryStorageByNamespace(ctx context.Context, host string)
 	var result []NamespaceStorageView
 	for rows.Next() {
 		var ns NamespaceStorageView
-		if err := rows.Scan(&ns.Namespace, &ns.TotalSizeBytes); err != nil {
+		if err := rows.Scan(&ns.Namespace, &ns.TotalSizeBytes, &ns.UniqueSizeBytes); err != nil {
 			return nil, fmt.Errorf("scan namespace storage: %w", err)
 		}
 		ns.DisplayName = ns.Namespace
+		ns.SharedSizeBytes = ns.TotalSizeBytes - ns.UniqueSizeBytes
 		result = append(result, ns)
 	}
 	return result, rows.Err()
@@ -522,14 +720,19 @@ func (s *Store) GetRegistryStorageByNamespace(ctx context.Context, host string)
 
 func (s *Store) GetStorageUsageByRegistry(ctx context.Context) ([]RegistryStorageUsageView, error) {
 	rows, err := s.query(ctx, `
+		WITH registry_blobs AS (
+			SELECT DISTINCT r.registry_id, rb.digest, rb.size_bytes
+			FROM repository_blobs rb
+			JOIN repositories r ON r.id = rb.repo_id
+		)
 		SELECT
 			r.host,
 			CASE WHEN r.name = '' THEN r.host ELSE r.name END,
-			COALESCE(SUM(rv.total_size_bytes), 0)
+			COALESCE(SUM(b.size_bytes), 0)
 		FROM registries r
-		LEFT JOIN repositories_view rv ON rv.registry_host = r.host
+		LEFT JOIN registry_blobs b ON b.registry_id = r.id
 		GROUP BY r.host, r.name
-		ORDER BY SUM(rv.total_size_bytes) DESC, r.host ASC`)
+		ORDER BY COALESCE(SUM(b.size_bytes), 0) DESC, r.host ASC`)
 	if err != nil {
 		return nil, fmt.Errorf("get storage usage by registry: %w", err)
 	}
@@ -547,19 +750,18 @@ func (s *Store) GetStorageUsageByRegistry(ctx context.Context) ([]RegistryStorag
 	return result, rows.Err()
 }
 
-func (s *Store) GetRegistryArchitectureCoverage(ctx context.Context, host string) ([]ArchitectureCoverageView, error) {
+// GetRegistryArchitectureCoverage counts the registry's repositories in the
+// namespaces of the scope, or all of them for a nil scope, by platform.
+func (s *Store) GetRegistryArchitectureCoverage(ctx context.Context, host string, scope NamespaceScope) ([]ArchitectureCoverageView, error) {
+	cond, condArgs := scope.and("reg.host", "r.namespace")
 	rows, err := s.query(ctx,
-		`SELECT m.architecture,
-		 CASE WHEN m.variant != '' THEN m.architecture || '/' || m.variant ELSE m.architecture END,
-		 COUNT(DISTINCT t.id)
-		 FROM tags t
-		 JOIN manifest_platforms mp ON t.digest = mp.index_digest
-		 JOIN manifests m ON m.digest = mp.platform_digest
-		 JOIN repositories r ON r.id = t.repo_id
+		`SELECT `+platformLabel+`, COUNT(DISTINCT rp.repo_id)
+		 FROM repository_platforms rp
+		 JOIN repositories r ON r.id = rp.repo_id
 		 JOIN registries reg ON reg.id = r.registry_id
-		 WHERE reg.host = ?
-		 GROUP BY m.architecture, m.variant
-		 ORDER BY COUNT(DISTINCT t.id) DESC`, host)
+		 WHERE reg.host = ?`+cond+`
+		 GROUP BY architecture, variant
+		 ORDER BY COUNT(DISTINCT rp.repo_id) DESC, architecture, variant`, append([]any{host}, condArgs...)...)
 	if err != nil {
 		return nil, fmt.Errorf("get architecture coverage: %w", err)
 	}
@@ -568,11 +770,9 @@ func (s *Store) GetRegistryArchitectureCoverage(ctx context.Context, host string
 	var result []ArchitectureCoverageView
 	for rows.Next() {
 		var ac ArchitectureCoverageView
-		var label string
-		if err := rows.Scan(&ac.Architecture, &label, &ac.RepositoryCount); err != nil {
+		if err := rows.Scan(&ac.Architecture, &ac.RepositoryCount); err != nil {
 			return nil, fmt.Errorf("scan architecture: %w", err)
 		}
-		ac.Architecture = label
 		result = append(result, ac)
 	}
 	return result, rows.Err()
@@ -582,7 +782,7 @@ func (s *Store) GetRegistryRepositories(ctx context.Context, host string) ([]Reg
 	rows, err := s.query(ctx,
 		`SELECT id, name, namespace,
 		 CASE WHEN namespace = '' THEN name ELSE namespace || '/' || name END,
-		 tags_count, total_size_bytes
+		 tags_count, total_size_bytes, unique_size_bytes, shared_size_bytes
 		 FROM repositories_view WHERE registry_host = ? ORDER BY name`, host)
 	if err != nil {
 		return nil, fmt.Errorf("get registry repositories: %w", err)
@@ -592,7 +792,7 @@ func (s *Store) GetRegistryRepositories(ctx context.Context, host string) ([]Reg
 	var result []RegistryRepositoryRow
 	for rows.Next() {
 		var row RegistryRepositoryRow
-		if err := rows.Scan(&row.ID, &row.Name, &row.Namespace, &row.DisplayName, &row.TagsCount, &row.TotalSizeInBytes); err != nil {
+		if err := rows.Scan(&row.ID, &row.Name, &row.Namespace, &row.DisplayName, &row.TagsCount, &row.TotalSizeInBytes, &row.UniqueSizeInBytes, &row.SharedSizeInBytes); err != nil {
 			return nil, fmt.Errorf("scan registry repo: %w", err)
 		}
 		result = append(result, row)
@@ -602,10 +802,7 @@ func (s *Store) GetRegistryRepositories(ctx context.Context, host string) ([]Reg
 
 func (s *Store) GetUniqueArchitectures(ctx context.Context) ([]string, error) {
 	rows, err := s.query(ctx,
-		`SELECT DISTINCT CASE WHEN variant != '' THEN architecture || '/' || variant ELSE architecture END
-		 FROM manifests WHERE architecture IS NOT NULL AND architecture != '' UNION
-		 SELECT DISTINCT architecture FROM manifest_platforms WHERE architecture != ''
-		 ORDER BY 1`)
+		`SELECT DISTINCT `+platformLabel+` FROM repository_platforms ORDER BY 1`)
 	if err != nil {
 		return nil, fmt.Errorf("get unique architectures: %w", err)
 	}
@@ -633,6 +830,218 @@ func (s *Store) GetTotalRepositoriesCount(ctx context.Context) (int, error) {
 	return count, nil
 }
 
+// GetTagTimeline returns the history of a tag, newest first, with a summary of
+// every manifest the tag pointed to.
+func (s *Store) GetTagTimeline(ctx context.Context, repositoryID uint, tagName string) ([]TagEventView, error) {
+	rows, err := s.query(ctx,
+		`SELECT id, event, old_digest, new_digest, sync_run_id, occurred_at
+		 FROM tag_events WHERE repo_id = ? AND tag_name = ?
+		 ORDER BY occurred_at DESC, id DESC`, repositoryID, tagName)
+	if err != nil {
+		return nil, fmt.Errorf("query tag events %d/%s: %w", repositoryID, tagName, err)
+	}
+	defer closeRows(rows)
+
+	var events []TagEventView
+	var digests []string
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var ev TagEventView
+		var runID sql.NullInt64
+		if err := rows.Scan(&ev.ID, &ev.Event, &ev.OldDigest, &ev.NewDigest, &runID, &ev.OccurredAt); err != nil {
+			return nil, fmt.Errorf("scan tag event: %w", err)
+		}
+		if runID.Valid {
+			ev.SyncRunID = &runID.Int64
+		}
+		for _, d := range []string{ev.OldDigest, ev.NewDigest} {
+			if d != "" && !seen[d] {
+				seen[d] = true
+				digests = append(digests, d)
+			}
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate tag events: %w", err)
+	}
+	if len(events) == 0 {
+		return events, nil
+	}
+
+	summaries, err := s.digestSummaries(ctx, digests)
+	if err != nil {
+		return nil, err
+	}
+
+	current := ""
+	if tag, err := s.GetTagByRepoAndName(ctx, repositoryID, tagName); err == nil {
+		current = tag.Digest
+	}
+	summaryFor := func(digest string) *DigestSummary {
+		if digest == "" {
+			return nil
+		}
+		summary, ok := summaries[digest]
+		if !ok {
+			summary = DigestSummary{Digest: digest, Platforms: []string{}}
+		}
+		summary.Current = digest == current
+		return &summary
+	}
+	for i := range events {
+		events[i].Old = summaryFor(events[i].OldDigest)
+		events[i].New = summaryFor(events[i].NewDigest)
+	}
+	return events, nil
+}
+
+func (s *Store) digestSummaries(ctx context.Context, digests []string) (map[string]DigestSummary, error) {
+	phs := make([]string, len(digests))
+	args := make([]any, len(digests))
+	for i, d := range digests {
+		phs[i] = "?"
+		args[i] = d
+	}
+	in := strings.Join(phs, ",")
+
+	manifestRows, err := s.query(ctx,
+		fmt.Sprintf(`SELECT digest, kind, size_bytes, created, COALESCE(os, ''), COALESCE(architecture, ''), COALESCE(variant, '')
+		 FROM manifests WHERE digest IN (%s)`, in),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("query timeline manifests: %w", err)
+	}
+	defer closeRows(manifestRows)
+
+	summaries := make(map[string]DigestSummary, len(digests))
+	for manifestRows.Next() {
+		var summary DigestSummary
+		var created sql.NullString
+		var osName, arch, variant string
+		if err := manifestRows.Scan(&summary.Digest, &summary.Kind, &summary.Size, &created, &osName, &arch, &variant); err != nil {
+			return nil, fmt.Errorf("scan timeline manifest: %w", err)
+		}
+		if created.Valid {
+			if t, err := parseTime(created.String); err == nil {
+				summary.CreatedAt = &t
+			}
+		}
+		summary.Platforms = []string{}
+		if platform := formatPlatform(osName, arch, variant); platform != "" {
+			summary.Platforms = append(summary.Platforms, platform)
+		}
+		summary.Available = true
+		summaries[summary.Digest] = summary
+	}
+	if err := manifestRows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate timeline manifests: %w", err)
+	}
+
+	platformRows, err := s.query(ctx,
+		fmt.Sprintf(`SELECT index_digest, os, architecture, variant FROM manifest_platforms
+		 WHERE index_digest IN (%s) ORDER BY index_digest, position`, in),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("query timeline platforms: %w", err)
+	}
+	defer closeRows(platformRows)
+
+	for platformRows.Next() {
+		var indexDigest, osName, arch, variant string
+		if err := platformRows.Scan(&indexDigest, &osName, &arch, &variant); err != nil {
+			return nil, fmt.Errorf("scan timeline platform: %w", err)
+		}
+		summary, ok := summaries[indexDigest]
+		if !ok {
+			continue
+		}
+		if platform := formatPlatform(osName, arch, variant); platform != "" {
+			summary.Platforms = append(summary.Platforms, platform)
+		}
+		summaries[indexDigest] = summary
+	}
+	return summaries, platformRows.Err()
+}
+
+// fullDigestLength is the length of a sha256 digest with its algorithm prefix.
+const fullDigestLength = len("sha256:") + 64
+
+// FindDigestUsage returns every tag, across all registries, that references
+// digest as its own manifest, as a platform of its index, or as a config or
+// layer of one of its images. A shorter digest is matched as a prefix.
+func (s *Store) FindDigestUsage(ctx context.Context, digest string) ([]DigestUsageGroup, error) {
+	op, arg := "=", digest
+	if len(digest) < fullDigestLength {
+		op, arg = "LIKE", digest+"%"
+	}
+
+	rows, err := s.query(ctx, fmt.Sprintf(`
+		WITH usage AS (
+			SELECT t.id AS tag_id, t.digest AS matched, 'tag' AS relation, m.os, m.architecture, m.variant
+			FROM tags t
+			LEFT JOIN manifests m ON m.digest = t.digest
+			WHERE t.digest %[1]s ?
+			UNION ALL
+			SELECT t.id, mp.platform_digest, 'platform', mp.os, mp.architecture, mp.variant
+			FROM manifest_platforms mp
+			JOIN tags t ON t.digest = mp.index_digest
+			WHERE mp.platform_digest %[1]s ?
+			UNION ALL
+			SELECT t.id, m.config_digest, 'config', m.os, m.architecture, m.variant
+			FROM manifests m
+			LEFT JOIN manifest_platforms mp ON mp.platform_digest = m.digest
+			JOIN tags t ON t.digest = m.digest OR t.digest = mp.index_digest
+			WHERE m.config_digest %[1]s ?
+			UNION ALL
+			SELECT t.id, ml.layer_digest, 'layer', m.os, m.architecture, m.variant
+			FROM manifest_layers ml
+			JOIN manifests m ON m.digest = ml.manifest_digest
+			LEFT JOIN manifest_platforms mp ON mp.platform_digest = m.digest
+			JOIN tags t ON t.digest = m.digest OR t.digest = mp.index_digest
+			WHERE ml.layer_digest %[1]s ?
+		)
+		SELECT DISTINCT reg.name, reg.host, r.namespace, r.name, t.name, t.digest, u.matched, u.relation,
+			COALESCE(u.os, ''), COALESCE(u.architecture, ''), COALESCE(u.variant, '')
+		FROM usage u
+		JOIN tags t ON t.id = u.tag_id
+		JOIN repositories r ON r.id = t.repo_id
+		JOIN registries reg ON reg.id = r.registry_id
+		ORDER BY reg.name, r.namespace, r.name, t.name, u.relation, u.matched`, op),
+		arg, arg, arg, arg)
+	if err != nil {
+		return nil, fmt.Errorf("find digest usage %s: %w", digest, err)
+	}
+	defer closeRows(rows)
+
+	var groups []DigestUsageGroup
+	for rows.Next() {
+		var registry, host, osName, arch, variant string
+		var u DigestUsage
+		if err := rows.Scan(&registry, &host, &u.Namespace, &u.Repository, &u.Tag, &u.TagDigest,
+			&u.Digest, &u.Relation, &osName, &arch, &variant); err != nil {
+			return nil, fmt.Errorf("scan digest usage: %w", err)
+		}
+		u.Platform = formatPlatform(osName, arch, variant)
+		if n := len(groups); n == 0 || groups[n-1].RegistryHost != host {
+			groups = append(groups, DigestUsageGroup{Registry: registry, RegistryHost: host})
+		}
+		g := &groups[len(groups)-1]
+		g.Usages = append(g.Usages, u)
+	}
+	return groups, rows.Err()
+}
+
+func formatPlatform(osName, arch, variant string) string {
+	parts := make([]string, 0, 3)
+	for _, p := range []string{osName, arch, variant} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
 func parseTime(value string) (time.Time, error) {
 	t, err := time.Parse("2006-01-02 15:04:05.999999999-07:00", value)
 	if err != nil {
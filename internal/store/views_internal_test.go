@@ -1,8 +1,13 @@
 package store
 
 import (
+	"context"
+	"database/sql"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/mod/semver"
 )
 
 func TestParseTime(t *testing.T) {
@@ -38,3 +43,73 @@ func TestParseTimeInvalid(t *testing.T) {
 		t.Fatal("expected parseTime to fail for invalid input")
 	}
 }
+
+func TestTagVersionKeyOrdersLikeSemver(t *testing.T) {
+	names := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-alpha-x", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "v1.0.0+build.5", "1.2",
+		"v1.9.0", "1.10.0", "v2", "10.0.0", "123456789012.0.0",
+	}
+	for _, left := range names {
+		for _, right := range names {
+			want := semver.Compare(canonical(left), canonical(right))
+			got := strings.Compare(tagVersionKey(left), tagVersionKey(right))
+			if got != want {
+				t.Errorf("%s vs %s: key order %d, semver order %d", left, right, got, want)
+			}
+		}
+	}
+
+	for _, name := range []string{"latest", "main", "1.0.0.0", "v01.2.3", ""} {
+		if key := tagVersionKey(name); key != "" {
+			t.Errorf("expected no version key for %q, got %q", name, key)
+		}
+	}
+}
+
+func canonical(name string) string {
+	if !strings.HasPrefix(name, "v") {
+		return "v" + name
+	}
+	return name
+}
+
+func TestBackfillVersionKeys(t *testing.T) {
+	ctx := context.Background()
+	s, err := New(ctx, ":memory:")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	reg, err := s.UpsertRegistryByFields(ctx, "test", "https://test.io", "test.io", 200)
+	if err != nil {
+		t.Fatalf("UpsertRegistryByFields: %v", err)
+	}
+	repo, err := s.UpsertRepositoryByFields(ctx, reg.ID, "lib", "app")
+	if err != nil {
+		t.Fatalf("UpsertRepositoryByFields: %v", err)
+	}
+	for _, name := range []string{"1.2.3", "latest"} {
+		if _, err := s.UpsertTagWithSync(ctx, repo.ID, name, "sha256:aaa", "image", "app/json", 1); err != nil {
+			t.Fatalf("UpsertTagWithSync: %v", err)
+		}
+	}
+	// Rows written before the column existed have no key.
+	if _, err := s.exec(ctx, "UPDATE tags SET version_key = NULL"); err != nil {
+		t.Fatalf("clear version keys: %v", err)
+	}
+
+	if err := backfillVersionKeys(ctx, s.db, s.dialect); err != nil {
+		t.Fatalf("backfillVersionKeys: %v", err)
+	}
+	for _, name := range []string{"1.2.3", "latest"} {
+		var key sql.NullString
+		if err := s.queryRow(ctx, "SELECT version_key FROM tags WHERE name = ?", name).Scan(&key); err != nil {
+			t.Fatalf("read version key: %v", err)
+		}
+		if !key.Valid || key.String != tagVersionKey(name) {
+			t.Fatalf("expected %s to get key %q, got %+v", name, tagVersionKey(name), key)
+		}
+	}
+}
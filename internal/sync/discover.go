@@ -5,16 +5,14 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	stdsync "sync"
 
 	"github.com/eznix86/docker-registry-ui/internal/progress"
 	"github.com/eznix86/docker-registry-ui/internal/registry"
 	"github.com/eznix86/docker-registry-ui/internal/store"
 	"github.com/eznix86/docker-registry-ui/internal/sync/planning"
-	registryclient "github.com/eznix86/registry-client"
 )
 
-const registryPageSize = 100
-
 // discoveryReport bundles all results from the discovery phase.
 type discoveryReport struct {
 	Jobs       []planning.Job
@@ -27,7 +25,9 @@ func discoverAll(
 	ctx context.Context,
 	s *store.Store,
 	rm *registry.Manager,
+	lim *limiter,
 	registries []store.Registry,
+	workers int,
 	prog progress.ProgressReporter,
 	logger Logger,
 ) (*discoveryReport, error) {
@@ -37,13 +37,14 @@ func discoverAll(
 	resultCh := make(chan discoveryResult, len(registries))
 	for _, reg := range registries {
 		go func(r store.Registry) {
-			repos, status, err := discoverOneRegistry(ctx, rm, r, logger)
+			repos, truncated, status, err := discoverOneRegistry(ctx, rm, lim, r, workers, logger)
 			resultCh <- discoveryResult{
-				regName: r.Name,
-				regHost: r.Host,
-				repos:   repos,
-				status:  status,
-				err:     err,
+				regName:   r.Name,
+				regHost:   r.Host,
+				repos:     repos,
+				truncated: truncated,
+				status:    status,
+				err:       err,
 			}
 		}(reg)
 	}
@@ -72,7 +73,11 @@ func discoverAll(
 			tagCount += len(repo.Tags)
 		}
 		logger.Info("Discovered", "registry", r.regName, "repositories", len(r.repos), "tags", tagCount)
-		report.Registries = append(report.Registries, DiscoveredRegistry{Name: r.regName, Host: r.regHost})
+		report.Registries = append(report.Registries, DiscoveredRegistry{
+			Name:             r.regName,
+			Host:             r.regHost,
+			CatalogTruncated: r.truncated,
+		})
 
 		jobs, repos := processDiscovered(r)
 		report.Jobs = append(report.Jobs, jobs...)
@@ -86,84 +91,140 @@ func discoverAll(
 }
 
 type discoveryResult struct {
-	regName string
-	regHost string
-	repos   []DiscoveredRepo
-	status  int
-	err     error
+	regName   string
+	regHost   string
+	repos     []DiscoveredRepo
+	truncated bool
+	status    int
+	err       error
 }
 
 func discoverOneRegistry(
 	ctx context.Context,
 	rm *registry.Manager,
+	lim *limiter,
 	reg store.Registry,
+	workers int,
 	logger Logger,
-) ([]DiscoveredRepo, int, error) {
+) ([]DiscoveredRepo, bool, int, error) {
 	client, err := rm.GetClient(reg.Name)
 	if err != nil {
-		return nil, 0, fmt.Errorf("get client %s: %w", reg.Name, err)
+		return nil, false, 0, fmt.Errorf("get client %s: %w", reg.Name, err)
 	}
 	status, err := client.HealthCheck(ctx)
 	if err != nil {
-		return nil, status, fmt.Errorf("health check %s: %w", reg.Name, err)
+		return nil, false, status, fmt.Errorf("health check %s: %w", reg.Name, err)
 	}
 
+	pager := client.CatalogPager()
 	var repositories []string
-	last := ""
 	for {
-		resp, err := client.GetCatalog(ctx, &registryclient.PaginationParams{N: registryPageSize, Last: last})
+		release, err := lim.acquire(ctx, reg.Name)
+		if err != nil {
+			return nil, false, status, err
+		}
+		page, more, err := pager.Next(ctx)
+		release()
 		if err != nil {
-			return nil, status, fmt.Errorf("catalog %s: %w", reg.Name, err)
+			lim.markFailure(reg.Name)
+			return nil, false, status, fmt.Errorf("catalog %s: %w", reg.Name, err)
 		}
-		repositories = append(repositories, resp.Repositories...)
-		if len(resp.Repositories) < registryPageSize {
+		lim.resetFailures(reg.Name)
+		repositories = append(repositories, page...)
+		if !more {
 			break
 		}
-		last = resp.Repositories[len(resp.Repositories)-1]
 		select {
 		case <-ctx.Done():
-			return nil, status, ctx.Err()
+			return nil, false, status, ctx.Err()
 		default:
 		}
 	}
+	if pager.Truncated() {
+		logger.Warn("Catalog listing truncated, stale repositories will not be pruned", "registry", reg.Name, "repositories", len(repositories))
+	}
+
+	discovered := listRepositoryTags(ctx, client, lim, reg, repositories, workers, logger)
+	return discovered, pager.Truncated(), status, nil
+}
 
-	var discovered []DiscoveredRepo
-	for _, repoFull := range repositories {
+// listRepositoryTags fans tag listing out over a bounded pool of workers that
+// share the registry's limiter slots with the rest of the sync. Results keep the
+// catalog order, although nothing downstream relies on it.
+func listRepositoryTags(
+	ctx context.Context,
+	client *registry.Client,
+	lim *limiter,
+	reg store.Registry,
+	repositories []string,
+	workers int,
+	logger Logger,
+) []DiscoveredRepo {
+	discovered := make([]DiscoveredRepo, len(repositories))
+	for i, repoFull := range repositories {
 		ns, name := splitRepoName(repoFull)
+		discovered[i] = DiscoveredRepo{Namespace: ns, Name: name}
+	}
 
-		var tags []string
-		tagsFetched := true
-		lastTag := ""
-	fetchTags:
-		for {
-			resp, err := client.ListTags(ctx, repoFull, &registryclient.PaginationParams{N: registryPageSize, Last: lastTag})
-			if err != nil {
-				logger.Warn("Failed to list tags", "registry", reg.Name, "repo", repoFull, "error", err)
-				tagsFetched = false
-				break
-			}
-			tags = append(tags, resp.Tags...)
-			if len(resp.Tags) < registryPageSize {
-				break
+	indexCh := make(chan int)
+	var wg stdsync.WaitGroup
+	for range min(max(workers, 1), len(repositories)) {
+		wg.Go(func() {
+			for i := range indexCh {
+				discovered[i].Tags, discovered[i].TagsFetched = listTags(ctx, client, lim, reg, repositories[i], logger)
 			}
-			lastTag = resp.Tags[len(resp.Tags)-1]
-			select {
-			case <-ctx.Done():
-				tagsFetched = false
-				break fetchTags
-			default:
-			}
-		}
-
-		discovered = append(discovered, DiscoveredRepo{
-			Namespace:   ns,
-			Name:        name,
-			Tags:        tags,
-			TagsFetched: tagsFetched,
 		})
 	}
 
-	return discovered, status, nil
+feed:
+	for i := range repositories {
+		select {
+		case indexCh <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexCh)
+	wg.Wait()
+
+	return discovered
+}
+
+// listTags pages through the tags of one repository. The boolean result is false
+// whenever the list may be incomplete, so pruning leaves the repository alone.
+func listTags(
+	ctx context.Context,
+	client *registry.Client,
+	lim *limiter,
+	reg store.Registry,
+	repoFull string,
+	logger Logger,
+) ([]string, bool) {
+	pager := client.TagsPager(repoFull)
+	var tags []string
+	for {
+		release, err := lim.acquire(ctx, reg.Name)
+		if err != nil {
+			return tags, false
+		}
+		page, more, err := pager.Next(ctx)
+		release()
+		if err != nil {
+			lim.markFailure(reg.Name)
+			logger.Warn("Failed to list tags", "registry", reg.Name, "repo", repoFull, "error", err)
+			return tags, false
+		}
+		lim.resetFailures(reg.Name)
+		tags = append(tags, page...)
+		if !more {
+			return tags, !pager.Truncated()
+		}
+		select {
+		case <-ctx.Done():
+			return tags, false
+		default:
+		}
+	}
 }
 
 func processDiscovered(r discoveryResult) ([]planning.Job, []DiscoveredRepository) {
@@ -221,10 +282,14 @@ func upsertDiscoveredRepos(
 	return nil
 }
 
+// pruneStaleRepos deletes repositories that are no longer in a fully listed
+// catalog. Their tags are recorded as deleted first, so the history that
+// outlives the repository ends with the deletion.
 func pruneStaleRepos(
 	ctx context.Context,
 	s *store.Store,
 	logger Logger,
+	runID int64,
 	discoveredRegs []DiscoveredRegistry,
 	discoveredRepos []DiscoveredRepository,
 ) error {
@@ -235,8 +300,16 @@ func pruneStaleRepos(
 	if err != nil {
 		return fmt.Errorf("get repos for pruning: %w", err)
 	}
+	tags, err := s.GetAllTags(ctx)
+	if err != nil {
+		return fmt.Errorf("get tags for pruning: %w", err)
+	}
+	tagsByRepo := groupTagsByRepo(tags)
 	validHosts := make(map[string]bool)
 	for _, dr := range discoveredRegs {
+		if dr.CatalogTruncated {
+			continue
+		}
 		validHosts[dr.Host] = true
 	}
 	validRepos := make(map[string]bool)
@@ -252,7 +325,15 @@ func pruneStaleRepos(
 		if validRepos[key] {
 			continue
 		}
-		if err := s.DeleteRepository(ctx, &store.Repository{ID: repo.ID}); err != nil {
+		err := s.WithinTx(ctx, func(tx *store.Store) error {
+			for _, tag := range tagsByRepo[repo.ID] {
+				if err := tx.RecordTagEvent(ctx, repo.ID, tag.Name, store.TagEventDeleted, tag.Digest, "", runID); err != nil {
+					return err
+				}
+			}
+			return tx.DeleteRepository(ctx, &store.Repository{ID: repo.ID})
+		})
+		if err != nil {
 			return fmt.Errorf("delete stale repo %s/%s: %w", repo.Namespace, repo.Name, err)
 		}
 		deleted++
@@ -266,11 +347,13 @@ func pruneStaleRepos(
 // pruneStaleTags removes tags from the database that are no longer present in the
 // discovered tag list. Only prunes tags from registries/repos where the full tag
 // list was successfully fetched (TagsFetched == true). If discovery failed for a
-// registry, its tags are left untouched to avoid accidental data loss.
+// registry, its tags are left untouched to avoid accidental data loss. Every
+// pruned tag is recorded as deleted in its history.
 func pruneStaleTags(
 	ctx context.Context,
 	s *store.Store,
 	logger Logger,
+	runID int64,
 	discoveredRepos []DiscoveredRepository,
 ) error {
 	repos, err := s.GetRepositoriesViewFiltered(ctx, store.RepositoryFilters{ShowUntagged: true})
@@ -303,7 +386,13 @@ func pruneStaleTags(
 			if current[tag.Name] {
 				continue
 			}
-			if err := s.DeleteTag(ctx, &store.Tag{ID: tag.ID}); err != nil {
+			err := s.WithinTx(ctx, func(tx *store.Store) error {
+				if err := tx.RecordTagEvent(ctx, repo.ID, tag.Name, store.TagEventDeleted, tag.Digest, "", runID); err != nil {
+					return err
+				}
+				return tx.DeleteTag(ctx, &store.Tag{ID: tag.ID})
+			})
+			if err != nil {
 				return fmt.Errorf("delete stale tag %s: %w", tag.Name, err)
 			}
 			deleted++
@@ -326,7 +415,13 @@ type DiscoveredRepository struct {
 }
 
 // DiscoveredRegistry represents a registry found during discovery.
-type DiscoveredRegistry struct{ Name, Host string }
+// CatalogTruncated marks a catalog that was not listed to the end, whose
+// repositories must not be pruned.
+type DiscoveredRegistry struct {
+	Name             string
+	Host             string
+	CatalogTruncated bool
+}
 
 // DiscoveredRepo holds repository-level discovery data from a single registry.
 type DiscoveredRepo struct {
@@ -0,0 +1,169 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdsync "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eznix86/docker-registry-ui/internal/registry"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+	registryclient "github.com/eznix86/registry-client"
+)
+
+// fakeTagLister answers tag listings from a map and records how many listings
+// ran at once. Each call waits until peak reaches gate (or a deadline passes)
+// so the bound is actually reached rather than depending on scheduling.
+type fakeTagLister struct {
+	registryclient.RegistryClient
+
+	tags  map[string][]string
+	fail  map[string]bool
+	delay func(repo string) time.Duration
+	gate  int32
+
+	inFlight atomic.Int32
+	peak     atomic.Int32
+
+	mu    stdsync.Mutex
+	order []string
+}
+
+func (f *fakeTagLister) ListTags(ctx context.Context, repo string, _ *registryclient.PaginationParams) (*registryclient.TagsResponse, error) {
+	n := f.inFlight.Add(1)
+	defer f.inFlight.Add(-1)
+	for {
+		peak := f.peak.Load()
+		if n <= peak || f.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for f.peak.Load() < f.gate && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if f.delay != nil {
+		select {
+		case <-time.After(f.delay(repo)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	f.mu.Lock()
+	f.order = append(f.order, repo)
+	f.mu.Unlock()
+
+	if f.fail[repo] {
+		return nil, errors.New("listing failed")
+	}
+	return &registryclient.TagsResponse{Tags: f.tags[repo]}, nil
+}
+
+func fakeRepositories(n int) ([]string, map[string][]string) {
+	repos := make([]string, n)
+	tags := make(map[string][]string, n)
+	for i := range repos {
+		repos[i] = fmt.Sprintf("team/app-%02d", i)
+		tags[repos[i]] = []string{"latest", fmt.Sprintf("v%d", i)}
+	}
+	return repos, tags
+}
+
+func TestListRepositoryTagsBoundsConcurrency(t *testing.T) {
+	t.Helper()
+
+	tests := []struct {
+		name      string
+		workers   int
+		maxPerReg int
+		want      int32
+	}{
+		{name: "workers", workers: 3, maxPerReg: 10, want: 3},
+		{name: "limiter", workers: 8, maxPerReg: 2, want: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repos, tags := fakeRepositories(20)
+			fake := &fakeTagLister{tags: tags, gate: test.want}
+			client := &registry.Client{RegistryClient: fake}
+
+			got := listRepositoryTags(context.Background(), client, newLimiter(test.maxPerReg, 100),
+				store.Registry{Name: "test"}, repos, test.workers, &defaultLogger{})
+
+			if peak := fake.peak.Load(); peak != test.want {
+				t.Fatalf("expected at most %d concurrent listings to be reached, peak was %d", test.want, peak)
+			}
+			if len(got) != len(repos) {
+				t.Fatalf("expected %d repositories, got %d", len(repos), len(got))
+			}
+			for _, repo := range got {
+				if !repo.TagsFetched {
+					t.Fatalf("expected tags of %s/%s to be fetched", repo.Namespace, repo.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestListRepositoryTagsIsolatesFailures(t *testing.T) {
+	t.Helper()
+
+	repos, tags := fakeRepositories(6)
+	fake := &fakeTagLister{tags: tags, fail: map[string]bool{repos[2]: true}}
+	client := &registry.Client{RegistryClient: fake}
+
+	got := listRepositoryTags(context.Background(), client, newLimiter(4, 100),
+		store.Registry{Name: "test"}, repos, 4, &defaultLogger{})
+
+	for i, repo := range got {
+		if i == 2 {
+			if repo.TagsFetched || len(repo.Tags) != 0 {
+				t.Fatalf("expected the failing repository to stay unfetched, got %+v", repo)
+			}
+			continue
+		}
+		if !repo.TagsFetched || len(repo.Tags) != 2 {
+			t.Fatalf("expected %s/%s to be listed despite the failure, got %+v", repo.Namespace, repo.Name, repo)
+		}
+	}
+}
+
+func TestListRepositoryTagsIgnoresCompletionOrder(t *testing.T) {
+	t.Helper()
+
+	repos, tags := fakeRepositories(8)
+	// Later repositories answer first, so completion order is the reverse of
+	// the catalog order.
+	rank := make(map[string]int, len(repos))
+	for i, repo := range repos {
+		rank[repo] = len(repos) - i
+	}
+	fake := &fakeTagLister{
+		tags:  tags,
+		gate:  int32(len(repos)),
+		delay: func(repo string) time.Duration { return time.Duration(rank[repo]) * 5 * time.Millisecond },
+	}
+	client := &registry.Client{RegistryClient: fake}
+
+	got := listRepositoryTags(context.Background(), client, newLimiter(len(repos), 100),
+		store.Registry{Name: "test"}, repos, len(repos), &defaultLogger{})
+
+	if fake.order[0] != repos[len(repos)-1] {
+		t.Fatalf("expected the last repository to finish first, order was %v", fake.order)
+	}
+	for i, repo := range got {
+		ns, name := splitRepoName(repos[i])
+		if repo.Namespace != ns || repo.Name != name {
+			t.Fatalf("result %d is %s/%s, want %s", i, repo.Namespace, repo.Name, repos[i])
+		}
+		if want := tags[repos[i]]; len(repo.Tags) != len(want) || repo.Tags[1] != want[1] {
+			t.Fatalf("result %d has tags %v, want %v", i, repo.Tags, want)
+		}
+	}
+}
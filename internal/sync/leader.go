@@ -0,0 +1,216 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/progress"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+)
+
+const (
+	syncLeaseName = "sync"
+
+	// DefaultLeaseTTL is how long a leader keeps the sync lease without renewing it.
+	DefaultLeaseTTL = 15 * time.Second
+
+	// leaderPollInterval paces how often the leader picks up triggers queued by
+	// followers and publishes its progress, and how often followers read it.
+	leaderPollInterval = time.Second
+)
+
+// election decides which replica runs the sync engine. Replicas sharing a
+// database compete for a lease; the holder renews it every third of its TTL
+// and everyone else follows until it expires.
+type election struct {
+	store  *store.Store
+	holder string
+	ttl    time.Duration
+}
+
+func newElection(s *store.Store, ttl time.Duration) *election {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &election{store: s, holder: holderID(), ttl: ttl}
+}
+
+// holderID identifies this process among replicas. The random suffix keeps a
+// restarted process from inheriting its predecessor's lease.
+func holderID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// run calls lead while this replica holds the lease and follow otherwise,
+// cancelling the running role whenever leadership changes hands.
+func (e *election) run(ctx context.Context, stopCh <-chan struct{}, lead, follow func(context.Context)) {
+	renew := time.NewTicker(e.ttl / 3)
+	defer renew.Stop()
+
+	var cancelTerm context.CancelFunc
+	var termDone chan struct{}
+	endTerm := func() {
+		if cancelTerm != nil {
+			cancelTerm()
+			<-termDone
+		}
+	}
+	startTerm := func(role func(context.Context)) {
+		termCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			role(termCtx)
+		}()
+		cancelTerm, termDone = cancel, done
+	}
+
+	leading := false
+	started := false
+	defer func() {
+		endTerm()
+		if leading {
+			if err := e.store.ReleaseLease(context.WithoutCancel(ctx), syncLeaseName, e.holder); err != nil {
+				clog.Warn("Failed to release sync lease", "holder", e.holder, "error", err)
+			}
+		}
+	}()
+
+	for {
+		acquired, err := e.store.AcquireLease(ctx, syncLeaseName, e.holder, e.ttl)
+		if err != nil {
+			// Without a renewed lease another replica may take over, so stop leading.
+			clog.Warn("Failed to renew sync lease", "holder", e.holder, "error", err)
+			acquired = false
+		}
+		if !started || acquired != leading {
+			endTerm()
+			leading, started = acquired, true
+			if leading {
+				clog.Info("Elected sync leader", "holder", e.holder)
+				startTerm(lead)
+			} else {
+				clog.Info("Following sync leader", "holder", e.holder)
+				startTerm(follow)
+			}
+		}
+
+		select {
+		case <-renew.C:
+		case <-ctx.Done():
+			return
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// progressMirror is implemented by reporters that can show progress made by
+// another process.
+type progressMirror interface {
+	Apply(u progress.Update)
+}
+
+// follow forwards manual triggers to the leader through the database and
+// mirrors the leader's progress into the local reporter.
+func (s *Service) follow(ctx context.Context) {
+	poll := time.NewTicker(leaderPollInterval)
+	defer poll.Stop()
+
+	mirror, _ := s.engine.progress.(progressMirror)
+	var lastSeen time.Time
+	for {
+		select {
+		case <-s.manualCh:
+			if err := s.engine.store.EnqueueSyncTrigger(ctx, "manual", s.election.holder); err != nil {
+				clog.Error("Failed to forward sync trigger", "error", err)
+			}
+		case <-poll.C:
+			if mirror == nil {
+				continue
+			}
+			p, err := s.engine.store.GetSyncProgress(ctx)
+			if err != nil {
+				clog.Warn("Failed to read leader progress", "error", err)
+				continue
+			}
+			if p == nil || !p.UpdatedAt.After(lastSeen) {
+				continue
+			}
+			lastSeen = p.UpdatedAt
+			mirror.Apply(progress.Update{
+				Completed: p.Completed,
+				Total:     p.Total,
+				Message:   p.Message,
+				Step:      p.Step,
+				Done:      p.Done,
+			})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// leaderDuties runs alongside lead: it starts syncs queued by followers and
+// publishes progress for them to mirror.
+func (s *Service) leaderDuties(ctx context.Context) {
+	poll := time.NewTicker(leaderPollInterval)
+	defer poll.Stop()
+
+	updates := s.progressUpdates()
+	var latest progress.Update
+	dirty := false
+	for {
+		select {
+		case u := <-updates:
+			latest, dirty = u, true
+		case <-poll.C:
+			if dirty {
+				if err := s.engine.store.SaveSyncProgress(ctx, store.SyncProgress{
+					Holder:    s.election.holder,
+					Completed: latest.Completed,
+					Total:     latest.Total,
+					Message:   latest.Message,
+					Step:      latest.Step,
+					Done:      latest.Done,
+				}); err != nil {
+					clog.Warn("Failed to publish sync progress", "error", err)
+				} else {
+					dirty = false
+				}
+			}
+			claimed, err := s.engine.store.ClaimSyncTriggers(ctx)
+			if err != nil {
+				clog.Warn("Failed to claim sync triggers", "error", err)
+				continue
+			}
+			if claimed {
+				s.runAsync(ctx, "forwarded")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// progressUpdates subscribes to the progress reporter once; leadership terms
+// share the subscription.
+func (s *Service) progressUpdates() <-chan progress.Update {
+	s.subscribeOnce.Do(func() {
+		s.updates = s.engine.progress.Subscribe()
+	})
+	return s.updates
+}
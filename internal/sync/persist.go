@@ -1,87 +1,361 @@
 package sync
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/eznix86/docker-registry-ui/internal/store"
 	"github.com/eznix86/docker-registry-ui/internal/sync/planning"
+	gojson "github.com/eznix86/registry-client/jsoncompat"
 )
 
+// Synced tags are written behind the workers in batches, so a sync makes one
+// commit per batch rather than one per tag.
+const (
+	defaultPersistBatchSize     = 50
+	defaultPersistFlushInterval = 250 * time.Millisecond
+)
+
+// persister owns the single goroutine that writes sync results. Workers queue
+// finished manifest graphs with save, unchanged tags with touch and failures
+// with fail; the writer commits them in batches of batchSize, or after
+// flushEvery when a batch is slow to fill. Workers never write themselves.
 type persister struct {
-	s *store.Store
+	s          *store.Store
+	runID      int64
+	batchSize  int
+	flushEvery time.Duration
+	queue      chan persistItem
+	done       chan struct{}
 }
 
-func newPersister(s *store.Store) *persister {
-	return &persister{s: s}
+// persistOp is what the writer does with a queued tag.
+type persistOp int
+
+const (
+	// persistSave writes the tag's manifest graph.
+	persistSave persistOp = iota
+	// persistTouch reschedules a tag whose digest did not change.
+	persistTouch
+	// persistFail records why the tag could not be synced.
+	persistFail
+)
+
+// persistItem is one tag waiting to be written. saved is called with the
+// outcome once its batch commits or fails.
+type persistItem struct {
+	op      persistOp
+	job     planning.Job
+	digest  string
+	graph   *ManifestGraph
+	syncErr error
+	saved   func(error)
 }
 
-func (p *persister) save(
-	ctx context.Context,
-	job planning.Job,
-	digest string,
-	graph *ManifestGraph,
-) error {
-	return p.s.WithinTx(ctx, func(tx *store.Store) error {
-		_, err := tx.UpsertTagWithSync(
-			ctx,
-			job.RepositoryID,
-			job.TagName,
-			digest,
-			string(graph.Kind),
-			graph.MediaType,
-			job.PriorityScore,
-		)
-		if err != nil {
-			return fmt.Errorf("upsert tag: %w", err)
-		}
-
-		if graph.Kind == KindIndex {
-			var indexSize int64
-			var indexCreated *time.Time
-			for _, pe := range graph.Platforms {
-				if len(pe.Raw) > 0 {
-					if err := p.savePlatform(ctx, tx, &pe); err != nil {
-						return err
-					}
-				} else {
-					if err := p.savePlatformStub(ctx, tx, &pe); err != nil {
-						return err
-					}
+func newPersister(s *store.Store, runID int64, batchSize int, flushEvery time.Duration) *persister {
+	return &persister{
+		s:          s,
+		runID:      runID,
+		batchSize:  batchSize,
+		flushEvery: flushEvery,
+		queue:      make(chan persistItem, batchSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// start runs the writer until close is called.
+func (p *persister) start(ctx context.Context) {
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.flushEvery)
+		defer ticker.Stop()
+
+		batch := make([]persistItem, 0, p.batchSize)
+		for {
+			select {
+			case item, ok := <-p.queue:
+				if !ok {
+					p.flush(ctx, batch)
+					return
 				}
-				indexSize += pe.Size
-				if pe.ConfigCreated != nil {
-					if indexCreated == nil || pe.ConfigCreated.After(*indexCreated) {
-						indexCreated = pe.ConfigCreated
-					}
+				batch = append(batch, item)
+				if len(batch) >= p.batchSize {
+					p.flush(ctx, batch)
+					batch = batch[:0]
 				}
+			case <-ticker.C:
+				p.flush(ctx, batch)
+				batch = batch[:0]
 			}
+		}
+	}()
+}
 
-			if _, err := tx.UpsertManifestByFields(ctx, digest, graph.MediaType, string(KindIndex),
-				string(graph.Raw), "", "", "", "", indexSize, indexCreated); err != nil {
-				return fmt.Errorf("upsert index manifest %s: %w", digest, err)
-			}
+// close writes what is still queued and waits for the writer to finish. No
+// save may be called afterwards.
+func (p *persister) close() {
+	close(p.queue)
+	<-p.done
+}
 
-			for _, pe := range graph.Platforms {
-				if err := tx.LinkManifestPlatform(ctx, digest, pe.Digest, pe.OS, pe.Architecture, pe.Variant,
-					pe.Position, pe.Size); err != nil {
-					return fmt.Errorf("link platform %s: %w", pe.Digest, err)
-				}
+// save queues a tag's manifest graph for writing. It blocks only while the
+// queue is full, and saved reports whether the tag was written.
+func (p *persister) save(ctx context.Context, job planning.Job, digest string, graph *ManifestGraph, saved func(error)) {
+	p.enqueue(ctx, persistItem{op: persistSave, job: job, digest: digest, graph: graph, saved: saved})
+}
+
+// touch queues a tag whose digest is unchanged, to push back its next check.
+func (p *persister) touch(ctx context.Context, job planning.Job, saved func(error)) {
+	p.enqueue(ctx, persistItem{op: persistTouch, job: job, saved: saved})
+}
+
+// fail queues the error that stopped a tag from syncing.
+func (p *persister) fail(ctx context.Context, job planning.Job, syncErr error, saved func(error)) {
+	p.enqueue(ctx, persistItem{op: persistFail, job: job, syncErr: syncErr, saved: saved})
+}
+
+func (p *persister) enqueue(ctx context.Context, item persistItem) {
+	select {
+	case p.queue <- item:
+	case <-ctx.Done():
+		item.saved(ctx.Err())
+	}
+}
+
+// flush writes a batch in one transaction. Each tag runs in its own savepoint
+// so a failing tag is rolled back alone and the rest of the batch commits.
+// The platforms and statistics of every repository with a saved or failed tag
+// are refreshed once, at the end.
+func (p *persister) flush(ctx context.Context, batch []persistItem) {
+	if len(batch) == 0 {
+		return
+	}
+	results := make([]error, len(batch))
+	err := p.s.WithinTx(ctx, func(tx *store.Store) error {
+		blobs := newBatchBlobs()
+		var repos []uint
+		for i, item := range batch {
+			results[i] = tx.WithinSavepoint(ctx, func() error {
+				return p.write(ctx, tx, blobs, item)
+			})
+			if results[i] != nil {
+				blobs.discard()
+				continue
+			}
+			blobs.keep()
+			if item.op != persistTouch && !slices.Contains(repos, item.job.RepositoryID) {
+				repos = append(repos, item.job.RepositoryID)
 			}
+		}
+		for _, repoID := range repos {
+			if err := tx.RefreshRepository(ctx, repoID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	for i, item := range batch {
+		if err != nil {
+			item.saved(err)
 		} else {
-			for _, pe := range graph.Platforms {
-				if err := p.savePlatform(ctx, tx, &pe); err != nil {
+			item.saved(results[i])
+		}
+	}
+}
+
+func (p *persister) write(ctx context.Context, tx *store.Store, blobs *batchBlobs, item persistItem) error {
+	job := item.job
+	switch item.op {
+	case persistTouch:
+		return tx.UpdateTagSyncMetadata(ctx, job.RepositoryID, job.TagName, job.PriorityScore, unchangedTagRecheckInterval)
+	case persistFail:
+		return tx.MarkTagSyncError(ctx, job.RepositoryID, job.TagName, item.syncErr.Error())
+	case persistSave:
+		return p.saveTag(ctx, tx, blobs, item)
+	}
+	return fmt.Errorf("unknown persist operation %d", item.op)
+}
+
+// batchBlobs remembers the layers and config blobs already upserted in a
+// batch, so tags sharing a base image write each blob once. Blobs written by
+// the tag in progress stay pending until its savepoint is released.
+type batchBlobs struct {
+	written map[string]bool
+	pending map[string]bool
+}
+
+func newBatchBlobs() *batchBlobs {
+	return &batchBlobs{written: map[string]bool{}, pending: map[string]bool{}}
+}
+
+// add reports whether key still has to be written, and marks it as written.
+func (b *batchBlobs) add(key string) bool {
+	if b.written[key] || b.pending[key] {
+		return false
+	}
+	b.pending[key] = true
+	return true
+}
+
+func (b *batchBlobs) keep() {
+	maps.Copy(b.written, b.pending)
+	clear(b.pending)
+}
+
+func (b *batchBlobs) discard() {
+	clear(b.pending)
+}
+
+func (p *persister) saveTag(ctx context.Context, tx *store.Store, blobs *batchBlobs, item persistItem) error {
+	job, digest, graph := item.job, item.digest, item.graph
+	_, err := tx.UpsertTagWithSync(
+		ctx,
+		job.RepositoryID,
+		job.TagName,
+		digest,
+		string(graph.Kind),
+		graph.MediaType,
+		job.PriorityScore,
+	)
+	if err != nil {
+		return fmt.Errorf("upsert tag: %w", err)
+	}
+	if err := p.recordTagEvent(ctx, tx, job, digest); err != nil {
+		return err
+	}
+	if err := tx.IndexTagSearch(ctx, job.RepositoryID, job.TagName, searchDocument(graph)); err != nil {
+		return err
+	}
+
+	if graph.Kind == KindIndex {
+		var indexSize int64
+		var indexCreated *time.Time
+		for _, pe := range graph.Platforms {
+			if len(pe.Raw) > 0 {
+				if err := p.savePlatform(ctx, tx, blobs, &pe); err != nil {
+					return err
+				}
+			} else {
+				if err := p.savePlatformStub(ctx, tx, &pe); err != nil {
 					return err
 				}
 			}
+			indexSize += pe.Size
+			if pe.ConfigCreated != nil {
+				if indexCreated == nil || pe.ConfigCreated.After(*indexCreated) {
+					indexCreated = pe.ConfigCreated
+				}
+			}
 		}
 
-		if err := tx.UpdateRepositorySyncTime(ctx, job.RepositoryID); err != nil {
-			return err
+		if _, err := tx.UpsertManifestByFields(ctx, digest, graph.MediaType, string(KindIndex),
+			string(graph.Raw), "", "", "", "", indexSize, indexCreated); err != nil {
+			return fmt.Errorf("upsert index manifest %s: %w", digest, err)
 		}
+
+		for _, pe := range graph.Platforms {
+			if err := tx.LinkManifestPlatform(ctx, digest, pe.Digest, pe.OS, pe.Architecture, pe.Variant,
+				pe.Position, pe.Size); err != nil {
+				return fmt.Errorf("link platform %s: %w", pe.Digest, err)
+			}
+		}
+	} else {
+		for _, pe := range graph.Platforms {
+			if err := p.savePlatform(ctx, tx, blobs, &pe); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.UpdateRepositorySyncTime(ctx, job.RepositoryID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// recordTagEvent appends the tag's move to its history. Tags whose earlier
+// syncs all failed have no digest yet and are recorded as created.
+func (p *persister) recordTagEvent(ctx context.Context, tx *store.Store, job planning.Job, digest string) error {
+	event := store.TagEventMoved
+	if job.ExistingDigest == "" {
+		event = store.TagEventCreated
+	} else if job.ExistingDigest == digest {
 		return nil
-	})
+	}
+	if err := tx.RecordTagEvent(ctx, job.RepositoryID, job.TagName, event, job.ExistingDigest, digest, p.runID); err != nil {
+		return fmt.Errorf("record tag event: %w", err)
+	}
+	return nil
+}
+
+// OCI annotation keys, also used as config labels, that feed the search index.
+const (
+	annotationTitle       = "org.opencontainers.image.title"
+	annotationDescription = "org.opencontainers.image.description"
+	annotationSource      = "org.opencontainers.image.source"
+	annotationVendor      = "org.opencontainers.image.vendor"
+)
+
+// imageConfigLabels reads only the labels of an image config blob.
+type imageConfigLabels struct {
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// searchDocument gathers the searchable metadata of a tag. Manifest
+// annotations win over config labels, and the first platform that sets a
+// value wins over the rest.
+func searchDocument(graph *ManifestGraph) store.SearchDocument {
+	var sources []map[string]string
+	if sm, err := parseSingleManifest(graph.Raw); err == nil {
+		sources = append(sources, sm.Annotations)
+	}
+	for _, pe := range graph.Platforms {
+		if len(pe.Raw) == 0 || bytes.Equal(pe.Raw, graph.Raw) {
+			continue
+		}
+		if sm, err := parseSingleManifest(pe.Raw); err == nil {
+			sources = append(sources, sm.Annotations)
+		}
+	}
+	for _, pe := range graph.Platforms {
+		if len(pe.ConfigRaw) == 0 {
+			continue
+		}
+		var cfg imageConfigLabels
+		if err := gojson.Unmarshal(pe.ConfigRaw, &cfg); err == nil {
+			sources = append(sources, cfg.Config.Labels)
+		}
+	}
+
+	first := func(key string) string {
+		for _, values := range sources {
+			if v := values[key]; v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	doc := store.SearchDocument{
+		Title:       first(annotationTitle),
+		Description: first(annotationDescription),
+		Source:      first(annotationSource),
+		Vendor:      first(annotationVendor),
+	}
+	for _, pe := range graph.Platforms {
+		if doc.ChartName == "" {
+			doc.ChartName = pe.ChartName
+		}
+		if doc.ChartDesc == "" {
+			doc.ChartDesc = pe.ChartDesc
+		}
+	}
+	return doc
 }
 
 func (p *persister) savePlatformStub(
@@ -111,9 +385,10 @@ func (p *persister) savePlatformStub(
 func (p *persister) savePlatform(
 	ctx context.Context,
 	tx *store.Store,
+	blobs *batchBlobs,
 	pe *PlatformEntry,
 ) error {
-	if pe.ConfigDigest != "" {
+	if pe.ConfigDigest != "" && blobs.add("config:"+pe.ConfigDigest) {
 		if _, err := tx.UpsertConfigBlobByFields(
 			ctx,
 			pe.ConfigDigest,
@@ -129,8 +404,10 @@ func (p *persister) savePlatform(
 
 	var layerDigests []string
 	for _, l := range pe.Layers {
-		if _, err := tx.UpsertLayerByFields(ctx, l.Digest, l.Size, l.MediaType); err != nil {
-			return fmt.Errorf("upsert layer %s: %w", l.Digest, err)
+		if blobs.add("layer:" + l.Digest) {
+			if _, err := tx.UpsertLayerByFields(ctx, l.Digest, l.Size, l.MediaType); err != nil {
+				return fmt.Errorf("upsert layer %s: %w", l.Digest, err)
+			}
 		}
 		layerDigests = append(layerDigests, l.Digest)
 	}
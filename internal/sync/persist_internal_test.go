@@ -0,0 +1,210 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eznix86/docker-registry-ui/internal/store"
+	"github.com/eznix86/docker-registry-ui/internal/sync/planning"
+)
+
+func setupPersistStore(t *testing.T) (*store.Store, *store.Repository, context.Context) {
+	t.Helper()
+	ctx := context.Background()
+	s, err := store.New(ctx, filepath.Join(t.TempDir(), "ui.db"))
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	reg, err := s.UpsertRegistryByFields(ctx, "test", "http://registry.test", "registry.test", 1)
+	if err != nil {
+		t.Fatalf("UpsertRegistryByFields: %v", err)
+	}
+	repo, err := s.UpsertRepositoryByFields(ctx, reg.ID, "team", "app")
+	if err != nil {
+		t.Fatalf("UpsertRepositoryByFields: %v", err)
+	}
+	return s, repo, ctx
+}
+
+func persistJob(repoID uint, tag string) planning.Job {
+	return planning.Job{
+		JobInput:      planning.JobInput{RegistryName: "test", Namespace: "team", RepoName: "app", TagName: tag},
+		RepositoryID:  repoID,
+		PriorityScore: 1,
+	}
+}
+
+func persistGraph(tag string) *ManifestGraph {
+	return &ManifestGraph{
+		Digest:    "sha256:" + tag,
+		MediaType: "application/vnd.oci.image.manifest.v1+json",
+		Kind:      KindImage,
+	}
+}
+
+// savedResults collects the outcome of each queued tag by name.
+type savedResults chan struct {
+	tag string
+	err error
+}
+
+func (r savedResults) callback(tag string) func(error) {
+	return func(err error) {
+		r <- struct {
+			tag string
+			err error
+		}{tag, err}
+	}
+}
+
+func (r savedResults) wait(t *testing.T, n int) map[string]error {
+	t.Helper()
+	got := make(map[string]error, n)
+	for range n {
+		select {
+		case res := <-r:
+			got[res.tag] = res.err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d saved callbacks, got %d", n, len(got))
+		}
+	}
+	return got
+}
+
+func (r savedResults) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case res := <-r:
+		t.Fatalf("expected nothing flushed yet, %s was saved", res.tag)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPersisterFlushesFullBatch(t *testing.T) {
+	t.Helper()
+
+	s, repo, ctx := setupPersistStore(t)
+	p := newPersister(s, 0, 3, time.Hour)
+	p.start(ctx)
+	defer p.close()
+
+	results := make(savedResults, 4)
+	for i := range 2 {
+		tag := fmt.Sprintf("v%d", i)
+		p.save(ctx, persistJob(repo.ID, tag), "sha256:"+tag, persistGraph(tag), results.callback(tag))
+	}
+	results.expectNone(t)
+
+	p.save(ctx, persistJob(repo.ID, "v2"), "sha256:v2", persistGraph("v2"), results.callback("v2"))
+	for tag, err := range results.wait(t, 3) {
+		if err != nil {
+			t.Fatalf("save %s: %v", tag, err)
+		}
+		if _, err := s.GetTagByRepoAndName(ctx, repo.ID, tag); err != nil {
+			t.Fatalf("expected %s to be written with its batch: %v", tag, err)
+		}
+	}
+}
+
+func TestPersisterFlushesOnInterval(t *testing.T) {
+	t.Helper()
+
+	s, repo, ctx := setupPersistStore(t)
+	p := newPersister(s, 0, 100, 10*time.Millisecond)
+	p.start(ctx)
+	defer p.close()
+
+	results := make(savedResults, 1)
+	p.save(ctx, persistJob(repo.ID, "latest"), "sha256:latest", persistGraph("latest"), results.callback("latest"))
+	if err := results.wait(t, 1)["latest"]; err != nil {
+		t.Fatalf("save latest: %v", err)
+	}
+}
+
+func TestPersisterFlushesOnClose(t *testing.T) {
+	t.Helper()
+
+	s, repo, ctx := setupPersistStore(t)
+	mustPersist(t, s, repo, ctx, "v1")
+
+	p := newPersister(s, 0, 100, time.Hour)
+	p.start(ctx)
+
+	results := make(savedResults, 3)
+	p.save(ctx, persistJob(repo.ID, "v2"), "sha256:v2", persistGraph("v2"), results.callback("v2"))
+	p.touch(ctx, persistJob(repo.ID, "v1"), results.callback("v1"))
+	p.fail(ctx, persistJob(repo.ID, "broken"), errors.New("manifest unknown"), results.callback("broken"))
+	results.expectNone(t)
+
+	p.close()
+	for tag, err := range results.wait(t, 3) {
+		if err != nil {
+			t.Fatalf("persist %s: %v", tag, err)
+		}
+	}
+
+	broken, err := s.GetTagByRepoAndName(ctx, repo.ID, "broken")
+	if err != nil {
+		t.Fatalf("GetTagByRepoAndName: %v", err)
+	}
+	if broken.SyncStatus != "error" || broken.LastError != "manifest unknown" {
+		t.Fatalf("expected the failure to be recorded, got status %q error %q", broken.SyncStatus, broken.LastError)
+	}
+	touched, err := s.GetTagByRepoAndName(ctx, repo.ID, "v1")
+	if err != nil {
+		t.Fatalf("GetTagByRepoAndName: %v", err)
+	}
+	if touched.NextCheckAt == nil || time.Until(*touched.NextCheckAt) < unchangedTagRecheckInterval/2 {
+		t.Fatalf("expected the unchanged tag to be rescheduled, next check at %v", touched.NextCheckAt)
+	}
+}
+
+func TestPersisterIsolatesFailingItem(t *testing.T) {
+	t.Helper()
+
+	s, repo, ctx := setupPersistStore(t)
+	p := newPersister(s, 0, 3, time.Hour)
+	p.start(ctx)
+	defer p.close()
+
+	results := make(savedResults, 3)
+	p.save(ctx, persistJob(repo.ID, "v1"), "sha256:v1", persistGraph("v1"), results.callback("v1"))
+	// The repository does not exist, so this tag's insert violates its
+	// foreign key.
+	p.save(ctx, persistJob(repo.ID+100, "orphan"), "sha256:orphan", persistGraph("orphan"), results.callback("orphan"))
+	p.save(ctx, persistJob(repo.ID, "v2"), "sha256:v2", persistGraph("v2"), results.callback("v2"))
+
+	got := results.wait(t, 3)
+	if got["orphan"] == nil {
+		t.Fatal("expected the orphan tag to fail")
+	}
+	for _, tag := range []string{"v1", "v2"} {
+		if got[tag] != nil {
+			t.Fatalf("expected %s to commit despite the failing tag, got %v", tag, got[tag])
+		}
+		if _, err := s.GetTagByRepoAndName(ctx, repo.ID, tag); err != nil {
+			t.Fatalf("expected %s to be written: %v", tag, err)
+		}
+	}
+	if _, err := s.GetTagByRepoAndName(ctx, repo.ID+100, "orphan"); err == nil {
+		t.Fatal("expected the failing tag to be rolled back")
+	}
+}
+
+func mustPersist(t *testing.T, s *store.Store, repo *store.Repository, ctx context.Context, tag string) {
+	t.Helper()
+	p := newPersister(s, 0, 1, time.Hour)
+	p.start(ctx)
+	results := make(savedResults, 1)
+	p.save(ctx, persistJob(repo.ID, tag), "sha256:"+tag, persistGraph(tag), results.callback(tag))
+	p.close()
+	if err := results.wait(t, 1)[tag]; err != nil {
+		t.Fatalf("save %s: %v", tag, err)
+	}
+}
@@ -1,6 +1,7 @@
 package sync
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
@@ -21,6 +22,14 @@ type Config struct {
 	Debug                   bool
 	SyncInterval            time.Duration
 	CircuitBreakerThreshold int
+	// PersistBatchSize and PersistFlushInterval bound how many synced tags
+	// are written per transaction and how long one waits for the batch to fill.
+	PersistBatchSize     int
+	PersistFlushInterval time.Duration
+	// LeaderElection makes replicas sharing a database elect one of them to
+	// run background syncs.
+	LeaderElection bool
+	LeaseTTL       time.Duration
 }
 
 // Deps provides dependencies for creating a new sync Service.
@@ -40,6 +49,10 @@ type Service struct {
 	running  sync.Mutex
 	stopOnce sync.Once
 	wg       sync.WaitGroup
+
+	election      *election
+	subscribeOnce sync.Once
+	updates       <-chan progress.Update
 }
 
 // ManualSyncChannel is a buffered channel for triggering manual syncs.
@@ -137,14 +150,16 @@ func (l *defaultLogger) Error(msg string, args ...any) { clog.Error(msg, args...
 func (l *defaultLogger) Debug(msg string, args ...any) { clog.Debug(msg, args...) }
 
 type engine struct {
-	store     *store.Store
-	manager   *registry.Manager
-	logger    Logger
-	workers   int
-	maxPerReg int
-	cbThresh  int
-	progress  progress.ProgressReporter
-	startTime time.Time
+	store      *store.Store
+	manager    *registry.Manager
+	logger     Logger
+	workers    int
+	maxPerReg  int
+	cbThresh   int
+	batchSize  int
+	flushEvery time.Duration
+	progress   progress.ProgressReporter
+	startTime  time.Time
 }
 
 // New creates a new sync Service from the given dependencies.
@@ -154,20 +169,26 @@ func New(deps Deps) (*Service, error) {
 		maxPerReg = max(deps.Config.Workers, 1)
 	}
 	eng := &engine{
-		store:     deps.Store,
-		manager:   deps.RegistryManager,
-		logger:    NewDefaultLogger(),
-		workers:   deps.Config.Workers,
-		maxPerReg: maxPerReg,
-		cbThresh:  deps.Config.CircuitBreakerThreshold,
-		progress:  deps.Progress,
-	}
-	return &Service{
+		store:      deps.Store,
+		manager:    deps.RegistryManager,
+		logger:     NewDefaultLogger(),
+		workers:    deps.Config.Workers,
+		maxPerReg:  maxPerReg,
+		cbThresh:   deps.Config.CircuitBreakerThreshold,
+		batchSize:  cmp.Or(deps.Config.PersistBatchSize, defaultPersistBatchSize),
+		flushEvery: cmp.Or(deps.Config.PersistFlushInterval, defaultPersistFlushInterval),
+		progress:   deps.Progress,
+	}
+	svc := &Service{
 		engine:   eng,
 		interval: deps.Config.SyncInterval,
 		stopCh:   make(chan struct{}),
 		manualCh: make(ManualSyncChannel, 1),
-	}, nil
+	}
+	if deps.Config.LeaderElection {
+		svc.election = newElection(deps.Store, deps.Config.LeaseTTL)
+	}
+	return svc, nil
 }
 
 // ManualSyncChan returns the channel used for triggering manual syncs.
@@ -182,9 +203,22 @@ func (s *Service) Run(ctx context.Context) (*Result, error) {
 	return result, nil
 }
 
-// StartBackground starts the sync service in background mode.
+// StartBackground starts the sync service in background mode. With leader
+// election enabled only the elected replica syncs; the others forward manual
+// triggers and mirror its progress.
 func (s *Service) StartBackground(ctx context.Context) {
+	if s.election == nil {
+		s.lead(ctx)
+		return
+	}
+	s.election.run(ctx, s.stopCh, s.lead, s.follow)
+}
+
+func (s *Service) lead(ctx context.Context) {
 	clog.Info("Starting background sync", "interval", s.interval)
+	if s.election != nil {
+		s.wg.Go(func() { s.leaderDuties(ctx) })
+	}
 	s.runAsync(ctx, "initial")
 
 	var tickerCh <-chan time.Time
@@ -243,12 +277,32 @@ func TriggerManualSync(ch ManualSyncChannel) bool {
 	}
 }
 
-// SyncAll runs the full synchronization pipeline.
+// SyncAll runs the full synchronization pipeline. Each call is recorded as a
+// sync run so tag history can tell which run saw a change.
 func (e *engine) SyncAll(ctx context.Context) (*Result, error) {
 	e.startTime = time.Now()
 	e.progress.Reset()
 	defer e.progress.Complete()
 
+	runID, err := e.store.StartSyncRun(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := e.syncAll(ctx, runID)
+	e.refreshRegistryStats(context.WithoutCancel(ctx), runID)
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	} else {
+		e.snapshotStorage(context.WithoutCancel(ctx), runID)
+	}
+	if finishErr := e.store.FinishSyncRun(context.WithoutCancel(ctx), runID, errMsg); finishErr != nil {
+		e.logger.Error("Failed to record sync run", "run", runID, "error", finishErr)
+	}
+	return result, err
+}
+
+func (e *engine) syncAll(ctx context.Context, runID int64) (*Result, error) {
 	if err := e.syncRegistries(ctx); err != nil {
 		return nil, fmt.Errorf("sync registries: %w", err)
 	}
@@ -261,7 +315,8 @@ func (e *engine) SyncAll(ctx context.Context) (*Result, error) {
 		return nil, ErrNoRegistries
 	}
 
-	report, err := e.discoverAll(ctx, registries)
+	lim := newLimiter(e.maxPerReg, e.cbThresh)
+	report, err := e.discoverAll(ctx, lim, registries)
 	if err != nil {
 		return nil, err
 	}
@@ -269,10 +324,10 @@ func (e *engine) SyncAll(ctx context.Context) (*Result, error) {
 	if err := e.upsertDiscoveredRepos(ctx, report, registries); err != nil {
 		return nil, err
 	}
-	if err := e.pruneStaleRepos(ctx, report); err != nil {
+	if err := e.pruneStaleRepos(ctx, runID, report); err != nil {
 		return nil, err
 	}
-	if err := e.pruneStaleTags(ctx, report); err != nil {
+	if err := e.pruneStaleTags(ctx, runID, report); err != nil {
 		return nil, err
 	}
 
@@ -287,7 +342,7 @@ func (e *engine) SyncAll(ctx context.Context) (*Result, error) {
 		return e.buildResult(nil), nil
 	}
 
-	stats := e.processTags(ctx, jobs)
+	stats := e.processTags(ctx, runID, lim, jobs)
 	e.progress.UpdateStep("Cleanup")
 	if err := e.store.CleanupOrphans(ctx); err != nil {
 		e.logger.Error("Cleanup orphans failed", "error", err)
@@ -296,6 +351,39 @@ func (e *engine) SyncAll(ctx context.Context) (*Result, error) {
 	return e.buildResult(stats), nil
 }
 
+// refreshRegistryStats recounts the repositories of the registries whose tags
+// the run changed, once they are saved and pruned. Batches keep tag counts and
+// sizes current as they go, but unique sizes depend on the whole registry.
+// Failures are logged.
+func (e *engine) refreshRegistryStats(ctx context.Context, runID int64) {
+	registries, err := e.store.GetSyncRunRegistries(ctx, runID)
+	if err != nil {
+		e.logger.Error("Failed to list the registries a sync changed", "run", runID, "error", err)
+		return
+	}
+	for _, registryID := range registries {
+		if err := e.store.RefreshRegistryStats(ctx, registryID); err != nil {
+			e.logger.Error("Failed to refresh registry stats", "registry", registryID, "error", err)
+		}
+	}
+}
+
+// snapshotStorage records the storage totals of a finished run and thins out
+// old snapshots. Failures are logged: they never fail the sync.
+func (e *engine) snapshotStorage(ctx context.Context, runID int64) {
+	now := time.Now()
+	err := e.store.WithinTx(ctx, func(tx *store.Store) error {
+		return tx.RecordStorageSnapshots(ctx, runID, now)
+	})
+	if err != nil {
+		e.logger.Error("Failed to record storage snapshots", "run", runID, "error", err)
+		return
+	}
+	if _, err := e.store.PruneStorageSnapshots(ctx, now); err != nil {
+		e.logger.Error("Failed to prune storage snapshots", "error", err)
+	}
+}
+
 func (e *engine) buildResult(stats *SyncStats) *Result {
 	if stats == nil {
 		return &Result{Duration: time.Since(e.startTime)}
@@ -311,16 +399,16 @@ func (e *engine) buildResult(stats *SyncStats) *Result {
 	}
 }
 
-func (e *engine) processTags(ctx context.Context, jobs []planning.Job) *SyncStats {
+func (e *engine) processTags(ctx context.Context, runID int64, lim *limiter, jobs []planning.Job) *SyncStats {
 	e.progress.UpdateStep("Syncing")
 	e.progress.UpdateMessage("Processing tags")
 	e.progress.SetTotal(len(jobs))
 
 	scheduler := planning.NewScheduler(jobs)
-	lim := newLimiter(e.maxPerReg, e.cbThresh)
 	stats := &SyncStats{TotalTags: len(jobs)}
 	f := newFetcher(lim)
-	pers := newPersister(e.store)
+	pers := newPersister(e.store, runID, e.batchSize, e.flushEvery)
+	pers.start(ctx)
 
 	var wg sync.WaitGroup
 	for i := range e.workers {
@@ -328,6 +416,8 @@ func (e *engine) processTags(ctx context.Context, jobs []planning.Job) *SyncStat
 		go e.runWorker(ctx, &wg, i, stats, f, pers, scheduler)
 	}
 	wg.Wait()
+	// Tags still queued for writing are counted once their batch commits.
+	pers.close()
 	return stats
 }
 
@@ -346,7 +436,7 @@ func (e *engine) runWorker(
 		if !ok {
 			return
 		}
-		if err := processTag(ctx, job, stats, f, p, e.store, e.manager, e.progress, e.logger); err != nil {
+		if err := processTag(ctx, job, stats, f, p, e.manager, e.progress, e.logger); err != nil {
 			e.logger.Error("Tag error", "worker", workerID, "tag", job.TagName, "error", err)
 		}
 	}
@@ -409,20 +499,20 @@ func (e *engine) loadRegistries(ctx context.Context) ([]store.Registry, error) {
 	return e.store.GetAllRegistries(ctx)
 }
 
-func (e *engine) discoverAll(ctx context.Context, registries []store.Registry) (*discoveryReport, error) {
-	return discoverAll(ctx, e.store, e.manager, registries, e.progress, e.logger)
+func (e *engine) discoverAll(ctx context.Context, lim *limiter, registries []store.Registry) (*discoveryReport, error) {
+	return discoverAll(ctx, e.store, e.manager, lim, registries, e.maxPerReg, e.progress, e.logger)
 }
 
 func (e *engine) upsertDiscoveredRepos(ctx context.Context, report *discoveryReport, registries []store.Registry) error {
 	return upsertDiscoveredRepos(ctx, e.store, e.logger, report.Repos, registries)
 }
 
-func (e *engine) pruneStaleRepos(ctx context.Context, report *discoveryReport) error {
-	return pruneStaleRepos(ctx, e.store, e.logger, report.Registries, report.Repos)
+func (e *engine) pruneStaleRepos(ctx context.Context, runID int64, report *discoveryReport) error {
+	return pruneStaleRepos(ctx, e.store, e.logger, runID, report.Registries, report.Repos)
 }
 
-func (e *engine) pruneStaleTags(ctx context.Context, report *discoveryReport) error {
-	return pruneStaleTags(ctx, e.store, e.logger, report.Repos)
+func (e *engine) pruneStaleTags(ctx context.Context, runID int64, report *discoveryReport) error {
+	return pruneStaleTags(ctx, e.store, e.logger, runID, report.Repos)
 }
 
 func (e *engine) prepareJobs(ctx context.Context, report *discoveryReport) ([]planning.Job, error) {
@@ -7,19 +7,19 @@ import (
 
 	"github.com/eznix86/docker-registry-ui/internal/progress"
 	"github.com/eznix86/docker-registry-ui/internal/registry"
-	"github.com/eznix86/docker-registry-ui/internal/store"
 	"github.com/eznix86/docker-registry-ui/internal/sync/planning"
 )
 
 const unchangedTagRecheckInterval = 30 * time.Second
 
+// processTag fetches one tag and hands the outcome to the persister. Its
+// progress task finishes once the persister has written the tag.
 func processTag(
 	ctx context.Context,
 	job planning.Job,
 	stats *SyncStats,
 	f *fetcher,
 	p *persister,
-	s *store.Store,
 	rm *registry.Manager,
 	prog progress.ProgressReporter,
 	logger Logger,
@@ -33,61 +33,68 @@ func processTag(
 	repoPath := job.RepoPath()
 	label := repoPath + ":" + job.TagName
 	task := prog.Track(label, "Processing")
-	defer task.Done()
 
 	digest, err := f.fetchDigest(ctx, client, repoPath, job.TagName, job.RegistryName)
 	if err != nil {
-		handleTagSyncError(ctx, s, stats, logger, job, label, err)
+		handleTagSyncError(ctx, p, stats, logger, task, job, label, err)
 		return nil
 	}
 
 	if job.ExistingDigest != "" && job.ExistingDigest == digest {
-		if dbErr := s.UpdateTagSyncMetadata(ctx, job.RepositoryID, job.TagName, job.PriorityScore, unchangedTagRecheckInterval); dbErr != nil {
-			logger.Error("Failed to update tag metadata", "tag", label, "dbError", dbErr)
-		}
-		stats.Record(TagStateUnchanged)
+		p.touch(ctx, job, func(dbErr error) {
+			defer task.Done()
+			if dbErr != nil {
+				logger.Error("Failed to update tag metadata", "tag", label, "dbError", dbErr)
+			}
+			stats.Record(TagStateUnchanged)
+		})
 		return nil
 	}
 
 	manifestResp, err := f.fetchManifest(ctx, client, repoPath, job.TagName, job.RegistryName)
 	if err != nil {
-		handleTagSyncError(ctx, s, stats, logger, job, label, err)
+		handleTagSyncError(ctx, p, stats, logger, task, job, label, err)
 		return nil
 	}
 
 	graph, err := buildManifestGraph(ctx, manifestResp, client, f, repoPath, job.RegistryName, label)
 	if err != nil {
 		logger.Error("Failed to build manifest graph", "tag", label, "error", err)
-		handleTagSyncError(ctx, s, stats, logger, job, label, err)
+		handleTagSyncError(ctx, p, stats, logger, task, job, label, err)
 		return nil
 	}
 
-	if err := p.save(ctx, job, digest, graph); err != nil {
-		logger.Error("Persist failed", "tag", label, "error", err)
-		stats.Record(TagStateError)
-		return nil
-	}
-
-	if job.ExistingDigest == "" {
-		stats.Record(TagStateNew)
-	} else {
-		stats.Record(TagStateChanged)
-	}
+	p.save(ctx, job, digest, graph, func(err error) {
+		defer task.Done()
+		if err != nil {
+			logger.Error("Persist failed", "tag", label, "error", err)
+			stats.Record(TagStateError)
+			return
+		}
+		if job.ExistingDigest == "" {
+			stats.Record(TagStateNew)
+		} else {
+			stats.Record(TagStateChanged)
+		}
+	})
 	return nil
 }
 
 func handleTagSyncError(
 	ctx context.Context,
-	s *store.Store,
+	p *persister,
 	stats *SyncStats,
 	logger Logger,
+	task progress.TaskReporter,
 	job planning.Job,
 	label string,
 	err error,
 ) {
-	if dbErr := s.MarkTagSyncError(ctx, job.RepositoryID, job.TagName, err.Error()); dbErr != nil {
-		logger.Error("Failed to record tag error", "tag", label, "dbError", dbErr)
-	}
-
-	stats.Record(TagStateError)
+	p.fail(ctx, job, err, func(dbErr error) {
+		defer task.Done()
+		if dbErr != nil {
+			logger.Error("Failed to record tag error", "tag", label, "dbError", dbErr)
+		}
+		stats.Record(TagStateError)
+	})
 }
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 
 	clog "github.com/charmbracelet/log"
@@ -35,11 +37,58 @@ type deleteTagsResponse struct {
 	Errors       map[string]string   `json:"errors,omitempty"`
 }
 
+type deleteTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// errorResponse is the body of failed requests outside /api/v1, which the
+// handlers write as a map under jsonKeyError.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type healthResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+}
+
+type syncTriggerResponse struct {
+	// Status is triggered, or busy when a sync is already running or pending.
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type tagHistoryResponse struct {
+	Tag    string               `json:"tag"`
+	Events []store.TagEventView `json:"events"`
+}
+
+type searchResponse struct {
+	Query string            `json:"query"`
+	Hits  []store.SearchHit `json:"hits"`
+}
+
+type digestUsageResponse struct {
+	Digest     string                   `json:"digest"`
+	Registries []store.DigestUsageGroup `json:"registries"`
+}
+
+type helmValuesResponse struct {
+	Content string `json:"content"`
+}
+
+type helmFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+type helmFilesResponse struct {
+	Files     []helmFile `json:"files"`
+	ChartYAML string     `json:"chartYaml"`
+}
+
 func (h *handler) health(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{
-		jsonKeyStatus: "ok",
-		"version":     version.New().Short(),
-	})
+	writeJSON(w, http.StatusOK, healthResponse{Status: "ok", Version: version.New().Short()})
 }
 
 func (h *handler) manualSync(w http.ResponseWriter, r *http.Request) {
@@ -48,14 +97,23 @@ func (h *handler) manualSync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.access(r).canEverywhere(PermissionSync) {
+		h.audit.Record(r.Context(), h.audit.event(r, store.AuditActionSyncTrigger, store.AuditOutcomeDenied))
+		writeJSON(w, http.StatusForbidden, map[string]string{jsonKeyError: "You cannot trigger syncs"})
+		return
+	}
+
 	triggered := sync.TriggerManualSync(h.manualCh)
 
 	status := http.StatusAccepted
-	msg := map[string]string{jsonKeyStatus: "triggered", "message": "Manual sync started"}
+	msg := syncTriggerResponse{Status: "triggered", Message: "Manual sync started"}
+	event := h.audit.event(r, store.AuditActionSyncTrigger, store.AuditOutcomeSuccess)
 	if !triggered {
 		status = http.StatusConflict
-		msg = map[string]string{jsonKeyStatus: "busy", "message": "Sync already running or pending"}
+		msg = syncTriggerResponse{Status: "busy", Message: "Sync already running or pending"}
+		event.Outcome, event.Detail = store.AuditOutcomeFailure, msg.Message
 	}
+	h.audit.Record(r.Context(), event)
 
 	writeJSON(w, status, msg)
 }
@@ -68,31 +126,47 @@ func (h *handler) deleteTags(w http.ResponseWriter, r *http.Request) {
 	registryHost := strings.ReplaceAll(registryName, "~", ":")
 	ctx := r.Context()
 
+	acc := h.access(r)
 	repo, err := h.store.GetRepositoryByPath(ctx, registryHost, namespace, repoName)
 	if err != nil {
 		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Repository not found"})
 		return
 	}
 
-	var req struct {
-		Tags []string `json:"tags"`
+	repoFull := repo.Name
+	if repo.Namespace != "" {
+		repoFull = repo.Namespace + "/" + repo.Name
+	}
+	event := h.audit.event(r, store.AuditActionTagsDelete, store.AuditOutcomeDenied)
+	event.Registry, event.Repository = repo.RegistryHost, repoFull
+
+	if !acc.can(PermissionView, repo.RegistryHost, repo.Namespace) {
+		h.audit.Record(ctx, event)
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Repository not found"})
+		return
 	}
+	if !acc.can(PermissionDelete, repo.RegistryHost, repo.Namespace) {
+		h.audit.Record(ctx, event)
+		writeJSON(w, http.StatusForbidden, map[string]string{jsonKeyError: "You cannot delete tags in this repository"})
+		return
+	}
+
+	var req deleteTagsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Tags) == 0 {
 		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "No tags specified"})
 		return
 	}
+	event.Tags = slices.Clone(req.Tags)
+	event.Outcome = store.AuditOutcomeFailure
 
 	client, err := h.regManager.GetClient(repo.Registry)
 	if err != nil {
+		event.Detail = "Registry client not found"
+		h.audit.Record(ctx, event)
 		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Registry client not found"})
 		return
 	}
 
-	repoFull := repo.Name
-	if repo.Namespace != "" {
-		repoFull = repo.Namespace + "/" + repo.Name
-	}
-
 	resp := deleteTagsResponse{
 		Errors:       make(map[string]string),
 		AliasDeleted: make(map[string][]string),
@@ -101,12 +175,17 @@ func (h *handler) deleteTags(w http.ResponseWriter, r *http.Request) {
 	requestedTags, digests := h.resolveRequestedTags(ctx, repo.ID, req.Tags, &resp)
 
 	if len(requestedTags) == 0 {
+		event.Detail = "No requested tag exists"
+		h.audit.Record(ctx, event)
 		writeJSON(w, http.StatusMultiStatus, resp)
 		return
 	}
+	event.Digests = digests
 
 	allTags, err := h.store.GetTagsByRepoAndDigests(ctx, repo.ID, digests)
 	if err != nil {
+		event.Detail = "Failed to load tag aliases"
+		h.audit.Record(ctx, event)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to load tag aliases"})
 		return
 	}
@@ -137,7 +216,19 @@ func (h *handler) deleteTags(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	resp.Deleted = h.deleteStoredTags(ctx, allTags, regErrors)
+	resp.Deleted = h.deleteStoredTags(ctx, repo.RegistryHost, allTags, regErrors)
+
+	// Aliases of the requested tags share their digests and went with them.
+	for _, aliases := range resp.AliasDeleted {
+		event.Tags = append(event.Tags, aliases...)
+	}
+	slices.Sort(event.Tags)
+	event.Tags = slices.Compact(event.Tags)
+	if resp.Failed == 0 {
+		event.Outcome = store.AuditOutcomeSuccess
+	}
+	event.Detail = fmt.Sprintf("%d deleted, %d failed", resp.Deleted, resp.Failed)
+	h.audit.Record(ctx, event)
 
 	status := http.StatusOK
 	if resp.Failed > 0 {
@@ -224,6 +315,7 @@ func buildDigestNames(allTags []store.Tag) map[string][]string {
 
 func (h *handler) deleteStoredTags(
 	ctx context.Context,
+	registryHost string,
 	allTags []store.Tag,
 	regErrors map[string]string,
 ) int {
@@ -233,7 +325,13 @@ func (h *handler) deleteStoredTags(
 			continue
 		}
 
-		if err := h.store.DeleteTag(ctx, &store.Tag{ID: tag.ID}); err != nil {
+		err := h.store.WithinTx(ctx, func(tx *store.Store) error {
+			if err := tx.RecordTagEvent(ctx, tag.RepositoryID, tag.Name, store.TagEventDeleted, tag.Digest, "", 0); err != nil {
+				return err
+			}
+			return tx.DeleteTag(ctx, &store.Tag{ID: tag.ID})
+		})
+		if err != nil {
 			clog.Warn("Failed to delete tag from DB", "tagID", tag.ID, "error", err)
 			continue
 		}
@@ -241,9 +339,171 @@ func (h *handler) deleteStoredTags(
 		deleted++
 	}
 
+	// Deleting tags can leave blobs to a single repository, changing the
+	// unique sizes of others in the registry.
+	if deleted > 0 {
+		reg, err := h.store.GetRegistryByHost(ctx, registryHost)
+		if err == nil {
+			err = h.store.RefreshRegistryStats(ctx, reg.ID)
+		}
+		if err != nil {
+			clog.Warn("Failed to refresh registry stats", "registry", registryHost, "error", err)
+		}
+	}
+
 	return deleted
 }
 
+// tagHistory returns the timeline of a tag. Deleted tags keep their history,
+// so the tag itself does not have to exist anymore.
+func (h *handler) tagHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	registryHost := strings.ReplaceAll(chi.URLParam(r, "registry"), "~", ":")
+	repoName := decodeRepoName(chi.URLParam(r, "repository"))
+	namespace := chi.URLParam(r, "namespace")
+	tagName := chi.URLParam(r, "tag")
+
+	repo, err := h.store.GetRepositoryByPath(ctx, registryHost, namespace, repoName)
+	if err != nil || !h.access(r).can(PermissionView, repo.RegistryHost, repo.Namespace) {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Repository not found"})
+		return
+	}
+
+	events, err := h.store.GetTagTimeline(ctx, repo.ID, tagName)
+	if err != nil {
+		clog.Error("Failed to load tag history", "repository", repo.ID, "tag", tagName, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to load tag history"})
+		return
+	}
+	if events == nil {
+		events = []store.TagEventView{}
+	}
+	writeJSON(w, http.StatusOK, tagHistoryResponse{Tag: tagName, Events: events})
+}
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// search returns ranked repositories and tags matching q, with snippets.
+func (h *handler) search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit := defaultSearchLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Invalid limit"})
+			return
+		}
+		limit = min(n, maxSearchLimit)
+	}
+
+	// Hits the user cannot view are dropped afterwards, so fetch as many as
+	// allowed to still fill the limit.
+	acc := h.access(r)
+	fetch := limit
+	if acc.restricted {
+		fetch = maxSearchLimit
+	}
+	hits, err := h.store.Search(r.Context(), query, fetch)
+	if err != nil {
+		clog.Error("Failed to search", "query", query, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Search failed"})
+		return
+	}
+	hits = slices.DeleteFunc(hits, func(hit store.SearchHit) bool {
+		return !acc.can(PermissionView, hit.RegistryHost, hit.Namespace)
+	})
+	hits = hits[:min(len(hits), limit)]
+	if hits == nil {
+		hits = []store.SearchHit{}
+	}
+	writeJSON(w, http.StatusOK, searchResponse{Query: query, Hits: hits})
+}
+
+// digestUsage lists every registry, repository and tag referencing a digest
+// or digest prefix, grouped by registry.
+func (h *handler) digestUsage(w http.ResponseWriter, r *http.Request) {
+	digest, ok := digestQuery(chi.URLParam(r, "digest"))
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Invalid digest"})
+		return
+	}
+
+	groups, err := h.store.FindDigestUsage(r.Context(), digest)
+	if err != nil {
+		clog.Error("Failed to look up digest", "digest", digest, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to look up digest"})
+		return
+	}
+	groups = h.access(r).visibleDigestUsage(groups)
+	if groups == nil {
+		groups = []store.DigestUsageGroup{}
+	}
+	writeJSON(w, http.StatusOK, digestUsageResponse{Digest: digest, Registries: groups})
+}
+
+// reclaimEstimate reports what deleting the tags listed in the tag query
+// parameter would let a registry garbage collection free.
+func (h *handler) reclaimEstimate(w http.ResponseWriter, r *http.Request) {
+	registryHost := strings.ReplaceAll(chi.URLParam(r, "registry"), "~", ":")
+	namespace := chi.URLParam(r, "namespace")
+	repoName := decodeRepoName(chi.URLParam(r, "repository"))
+	ctx := r.Context()
+
+	repo, err := h.store.GetRepositoryByPath(ctx, registryHost, namespace, repoName)
+	if err != nil || !h.access(r).can(PermissionView, repo.RegistryHost, repo.Namespace) {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Repository not found"})
+		return
+	}
+
+	tags := r.URL.Query()["tag"]
+	if len(tags) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "No tags specified"})
+		return
+	}
+
+	est, err := h.store.EstimateTagDeletion(ctx, repo.ID, tags)
+	if err != nil {
+		clog.Error("Failed to estimate reclaimable storage", "repository", repoName, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to estimate reclaimable storage"})
+		return
+	}
+	writeJSON(w, http.StatusOK, est)
+}
+
+// registryReclaimable reports what a garbage collection of the registry
+// would free now.
+func (h *handler) registryReclaimable(w http.ResponseWriter, r *http.Request) {
+	host := strings.ReplaceAll(chi.URLParam(r, "registry"), "~", ":")
+	ctx := r.Context()
+
+	if _, err := h.store.GetRegistryByHost(ctx, host); err != nil || !h.access(r).canInRegistry(PermissionView, host) {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Registry not found"})
+		return
+	}
+	// The estimate covers the whole registry, so it needs every namespace.
+	_, wholeRegistry, err := h.registryScope(r, host)
+	if err != nil {
+		clog.Error("Failed to resolve registry access", "registry", host, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to estimate reclaimable storage"})
+		return
+	}
+	if !wholeRegistry {
+		writeJSON(w, http.StatusForbidden, map[string]string{jsonKeyError: "You cannot view every namespace of the registry"})
+		return
+	}
+
+	est, err := h.store.GetReclaimableStorage(ctx, host)
+	if err != nil {
+		clog.Error("Failed to estimate reclaimable storage", "registry", host, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to estimate reclaimable storage"})
+		return
+	}
+	writeJSON(w, http.StatusOK, est)
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -258,7 +518,7 @@ func (h *handler) helmValues(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, status, map[string]string{"error": errMsg})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"content": chart.Values})
+	writeJSON(w, http.StatusOK, helmValuesResponse{Content: chart.Values})
 }
 
 func (h *handler) helmFiles(w http.ResponseWriter, r *http.Request) {
@@ -267,14 +527,11 @@ func (h *handler) helmFiles(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, status, map[string]string{"error": errMsg})
 		return
 	}
-	files := make([]map[string]string, 0, len(chart.Files))
+	files := make([]helmFile, 0, len(chart.Files))
 	for _, f := range chart.Files {
-		files = append(files, map[string]string{"path": f.Path, "content": f.Content})
+		files = append(files, helmFile{Path: f.Path, Content: f.Content})
 	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"files":     files,
-		"chartYaml": chart.ChartYAML,
-	})
+	writeJSON(w, http.StatusOK, helmFilesResponse{Files: files, ChartYAML: chart.ChartYAML})
 }
 
 func (h *handler) resolveHelmChart(r *http.Request) (*helm.Chart, int, string) {
@@ -285,7 +542,7 @@ func (h *handler) resolveHelmChart(r *http.Request) (*helm.Chart, int, string) {
 	namespace := chi.URLParam(r, "namespace")
 
 	repo, err := h.store.GetRepositoryByPath(ctx, registryName, namespace, repoName)
-	if err != nil {
+	if err != nil || !h.access(r).can(PermissionView, repo.RegistryHost, repo.Namespace) {
 		return nil, http.StatusNotFound, "Repository not found"
 	}
 
@@ -0,0 +1,372 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+// The /api/v1 namespace is the stable JSON API for scripts. Successful
+// responses wrap their payload in data, with nextCursor on lists that have
+// more pages; failures return an apiError envelope.
+const apiV1Prefix = "/api/v1"
+
+const (
+	defaultAPIPageSize = 50
+	maxAPIPageSize     = 200
+)
+
+// Error codes of the apiError envelope.
+const (
+	apiCodeBadRequest   = "bad_request"
+	apiCodeInvalidQuery = "invalid_query"
+	apiCodeNotFound     = "not_found"
+	apiCodeUnauthorized = "unauthorized"
+	apiCodeForbidden    = "forbidden"
+	apiCodeInternal     = "internal"
+)
+
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"requestId,omitempty"`
+	Query     *queryError `json:"query,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiResponse[T any] struct {
+	Data T `json:"data"`
+}
+
+type apiList[T any] struct {
+	Data       []T    `json:"data"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+type apiRegistry struct {
+	Name       string     `json:"name"`
+	Host       string     `json:"host"`
+	PublicHost string     `json:"publicHost,omitempty"`
+	Status     int        `json:"status"`
+	LastSyncAt *time.Time `json:"lastSyncAt"`
+}
+
+type apiRegistryDetail struct {
+	apiRegistry
+	Stats                *store.RegistryStatsView         `json:"stats"`
+	ArchitectureCoverage []store.ArchitectureCoverageView `json:"architectureCoverage"`
+	StorageByNamespace   []store.NamespaceStorageView     `json:"storageByNamespace"`
+}
+
+// apiCursor is the position encoded in nextCursor: the store's keyset cursor
+// of the last item returned, with the page size and tag sort of the first
+// request so following pages keep them.
+type apiCursor struct {
+	After string `json:"a"`
+	Limit int    `json:"l"`
+	Sort  string `json:"s,omitempty"`
+}
+
+func (c apiCursor) encode() string {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// next is the cursor continuing after the store cursor after, or empty when
+// there is no next page.
+func (c apiCursor) next(after string) string {
+	if after == "" {
+		return ""
+	}
+	c.After = after
+	return c.encode()
+}
+
+// parseAPICursor reads the cursor parameter, or starts at the first page with
+// the limit parameter as the page size.
+func parseAPICursor(r *http.Request) (apiCursor, error) {
+	q := r.URL.Query()
+	if raw := q.Get("cursor"); raw != "" {
+		var c apiCursor
+		decoded, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil || json.Unmarshal(decoded, &c) != nil || c.After == "" || c.Limit < 1 || c.Limit > maxAPIPageSize {
+			return apiCursor{}, errInvalidCursor
+		}
+		return c, nil
+	}
+
+	c := apiCursor{Limit: defaultAPIPageSize}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return apiCursor{}, errors.New("invalid limit")
+		}
+		c.Limit = min(n, maxAPIPageSize)
+	}
+	return c, nil
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeJSON(w, status, apiErrorResponse{Error: apiError{
+		Code:      code,
+		Message:   message,
+		RequestID: chimw.GetReqID(r.Context()),
+	}})
+}
+
+func isAPIv1Request(r *http.Request) bool {
+	return r.URL.Path == apiV1Prefix || strings.HasPrefix(r.URL.Path, apiV1Prefix+"/")
+}
+
+func (h *handler) apiV1Routes(api chi.Router) {
+	api.Get("/registries", h.apiRegistries)
+	api.Get("/registries/{registry}", h.apiRegistry)
+	api.Get("/repositories", h.apiRepositories)
+	api.Get("/repositories/{registry}/{repository}", h.apiRepository)
+	api.Get("/repositories/{registry}/{namespace}/{repository}", h.apiRepository)
+	api.Get("/repositories/{registry}/{repository}/tags", h.apiTags)
+	api.Get("/repositories/{registry}/{namespace}/{repository}/tags", h.apiTags)
+	api.Get("/repositories/{registry}/{repository}/tags/{tag}", h.apiTag)
+	api.Get("/repositories/{registry}/{namespace}/{repository}/tags/{tag}", h.apiTag)
+
+	api.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, r, http.StatusNotFound, apiCodeNotFound, "No such endpoint")
+	})
+	api.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, r, http.StatusMethodNotAllowed, apiCodeBadRequest, "Method not allowed")
+	})
+}
+
+func (h *handler) toAPIRegistry(reg store.Registry) apiRegistry {
+	out := apiRegistry{Name: reg.Name, Host: reg.Host, Status: reg.Status, LastSyncAt: reg.LastSyncAt}
+	if client, err := h.regManager.GetClient(reg.Name); err == nil {
+		out.PublicHost = client.PublicHost()
+	}
+	return out
+}
+
+func (h *handler) apiRegistries(w http.ResponseWriter, r *http.Request) {
+	registries, err := h.store.GetAllRegistries(r.Context())
+	if err != nil {
+		h.apiInternalError(w, r, "Failed to list registries", err)
+		return
+	}
+	registries = h.access(r).visibleRegistries(registries)
+	out := make([]apiRegistry, len(registries))
+	for i, reg := range registries {
+		out[i] = h.toAPIRegistry(reg)
+	}
+	writeJSON(w, http.StatusOK, apiResponse[[]apiRegistry]{Data: out})
+}
+
+func (h *handler) apiRegistry(w http.ResponseWriter, r *http.Request) {
+	host := strings.ReplaceAll(chi.URLParam(r, "registry"), "~", ":")
+	ctx := r.Context()
+	acc := h.access(r)
+
+	reg, err := h.store.GetRegistryByHost(ctx, host)
+	if err != nil || !acc.canInRegistry(PermissionView, host) {
+		writeAPIError(w, r, http.StatusNotFound, apiCodeNotFound, "Registry not found")
+		return
+	}
+	detail := apiRegistryDetail{apiRegistry: h.toAPIRegistry(*reg)}
+	scope, _, err := h.registryScope(r, host)
+	if err != nil {
+		h.apiInternalError(w, r, "Failed to load registry stats", err)
+		return
+	}
+	if detail.Stats, err = h.store.GetRegistryStats(ctx, host, scope); err != nil {
+		h.apiInternalError(w, r, "Failed to load registry stats", err)
+		return
+	}
+	if detail.ArchitectureCoverage, err = h.store.GetRegistryArchitectureCoverage(ctx, host, scope); err != nil {
+		h.apiInternalError(w, r, "Failed to load registry stats", err)
+		return
+	}
+	if detail.StorageByNamespace, err = h.store.GetRegistryStorageByNamespace(ctx, host); err != nil {
+		h.apiInternalError(w, r, "Failed to load registry stats", err)
+		return
+	}
+	detail.StorageByNamespace = slices.DeleteFunc(detail.StorageByNamespace, func(ns store.NamespaceStorageView) bool {
+		return !acc.can(PermissionView, host, ns.Namespace)
+	})
+	writeJSON(w, http.StatusOK, apiResponse[apiRegistryDetail]{Data: detail})
+}
+
+// apiRepositories lists repositories matching the explore filters: registry,
+// arch and os parameters, untagged=true, and the query language in q.
+// Without a search they are ordered by path; with one, by relevance.
+func (h *handler) apiRepositories(w http.ResponseWriter, r *http.Request) {
+	cursor, err := parseAPICursor(r)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, apiCodeBadRequest, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	filters := store.RepositoryFilters{
+		Architectures:    q["arch"],
+		OperatingSystems: q["os"],
+		ShowUntagged:     q.Get("untagged") == "true",
+	}
+	for _, reg := range q["registry"] {
+		filters.Registries = append(filters.Registries, strings.ReplaceAll(reg, "~", ":"))
+	}
+	if err := parseExploreQuery(q.Get("q"), time.Now(), &filters); err != nil {
+		var qe *queryError
+		if !errors.As(err, &qe) {
+			qe = &queryError{Message: err.Error()}
+		}
+		writeJSON(w, http.StatusBadRequest, apiErrorResponse{Error: apiError{
+			Code:      apiCodeInvalidQuery,
+			Message:   qe.Error(),
+			RequestID: chimw.GetReqID(r.Context()),
+			Query:     qe,
+		}})
+		return
+	}
+
+	if filters.Scope, err = h.viewScope(r); err != nil {
+		h.apiInternalError(w, r, "Failed to list repositories", err)
+		return
+	}
+	page, err := h.store.GetRepositoriesPage(r.Context(), filters, cursor.After, cursor.Limit)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		writeAPIError(w, r, http.StatusBadRequest, apiCodeBadRequest, errInvalidCursor.Error())
+		return
+	}
+	if err != nil {
+		h.apiInternalError(w, r, "Failed to list repositories", err)
+		return
+	}
+
+	resp := apiList[store.RepositoryView]{Data: page.Repositories, Total: page.TotalCount, NextCursor: cursor.next(page.Next)}
+	if resp.Data == nil {
+		resp.Data = []store.RepositoryView{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apiRepositoryFromPath resolves the repository named by the route, writing a
+// not found error when there is none.
+func (h *handler) apiRepositoryFromPath(w http.ResponseWriter, r *http.Request) (*store.RepositoryView, bool) {
+	registryHost := strings.ReplaceAll(chi.URLParam(r, "registry"), "~", ":")
+	namespace := chi.URLParam(r, "namespace")
+	repoName := decodeRepoName(chi.URLParam(r, "repository"))
+
+	repo, err := h.store.GetRepositoryByPath(r.Context(), registryHost, namespace, repoName)
+	if err != nil || !h.access(r).can(PermissionView, repo.RegistryHost, repo.Namespace) {
+		writeAPIError(w, r, http.StatusNotFound, apiCodeNotFound, "Repository not found")
+		return nil, false
+	}
+	if client, err := h.regManager.GetClient(repo.Registry); err == nil {
+		repo.RegistryPublicHost = client.PublicHost()
+	}
+	return repo, true
+}
+
+func (h *handler) apiRepository(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.apiRepositoryFromPath(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, apiResponse[store.RepositoryView]{Data: *repo})
+}
+
+// apiTagSorts are the sort parameter values of the tags endpoint, as the tag
+// page offers them.
+var apiTagSorts = map[string]bool{
+	"newest": true, "oldest": true, "size-asc": true, "size-desc": true, "name-asc": true, "name-desc": true,
+}
+
+// apiTags pages through a repository's tags. sort picks the order (newest by
+// default), filter matches part of the name, and asOf rebuilds the tags as
+// they stood at an RFC 3339 time.
+func (h *handler) apiTags(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.apiRepositoryFromPath(w, r)
+	if !ok {
+		return
+	}
+	cursor, err := parseAPICursor(r)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, apiCodeBadRequest, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	filter := store.TagFilter{SortBy: q.Get("sort"), Name: q.Get("filter")}
+	switch {
+	case filter.SortBy == "" && cursor.Sort != "":
+		filter.SortBy = cursor.Sort
+	case filter.SortBy == "":
+		filter.SortBy = "newest"
+	case !apiTagSorts[filter.SortBy]:
+		writeAPIError(w, r, http.StatusBadRequest, apiCodeBadRequest,
+			"Invalid sort, expected newest, oldest, size-asc, size-desc, name-asc or name-desc")
+		return
+	}
+	// A cursor only continues the order it was issued for.
+	if cursor.After != "" && cursor.Sort != filter.SortBy {
+		writeAPIError(w, r, http.StatusBadRequest, apiCodeBadRequest, errInvalidCursor.Error())
+		return
+	}
+	cursor.Sort = filter.SortBy
+	if raw := q.Get("asOf"); raw != "" {
+		if filter.AsOf = parseAsOf(raw); filter.AsOf == nil {
+			writeAPIError(w, r, http.StatusBadRequest, apiCodeBadRequest, "Invalid asOf, expected an RFC 3339 time")
+			return
+		}
+	}
+
+	page, err := h.store.GetTagsPage(r.Context(), repo.ID, filter, cursor.After, cursor.Limit)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		writeAPIError(w, r, http.StatusBadRequest, apiCodeBadRequest, errInvalidCursor.Error())
+		return
+	}
+	if err != nil {
+		h.apiInternalError(w, r, "Failed to list tags", err)
+		return
+	}
+	resp := apiList[store.TagView]{Data: page.Tags, Total: page.TotalCount, NextCursor: cursor.next(page.Next)}
+	if resp.Data == nil {
+		resp.Data = []store.TagView{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *handler) apiTag(w http.ResponseWriter, r *http.Request) {
+	repo, ok := h.apiRepositoryFromPath(w, r)
+	if !ok {
+		return
+	}
+	tag, err := h.store.GetTagDetail(r.Context(), repo.ID, chi.URLParam(r, "tag"))
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, apiCodeNotFound, "Tag not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, apiResponse[store.TagDetailView]{Data: *tag})
+}
+
+func (h *handler) apiInternalError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	clog.Error(message, "path", r.URL.Path, "error", err)
+	writeAPIError(w, r, http.StatusInternalServerError, apiCodeInternal, message)
+}
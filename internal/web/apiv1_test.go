@@ -0,0 +1,286 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/registry"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+const apiTestHost = "other.example.com"
+
+// newAPITestHandler serves /api/v1 from a store holding team-a/app,
+// team-a/tools and team-b/app, each with the tags v1 to v5. The test policy
+// restricts members of team-a to their own namespaces on apiTestHost.
+func newAPITestHandler(t *testing.T) (*handler, *store.Store, *store.Repository) {
+	t.Helper()
+	ctx := context.Background()
+	s, err := store.New(ctx, ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+
+	reg, err := s.UpsertRegistryByFields(ctx, "other", "https://"+apiTestHost, apiTestHost, 1)
+	if err != nil {
+		t.Fatalf("UpsertRegistryByFields: %v", err)
+	}
+	var first *store.Repository
+	for _, path := range [][2]string{{"team-a", "app"}, {"team-a", "tools"}, {"team-b", "app"}} {
+		repo, err := s.UpsertRepositoryByFields(ctx, reg.ID, path[0], path[1])
+		if err != nil {
+			t.Fatalf("UpsertRepositoryByFields: %v", err)
+		}
+		for _, tag := range []string{"v1", "v2", "v3", "v4", "v5"} {
+			if _, err := s.UpsertTagWithSync(ctx, repo.ID, tag, "sha256:"+tag, "image", "app/json", 1.0); err != nil {
+				t.Fatalf("UpsertTagWithSync: %v", err)
+			}
+		}
+		if err := s.RefreshRepository(ctx, repo.ID); err != nil {
+			t.Fatalf("RefreshRepository: %v", err)
+		}
+		if first == nil {
+			first = repo
+		}
+	}
+
+	a := &AuthHandler{config: AuthConfig{PolicyFile: writePolicy(t, testPolicy)}, logger: clog.Default()}
+	if err := a.ReloadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	return &handler{store: s, regManager: &registry.Manager{}, authHandler: a}, s, first
+}
+
+// serveAPI requests target from the /api/v1 routes as user.
+func serveAPI(t *testing.T, h *handler, user *SessionUser, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	router := chi.NewRouter()
+	router.Use(chimw.RequestID)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+		})
+	})
+	router.Route(apiV1Prefix, h.apiV1Routes)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+	return w
+}
+
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) apiError {
+	t.Helper()
+	var resp apiErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected an error envelope, got %q: %v", w.Body.String(), err)
+	}
+	return resp.Error
+}
+
+// pageNames follows nextCursor from target and returns the names listed.
+func pageNames(t *testing.T, h *handler, user *SessionUser, target string, name func(json.RawMessage) string) []string {
+	t.Helper()
+	var names []string
+	for next := target; ; {
+		w := serveAPI(t, h, user, next)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", next, w.Code, w.Body.String())
+		}
+		var page apiList[json.RawMessage]
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode page: %v", err)
+		}
+		for _, item := range page.Data {
+			names = append(names, name(item))
+		}
+		if page.NextCursor == "" {
+			return names
+		}
+		base, _, _ := strings.Cut(target, "?")
+		next = base + "?cursor=" + page.NextCursor
+	}
+}
+
+func tagName(item json.RawMessage) string {
+	var tag struct{ Name string }
+	_ = json.Unmarshal(item, &tag)
+	return tag.Name
+}
+
+func repositoryPath(item json.RawMessage) string {
+	var repo struct{ Namespace, Name string }
+	_ = json.Unmarshal(item, &repo)
+	return repo.Namespace + "/" + repo.Name
+}
+
+func TestAPIErrorEnvelope(t *testing.T) {
+	h, _, _ := newAPITestHandler(t)
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+		code   string
+	}{
+		{name: "unknown endpoint", target: "/api/v1/nothing", status: http.StatusNotFound, code: apiCodeNotFound},
+		{name: "unknown registry", target: "/api/v1/registries/missing.example.com", status: http.StatusNotFound, code: apiCodeNotFound},
+		{name: "invalid query", target: "/api/v1/repositories?q=size:%3Eabc", status: http.StatusBadRequest, code: apiCodeInvalidQuery},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveAPI(t, h, nil, test.target)
+			if w.Code != test.status {
+				t.Fatalf("expected %d, got %d %s", test.status, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Fatalf("expected a JSON error, got %q", ct)
+			}
+			apiErr := decodeAPIError(t, w)
+			if apiErr.Code != test.code || apiErr.Message == "" || apiErr.RequestID == "" {
+				t.Fatalf("expected code %s with a message and request id, got %+v", test.code, apiErr)
+			}
+			if (test.code == apiCodeInvalidQuery) != (apiErr.Query != nil) {
+				t.Fatalf("expected query details only for invalid_query, got %+v", apiErr.Query)
+			}
+		})
+	}
+}
+
+func TestAPIRejectsInvalidParameters(t *testing.T) {
+	h, _, _ := newAPITestHandler(t)
+	tags := "/api/v1/repositories/" + apiTestHost + "/team-a/app/tags"
+
+	w := serveAPI(t, h, nil, tags+"?limit=2")
+	var page apiList[json.RawMessage]
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil || page.NextCursor == "" {
+		t.Fatalf("expected a first page with a cursor, got %s: %v", w.Body.String(), err)
+	}
+	w = serveAPI(t, h, nil, "/api/v1/repositories?untagged=true&limit=1")
+	var repos apiList[json.RawMessage]
+	if err := json.Unmarshal(w.Body.Bytes(), &repos); err != nil || repos.NextCursor == "" {
+		t.Fatalf("expected a first page with a cursor, got %s: %v", w.Body.String(), err)
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		message string
+	}{
+		{name: "garbage cursor", target: "/api/v1/repositories?cursor=not-a-cursor", message: "invalid cursor"},
+		{name: "cursor of another listing", target: tags + "?cursor=" + repos.NextCursor, message: "invalid cursor"},
+		{name: "cursor of another sort", target: tags + "?sort=name-asc&cursor=" + page.NextCursor, message: "invalid cursor"},
+		{name: "zero limit", target: "/api/v1/repositories?limit=0", message: "invalid limit"},
+		{name: "non-numeric limit", target: tags + "?limit=ten", message: "invalid limit"},
+		{name: "unknown sort", target: tags + "?sort=popular", message: "Invalid sort"},
+		{name: "malformed asOf", target: tags + "?asOf=yesterday", message: "Invalid asOf"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveAPI(t, h, nil, test.target)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d %s", w.Code, w.Body.String())
+			}
+			if apiErr := decodeAPIError(t, w); apiErr.Code != apiCodeBadRequest || !strings.Contains(apiErr.Message, test.message) {
+				t.Fatalf("expected a bad_request error about %q, got %+v", test.message, apiErr)
+			}
+		})
+	}
+}
+
+func TestAPIHidesRepositoriesOutsidePolicy(t *testing.T) {
+	h, _, _ := newAPITestHandler(t)
+	teamA := &SessionUser{Subject: "alice", Groups: []string{"team-a"}}
+
+	for _, target := range []string{
+		"/api/v1/repositories/" + apiTestHost + "/team-b/app",
+		"/api/v1/repositories/" + apiTestHost + "/team-b/app/tags",
+		"/api/v1/repositories/" + apiTestHost + "/team-b/app/tags/v1",
+	} {
+		w := serveAPI(t, h, teamA, target)
+		if w.Code != http.StatusNotFound || decodeAPIError(t, w).Code != apiCodeNotFound {
+			t.Fatalf("expected %s to be hidden, got %d %s", target, w.Code, w.Body.String())
+		}
+		if w := serveAPI(t, h, nil, target); w.Code != http.StatusOK {
+			t.Fatalf("expected %s without a policy user, got %d %s", target, w.Code, w.Body.String())
+		}
+	}
+
+	// Pages are cut after the policy filter, so they stay full.
+	got := pageNames(t, h, teamA, "/api/v1/repositories?untagged=true&limit=1", repositoryPath)
+	if want := []string{"team-a/app", "team-a/tools"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestAPIRegistryStatsCoverVisibleNamespaces(t *testing.T) {
+	h, _, _ := newAPITestHandler(t)
+	target := "/api/v1/registries/" + apiTestHost
+
+	for _, test := range []struct {
+		name  string
+		user  *SessionUser
+		repos int
+	}{
+		{name: "unrestricted", repos: 3},
+		{name: "team-a", user: &SessionUser{Subject: "alice", Groups: []string{"team-a"}}, repos: 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			w := serveAPI(t, h, test.user, target)
+			var resp apiResponse[apiRegistryDetail]
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+				t.Fatalf("GET %s: %d %s", target, w.Code, w.Body.String())
+			}
+			if stats := resp.Data.Stats; stats.RepositoryCount != test.repos || stats.TagCount != test.repos*5 {
+				t.Fatalf("expected %d repositories with 5 tags each, got %+v", test.repos, stats)
+			}
+		})
+	}
+}
+
+func TestAPIPagesByKeyset(t *testing.T) {
+	h, s, repo := newAPITestHandler(t)
+	ctx := context.Background()
+	tags := "/api/v1/repositories/" + apiTestHost + "/team-a/app/tags"
+
+	if got, want := pageNames(t, h, nil, tags+"?sort=name-asc&limit=2", tagName), []string{"v1", "v2", "v3", "v4", "v5"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got, want := pageNames(t, h, nil, tags+"?limit=2", tagName), []string{"v5", "v4", "v3", "v2", "v1"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	// A tag added before the cursor does not shift the next page.
+	w := serveAPI(t, h, nil, tags+"?sort=name-asc&limit=2")
+	var page apiList[json.RawMessage]
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	if _, err := s.UpsertTagWithSync(ctx, repo.ID, "v0", "sha256:v0", "image", "app/json", 1.0); err != nil {
+		t.Fatalf("UpsertTagWithSync: %v", err)
+	}
+	w = serveAPI(t, h, nil, tags+"?cursor="+page.NextCursor)
+	var next apiList[json.RawMessage]
+	if err := json.Unmarshal(w.Body.Bytes(), &next); err != nil || len(next.Data) != 2 {
+		t.Fatalf("expected a second page of two tags, got %s: %v", w.Body.String(), err)
+	}
+	if got := tagName(next.Data[0]); got != "v3" {
+		t.Fatalf("expected the second page to continue at v3, got %s", got)
+	}
+	if next.Total != 6 {
+		t.Fatalf("expected the total to count the new tag, got %d", next.Total)
+	}
+
+	got := pageNames(t, h, nil, "/api/v1/repositories?untagged=true&limit=2", repositoryPath)
+	if want := []string{"team-a/app", "team-a/tools", "team-b/app"}; !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,350 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"github.com/romsar/gonertia/v3"
+)
+
+const maxAuditPageSize = 200
+
+var auditActions = []string{
+	store.AuditActionTagsDelete,
+	store.AuditActionSyncTrigger,
+	store.AuditActionLogin,
+	store.AuditActionLogout,
+	store.AuditActionTokenCreate,
+	store.AuditActionTokenRevoke,
+	store.AuditActionConfigReload,
+	store.AuditActionAuditPrune,
+}
+
+var auditOutcomes = []string{store.AuditOutcomeSuccess, store.AuditOutcomeFailure, store.AuditOutcomeDenied}
+
+type AuditConfig struct {
+	// File, when set, also receives every event as a JSON line, for log
+	// shippers. It is only ever appended to.
+	File string
+	// TrustForwardedFor takes the client IP from the last X-Forwarded-For
+	// entry, the one added by the reverse proxy in front of the server.
+	TrustForwardedFor bool
+}
+
+// Sign-ins that fail before the user is known need no session, so anyone
+// can cause them. Each client has one recorded per anonymousFailureWindow,
+// and the next recorded one counts those left out in between.
+const (
+	anonymousFailureWindow = time.Minute
+	// maxAnonymousFailureClients bounds the clients tracked at once. Past
+	// it, the clients not tracked share one allowance.
+	maxAnonymousFailureClients = 1024
+)
+
+// AuditLog records destructive and administrative actions. A nil AuditLog
+// records nothing.
+type AuditLog struct {
+	store  auditStore
+	config AuditConfig
+	logger *clog.Logger
+
+	mu   sync.Mutex
+	file *os.File
+
+	anonymous failureThrottle
+}
+
+// failureThrottle tracks when each client last had an anonymous failure
+// recorded.
+type failureThrottle struct {
+	mu      sync.Mutex
+	clients map[string]*throttledClient
+}
+
+type throttledClient struct {
+	recordedAt time.Time
+	// suppressed counts the failures left out since recordedAt.
+	suppressed int
+}
+
+// allow reports whether a failure of the client at now is recorded, and how
+// many of its failures were left out since the last one that was.
+func (t *failureThrottle) allow(client string, now time.Time) (bool, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.clients == nil {
+		t.clients = make(map[string]*throttledClient)
+	}
+	if _, ok := t.clients[client]; !ok && len(t.clients) >= maxAnonymousFailureClients {
+		for key, c := range t.clients {
+			if now.Sub(c.recordedAt) >= anonymousFailureWindow {
+				delete(t.clients, key)
+			}
+		}
+		if len(t.clients) >= maxAnonymousFailureClients {
+			client = ""
+		}
+	}
+
+	c, ok := t.clients[client]
+	if !ok {
+		c = &throttledClient{}
+		t.clients[client] = c
+	}
+	if !c.recordedAt.IsZero() && now.Sub(c.recordedAt) < anonymousFailureWindow {
+		c.suppressed++
+		return false, c.suppressed
+	}
+	suppressed := c.suppressed
+	c.recordedAt, c.suppressed = now, 0
+	return true, suppressed
+}
+
+type auditStore interface {
+	RecordAuditEvent(ctx context.Context, event *store.AuditEvent) error
+}
+
+func NewAuditLog(s *store.Store, cfg AuditConfig, logger *clog.Logger) (*AuditLog, error) {
+	l := &AuditLog{store: s, config: cfg, logger: logger}
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log file: %w", err)
+		}
+		l.file = f
+	}
+	return l, nil
+}
+
+// Record stores the event and appends it to the file. Failures are logged
+// rather than failing the audited action, which has already happened.
+func (l *AuditLog) Record(ctx context.Context, event store.AuditEvent) {
+	if l == nil {
+		return
+	}
+	// The event is recorded even when the client has gone away.
+	if err := l.store.RecordAuditEvent(context.WithoutCancel(ctx), &event); err != nil {
+		l.logger.Error("Failed to record audit event", "action", event.Action, "error", err)
+	}
+	l.Append(event)
+}
+
+// Append writes an event already stored, such as one recorded within a store
+// transaction, to the file. Without a file it does nothing.
+func (l *AuditLog) Append(event store.AuditEvent) {
+	if l == nil || l.file == nil {
+		return
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		l.logger.Error("Failed to encode audit event", "action", event.Action, "error", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		l.logger.Error("Failed to write audit log file", "file", l.config.File, "error", err)
+	}
+}
+
+// recordAnonymousFailure records a failure by someone not signed in, unless
+// the client already had one recorded within anonymousFailureWindow.
+func (l *AuditLog) recordAnonymousFailure(ctx context.Context, event store.AuditEvent) {
+	if l == nil {
+		return
+	}
+	recorded, suppressed := l.anonymous.allow(event.ClientIP, time.Now())
+	if !recorded {
+		if suppressed == 1 {
+			l.logger.Warn("Not recording further anonymous failures from the client for a while",
+				"action", event.Action, "client", event.ClientIP)
+		}
+		return
+	}
+	if suppressed > 0 {
+		event.Detail += fmt.Sprintf(" (%d more failures from the client since the last recorded)", suppressed)
+	}
+	l.Record(ctx, event)
+}
+
+func (l *AuditLog) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// event starts an event for the request, filled in with the signed-in user,
+// the API token they used, the request ID and the client IP.
+func (l *AuditLog) event(r *http.Request, action, outcome string) store.AuditEvent {
+	event := store.AuditEvent{
+		Action:    action,
+		Outcome:   outcome,
+		RequestID: chimw.GetReqID(r.Context()),
+		ClientIP:  l.clientIP(r),
+	}
+	if user, ok := UserFromContext(r.Context()); ok {
+		event.ActorSubject, event.ActorEmail, event.ActorName = user.Subject, user.Email, user.Name
+	}
+	if token, ok := APITokenFromContext(r.Context()); ok {
+		event.TokenID, event.TokenName = &token.ID, token.Name
+	}
+	return event
+}
+
+func (l *AuditLog) clientIP(r *http.Request) string {
+	if l != nil && l.config.TrustForwardedFor {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type auditResponse struct {
+	Events []store.AuditEvent `json:"events"`
+	// NextBefore pages to older events, and is 0 on the last page.
+	NextBefore uint `json:"nextBefore"`
+}
+
+// auditFilter reads the filter from the query: action, outcome, actor,
+// target, since and until as RFC 3339 times or dates, before and limit.
+func auditFilter(q url.Values) (store.AuditFilter, error) {
+	filter := store.AuditFilter{
+		Action:  q.Get("action"),
+		Outcome: q.Get("outcome"),
+		Actor:   strings.TrimSpace(q.Get("actor")),
+		Target:  strings.TrimSpace(q.Get("target")),
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **time.Time
+		// days is added to a bare date, so until covers the whole day.
+		days int
+	}{{"since", &filter.Since, 0}, {"until", &filter.Until, 1}} {
+		value := q.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			date, dateErr := time.Parse(time.DateOnly, value)
+			if dateErr != nil {
+				return filter, fmt.Errorf("%s must be an RFC 3339 time or a date", bound.name)
+			}
+			t = date.AddDate(0, 0, bound.days)
+		}
+		*bound.dst = &t
+	}
+	if value := q.Get("before"); value != "" {
+		before, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, errors.New("before must be an event id")
+		}
+		filter.BeforeID = uint(before)
+	}
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			return filter, fmt.Errorf("limit must be 1 to %d", maxAuditPageSize)
+		}
+		filter.Limit = limit
+	}
+	return filter, nil
+}
+
+func (h *handler) listAuditEvents(r *http.Request, filter store.AuditFilter) (auditResponse, error) {
+	events, more, err := h.store.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		return auditResponse{}, err
+	}
+	resp := auditResponse{Events: events}
+	if more {
+		resp.NextBefore = events[len(events)-1].ID
+	}
+	return resp, nil
+}
+
+func (h *handler) auditEvents(w http.ResponseWriter, r *http.Request) {
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "The audit log requires OIDC sign-in"})
+		return
+	}
+	if !h.authHandler.IsAdmin(user) {
+		writeJSON(w, http.StatusForbidden, map[string]string{jsonKeyError: "Only administrators can read the audit log"})
+		return
+	}
+	filter, err := auditFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: err.Error()})
+		return
+	}
+	resp, err := h.listAuditEvents(r, filter)
+	if err != nil {
+		clog.Error("Failed to list audit events", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to list audit events"})
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *handler) auditPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := UserFromContext(r.Context())
+	if !ok || !h.authHandler.IsAdmin(user) {
+		h.notFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	filter, err := auditFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := h.listAuditEvents(r, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.renderPage(w, r, "Audit", gonertia.Props{
+		"events":     resp.Events,
+		"nextBefore": resp.NextBefore,
+		"actions":    auditActions,
+		"outcomes":   auditOutcomes,
+		"filters": map[string]string{
+			"action":  filter.Action,
+			"outcome": filter.Outcome,
+			"actor":   filter.Actor,
+			"target":  filter.Target,
+			"since":   q.Get("since"),
+			"until":   q.Get("until"),
+		},
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
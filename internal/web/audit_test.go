@@ -0,0 +1,163 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+)
+
+type fakeAuditStore struct {
+	events []store.AuditEvent
+}
+
+func (f *fakeAuditStore) RecordAuditEvent(_ context.Context, event *store.AuditEvent) error {
+	event.ID = uint(len(f.events) + 1)
+	f.events = append(f.events, *event)
+	return nil
+}
+
+func TestAuditLogRecordsRequests(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	db := &fakeAuditStore{}
+	l := &AuditLog{store: db, config: AuditConfig{File: file, TrustForwardedFor: true}, logger: clog.Default()}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.file = f
+
+	r := httptest.NewRequest("DELETE", "/r/registry.example.com/app/tags", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Add("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	token := &store.APIToken{ID: 3, Name: "ci"}
+	ctx := context.WithValue(r.Context(), userContextKey, &SessionUser{Subject: "alice", Email: "alice@example.com"})
+	r = r.WithContext(context.WithValue(ctx, apiTokenContextKey, token))
+
+	l.Record(r.Context(), l.event(r, store.AuditActionTagsDelete, store.AuditOutcomeSuccess))
+	l.Record(r.Context(), l.event(httptest.NewRequest("POST", "/oauth/logout", nil), store.AuditActionLogout, store.AuditOutcomeSuccess))
+	// An event stored elsewhere, like a prune, only goes to the file.
+	l.Append(store.AuditEvent{ID: 9, Action: store.AuditActionAuditPrune, ActorName: "ops@db-host"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := db.events[0]
+	if got.ActorEmail != "alice@example.com" || got.TokenID == nil || *got.TokenID != 3 || got.TokenName != "ci" ||
+		got.ClientIP != "198.51.100.7" {
+		t.Fatalf("unexpected event %+v", got)
+	}
+	if db.events[1].ClientIP != "192.0.2.1" || db.events[1].ActorSubject != "" {
+		t.Fatalf("expected the remote address of an anonymous request, got %+v", db.events[1])
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || len(db.events) != 2 {
+		t.Fatalf("expected a line per event and the appended one not stored again, got %q", data)
+	}
+	var line store.AuditEvent
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil || line.ID != 1 || line.Action != store.AuditActionTagsDelete {
+		t.Fatalf("expected the first event with its id, got %+v, %v", line, err)
+	}
+	if err := json.Unmarshal([]byte(lines[2]), &line); err != nil || line.ID != 9 || line.ActorName != "ops@db-host" {
+		t.Fatalf("expected the appended event last, got %+v, %v", line, err)
+	}
+
+	var nilLog *AuditLog
+	nilLog.Record(r.Context(), store.AuditEvent{Action: store.AuditActionLogin})
+	nilLog.Append(store.AuditEvent{Action: store.AuditActionLogin})
+	if err := nilLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditLogThrottlesAnonymousFailures(t *testing.T) {
+	db := &fakeAuditStore{}
+	l := &AuditLog{store: db, logger: clog.Default()}
+	failure := func(remoteAddr string) {
+		r := httptest.NewRequest("GET", "/auth/callback?error=x", nil)
+		r.RemoteAddr = remoteAddr
+		event := l.event(r, store.AuditActionLogin, store.AuditOutcomeFailure)
+		event.Detail = "Provider error: x"
+		l.recordAnonymousFailure(r.Context(), event)
+	}
+
+	for range 5 {
+		failure("203.0.113.9:4000")
+	}
+	failure("198.51.100.7:4000")
+	if len(db.events) != 2 || db.events[0].ClientIP != "203.0.113.9" || db.events[1].ClientIP != "198.51.100.7" {
+		t.Fatalf("expected one event per client, got %+v", db.events)
+	}
+
+	l.anonymous.clients["203.0.113.9"].recordedAt = time.Now().Add(-anonymousFailureWindow)
+	failure("203.0.113.9:4000")
+	if len(db.events) != 3 || db.events[2].Detail != "Provider error: x (4 more failures from the client since the last recorded)" {
+		t.Fatalf("expected the next window to count the failures left out, got %+v", db.events)
+	}
+}
+
+func TestFailureThrottleBoundsClients(t *testing.T) {
+	var throttle failureThrottle
+	now := time.Now()
+	for i := range maxAnonymousFailureClients {
+		if ok, _ := throttle.allow(fmt.Sprintf("10.0.%d.%d", i/256, i%256), now); !ok {
+			t.Fatalf("expected the first failure of client %d to be recorded", i)
+		}
+	}
+	// Past the limit, new clients share one allowance.
+	if ok, _ := throttle.allow("192.0.2.1", now); !ok {
+		t.Fatal("expected the shared allowance to record once")
+	}
+	if ok, _ := throttle.allow("192.0.2.2", now); ok {
+		t.Fatal("expected another untracked client to share the used allowance")
+	}
+	if len(throttle.clients) != maxAnonymousFailureClients+1 {
+		t.Fatalf("expected the tracked clients to stay bounded, got %d", len(throttle.clients))
+	}
+
+	// Once their window has passed, clients make room again.
+	if ok, _ := throttle.allow("192.0.2.3", now.Add(anonymousFailureWindow)); !ok {
+		t.Fatal("expected a new client to be recorded after the window")
+	}
+	if len(throttle.clients) != 1 {
+		t.Fatalf("expected the expired clients dropped, got %d", len(throttle.clients))
+	}
+}
+
+func TestAuditFilter(t *testing.T) {
+	filter, err := auditFilter(url.Values{
+		"action": {store.AuditActionTagsDelete},
+		"actor":  {" alice "},
+		"since":  {"2026-03-01"},
+		"until":  {"2026-03-01"},
+		"before": {"42"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	if filter.Action != store.AuditActionTagsDelete || filter.Actor != "alice" || filter.BeforeID != 42 ||
+		!filter.Since.Equal(day) || !filter.Until.Equal(day.AddDate(0, 0, 1)) {
+		t.Fatalf("unexpected filter %+v", filter)
+	}
+
+	for _, q := range []url.Values{{"since": {"yesterday"}}, {"before": {"x"}}, {"limit": {"500"}}} {
+		if _, err := auditFilter(q); err == nil {
+			t.Errorf("expected %v to be rejected", q)
+		}
+	}
+}
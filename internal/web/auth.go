@@ -9,11 +9,14 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	clog "github.com/charmbracelet/log"
 	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/eznix86/docker-registry-ui/internal/store"
 	"github.com/gorilla/securecookie"
 	"golang.org/x/oauth2"
 )
@@ -39,6 +42,16 @@ type AuthConfig struct {
 	ClaimRoles          string
 	ClaimName           string
 	SessionMaxAge       time.Duration
+
+	// Admin* name the users who can see and revoke every user's API tokens
+	// and read the audit log, and who the access policy does not restrict.
+	AdminEmails string
+	AdminGroups string
+	AdminRoles  string
+
+	// PolicyFile is the JSON access policy restricting what users can do,
+	// read at start and by ReloadPolicy. Without one everyone can do anything.
+	PolicyFile string
 }
 
 func (c AuthConfig) Enabled() bool {
@@ -53,10 +66,23 @@ type AuthHandler struct {
 	cookies       *securecookie.SecureCookie
 	logger        *clog.Logger
 	allowed       authzRules
+	admins        authzRules
+	tokens        apiTokenStore
+	audit         *AuditLog
+	policy        atomic.Pointer[Policy]
 	secureCookie  bool
 	sessionMaxAge time.Duration
 }
 
+// apiTokenStore looks up the API tokens requests authenticate with, and keeps
+// them in line with their users' claims.
+type apiTokenStore interface {
+	GetAPITokenByHash(ctx context.Context, hash string) (*store.APIToken, error)
+	TouchAPIToken(ctx context.Context, id uint, at time.Time) error
+	NarrowAPITokenClaims(ctx context.Context, subject string, groups, roles []string) (int, error)
+	RevokeUserAPITokens(ctx context.Context, subject string, at time.Time) (int, error)
+}
+
 type authzRules struct {
 	emails       map[string]struct{}
 	emailDomains map[string]struct{}
@@ -95,6 +121,7 @@ func NewAuthHandler(ctx context.Context, cfg AuthConfig, logger *clog.Logger) (*
 		config:        cfg,
 		logger:        logger,
 		allowed:       parseAuthzRules(cfg),
+		admins:        parseAdminRules(cfg),
 		secureCookie:  shouldSecureCookies(cfg.RedirectURI),
 		sessionMaxAge: cfg.SessionMaxAge,
 	}
@@ -106,6 +133,10 @@ func NewAuthHandler(ctx context.Context, cfg AuthConfig, logger *clog.Logger) (*
 		return a, nil
 	}
 
+	if err := a.ReloadPolicy(); err != nil {
+		return nil, err
+	}
+
 	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
 	if err != nil {
 		return nil, fmt.Errorf("oidc init from issuer %q: %w", cfg.IssuerURL, err)
@@ -130,6 +161,16 @@ func NewAuthHandler(ctx context.Context, cfg AuthConfig, logger *clog.Logger) (*
 
 func (a *AuthHandler) Enabled() bool { return a.config.Enabled() }
 
+// IsAdmin reports whether the user matches OIDC_ADMIN_EMAILS, _GROUPS or
+// _ROLES.
+func (a *AuthHandler) IsAdmin(user *SessionUser) bool {
+	if a == nil || user == nil {
+		return false
+	}
+	_, email := a.admins.emails[user.Email]
+	return email || anyMatch(a.admins.groups, user.Groups) || anyMatch(a.admins.roles, user.Roles)
+}
+
 func (a *AuthHandler) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !a.Enabled() {
@@ -137,17 +178,22 @@ func (a *AuthHandler) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if secret, ok := bearerToken(r); ok {
+			a.serveToken(w, r, next, secret)
+			return
+		}
+
 		user, err := a.readSession(r)
 		if err != nil {
-			if isPageRequest(r) {
+			if isPageRequest(r) && !isAPIv1Request(r) {
 				http.Redirect(w, r, "/oauth/login", http.StatusFound)
 			} else {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				denyRequest(w, r, http.StatusUnauthorized, "unauthorized")
 			}
 			return
 		}
 		if err := a.authorize(user); err != nil {
-			http.Error(w, "forbidden", http.StatusForbidden)
+			denyRequest(w, r, http.StatusForbidden, "forbidden")
 			return
 		}
 
@@ -187,12 +233,14 @@ func (a *AuthHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	if errType := q.Get("error"); errType != "" {
 		desc := q.Get("error_description")
 		a.logger.Error("oidc callback error", "error", errType, "description", desc)
+		a.auditLogin(r, nil, store.AuditOutcomeFailure, "Provider error: "+errType)
 		http.Redirect(w, r, callbackErrorPath(errType), http.StatusFound)
 		return
 	}
 
 	if err := a.verifyState(r, q.Get("state")); err != nil {
 		a.logger.Error("oidc state verification failed", "error", err)
+		a.auditLogin(r, nil, store.AuditOutcomeFailure, "Invalid state")
 		http.Error(w, "invalid state", http.StatusForbidden)
 		return
 	}
@@ -200,12 +248,14 @@ func (a *AuthHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	oauth2Token, err := a.oauth2Config.Exchange(r.Context(), q.Get("code"))
 	if err != nil {
 		a.logger.Error("oidc code exchange failed", "error", err)
+		a.auditLogin(r, nil, store.AuditOutcomeFailure, "Code exchange failed")
 		http.Error(w, "authentication failed", http.StatusInternalServerError)
 		return
 	}
 
 	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
 	if !ok {
+		a.auditLogin(r, nil, store.AuditOutcomeFailure, "No id_token in response")
 		http.Error(w, "no id_token in response", http.StatusInternalServerError)
 		return
 	}
@@ -213,6 +263,7 @@ func (a *AuthHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	idToken, err := a.verifier.Verify(r.Context(), rawIDToken)
 	if err != nil {
 		a.logger.Error("oidc id_token verification failed", "error", err)
+		a.auditLogin(r, nil, store.AuditOutcomeFailure, "Token verification failed")
 		http.Error(w, "token verification failed", http.StatusInternalServerError)
 		return
 	}
@@ -220,6 +271,7 @@ func (a *AuthHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 	var rawClaims map[string]any
 	if err := idToken.Claims(&rawClaims); err != nil {
 		a.logger.Error("oidc claim extraction failed", "error", err)
+		a.auditLogin(r, nil, store.AuditOutcomeFailure, "Claim extraction failed")
 		http.Error(w, "failed to extract claims", http.StatusInternalServerError)
 		return
 	}
@@ -231,25 +283,71 @@ func (a *AuthHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
 			"email", user.Email,
 			"reason", err,
 		)
+		a.auditLogin(r, user, store.AuditOutcomeDenied, err.Error())
+		a.syncUserTokens(r, user, false)
 		http.Error(w, "access denied: "+err.Error(), http.StatusForbidden)
 		return
 	}
 
 	if err := a.writeSessionCookie(w, user); err != nil {
+		a.auditLogin(r, user, store.AuditOutcomeFailure, "Failed to create session")
 		http.Error(w, "failed to create session", http.StatusInternalServerError)
 		return
 	}
+	a.auditLogin(r, user, store.AuditOutcomeSuccess, "")
+	a.syncUserTokens(r, user, true)
 
 	a.clearStateCookie(w)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// syncUserTokens brings the user's API tokens in line with the claims they
+// just signed in with: the tokens lose the groups and roles the user no
+// longer has, and are revoked when the user is no longer allowed in. Claims
+// are only learned at sign-in, so a user removed at the provider keeps their
+// tokens until they try to sign in again or an administrator revokes them.
+func (a *AuthHandler) syncUserTokens(r *http.Request, user *SessionUser, allowed bool) {
+	if a.tokens == nil || user.Subject == "" {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	if allowed {
+		n, err := a.tokens.NarrowAPITokenClaims(ctx, user.Subject, user.Groups, user.Roles)
+		if err != nil {
+			a.logger.Error("Failed to narrow api tokens to the user's claims", "sub", user.Subject, "error", err)
+		} else if n > 0 {
+			a.logger.Info("Narrowed api tokens to the user's claims", "sub", user.Subject, "tokens", n)
+		}
+		return
+	}
+
+	n, err := a.tokens.RevokeUserAPITokens(ctx, user.Subject, time.Now())
+	if err != nil {
+		a.logger.Error("Failed to revoke api tokens of a denied user", "sub", user.Subject, "error", err)
+		return
+	}
+	if n == 0 {
+		return
+	}
+	event := a.audit.event(r, store.AuditActionTokenRevoke, store.AuditOutcomeSuccess)
+	event.ActorSubject, event.ActorEmail, event.ActorName = user.Subject, user.Email, user.Name
+	event.Detail = strconv.Itoa(n) + " tokens of a user denied sign-in"
+	a.audit.Record(r.Context(), event)
+}
+
 func (a *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	if !a.Enabled() {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
+	// Logout is outside the middleware, so the session is read for the
+	// event here.
+	event := a.audit.event(r, store.AuditActionLogout, store.AuditOutcomeSuccess)
+	if user, err := a.readSession(r); err == nil {
+		event.ActorSubject, event.ActorEmail, event.ActorName = user.Subject, user.Email, user.Name
+	}
+	a.audit.Record(r.Context(), event)
 	a.clearSessionCookie(w)
 
 	endSessionURL, err := a.endSessionEndpoint()
@@ -262,6 +360,20 @@ func (a *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, endSessionURL, http.StatusFound)
 }
 
+// auditLogin records a sign-in attempt by the user, or by someone unknown
+// when it failed before the user was. Anyone can cause those, so they are
+// throttled per client.
+func (a *AuthHandler) auditLogin(r *http.Request, user *SessionUser, outcome, detail string) {
+	event := a.audit.event(r, store.AuditActionLogin, outcome)
+	event.Detail = detail
+	if user == nil {
+		a.audit.recordAnonymousFailure(r.Context(), event)
+		return
+	}
+	event.ActorSubject, event.ActorEmail, event.ActorName = user.Subject, user.Email, user.Name
+	a.audit.Record(r.Context(), event)
+}
+
 func (a *AuthHandler) endSessionEndpoint() (string, error) {
 	var claims struct {
 		EndSessionEndpoint string `json:"end_session_endpoint"`
@@ -505,6 +617,14 @@ func parseAuthzRules(cfg AuthConfig) authzRules {
 	}
 }
 
+func parseAdminRules(cfg AuthConfig) authzRules {
+	return authzRules{
+		emails: parseSet(cfg.AdminEmails),
+		groups: parseSet(cfg.AdminGroups),
+		roles:  parseSet(cfg.AdminRoles),
+	}
+}
+
 func parseSet(s string) map[string]struct{} {
 	if s == "" {
 		return nil
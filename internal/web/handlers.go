@@ -3,7 +3,9 @@ package web
 import (
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/eznix86/docker-registry-ui/internal/helm"
 	"github.com/eznix86/docker-registry-ui/internal/progress"
@@ -22,24 +24,43 @@ type handler struct {
 	broadcaster  *progress.WebSocketBroadcaster
 	manualCh     sync.ManualSyncChannel
 	authHandler  *AuthHandler
+	audit        *AuditLog
 	showUsageBar bool
 }
 
 func (h *handler) renderPage(w http.ResponseWriter, r *http.Request, page string, props gonertia.Props) error {
 	if user, ok := UserFromContext(r.Context()); ok {
-		props["auth"] = gonertia.Props{"user": user}
+		props["auth"] = gonertia.Props{
+			"user":    user,
+			"admin":   h.authHandler.IsAdmin(user),
+			"canSync": h.authHandler.accessFor(user).canEverywhere(PermissionSync),
+		}
 	}
 	return h.inertia.Render(w, r, page, props)
 }
 
 func (h *handler) explore(w http.ResponseWriter, r *http.Request) {
-	filters := parseExploreFilters(r)
+	filters, query, queryErr := parseExploreFilters(r)
 
 	ctx := r.Context()
-	repos, err := h.store.GetRepositoriesViewFiltered(ctx, filters)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	acc := h.access(r)
+	repos := []store.RepositoryView{}
+	var usage *digestUsageProps
+	if digest, ok := digestQuery(query); ok {
+		groups, err := h.store.FindDigestUsage(ctx, digest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		usage = &digestUsageProps{Digest: digest, Registries: acc.visibleDigestUsage(groups)}
+	} else if queryErr == nil {
+		var err error
+		repos, err = h.store.GetRepositoriesViewFiltered(ctx, filters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		repos = acc.visibleRepositories(repos)
 	}
 
 	registries, err := h.store.GetAllRegistries(ctx)
@@ -53,7 +74,7 @@ func (h *handler) explore(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	total, err := h.store.GetTotalRepositoriesCount(ctx)
+	total, err := h.visibleRepositoryCount(r, acc)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -61,10 +82,11 @@ func (h *handler) explore(w http.ResponseWriter, r *http.Request) {
 
 	props := gonertia.Props{
 		"repositories":      repos,
-		"registries":        toRegistryOptions(registries, h.regManager),
+		"registries":        toRegistryOptions(acc.visibleRegistries(registries), h.regManager),
 		"totalRepositories": total,
 		"architectures":     archs,
-		"filters":           exploreProps(filters),
+		"filters":           exploreProps(filters, query, queryErr),
+		"digestUsage":       usage,
 	}
 
 	if h.showUsageBar {
@@ -74,6 +96,9 @@ func (h *handler) explore(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		storageByRegistry = slices.DeleteFunc(storageByRegistry, func(u store.RegistryStorageUsageView) bool {
+			return !acc.canInRegistry(PermissionView, u.RegistryHost)
+		})
 		props["charts"] = gonertia.Props{"storageByRegistry": storageByRegistry}
 	}
 
@@ -86,9 +111,10 @@ func (h *handler) registryPage(w http.ResponseWriter, r *http.Request) {
 	host := chi.URLParam(r, "registry")
 	host = strings.ReplaceAll(host, "~", ":")
 	ctx := r.Context()
+	acc := h.access(r)
 
 	reg, err := h.store.GetRegistryByHost(ctx, host)
-	if err != nil {
+	if err != nil || !acc.canInRegistry(PermissionView, host) {
 		http.Error(w, "registry not found", http.StatusNotFound)
 		return
 	}
@@ -98,7 +124,14 @@ func (h *handler) registryPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	stats, err := h.store.GetRegistryStats(ctx, host)
+	// Users who cannot view every namespace get stats over the ones they can,
+	// and no registry-wide trend or reclaimable estimate.
+	scope, wholeRegistry, err := h.registryScope(r, host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats, err := h.store.GetRegistryStats(ctx, host, scope)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -108,7 +141,7 @@ func (h *handler) registryPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	archCoverage, err := h.store.GetRegistryArchitectureCoverage(ctx, host)
+	archCoverage, err := h.store.GetRegistryArchitectureCoverage(ctx, host, scope)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -118,6 +151,37 @@ func (h *handler) registryPage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	period, window := parseStorageTrendPeriod(r)
+	since := time.Now().Add(-window)
+	storageTrend := []store.StorageSnapshotPoint{}
+	if wholeRegistry {
+		if storageTrend, err = h.store.GetStorageTrend(ctx, host, since); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	namespaceGrowth, err := h.store.GetNamespaceGrowth(ctx, host, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var reclaimable *store.ReclaimEstimate
+	if wholeRegistry {
+		if reclaimable, err = h.store.GetReclaimableStorage(ctx, host); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	storageByNS = slices.DeleteFunc(storageByNS, func(ns store.NamespaceStorageView) bool {
+		return !acc.can(PermissionView, host, ns.Namespace)
+	})
+	namespaceGrowth = slices.DeleteFunc(namespaceGrowth, func(ns store.NamespaceGrowthView) bool {
+		return !acc.can(PermissionView, host, ns.Namespace)
+	})
+	repoList = slices.DeleteFunc(repoList, func(repo store.RegistryRepositoryRow) bool {
+		return !acc.can(PermissionView, host, repo.Namespace)
+	})
 
 	registryPublicHost := ""
 	if client, err := h.regManager.GetClient(reg.Name); err == nil {
@@ -131,10 +195,18 @@ func (h *handler) registryPage(w http.ResponseWriter, r *http.Request) {
 			"publicHost":  registryPublicHost,
 			jsonKeyStatus: reg.Status,
 		},
-		"registries":   toRegistryOptions(registries, h.regManager),
-		"stats":        stats,
-		"charts":       gonertia.Props{"storageByNamespace": storageByNS, "architectureCoverage": archCoverage},
-		"repositories": repoList,
+		"registries": toRegistryOptions(acc.visibleRegistries(registries), h.regManager),
+		"stats":      stats,
+		"charts": gonertia.Props{
+			"storageByNamespace":   storageByNS,
+			"architectureCoverage": archCoverage,
+			"storageTrend":         storageTrend,
+			"namespaceGrowth":      namespaceGrowth,
+		},
+		"trendPeriod":   period,
+		"reclaimable":   reclaimable,
+		"wholeRegistry": wholeRegistry,
+		"repositories":  repoList,
 	}
 
 	if err := h.renderPage(w, r, "Registry", props); err != nil {
@@ -150,8 +222,9 @@ func (h *handler) repositoryPage(w http.ResponseWriter, r *http.Request) {
 	repoName = decodeRepoName(repoName)
 	ctx := r.Context()
 
+	acc := h.access(r)
 	repo, err := h.store.GetRepositoryByPath(ctx, registryHost, namespace, repoName)
-	if err != nil {
+	if err != nil || !acc.can(PermissionView, repo.RegistryHost, repo.Namespace) {
 		http.Error(w, "repository not found", http.StatusNotFound)
 		return
 	}
@@ -160,7 +233,11 @@ func (h *handler) repositoryPage(w http.ResponseWriter, r *http.Request) {
 		repo.RegistryPublicHost = client.PublicHost()
 	}
 
-	tagFilter := parseTagFilter(r)
+	tagFilter, err := parseTagFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	pagination := parseScroll(r, 5)
 
 	result, err := h.store.GetTagsForRepository(ctx, repo.ID, tagFilter, pagination)
@@ -180,9 +257,10 @@ func (h *handler) repositoryPage(w http.ResponseWriter, r *http.Request) {
 				PreviousPage: result.PreviousPage,
 			}),
 		),
-		"filters": gonertia.Props{"sortBy": tagFilter.SortBy, "filter": tagFilter.Name},
+		"filters":       gonertia.Props{"sortBy": tagFilter.SortBy, "filter": tagFilter.Name, "asOf": formatAsOf(tagFilter.AsOf)},
+		"canDeleteTags": tagFilter.AsOf == nil && acc.can(PermissionDelete, repo.RegistryHost, repo.Namespace),
 		"bulkDeleteTags": gonertia.Optional(func() (any, error) {
-			if repo.TagsCount == 0 {
+			if repo.TagsCount == 0 || tagFilter.AsOf != nil {
 				return []store.TagView{}, nil
 			}
 			r, err := h.store.GetTagsForRepository(ctx, repo.ID, tagFilter, store.ScrollPagination{Page: 1, PageSize: repo.TagsCount})
@@ -198,6 +276,19 @@ func (h *handler) repositoryPage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// visibleRepositoryCount counts every repository the user can view, without
+// the explore filters.
+func (h *handler) visibleRepositoryCount(r *http.Request, acc access) (int, error) {
+	if !acc.restricted {
+		return h.store.GetTotalRepositoriesCount(r.Context())
+	}
+	repos, err := h.store.GetRepositoriesView(r.Context())
+	if err != nil {
+		return 0, err
+	}
+	return len(acc.visibleRepositories(repos)), nil
+}
+
 func (h *handler) notFound(w http.ResponseWriter, r *http.Request) {
 	if err := h.renderPage(w, r, "NotFound", gonertia.Props{}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
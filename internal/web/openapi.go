@@ -0,0 +1,592 @@
+package web
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/eznix86/docker-registry-ui/internal/store"
+	"github.com/eznix86/docker-registry-ui/internal/version"
+)
+
+// The OpenAPI document lists every JSON endpoint in apiOperations. Schemas are
+// derived from the Go types the handlers write, so changing a response type
+// changes the document with it.
+
+//go:embed openapi.html
+var openAPIViewer []byte
+
+// apiOperation documents one route. Its path is the chi pattern, whose
+// {params} become path parameters.
+type apiOperation struct {
+	method  string
+	path    string
+	tag     string
+	summary string
+	// public operations need no credentials, and sessionOnly ones cannot be
+	// called with an API token.
+	public      bool
+	sessionOnly bool
+	query       []apiQueryParam
+	body        any
+	responses   []apiResponseDoc
+}
+
+// apiQueryParam documents a query parameter. value is a zero value of the Go
+// type the handler reads it as; slices are repeated parameters.
+type apiQueryParam struct {
+	name        string
+	description string
+	value       any
+	enum        []string
+	required    bool
+}
+
+// apiResponseDoc documents a response status. body is a zero value of the type
+// written, or nil when there is no body.
+type apiResponseDoc struct {
+	status      int
+	description string
+	body        any
+}
+
+var apiTags = []struct{ name, description string }{
+	{"v1", "The stable API. Paths and response shapes only change in backwards-compatible ways."},
+	{"Sync", "Registry synchronisation."},
+	{"Tags", "Tag deletion and history."},
+	{"Search", "Full-text and digest search."},
+	{"Storage", "Garbage collection estimates."},
+	{"Helm", "Helm chart contents, read from the registry."},
+	{"Tokens", "API tokens of the signed-in user. Only available with OIDC sign-in."},
+	{"Policy", "The access policy of OIDC_POLICY_FILE. Repositories a user cannot view are left out of " +
+		"every response, or not found."},
+	{"Audit", "Tag deletions, sync triggers, sign-ins, token changes and configuration reloads, newest first."},
+	{"System", "Health and API description."},
+}
+
+var apiPathParams = map[string]string{
+	"registry":   "Registry host, with ~ in place of the colon before a port",
+	"namespace":  "Repository namespace",
+	"repository": "Repository name, URL-escaped",
+	"tag":        "Tag name",
+	"digest":     "A digest such as sha256:3f1c..., or its first 8 or more hex characters",
+	"id":         "Token ID",
+}
+
+func okDoc(body any) apiResponseDoc {
+	return apiResponseDoc{status: http.StatusOK, description: "OK", body: body}
+}
+
+func errorDoc(status int, description string) apiResponseDoc {
+	return apiResponseDoc{status: status, description: description, body: errorResponse{}}
+}
+
+func errorDocV1(status int, description string) apiResponseDoc {
+	return apiResponseDoc{status: status, description: description, body: apiErrorResponse{}}
+}
+
+// repositoryOperations documents a route registered for repositories both with
+// and without a namespace.
+func repositoryOperations(prefix, suffix string, op apiOperation) []apiOperation {
+	withNamespace := op
+	op.path = prefix + "/{registry}/{repository}" + suffix
+	withNamespace.path = prefix + "/{registry}/{namespace}/{repository}" + suffix
+	return []apiOperation{op, withNamespace}
+}
+
+var (
+	cursorParam = apiQueryParam{name: "cursor", description: "The nextCursor of the previous page", value: ""}
+	limitParam  = apiQueryParam{name: "limit", description: "Page size, 50 by default and at most 200; ignored with a cursor", value: 0}
+)
+
+func apiOperations() []apiOperation {
+	ops := []apiOperation{
+		{
+			method: http.MethodGet, path: "/healthz", tag: "System", public: true,
+			summary:   "Report that the server is up",
+			responses: []apiResponseDoc{okDoc(healthResponse{})},
+		},
+		{
+			method: http.MethodGet, path: "/api/openapi.json", tag: "System",
+			summary:   "This document",
+			responses: []apiResponseDoc{okDoc(map[string]any{})},
+		},
+		{
+			method: http.MethodPost, path: "/api/sync/trigger", tag: "Sync",
+			summary: "Start a sync of every registry",
+			responses: []apiResponseDoc{
+				{status: http.StatusAccepted, description: "Sync started", body: syncTriggerResponse{}},
+				{status: http.StatusConflict, description: "A sync is already running or pending", body: syncTriggerResponse{}},
+				errorDoc(http.StatusForbidden, "The access policy does not grant sync on every registry"),
+			},
+		},
+		{
+			method: http.MethodGet, path: "/api/search", tag: "Search",
+			summary: "Search repositories and tags",
+			query: []apiQueryParam{
+				{name: "q", description: "Words to match, each as a prefix", value: ""},
+				{name: "limit", description: "Maximum number of hits, 20 by default and at most 100", value: 0},
+			},
+			responses: []apiResponseDoc{okDoc(searchResponse{}), errorDoc(http.StatusBadRequest, "Invalid limit")},
+		},
+		{
+			method: http.MethodGet, path: "/api/digests/{digest}", tag: "Search",
+			summary:   "Find the tags, indexes and images referencing a digest",
+			responses: []apiResponseDoc{okDoc(digestUsageResponse{}), errorDoc(http.StatusBadRequest, "Invalid digest")},
+		},
+		{
+			method: http.MethodGet, path: "/api/registries/{registry}/reclaimable", tag: "Storage",
+			summary: "Estimate what a garbage collection of the registry would free now",
+			responses: []apiResponseDoc{
+				okDoc(store.ReclaimEstimate{}),
+				errorDoc(http.StatusForbidden, "The access policy hides some namespaces of the registry"),
+				errorDoc(http.StatusNotFound, "Registry not found"),
+			},
+		},
+		{
+			method: http.MethodGet, path: "/r/{registry}/{namespace}/{repository}/helm/{tag}/values", tag: "Helm",
+			summary: "Read the values.yaml of a chart",
+			responses: []apiResponseDoc{
+				okDoc(helmValuesResponse{}),
+				errorDoc(http.StatusBadRequest, "Tag is not a Helm chart"),
+				errorDoc(http.StatusNotFound, "Repository or tag not found"),
+				errorDoc(http.StatusBadGateway, "The chart could not be read from the registry"),
+			},
+		},
+		{
+			method: http.MethodGet, path: "/r/{registry}/{namespace}/{repository}/helm/{tag}/files", tag: "Helm",
+			summary: "Read the files of a chart",
+			responses: []apiResponseDoc{
+				okDoc(helmFilesResponse{}),
+				errorDoc(http.StatusBadRequest, "Tag is not a Helm chart"),
+				errorDoc(http.StatusNotFound, "Repository or tag not found"),
+				errorDoc(http.StatusBadGateway, "The chart could not be read from the registry"),
+			},
+		},
+		{
+			method: http.MethodGet, path: apiV1Prefix + "/registries", tag: "v1",
+			summary:   "List registries",
+			responses: []apiResponseDoc{okDoc(apiResponse[[]apiRegistry]{})},
+		},
+		{
+			method: http.MethodGet, path: apiV1Prefix + "/registries/{registry}", tag: "v1",
+			summary:   "Get a registry with the storage statistics of the namespaces you can view",
+			responses: []apiResponseDoc{okDoc(apiResponse[apiRegistryDetail]{}), errorDocV1(http.StatusNotFound, "Registry not found")},
+		},
+		{
+			method: http.MethodGet, path: apiV1Prefix + "/repositories", tag: "v1",
+			summary: "List repositories",
+			query: []apiQueryParam{
+				{name: "registry", description: "Registry hosts to include", value: []string{}},
+				{name: "arch", description: "Architectures, such as arm64 or arm/v7", value: []string{}},
+				{name: "os", description: "Operating systems, such as linux", value: []string{}},
+				{name: "untagged", description: "Include repositories without tags", value: false},
+				{name: "q", description: "Search words and filters in the explore query language", value: ""},
+				cursorParam,
+				limitParam,
+			},
+			responses: []apiResponseDoc{
+				okDoc(apiList[store.RepositoryView]{}),
+				errorDocV1(http.StatusBadRequest, "Invalid cursor, limit or query; invalid_query errors point at the token"),
+			},
+		},
+	}
+
+	ops = append(ops,
+		apiOperation{
+			method: http.MethodGet, path: "/api/tokens", tag: "Tokens", sessionOnly: true,
+			summary:   "List your API tokens",
+			responses: []apiResponseDoc{okDoc(tokensResponse{}), errorDoc(http.StatusNotFound, "OIDC sign-in is disabled")},
+		},
+		apiOperation{
+			method: http.MethodPost, path: "/api/tokens", tag: "Tokens", sessionOnly: true,
+			summary: "Create an API token; its secret is only returned in this response",
+			body:    createTokenRequest{},
+			responses: []apiResponseDoc{
+				{status: http.StatusCreated, description: "Created", body: createTokenResponse{}},
+				errorDoc(http.StatusBadRequest, "Invalid name, scopes or expiry"),
+				errorDoc(http.StatusNotFound, "OIDC sign-in is disabled"),
+			},
+		},
+		apiOperation{
+			method: http.MethodDelete, path: "/api/tokens/{id}", tag: "Tokens", sessionOnly: true,
+			summary: "Revoke one of your API tokens, or any token as an administrator",
+			responses: []apiResponseDoc{
+				{status: http.StatusNoContent, description: "Revoked"},
+				errorDoc(http.StatusNotFound, "No such unrevoked token"),
+			},
+		},
+		apiOperation{
+			method: http.MethodGet, path: "/api/admin/tokens", tag: "Tokens", sessionOnly: true,
+			summary: "List the API tokens of every user",
+			responses: []apiResponseDoc{
+				okDoc(tokensResponse{}),
+				errorDoc(http.StatusForbidden, "Not an administrator"),
+				errorDoc(http.StatusNotFound, "OIDC sign-in is disabled"),
+			},
+		},
+		apiOperation{
+			method: http.MethodPost, path: "/api/admin/policy/test", tag: "Policy", sessionOnly: true,
+			summary: "List what a user with an email, groups and roles could do in each known namespace",
+			body:    policyTestRequest{},
+			responses: []apiResponseDoc{
+				okDoc(policyTestResponse{}),
+				errorDoc(http.StatusBadRequest, "Invalid request body"),
+				errorDoc(http.StatusForbidden, "Not an administrator"),
+				errorDoc(http.StatusNotFound, "OIDC sign-in is disabled"),
+			},
+		},
+		apiOperation{
+			method: http.MethodGet, path: "/api/admin/audit", tag: "Audit", sessionOnly: true,
+			summary: "List audit events",
+			query: []apiQueryParam{
+				{name: "action", description: "Only events of this action", value: "", enum: auditActions},
+				{name: "outcome", description: "Only events with this outcome", value: "", enum: auditOutcomes},
+				{name: "actor", description: "Part of the actor's email or name", value: ""},
+				{name: "target", description: "Part of the registry or repository", value: ""},
+				{name: "since", description: "Only events at or after this RFC 3339 time or date", value: ""},
+				{name: "until", description: "Only events before this RFC 3339 time, or up to the end of this date", value: ""},
+				{name: "before", description: "The nextBefore of the previous page", value: 0},
+				{name: "limit", description: "Page size, 50 by default and at most 200", value: 0},
+			},
+			responses: []apiResponseDoc{
+				okDoc(auditResponse{}),
+				errorDoc(http.StatusBadRequest, "Invalid filter"),
+				errorDoc(http.StatusForbidden, "Not an administrator"),
+				errorDoc(http.StatusNotFound, "OIDC sign-in is disabled"),
+			},
+		},
+	)
+	ops = append(ops, repositoryOperations(apiV1Prefix+"/repositories", "", apiOperation{
+		method: http.MethodGet, tag: "v1",
+		summary:   "Get a repository",
+		responses: []apiResponseDoc{okDoc(apiResponse[store.RepositoryView]{}), errorDocV1(http.StatusNotFound, "Repository not found")},
+	})...)
+	ops = append(ops, repositoryOperations(apiV1Prefix+"/repositories", "/tags", apiOperation{
+		method: http.MethodGet, tag: "v1",
+		summary: "List the tags of a repository",
+		query: []apiQueryParam{
+			{name: "sort", description: "Order of the tags, newest by default", value: "",
+				enum: []string{"newest", "oldest", "size-asc", "size-desc", "name-asc", "name-desc"}},
+			{name: "filter", description: "Part of the tag name", value: ""},
+			{name: "asOf", description: "List the tags as they stood at this time", value: time.Time{}},
+			cursorParam,
+			limitParam,
+		},
+		responses: []apiResponseDoc{
+			okDoc(apiList[store.TagView]{}),
+			errorDocV1(http.StatusBadRequest, "Invalid cursor, limit, sort or asOf"),
+			errorDocV1(http.StatusNotFound, "Repository not found"),
+		},
+	})...)
+	ops = append(ops, repositoryOperations(apiV1Prefix+"/repositories", "/tags/{tag}", apiOperation{
+		method: http.MethodGet, tag: "v1",
+		summary: "Get a tag with its images, configs and layers",
+		responses: []apiResponseDoc{
+			okDoc(apiResponse[store.TagDetailView]{}),
+			errorDocV1(http.StatusNotFound, "Repository or tag not found"),
+		},
+	})...)
+	ops = append(ops, repositoryOperations("/r", "/tags", apiOperation{
+		method: http.MethodDelete, tag: "Tags",
+		summary: "Delete tags from the registry, with every tag sharing their manifests",
+		body:    deleteTagsRequest{},
+		responses: []apiResponseDoc{
+			okDoc(deleteTagsResponse{}),
+			{status: http.StatusMultiStatus, description: "Some tags could not be deleted", body: deleteTagsResponse{}},
+			errorDoc(http.StatusBadRequest, "No tags specified"),
+			errorDoc(http.StatusForbidden, "The access policy grants no delete permission on the repository"),
+			errorDoc(http.StatusNotFound, "Repository not found"),
+		},
+	})...)
+	ops = append(ops, repositoryOperations("/r", "/tags/reclaimable", apiOperation{
+		method: http.MethodGet, tag: "Storage",
+		summary: "Estimate what deleting tags would let a garbage collection free",
+		query: []apiQueryParam{
+			{name: "tag", description: "Tags that would be deleted", value: []string{}, required: true},
+		},
+		responses: []apiResponseDoc{
+			okDoc(store.ReclaimEstimate{}),
+			errorDoc(http.StatusBadRequest, "No tags specified"),
+			errorDoc(http.StatusNotFound, "Repository not found"),
+		},
+	})...)
+	ops = append(ops, repositoryOperations("/r", "/tags/{tag}/history", apiOperation{
+		method: http.MethodGet, tag: "Tags",
+		summary:   "List the events of a tag, including after it was deleted",
+		responses: []apiResponseDoc{okDoc(tagHistoryResponse{}), errorDoc(http.StatusNotFound, "Repository not found")},
+	})...)
+	return ops
+}
+
+// openAPIDocument builds the OpenAPI 3.1 document of apiOperations.
+func openAPIDocument() map[string]any {
+	b := &schemaBuilder{schemas: map[string]any{}, names: map[reflect.Type]string{}, types: map[string]reflect.Type{}}
+
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations() {
+		if paths[op.path] == nil {
+			paths[op.path] = map[string]any{}
+		}
+		paths[op.path][strings.ToLower(op.method)] = b.operation(op)
+	}
+
+	tags := make([]map[string]string, len(apiTags))
+	for i, t := range apiTags {
+		tags[i] = map[string]string{"name": t.name, "description": t.description}
+	}
+
+	return map[string]any{
+		"openapi": "3.1.0",
+		"info": map[string]any{
+			"title":   "Container Hub API",
+			"version": version.New().Short(),
+			"description": "Endpoints tagged v1 are stable; the others serve the UI and may change between releases. " +
+				"Failed v1 requests return an ApiErrorResponse, others an ErrorResponse.",
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]any{
+				"session": map[string]any{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        sessionCookieName,
+					"description": "The session of a signed-in user. Only required when OIDC sign-in is enabled.",
+				},
+				"bearer": map[string]any{
+					"type":   "http",
+					"scheme": "bearer",
+					"description": "An API token. GET requests need the read scope, the sync trigger the sync scope, " +
+						"and tag deletion the delete scope.",
+				},
+			},
+		},
+		"security": []map[string][]string{{"session": {}}, {"bearer": {}}},
+	}
+}
+
+func (b *schemaBuilder) operation(op apiOperation) map[string]any {
+	out := map[string]any{
+		"operationId": operationID(op),
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+	}
+	switch {
+	case op.public:
+		out["security"] = []any{}
+	case op.sessionOnly:
+		out["security"] = []map[string][]string{{"session": {}}}
+	}
+
+	var params []map[string]any
+	for _, name := range pathParams(op.path) {
+		params = append(params, map[string]any{
+			"name":        name,
+			"in":          "path",
+			"required":    true,
+			"description": apiPathParams[name],
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+	for _, q := range op.query {
+		schema := b.schema(reflect.TypeOf(q.value))
+		if q.enum != nil {
+			schema["enum"] = q.enum
+		}
+		param := map[string]any{"name": q.name, "in": "query", "description": q.description, "schema": schema}
+		if q.required {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	if params != nil {
+		out["parameters"] = params
+	}
+
+	if op.body != nil {
+		out["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(op.body))}},
+		}
+	}
+
+	responses := map[string]any{}
+	for _, resp := range op.responses {
+		doc := map[string]any{"description": resp.description}
+		if resp.body != nil {
+			doc["content"] = map[string]any{"application/json": map[string]any{"schema": b.schema(reflect.TypeOf(resp.body))}}
+		}
+		responses[strconv.Itoa(resp.status)] = doc
+	}
+	out["responses"] = responses
+	return out
+}
+
+// pathParams returns the names of the {params} of a chi pattern.
+func pathParams(pattern string) []string {
+	var names []string
+	for part := range strings.SplitSeq(pattern, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			names = append(names, part[1:len(part)-1])
+		}
+	}
+	return names
+}
+
+// operationID names an operation after its method and path, such as
+// getApiV1RepositoriesRegistryRepository.
+func operationID(op apiOperation) string {
+	var id strings.Builder
+	id.WriteString(strings.ToLower(op.method))
+	words := strings.FieldsFunc(op.path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		id.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return id.String()
+}
+
+// schemaBuilder converts Go types to JSON Schemas the way encoding/json
+// marshals them. Named structs become shared components.
+type schemaBuilder struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+	types   map[string]reflect.Type
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(b.schema(t.Elem()))
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" || strings.Contains(t.Name(), "[") {
+			// Anonymous structs and generic envelopes are described in place.
+			return b.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + b.component(t)}
+	default:
+		return map[string]any{}
+	}
+}
+
+// component registers a named struct under components/schemas, prefixing the
+// package name when two packages use the same type name.
+func (b *schemaBuilder) component(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := upperFirst(t.Name())
+	if other, taken := b.types[name]; taken && other != t {
+		name = upperFirst(path.Base(t.PkgPath())) + name
+	}
+	b.names[t] = name
+	b.types[name] = t
+	// Registered before its fields so self-referencing types terminate.
+	b.schemas[name] = map[string]any{}
+	b.schemas[name] = b.object(t)
+	return name
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	b.fields(t, properties, &required)
+
+	out := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		slices.Sort(required)
+		out["required"] = required
+	}
+	return out
+}
+
+func (b *schemaBuilder) fields(t reflect.Type, properties map[string]any, required *[]string) {
+	for f := range t.Fields() {
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.fields(f.Type, properties, required)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func nullable(schema map[string]any) map[string]any {
+	if len(schema) == 0 {
+		return schema
+	}
+	if typ, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typ, "null"}
+		return schema
+	}
+	return map[string]any{"anyOf": []any{schema, map[string]any{"type": "null"}}}
+}
+
+func upperFirst(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (h *handler) openAPISpec(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
+
+// openAPIDocs serves a viewer for the document that needs no external assets.
+func (h *handler) openAPIDocs(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy",
+		"default-src 'none'; script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	_, _ = w.Write(openAPIViewer)
+}
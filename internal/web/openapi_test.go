@@ -0,0 +1,133 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/romsar/gonertia/v3"
+)
+
+// undocumentedRoutes serve pages, assets, sign-in and WebSockets rather than
+// JSON, so they have no OpenAPI entry.
+var undocumentedRoutes = map[string]bool{
+	"/":                                      true,
+	"/r/{registry}":                          true,
+	"/r/{registry}/{repository}":             true,
+	"/r/{registry}/{namespace}/{repository}": true,
+	"/build/*":                               true,
+	"/public/*":                              true,
+	"/oauth/login":                           true,
+	"/oauth/callback":                        true,
+	"/oauth/logout":                          true,
+	"/ws/sync/progress":                      true,
+	"/api/docs":                              true,
+	"/settings/tokens":                       true,
+	"/admin/tokens":                          true,
+	"/admin/audit":                           true,
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	gi, err := gonertia.New("<html></html>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vi, err := gonertia.NewVite(gi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(&handler{}, Options{Inertia: vi})
+
+	paths := openAPIDocument()["paths"].(map[string]map[string]any)
+	routes := map[string]bool{}
+	err = chi.Walk(router, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if undocumentedRoutes[route] {
+			return nil
+		}
+		routes[method+" "+route] = true
+		if _, ok := paths[route][strings.ToLower(method)]; !ok {
+			t.Errorf("route %s %s has no entry in apiOperations", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, item := range paths {
+		for method := range item {
+			if !routes[strings.ToUpper(method)+" "+path] {
+				t.Errorf("OpenAPI entry %s %s has no route", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIDocumentResolves(t *testing.T) {
+	raw, err := json.Marshal(openAPIDocument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]string{}
+	for path, item := range doc.Paths {
+		for method, rawOp := range item {
+			var op struct {
+				OperationID string `json:"operationId"`
+				Parameters  []struct {
+					Name        string `json:"name"`
+					In          string `json:"in"`
+					Description string `json:"description"`
+				} `json:"parameters"`
+			}
+			if err := json.Unmarshal(rawOp, &op); err != nil {
+				t.Fatal(err)
+			}
+			if other, dup := ids[op.OperationID]; dup {
+				t.Errorf("operationId %s of %s %s is also used by %s", op.OperationID, method, path, other)
+			}
+			ids[op.OperationID] = method + " " + path
+			for _, p := range op.Parameters {
+				if p.Description == "" {
+					t.Errorf("%s parameter %s of %s %s has no description", p.In, p.Name, method, path)
+				}
+			}
+		}
+	}
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, ok := doc.Components.Schemas[name]; !ok {
+					t.Errorf("unresolved schema reference %s", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	var tree any
+	if err := json.Unmarshal(raw, &tree); err != nil {
+		t.Fatal(err)
+	}
+	walk(tree)
+}
@@ -0,0 +1,351 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+)
+
+// Permissions a policy grants on repositories.
+const (
+	PermissionView   = "view"
+	PermissionSync   = "sync"
+	PermissionDelete = "delete"
+)
+
+var policyPermissions = []string{PermissionView, PermissionSync, PermissionDelete}
+
+// rootNamespace is what policies call the namespace of repositories without
+// one.
+const rootNamespace = "library"
+
+// Policy maps OIDC identities to permissions on registries and namespaces. A
+// user can do what at least one matching rule grants and nothing else.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyRule grants Permissions on the repositories matching Registries and
+// Namespaces to users matching any of Emails, Groups and Roles, or to every
+// user when it names none.
+type PolicyRule struct {
+	Name   string   `json:"name,omitempty"`
+	Emails []string `json:"emails,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// Registries are host globs and Namespaces namespace globs, matched with
+	// path.Match; leaving either out matches everything. Repositories without
+	// a namespace are in rootNamespace, as in the storage charts.
+	Registries  []string `json:"registries,omitempty"`
+	Namespaces  []string `json:"namespaces,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// LoadPolicy reads and validates a JSON policy file.
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", file, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", file, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	for i, rule := range p.Rules {
+		if len(rule.Permissions) == 0 {
+			return fmt.Errorf("rule %s grants no permissions", rule.label(i))
+		}
+		for _, perm := range rule.Permissions {
+			if !slices.Contains(policyPermissions, perm) {
+				return fmt.Errorf("rule %s: unknown permission %q", rule.label(i), perm)
+			}
+		}
+		for _, pattern := range slices.Concat(rule.Registries, rule.Namespaces) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %s: pattern %q: %w", rule.label(i), pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// label names a rule in errors and policy test results.
+func (r *PolicyRule) label(i int) string {
+	if r.Name != "" {
+		return strconv.Quote(r.Name)
+	}
+	return "#" + strconv.Itoa(i+1)
+}
+
+func (r *PolicyRule) matchesUser(user *SessionUser) bool {
+	if len(r.Emails) == 0 && len(r.Groups) == 0 && len(r.Roles) == 0 {
+		return true
+	}
+	return slices.Contains(r.Emails, user.Email) ||
+		slices.ContainsFunc(user.Groups, func(g string) bool { return slices.Contains(r.Groups, g) }) ||
+		slices.ContainsFunc(user.Roles, func(role string) bool { return slices.Contains(r.Roles, role) })
+}
+
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		ok, _ := path.Match(pattern, value)
+		return ok
+	})
+}
+
+// access is what the user of a request can do. The zero value, used without
+// a policy or a signed-in user, can do everything.
+type access struct {
+	restricted bool
+	// rules are the policy rules matching the user.
+	rules []PolicyRule
+}
+
+// accessFor resolves the user's rules in the current policy. Administrators
+// are not restricted by it.
+func (a *AuthHandler) accessFor(user *SessionUser) access {
+	if a == nil || user == nil {
+		return access{}
+	}
+	policy := a.policy.Load()
+	if policy == nil || a.IsAdmin(user) {
+		return access{}
+	}
+	acc := access{restricted: true}
+	for _, rule := range policy.Rules {
+		if rule.matchesUser(user) {
+			acc.rules = append(acc.rules, rule)
+		}
+	}
+	return acc
+}
+
+// can reports whether the permission is granted on repositories of the
+// namespace in the registry.
+func (acc access) can(perm, host, namespace string) bool {
+	if namespace == "" {
+		namespace = rootNamespace
+	}
+	return !acc.restricted || slices.ContainsFunc(acc.rules, func(r PolicyRule) bool {
+		return slices.Contains(r.Permissions, perm) && matchesAny(r.Registries, host) && matchesAny(r.Namespaces, namespace)
+	})
+}
+
+// canInRegistry reports whether the permission is granted on some namespace
+// of the registry.
+func (acc access) canInRegistry(perm, host string) bool {
+	return !acc.restricted || slices.ContainsFunc(acc.rules, func(r PolicyRule) bool {
+		return slices.Contains(r.Permissions, perm) && matchesAny(r.Registries, host)
+	})
+}
+
+// canEverywhere reports whether the permission is granted on every namespace
+// of every registry, by a rule listing neither. Syncs cover every registry,
+// so triggering one needs sync everywhere.
+func (acc access) canEverywhere(perm string) bool {
+	return !acc.restricted || slices.ContainsFunc(acc.rules, func(r PolicyRule) bool {
+		return slices.Contains(r.Permissions, perm) && len(r.Registries) == 0 && len(r.Namespaces) == 0
+	})
+}
+
+// ReloadPolicy reads the policy file again. On error the current policy stays
+// in force.
+func (a *AuthHandler) ReloadPolicy() error {
+	if a.config.PolicyFile == "" {
+		return nil
+	}
+	policy, err := LoadPolicy(a.config.PolicyFile)
+	if err != nil {
+		return err
+	}
+	a.policy.Store(policy)
+	a.logger.Info("Access policy loaded", "file", a.config.PolicyFile, "rules", len(policy.Rules))
+	return nil
+}
+
+func (h *handler) access(r *http.Request) access {
+	user, _ := UserFromContext(r.Context())
+	return h.authHandler.accessFor(user)
+}
+
+// visibleRepositories drops the repositories the user cannot view.
+func (acc access) visibleRepositories(repos []store.RepositoryView) []store.RepositoryView {
+	return slices.DeleteFunc(repos, func(repo store.RepositoryView) bool {
+		return !acc.can(PermissionView, repo.RegistryHost, repo.Namespace)
+	})
+}
+
+// viewScope is the namespaces the user of the request can view, for queries
+// to filter in SQL. It is nil when the user is not restricted.
+func (h *handler) viewScope(r *http.Request) (store.NamespaceScope, error) {
+	acc := h.access(r)
+	if !acc.restricted {
+		return nil, nil
+	}
+	namespaces, err := h.store.GetNamespaces(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	return acc.scope(PermissionView, namespaces), nil
+}
+
+// registryScope is the namespaces of the registry the user of the request
+// can view, and whether they are all of its namespaces. The scope is nil when
+// the user is not restricted.
+func (h *handler) registryScope(r *http.Request, host string) (store.NamespaceScope, bool, error) {
+	acc := h.access(r)
+	if !acc.restricted {
+		return nil, true, nil
+	}
+	namespaces, err := h.store.GetNamespaces(r.Context())
+	if err != nil {
+		return nil, false, err
+	}
+	all := namespaces[host]
+	scope := acc.scope(PermissionView, store.NamespaceScope{host: slices.Clone(all)})
+	return scope, len(scope[host]) == len(all), nil
+}
+
+// scope keeps the namespaces the permission is granted on.
+func (acc access) scope(perm string, namespaces store.NamespaceScope) store.NamespaceScope {
+	for host, list := range namespaces {
+		namespaces[host] = slices.DeleteFunc(list, func(ns string) bool { return !acc.can(perm, host, ns) })
+	}
+	return namespaces
+}
+
+// visibleRegistries drops the registries the user cannot view anything in.
+func (acc access) visibleRegistries(registries []store.Registry) []store.Registry {
+	return slices.DeleteFunc(registries, func(reg store.Registry) bool {
+		return !acc.canInRegistry(PermissionView, reg.Host)
+	})
+}
+
+// visibleDigestUsage drops the usages in repositories the user cannot view,
+// and the registries left without any.
+func (acc access) visibleDigestUsage(groups []store.DigestUsageGroup) []store.DigestUsageGroup {
+	for i := range groups {
+		groups[i].Usages = slices.DeleteFunc(groups[i].Usages, func(u store.DigestUsage) bool {
+			return !acc.can(PermissionView, groups[i].RegistryHost, u.Namespace)
+		})
+	}
+	return slices.DeleteFunc(groups, func(g store.DigestUsageGroup) bool { return len(g.Usages) == 0 })
+}
+
+type policyTestRequest struct {
+	Email  string   `json:"email"`
+	Groups []string `json:"groups"`
+	Roles  []string `json:"roles"`
+}
+
+type policyTestResponse struct {
+	// Enforced is false when no policy file is configured, so every signed-in
+	// user can do everything.
+	Enforced bool `json:"enforced"`
+	Admin    bool `json:"admin"`
+	// MatchedRules are the names, or #positions, of the rules matching the
+	// user.
+	MatchedRules []string `json:"matchedRules"`
+	CanSync      bool     `json:"canSync"`
+	// Access lists the known namespaces the user has any permission on.
+	Access []policyTestGrant `json:"access"`
+}
+
+type policyTestGrant struct {
+	Registry    string   `json:"registry"`
+	Namespace   string   `json:"namespace"`
+	Permissions []string `json:"permissions"`
+}
+
+// testPolicy answers what a user with the email, groups and roles could do in
+// the known registries and namespaces.
+func (h *handler) testPolicy(w http.ResponseWriter, r *http.Request) {
+	admin, ok := UserFromContext(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Access policies require OIDC sign-in"})
+		return
+	}
+	if !h.authHandler.IsAdmin(admin) {
+		writeJSON(w, http.StatusForbidden, map[string]string{jsonKeyError: "Only administrators can test the access policy"})
+		return
+	}
+
+	var req policyTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Invalid request body"})
+		return
+	}
+	user := &SessionUser{Email: req.Email, Groups: req.Groups, Roles: req.Roles}
+	acc := h.authHandler.accessFor(user)
+
+	resp := policyTestResponse{
+		Admin:        h.authHandler.IsAdmin(user),
+		MatchedRules: []string{},
+		CanSync:      acc.canEverywhere(PermissionSync),
+		Access:       []policyTestGrant{},
+	}
+	if policy := h.authHandler.policy.Load(); policy != nil {
+		resp.Enforced = true
+		for i, rule := range policy.Rules {
+			if rule.matchesUser(user) {
+				resp.MatchedRules = append(resp.MatchedRules, rule.label(i))
+			}
+		}
+	}
+
+	grants, err := h.policyGrants(r, acc)
+	if err != nil {
+		clog.Error("Failed to test access policy", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to list namespaces"})
+		return
+	}
+	resp.Access = append(resp.Access, grants...)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *handler) policyGrants(r *http.Request, acc access) ([]policyTestGrant, error) {
+	ctx := r.Context()
+	registries, err := h.store.GetAllRegistries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var grants []policyTestGrant
+	for _, reg := range registries {
+		namespaces, err := h.store.GetRegistryStorageByNamespace(ctx, reg.Host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range namespaces {
+			var perms []string
+			for _, perm := range policyPermissions {
+				if acc.can(perm, reg.Host, ns.Namespace) {
+					perms = append(perms, perm)
+				}
+			}
+			if len(perms) > 0 {
+				grants = append(grants, policyTestGrant{Registry: reg.Host, Namespace: ns.Namespace, Permissions: perms})
+			}
+		}
+	}
+	return grants, nil
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	clog "github.com/charmbracelet/log"
+)
+
+const testPolicy = `{
+	"rules": [
+		{"name": "everyone reads", "permissions": ["view"], "registries": ["registry.example.com"]},
+		{"name": "team a", "groups": ["team-a"], "namespaces": ["team-a", "team-a-*"], "permissions": ["view", "delete"]},
+		{"roles": ["ops"], "permissions": ["view", "sync", "delete"]},
+		{"name": "registry ops", "groups": ["registry-ops"], "registries": ["registry.example.com"], "permissions": ["sync"]}
+	]
+}`
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestPolicyAccess(t *testing.T) {
+	a := &AuthHandler{
+		config: AuthConfig{PolicyFile: writePolicy(t, testPolicy)},
+		logger: clog.Default(),
+		admins: authzRules{emails: map[string]struct{}{"root@example.com": {}}},
+	}
+	if err := a.ReloadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		user      *SessionUser
+		perm      string
+		host      string
+		namespace string
+		want      bool
+	}{
+		{"anyone views the shared registry", &SessionUser{}, PermissionView, "registry.example.com", "", true},
+		{"nobody else views other registries", &SessionUser{}, PermissionView, "other.example.com", "team-a", false},
+		{"team views its namespace anywhere", &SessionUser{Groups: []string{"team-a"}}, PermissionView, "other.example.com", "team-a", true},
+		{"team deletes in matching namespaces", &SessionUser{Groups: []string{"team-a"}}, PermissionDelete, "registry.example.com", "team-a-tools", true},
+		{"team cannot delete elsewhere", &SessionUser{Groups: []string{"team-a"}}, PermissionDelete, "registry.example.com", "team-b", false},
+		{"team cannot sync", &SessionUser{Groups: []string{"team-a"}}, PermissionSync, "registry.example.com", "team-a", false},
+		{"ops deletes at the root", &SessionUser{Roles: []string{"ops"}}, PermissionDelete, "other.example.com", "", true},
+		{"admins ignore the policy", &SessionUser{Email: "root@example.com"}, PermissionDelete, "other.example.com", "x", true},
+		{"without a user nothing is restricted", nil, PermissionDelete, "other.example.com", "x", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.accessFor(tt.user).can(tt.perm, tt.host, tt.namespace); got != tt.want {
+				t.Errorf("can(%s, %s, %q) = %v, want %v", tt.perm, tt.host, tt.namespace, got, tt.want)
+			}
+		})
+	}
+
+	team := a.accessFor(&SessionUser{Groups: []string{"team-a"}})
+	if team.canEverywhere(PermissionSync) {
+		t.Error("team-a can sync without a rule granting it")
+	}
+	if a.accessFor(&SessionUser{Groups: []string{"registry-ops"}}).canEverywhere(PermissionSync) {
+		t.Error("registry-ops can trigger a sync of every registry with sync on one")
+	}
+	if !a.accessFor(&SessionUser{Roles: []string{"ops"}}).canEverywhere(PermissionSync) {
+		t.Error("ops cannot sync despite a rule granting it everywhere")
+	}
+	if !team.canInRegistry(PermissionDelete, "other.example.com") {
+		t.Error("team-a cannot delete in any namespace of other.example.com")
+	}
+}
+
+func TestLoadPolicyRejectsInvalidRules(t *testing.T) {
+	tests := map[string]string{
+		"unknown permission": `{"rules": [{"permissions": ["write"]}]}`,
+		"no permissions":     `{"rules": [{"groups": ["a"]}]}`,
+		"bad pattern":        `{"rules": [{"namespaces": ["team-["], "permissions": ["view"]}]}`,
+		"unknown field":      `{"rules": [{"group": ["a"], "permissions": ["view"]}]}`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadPolicy(writePolicy(t, content)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if _, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.json")); err == nil ||
+		!strings.Contains(err.Error(), "read policy") {
+		t.Errorf("expected a read error, got %v", err)
+	}
+}
@@ -1,9 +1,11 @@
 package web
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/eznix86/docker-registry-ui/internal/registry"
 	"github.com/eznix86/docker-registry-ui/internal/store"
@@ -14,6 +16,16 @@ type explorePageFilters struct {
 	Architectures []string `json:"architectures"`
 	ShowUntagged  bool     `json:"showUntagged"`
 	Search        string   `json:"search"`
+	// Query is the raw query language string from the search box.
+	Query      string      `json:"query"`
+	QueryError *queryError `json:"queryError,omitempty"`
+}
+
+// digestUsageProps is shown instead of repositories when the search box
+// holds a digest.
+type digestUsageProps struct {
+	Digest     string                   `json:"digest"`
+	Registries []store.DigestUsageGroup `json:"registries"`
 }
 
 type registryOption struct {
@@ -23,26 +35,42 @@ type registryOption struct {
 	Status     int    `json:"status"`
 }
 
-func parseExploreFilters(r *http.Request) store.RepositoryFilters {
+// parseExploreFilters reads the sidebar filters and the query string in q,
+// or in search for links made before the query language.
+func parseExploreFilters(r *http.Request) (store.RepositoryFilters, string, *queryError) {
 	q := r.URL.Query()
 	registries := q["registries"]
 	for i, reg := range registries {
 		registries[i] = strings.ReplaceAll(reg, "~", ":")
 	}
-	return store.RepositoryFilters{
+	filters := store.RepositoryFilters{
 		Registries:    registries,
 		Architectures: q["architectures"],
-		Search:        q.Get("search"),
 		ShowUntagged:  q.Get("untagged") == "true",
 	}
+
+	query := q.Get("q")
+	if query == "" {
+		query = q.Get("search")
+	}
+	if err := parseExploreQuery(query, time.Now(), &filters); err != nil {
+		var qe *queryError
+		if !errors.As(err, &qe) {
+			qe = &queryError{Message: err.Error()}
+		}
+		return filters, query, qe
+	}
+	return filters, query, nil
 }
 
-func exploreProps(f store.RepositoryFilters) explorePageFilters {
+func exploreProps(f store.RepositoryFilters, query string, queryErr *queryError) explorePageFilters {
 	return explorePageFilters{
 		Registries:    f.Registries,
 		Architectures: f.Architectures,
 		ShowUntagged:  f.ShowUntagged,
 		Search:        f.Search,
+		Query:         query,
+		QueryError:    queryErr,
 	}
 }
 
@@ -58,16 +86,68 @@ func toRegistryOptions(registries []store.Registry, manager *registry.Manager) [
 	return result
 }
 
-func parseTagFilter(r *http.Request) store.TagFilter {
+// errInvalidAsOf rejects an asOf value parseAsOf cannot read, rather than
+// quietly showing the current tags in its place.
+var errInvalidAsOf = errors.New("invalid asOf, expected an RFC 3339 time")
+
+func parseTagFilter(r *http.Request) (store.TagFilter, error) {
 	q := r.URL.Query()
 	sortBy := q.Get("sortBy")
 	if sortBy == "" {
 		sortBy = "newest"
 	}
-	return store.TagFilter{
+	filter := store.TagFilter{
 		SortBy: sortBy,
 		Name:   q.Get("filter"),
 	}
+	if raw := q.Get("asOf"); raw != "" {
+		if filter.AsOf = parseAsOf(raw); filter.AsOf == nil {
+			return filter, errInvalidAsOf
+		}
+	}
+	return filter, nil
+}
+
+// parseAsOf accepts an RFC 3339 timestamp, or a datetime-local value taken as UTC.
+// Colons may arrive encoded as "~" like other route values.
+func parseAsOf(raw string) *time.Time {
+	if raw == "" {
+		return nil
+	}
+	raw = strings.ReplaceAll(raw, "~", ":")
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04"} {
+		if t, err := time.Parse(layout, raw); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func formatAsOf(asOf *time.Time) string {
+	if asOf == nil {
+		return ""
+	}
+	return asOf.UTC().Format(time.RFC3339)
+}
+
+// storageTrendPeriods are the windows offered by the registry growth charts.
+var storageTrendPeriods = map[string]time.Duration{
+	"7d":   7 * 24 * time.Hour,
+	"30d":  30 * 24 * time.Hour,
+	"90d":  90 * 24 * time.Hour,
+	"365d": 365 * 24 * time.Hour,
+}
+
+const defaultStorageTrendPeriod = "30d"
+
+// parseStorageTrendPeriod reads the period query parameter, falling back to
+// 30 days for unknown values.
+func parseStorageTrendPeriod(r *http.Request) (string, time.Duration) {
+	period := r.URL.Query().Get("period")
+	if d, ok := storageTrendPeriods[period]; ok {
+		return period, d
+	}
+	return defaultStorageTrendPeriod, storageTrendPeriods[defaultStorageTrendPeriod]
 }
 
 func parseScroll(r *http.Request, defaultSize int) store.ScrollPagination {
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTagFilterAsOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  *time.Time
+		err   error
+	}{
+		{name: "absent", query: ""},
+		{name: "rfc3339", query: "asOf=2024-05-01T10:00:00Z", want: new(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))},
+		{name: "encoded colons", query: "asOf=2024-05-01T10~00", want: new(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))},
+		{name: "malformed", query: "asOf=yesterday", err: errInvalidAsOf},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := parseTagFilter(httptest.NewRequest("GET", "/r/test.io/app?"+test.query, nil))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if (filter.AsOf == nil) != (test.want == nil) || (test.want != nil && !filter.AsOf.Equal(*test.want)) {
+				t.Fatalf("expected asOf %v, got %v", test.want, filter.AsOf)
+			}
+		})
+	}
+}
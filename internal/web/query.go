@@ -0,0 +1,261 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eznix86/docker-registry-ui/internal/store"
+)
+
+// The explore query language: whitespace-separated filters such as
+//
+//	arch:arm64 os:linux ns:platform size>500MB updated<7d label:team=payments kind:helm registry:ghcr.io
+//
+// Words that do not start with one of the filter keys below, or that are in
+// double quotes, become the full-text search, so image references such as
+// nginx:1.25 and URLs are searched for as typed.
+
+// queryError points at the token of an explore query that could not be parsed.
+type queryError struct {
+	Message string `json:"message"`
+	Token   string `json:"token"`
+	// Position is the byte offset of Token in the query.
+	Position int `json:"position"`
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("%s: %q at position %d", e.Message, e.Token, e.Position+1)
+}
+
+type queryToken struct {
+	text string
+	// raw is the token as typed, quotes included.
+	raw string
+	pos int
+	// quoted tokens are always search text.
+	quoted bool
+}
+
+var (
+	queryFilterPattern = regexp.MustCompile(`^([a-zA-Z]+)(:|>=|<=|>|<)(.*)$`)
+	querySizePattern   = regexp.MustCompile(`^(?i)(\d+(?:\.\d+)?)\s*(b|kb|mb|gb|tb)?$`)
+	queryAgePattern    = regexp.MustCompile(`^(\d+)(m|h|d|w)$`)
+	queryLabelPattern  = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	// queryDigestPattern accepts a digest or a prefix of at least 8 hex digits.
+	queryDigestPattern = regexp.MustCompile(`^sha(256|512):[a-f0-9]{8,128}$`)
+)
+
+var querySizeUnits = map[string]float64{
+	"":   1,
+	"b":  1,
+	"kb": 1 << 10,
+	"mb": 1 << 20,
+	"gb": 1 << 30,
+	"tb": 1 << 40,
+}
+
+var queryAgeUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+var queryKinds = map[string]bool{"image": true, "index": true, "helm": true}
+
+// queryFilterKeys are the keys parseExploreQuery reads as filters.
+var queryFilterKeys = map[string]bool{
+	"arch": true, "architecture": true, "os": true, "ns": true, "namespace": true, "registry": true,
+	"reg": true, "kind": true, "label": true, "size": true, "updated": true,
+}
+
+// parseExploreQuery adds the filters of query to f. Relative ages in
+// updated filters are taken from now.
+func parseExploreQuery(query string, now time.Time, f *store.RepositoryFilters) error {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return err
+	}
+
+	var text []string
+	for _, tok := range tokens {
+		m := queryFilterPattern.FindStringSubmatch(tok.text)
+		if tok.quoted || m == nil || !queryFilterKeys[strings.ToLower(m[1])] {
+			text = append(text, tok.text)
+			continue
+		}
+		key, op, value := strings.ToLower(m[1]), m[2], m[3]
+		fail := func(format string, args ...any) error {
+			return &queryError{Message: fmt.Sprintf(format, args...), Token: tok.raw, Position: tok.pos}
+		}
+		if value == "" {
+			return fail("missing value for %s", key)
+		}
+
+		switch key {
+		case "arch", "architecture":
+			if op != ":" {
+				return fail("%s only supports %q", key, ":")
+			}
+			f.Architectures = append(f.Architectures, value)
+		case "os":
+			if op != ":" {
+				return fail("%s only supports %q", key, ":")
+			}
+			f.OperatingSystems = append(f.OperatingSystems, strings.ToLower(value))
+		case "ns", "namespace":
+			if op != ":" {
+				return fail("%s only supports %q", key, ":")
+			}
+			f.Namespaces = append(f.Namespaces, strings.Trim(value, "/"))
+		case "registry", "reg":
+			if op != ":" {
+				return fail("%s only supports %q", key, ":")
+			}
+			f.Registries = append(f.Registries, value)
+		case "kind":
+			if op != ":" {
+				return fail("%s only supports %q", key, ":")
+			}
+			value = strings.ToLower(value)
+			if !queryKinds[value] {
+				return fail("unknown kind %q, expected image, index or helm", value)
+			}
+			f.Kind = value
+		case "label":
+			if op != ":" {
+				return fail("%s only supports %q", key, ":")
+			}
+			k, v, _ := strings.Cut(value, "=")
+			if !queryLabelPattern.MatchString(k) {
+				return fail("invalid label name %q", k)
+			}
+			f.Labels = append(f.Labels, store.LabelFilter{Key: k, Value: v})
+		case "size":
+			if err := applySizeFilter(op, value, f); err != nil {
+				return fail("%s", err)
+			}
+		case "updated":
+			if err := applyUpdatedFilter(op, value, now, f); err != nil {
+				return fail("%s", err)
+			}
+		}
+	}
+
+	f.Search = strings.Join(text, " ")
+	return nil
+}
+
+// tokenizeQuery splits query on whitespace. Double quotes group words, both
+// around a whole token and around a filter value (label:"a b").
+func tokenizeQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	i := 0
+	for i < len(query) {
+		if isQuerySpace(query[i]) {
+			i++
+			continue
+		}
+		tok := queryToken{pos: i}
+		var b strings.Builder
+		segments := 0
+		for i < len(query) && !isQuerySpace(query[i]) {
+			segments++
+			if query[i] != '"' {
+				end := i
+				for end < len(query) && !isQuerySpace(query[end]) && query[end] != '"' {
+					end++
+				}
+				b.WriteString(query[i:end])
+				i = end
+				continue
+			}
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, &queryError{Message: "unterminated quote", Token: query[i:], Position: i}
+			}
+			b.WriteString(query[i+1 : i+1+end])
+			i += end + 2
+		}
+		tok.quoted = segments == 1 && query[tok.pos] == '"'
+		tok.text = b.String()
+		tok.raw = query[tok.pos:i]
+		tokens = append(tokens, tok)
+	}
+	return tokens, nil
+}
+
+// digestQuery reports whether the whole query is a digest to look up rather
+// than a search, and returns it normalised.
+func digestQuery(query string) (string, bool) {
+	digest := strings.ToLower(strings.TrimSpace(query))
+	return digest, queryDigestPattern.MatchString(digest)
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func applySizeFilter(op, value string, f *store.RepositoryFilters) error {
+	m := querySizePattern.FindStringSubmatch(value)
+	if m == nil {
+		return fmt.Errorf("invalid size %q, expected a number with an optional B, KB, MB, GB or TB unit", value)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	bytes := int64(math.Round(n * querySizeUnits[strings.ToLower(m[2])]))
+
+	switch op {
+	case ">":
+		bytes++
+		f.MinSizeBytes = &bytes
+	case ">=":
+		f.MinSizeBytes = &bytes
+	case "<":
+		bytes--
+		f.MaxSizeBytes = &bytes
+	case "<=":
+		f.MaxSizeBytes = &bytes
+	default:
+		return errors.New("size needs a comparison such as size>500MB")
+	}
+	return nil
+}
+
+// applyUpdatedFilter reads ages (updated<7d: within the last 7 days) and
+// dates (updated>2025-01-31: since that day).
+func applyUpdatedFilter(op, value string, now time.Time, f *store.RepositoryFilters) error {
+	if op == ":" {
+		return errors.New("updated needs a comparison such as updated<7d")
+	}
+	newer := op == ">" || op == ">="
+
+	var at time.Time
+	if m := queryAgePattern.FindStringSubmatch(value); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return fmt.Errorf("invalid age %q", value)
+		}
+		at = now.Add(-time.Duration(n) * queryAgeUnits[m[2]])
+		// A smaller age is more recent.
+		newer = !newer
+	} else if t, err := time.Parse(time.DateOnly, value); err == nil {
+		at = t
+	} else {
+		return fmt.Errorf("invalid time %q, expected an age like 7d or a date like 2025-01-31", value)
+	}
+
+	if newer {
+		f.UpdatedAfter = &at
+	} else {
+		f.UpdatedBefore = &at
+	}
+	return nil
+}
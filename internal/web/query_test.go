@@ -0,0 +1,101 @@
+package web
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eznix86/docker-registry-ui/internal/store"
+)
+
+func TestParseExploreQuery(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	size := func(n int64) *int64 { return &n }
+	at := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name  string
+		query string
+		want  store.RepositoryFilters
+	}{
+		{
+			name:  "example",
+			query: "arch:arm64 os:Linux ns:platform/ size>500MB updated<7d label:team=payments kind:helm registry:ghcr.io postgres",
+			want: store.RepositoryFilters{
+				Architectures:    []string{"arm64"},
+				OperatingSystems: []string{"linux"},
+				Namespaces:       []string{"platform"},
+				MinSizeBytes:     size(500<<20 + 1),
+				UpdatedAfter:     at(now.Add(-7 * 24 * time.Hour)),
+				Labels:           []store.LabelFilter{{Key: "team", Value: "payments"}},
+				Kind:             "helm",
+				Registries:       []string{"ghcr.io"},
+				Search:           "postgres",
+			},
+		},
+		{
+			name:  "size and date bounds",
+			query: "size<=1GB updated>2025-01-31",
+			want:  store.RepositoryFilters{MaxSizeBytes: size(1 << 30), UpdatedAfter: at(time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC))},
+		},
+		{name: "quoted filter is text", query: `"kind:helm" cache`, want: store.RepositoryFilters{Search: "kind:helm cache"}},
+		{name: "quoted value", query: `label:"team=data platform"`, want: store.RepositoryFilters{Labels: []store.LabelFilter{{Key: "team", Value: "data platform"}}}},
+		{name: "label presence", query: "label:org.opencontainers.image.vendor", want: store.RepositoryFilters{Labels: []store.LabelFilter{{Key: "org.opencontainers.image.vendor"}}}},
+		{name: "image reference", query: "nginx:1.25", want: store.RepositoryFilters{Search: "nginx:1.25"}},
+		{name: "qualified reference", query: "ghcr.io/org/app:latest", want: store.RepositoryFilters{Search: "ghcr.io/org/app:latest"}},
+		{name: "source url", query: "https://github.com/org/app kind:image", want: store.RepositoryFilters{Search: "https://github.com/org/app", Kind: "image"}},
+		{name: "unknown comparison", query: "replicas>3", want: store.RepositoryFilters{Search: "replicas>3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got store.RepositoryFilters
+			if err := parseExploreQuery(test.query, now, &got); err != nil {
+				t.Fatalf("parseExploreQuery(%q): %v", test.query, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("parseExploreQuery(%q) =\n%+v\nwant\n%+v", test.query, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseExploreQueryErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		token    string
+		position int
+	}{
+		{name: "unterminated quote", query: `cache "postgres op`, token: `"postgres op`, position: 6},
+		{name: "unterminated value quote", query: `label:"team`, token: `"team`, position: 6},
+		{name: "missing value", query: "cache arch:", token: "arch:", position: 6},
+		{name: "comparison on arch", query: "arch>1", token: "arch>1", position: 0},
+		{name: "comparison on os", query: "os<linux", token: "os<linux", position: 0},
+		{name: "comparison on namespace", query: "ns>=a", token: "ns>=a", position: 0},
+		{name: "comparison on registry", query: "registry<x", token: "registry<x", position: 0},
+		{name: "comparison on kind", query: "kind>helm", token: "kind>helm", position: 0},
+		{name: "comparison on label", query: "label<a", token: "label<a", position: 0},
+		{name: "unknown kind", query: "a kind:chart", token: "kind:chart", position: 2},
+		{name: "invalid label name", query: "label:te$m=x", token: "label:te$m=x", position: 0},
+		{name: "invalid size", query: "size>lots", token: "size>lots", position: 0},
+		{name: "size without comparison", query: "size:5MB", token: "size:5MB", position: 0},
+		{name: "updated without comparison", query: "updated:7d", token: "updated:7d", position: 0},
+		{name: "invalid time", query: "  updated<yesterday", token: "updated<yesterday", position: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var f store.RepositoryFilters
+			err := parseExploreQuery(test.query, time.Now(), &f)
+			var qe *queryError
+			if !errors.As(err, &qe) {
+				t.Fatalf("expected a query error for %q, got %v", test.query, err)
+			}
+			if qe.Token != test.token || qe.Position != test.position {
+				t.Fatalf("expected %q at %d, got %q at %d (%s)", test.token, test.position, qe.Token, qe.Position, qe.Message)
+			}
+		})
+	}
+}
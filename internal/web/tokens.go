@@ -0,0 +1,349 @@
+package web
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	clog "github.com/charmbracelet/log"
+	"github.com/eznix86/docker-registry-ui/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/romsar/gonertia/v3"
+)
+
+// API tokens are apiTokenPrefix followed by 32 random bytes in base64url. The
+// prefix makes them easy to find in leaked logs and configs.
+const (
+	apiTokenPrefix = "chub_"
+	// apiTokenDisplayLength is how much of a token is kept to tell tokens
+	// apart in lists.
+	apiTokenDisplayLength = len(apiTokenPrefix) + 6
+	maxAPITokenNameLength = 100
+	maxAPITokenDays       = 3650
+)
+
+const apiTokenContextKey contextKey = "api-token"
+
+var apiTokenScopes = []string{store.APITokenScopeRead, store.APITokenScopeSync, store.APITokenScopeDelete}
+
+// APITokenFromContext returns the token a request was authenticated with, if
+// it was made with one rather than a browser session.
+func APITokenFromContext(ctx context.Context) (*store.APIToken, bool) {
+	t, ok := ctx.Value(apiTokenContextKey).(*store.APIToken)
+	return t, ok
+}
+
+func generateAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// apiTokenScope returns the scope a request made with a token needs, or false
+// when tokens cannot make it at all: tokens cannot manage tokens.
+func apiTokenScope(r *http.Request) (string, bool) {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/tokens"), strings.HasPrefix(path, "/api/admin/"),
+		strings.HasPrefix(path, "/settings/"), strings.HasPrefix(path, "/admin/"):
+		return "", false
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return store.APITokenScopeRead, true
+	case r.Method == http.MethodPost && path == "/api/sync/trigger":
+		return store.APITokenScopeSync, true
+	case r.Method == http.MethodDelete && strings.HasPrefix(path, "/r/") && strings.HasSuffix(path, "/tags"):
+		return store.APITokenScopeDelete, true
+	}
+	return "", false
+}
+
+// serveToken authenticates a request made with an API token as the user who
+// created it.
+func (a *AuthHandler) serveToken(w http.ResponseWriter, r *http.Request, next http.Handler, secret string) {
+	ctx := r.Context()
+	token, err := a.lookupToken(ctx, secret)
+	if err != nil {
+		a.logger.Debug("api token rejected", "error", err)
+		denyRequest(w, r, http.StatusUnauthorized, "Invalid, expired or revoked API token")
+		return
+	}
+
+	user := &SessionUser{
+		Subject: token.UserSubject,
+		Email:   token.UserEmail,
+		Name:    token.UserName,
+		Groups:  token.UserGroups,
+		Roles:   token.UserRoles,
+	}
+	if err := a.authorize(user); err != nil {
+		denyRequest(w, r, http.StatusForbidden, "Access denied")
+		return
+	}
+	scope, allowed := apiTokenScope(r)
+	if !allowed {
+		denyRequest(w, r, http.StatusForbidden, "API tokens cannot be used for this request")
+		return
+	}
+	if !token.HasScope(scope) {
+		denyRequest(w, r, http.StatusForbidden, "API token lacks the "+scope+" scope")
+		return
+	}
+
+	if err := a.tokens.TouchAPIToken(ctx, token.ID, time.Now()); err != nil {
+		a.logger.Warn("Failed to record api token use", "token", token.ID, "error", err)
+	}
+	ctx = context.WithValue(ctx, userContextKey, user)
+	ctx = context.WithValue(ctx, apiTokenContextKey, token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (a *AuthHandler) lookupToken(ctx context.Context, secret string) (*store.APIToken, error) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) || a.tokens == nil {
+		return nil, errors.New("not an api token")
+	}
+	token, err := a.tokens.GetAPITokenByHash(ctx, hashAPIToken(secret))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Active(time.Now()) {
+		return nil, errors.New("api token revoked or expired")
+	}
+	return token, nil
+}
+
+// denyRequest writes an authentication or authorization failure in the error
+// format of the route.
+func denyRequest(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if isAPIv1Request(r) {
+		code := apiCodeForbidden
+		if status == http.StatusUnauthorized {
+			code = apiCodeUnauthorized
+		}
+		writeAPIError(w, r, status, code, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+type createTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresInDays is 0 for a token that does not expire.
+	ExpiresInDays int `json:"expiresInDays"`
+}
+
+type createTokenResponse struct {
+	Token store.APIToken `json:"token"`
+	// Secret is the token itself. It is only ever returned here.
+	Secret string `json:"secret"`
+}
+
+type tokensResponse struct {
+	Tokens []store.APIToken `json:"tokens"`
+}
+
+// tokenUser returns the signed-in user, writing an error when there is none
+// because OIDC sign-in is disabled.
+func tokenUser(w http.ResponseWriter, r *http.Request) (*SessionUser, bool) {
+	user, ok := UserFromContext(r.Context())
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "API tokens require OIDC sign-in"})
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *handler) createToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req createTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Invalid request body"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPITokenNameLength {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Name must be 1 to 100 characters"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Choose at least one scope"})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(apiTokenScopes, scope) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Unknown scope " + scope})
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPITokenDays {
+		writeJSON(w, http.StatusBadRequest, map[string]string{jsonKeyError: "Expiry must be 0 to 3650 days"})
+		return
+	}
+
+	secret, err := generateAPIToken()
+	if err != nil {
+		clog.Error("Failed to generate api token", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to create token"})
+		return
+	}
+	scopes := slices.Clone(req.Scopes)
+	slices.SortFunc(scopes, func(a, b string) int {
+		return slices.Index(apiTokenScopes, a) - slices.Index(apiTokenScopes, b)
+	})
+	token := store.APIToken{
+		TokenHash:   hashAPIToken(secret),
+		Prefix:      secret[:apiTokenDisplayLength],
+		Name:        req.Name,
+		Scopes:      slices.Compact(scopes),
+		UserSubject: user.Subject,
+		UserEmail:   user.Email,
+		UserName:    user.Name,
+		UserGroups:  user.Groups,
+		UserRoles:   user.Roles,
+	}
+	if req.ExpiresInDays > 0 {
+		expires := time.Now().UTC().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expires
+	}
+	event := h.audit.event(r, store.AuditActionTokenCreate, store.AuditOutcomeSuccess)
+	if err := h.store.CreateAPIToken(r.Context(), &token); err != nil {
+		clog.Error("Failed to create api token", "user", user.Subject, "error", err)
+		event.Outcome, event.Detail = store.AuditOutcomeFailure, "Token "+strconv.Quote(token.Name)
+		h.audit.Record(r.Context(), event)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to create token"})
+		return
+	}
+	event.Detail = "Token " + strconv.Quote(token.Name) + " #" + strconv.FormatUint(uint64(token.ID), 10) +
+		" with scopes " + strings.Join(token.Scopes, ", ")
+	h.audit.Record(r.Context(), event)
+	writeJSON(w, http.StatusCreated, createTokenResponse{Token: token, Secret: secret})
+}
+
+func (h *handler) listTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	h.writeTokens(w, r, user.Subject)
+}
+
+func (h *handler) listAllTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	if !h.authHandler.IsAdmin(user) {
+		writeJSON(w, http.StatusForbidden, map[string]string{jsonKeyError: "Only administrators can list every token"})
+		return
+	}
+	h.writeTokens(w, r, "")
+}
+
+func (h *handler) writeTokens(w http.ResponseWriter, r *http.Request, subject string) {
+	tokens, err := h.store.ListAPITokens(r.Context(), subject)
+	if err != nil {
+		clog.Error("Failed to list api tokens", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to list tokens"})
+		return
+	}
+	writeJSON(w, http.StatusOK, tokensResponse{Tokens: tokens})
+}
+
+// revokeToken revokes one of the user's tokens, or any token for an
+// administrator.
+func (h *handler) revokeToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := tokenUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Token not found"})
+		return
+	}
+
+	subject := user.Subject
+	if h.authHandler.IsAdmin(user) {
+		subject = ""
+	}
+	err = h.store.RevokeAPIToken(r.Context(), uint(id), subject, time.Now())
+	event := h.audit.event(r, store.AuditActionTokenRevoke, store.AuditOutcomeSuccess)
+	event.Detail = "Token #" + strconv.FormatUint(id, 10)
+	if errors.Is(err, sql.ErrNoRows) {
+		event.Outcome, event.Detail = store.AuditOutcomeFailure, event.Detail+" not found or already revoked"
+	} else if err != nil {
+		event.Outcome, event.Detail = store.AuditOutcomeFailure, event.Detail+": "+err.Error()
+	}
+	h.audit.Record(r.Context(), event)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, map[string]string{jsonKeyError: "Token not found"})
+		return
+	}
+	if err != nil {
+		clog.Error("Failed to revoke api token", "token", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{jsonKeyError: "Failed to revoke token"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) tokensPage(w http.ResponseWriter, r *http.Request) {
+	h.renderTokensPage(w, r, false)
+}
+
+func (h *handler) adminTokensPage(w http.ResponseWriter, r *http.Request) {
+	h.renderTokensPage(w, r, true)
+}
+
+func (h *handler) renderTokensPage(w http.ResponseWriter, r *http.Request, all bool) {
+	user, ok := UserFromContext(r.Context())
+	if !ok || (all && !h.authHandler.IsAdmin(user)) {
+		h.notFound(w, r)
+		return
+	}
+	subject := user.Subject
+	if all {
+		subject = ""
+	}
+	tokens, err := h.store.ListAPITokens(r.Context(), subject)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.renderPage(w, r, "Tokens", gonertia.Props{
+		"tokens": tokens,
+		"scopes": apiTokenScopes,
+		"all":    all,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -37,6 +37,7 @@ type Options struct {
 	Broadcaster     *progress.WebSocketBroadcaster
 	ManualSyncChan  sync.ManualSyncChannel
 	AuthHandler     *AuthHandler
+	AuditLog        *AuditLog
 	Host            string
 	Port            string
 	Debug           bool
@@ -65,12 +66,33 @@ func New(opts Options) (*Server, error) {
 		broadcaster:  opts.Broadcaster,
 		manualCh:     opts.ManualSyncChan,
 		authHandler:  opts.AuthHandler,
+		audit:        opts.AuditLog,
 		showUsageBar: opts.ShowUsageBar,
 	}
 
-	r := chi.NewRouter()
+	if opts.AuthHandler != nil {
+		opts.AuthHandler.tokens = opts.Store
+		opts.AuthHandler.audit = opts.AuditLog
+	}
+
 	addr := fmt.Sprintf("%s:%s", opts.Host, opts.Port)
 
+	return &Server{
+		router: &router{
+			srv: &http.Server{
+				Addr:              addr,
+				Handler:           newRouter(h, opts),
+				ReadHeaderTimeout: 10 * time.Second,
+			},
+			logger: clog.Default(),
+		},
+	}, nil
+}
+
+// newRouter registers every route of the server on a new router.
+func newRouter(h *handler, opts Options) chi.Router {
+	r := chi.NewRouter()
+
 	r.Use(chimw.StripSlashes)
 	r.Use(chimw.RequestID)
 	r.Use(chimw.Recoverer)
@@ -105,9 +127,25 @@ func New(opts Options) (*Server, error) {
 			group.HandleFunc("/ws/sync/progress", h.wsProgress)
 		}
 		group.Post("/api/sync/trigger", h.manualSync)
+		group.Get("/api/search", h.search)
+		group.Get("/api/digests/{digest}", h.digestUsage)
+		group.Get("/api/registries/{registry}/reclaimable", h.registryReclaimable)
+		group.Route(apiV1Prefix, h.apiV1Routes)
+		group.Get("/api/openapi.json", h.openAPISpec)
+		group.Get("/api/docs", h.openAPIDocs)
+		group.Get("/api/tokens", h.listTokens)
+		group.Post("/api/tokens", h.createToken)
+		group.Delete("/api/tokens/{id}", h.revokeToken)
+		group.Get("/api/admin/tokens", h.listAllTokens)
+		group.Post("/api/admin/policy/test", h.testPolicy)
+		group.Get("/api/admin/audit", h.auditEvents)
 
 		group.Delete("/r/{registry}/{repository}/tags", h.deleteTags)
 		group.Delete("/r/{registry}/{namespace}/{repository}/tags", h.deleteTags)
+		group.Get("/r/{registry}/{repository}/tags/reclaimable", h.reclaimEstimate)
+		group.Get("/r/{registry}/{namespace}/{repository}/tags/reclaimable", h.reclaimEstimate)
+		group.Get("/r/{registry}/{repository}/tags/{tag}/history", h.tagHistory)
+		group.Get("/r/{registry}/{namespace}/{repository}/tags/{tag}/history", h.tagHistory)
 
 		group.Get("/r/{registry}/{namespace}/{repository}/helm/{tag}/values", h.helmValues)
 		group.Get("/r/{registry}/{namespace}/{repository}/helm/{tag}/files", h.helmFiles)
@@ -119,20 +157,14 @@ func New(opts Options) (*Server, error) {
 			group.Get("/r/{registry}", h.registryPage)
 			group.Get("/r/{registry}/{repository}", h.repositoryPage)
 			group.Get("/r/{registry}/{namespace}/{repository}", h.repositoryPage)
+			group.Get("/settings/tokens", h.tokensPage)
+			group.Get("/admin/tokens", h.adminTokensPage)
+			group.Get("/admin/audit", h.auditPage)
 			group.NotFound(h.notFound)
 		})
 	})
 
-	return &Server{
-		router: &router{
-			srv: &http.Server{
-				Addr:              addr,
-				Handler:           r,
-				ReadHeaderTimeout: 10 * time.Second,
-			},
-			logger: clog.Default(),
-		},
-	}, nil
+	return r
 }
 
 func (s *Server) Start() error {
@@ -0,0 +1,50 @@
+import type { Ref } from "vue"
+import type { ReclaimEstimate } from "~/types"
+import { useDebounceFn } from "@vueuse/core"
+import { ref } from "vue"
+import { currentTagsPath } from "~/lib/routes"
+
+export function useReclaimEstimate() {
+	const loading = ref(false)
+	const estimate = ref<ReclaimEstimate | null>(null)
+	let requested = 0
+
+	async function fetchEstimate(tagNames: string[]): Promise<void> {
+		const request = ++requested
+		if (tagNames.length === 0) {
+			estimate.value = null
+			return
+		}
+
+		loading.value = true
+		try {
+			const params = new URLSearchParams()
+			for (const name of tagNames) {
+				params.append("tag", name)
+			}
+			const resp = await fetch(`${currentTagsPath()}/reclaimable?${params}`, { headers: { Accept: "application/json" } })
+			if (!resp.ok) {
+				throw new Error(`Request failed (${resp.status})`)
+			}
+			const data = (await resp.json()) as ReclaimEstimate
+			if (request === requested) {
+				estimate.value = data
+			}
+		}
+		catch {
+			// The estimate is informational; the deletion works without it.
+			if (request === requested) {
+				estimate.value = null
+			}
+		}
+		finally {
+			if (request === requested) {
+				loading.value = false
+			}
+		}
+	}
+
+	const fetchEstimateDebounced = useDebounceFn(fetchEstimate, 250)
+
+	return { loading, estimate: estimate as Ref<ReclaimEstimate | null>, fetchEstimate, fetchEstimateDebounced }
+}
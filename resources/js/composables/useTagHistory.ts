@@ -0,0 +1,39 @@
+import type { Ref } from "vue"
+import type { Repository, TagEvent } from "~/types"
+import { ref } from "vue"
+import { repositoryPath } from "~/lib/routes"
+
+interface TagHistoryResponse {
+	tag: string
+	events: TagEvent[]
+}
+
+export function useTagHistory() {
+	const loading = ref(false)
+	const error = ref<string | null>(null)
+	const events = ref<TagEvent[]>([])
+
+	async function fetchHistory(repository: Repository, tagName: string): Promise<void> {
+		loading.value = true
+		error.value = null
+		events.value = []
+		try {
+			const url = `${repositoryPath(repository)}/tags/${encodeURIComponent(tagName)}/history`
+			const resp = await fetch(url, { headers: { Accept: "application/json" } })
+			if (!resp.ok) {
+				const body = await resp.json().catch(() => ({}))
+				throw new Error(body.error || `Request failed (${resp.status})`)
+			}
+			const data = (await resp.json()) as TagHistoryResponse
+			events.value = data.events
+		}
+		catch (e) {
+			error.value = e instanceof Error ? e.message : String(e)
+		}
+		finally {
+			loading.value = false
+		}
+	}
+
+	return { loading, error: error as Ref<string | null>, events, fetchHistory }
+}
@@ -15,6 +15,9 @@ export interface SharedProps extends PageProps {
 	appVersion: string
 	auth?: {
 		user?: AuthUser
+		admin?: boolean
+		// canSync is false when the access policy grants no sync permission.
+		canSync?: boolean
 	}
 }
 
@@ -28,6 +31,20 @@ export interface Repository {
 	tagsCount: number
 	architectures?: string[]
 	totalSizeInBytes?: number
+	uniqueSizeInBytes?: number
+	sharedSizeInBytes?: number
+	match?: SearchMatch
+}
+
+export interface SnippetPart {
+	text: string
+	match?: boolean
+}
+
+export interface SearchMatch {
+	tag?: string
+	field: string
+	snippet: SnippetPart[]
 }
 
 export interface Registry {
@@ -48,8 +65,34 @@ export interface NamespaceStorage {
 	namespace: string
 	displayName: string
 	totalSizeBytes: number
+	uniqueSizeBytes: number
+	sharedSizeBytes: number
+}
+
+export interface StorageSnapshotPoint {
+	takenAt: string
+	sizeBytes: number
+	repositoryCount: number
+	tagCount: number
+}
+
+export interface NamespaceGrowth {
+	namespace: string
+	displayName: string
+	startBytes: number
+	endBytes: number
+	growthBytes: number
+}
+
+export interface ReclaimEstimate {
+	manifests: number
+	blobs: number
+	reclaimableBytes: number
+	retainedBytes: number
 }
 
+export type StorageTrendPeriod = "7d" | "30d" | "90d" | "365d"
+
 export interface ArchitectureCoverage {
 	architecture: string
 	repositoryCount: number
@@ -62,6 +105,8 @@ export interface RegistryRepositoryRow {
 	displayName: string
 	tagsCount: number
 	totalSizeInBytes: number
+	uniqueSizeInBytes: number
+	sharedSizeInBytes: number
 }
 
 export interface RegistryStorageUsage {
@@ -70,11 +115,42 @@ export interface RegistryStorageUsage {
 	totalSizeBytes: number
 }
 
+export interface QueryError {
+	message: string
+	token: string
+	position: number
+}
+
 export interface ExploreFilters {
 	registries: string[]
 	architectures: string[]
 	showUntagged: boolean
 	search: string
+	query: string
+	queryError?: QueryError
+}
+
+export type DigestRelation = "tag" | "platform" | "config" | "layer"
+
+export interface DigestUsage {
+	namespace: string
+	repository: string
+	tag: string
+	tagDigest: string
+	digest: string
+	relation: DigestRelation
+	platform?: string
+}
+
+export interface DigestUsageGroup {
+	registry: string
+	registryHost: string
+	usages: DigestUsage[]
+}
+
+export interface DigestUsageResult {
+	digest: string
+	registries: DigestUsageGroup[]
 }
 
 export type ExploreProps = PageProps & SharedProps & {
@@ -83,6 +159,7 @@ export type ExploreProps = PageProps & SharedProps & {
 	totalRepositories: number
 	architectures: string[]
 	filters: ExploreFilters
+	digestUsage?: DigestUsageResult | null
 	showUsageBar?: boolean
 	charts?: {
 		storageByRegistry: RegistryStorageUsage[]
@@ -112,11 +189,14 @@ export interface Tag {
 	chartDesc?: string
 	chartApiVersion?: string
 	chartType?: string
+	manifestNotRetained?: boolean
+	readOnly?: boolean
 }
 
 export interface RepositoryFilters {
 	sortBy: "newest" | "oldest" | "name-asc" | "name-desc" | "size-asc" | "size-desc"
 	filter: string
+	asOf?: string
 }
 
 export interface TagScroll {
@@ -128,6 +208,7 @@ export type RepositoryProps = PageProps & SharedProps & {
 	tags: TagScroll
 	bulkDeleteTags?: Tag[]
 	filters: RepositoryFilters
+	canDeleteTags: boolean
 }
 
 export type RegistryPageProps = PageProps & SharedProps & {
@@ -137,6 +218,98 @@ export type RegistryPageProps = PageProps & SharedProps & {
 	charts?: {
 		storageByNamespace: NamespaceStorage[]
 		architectureCoverage: ArchitectureCoverage[]
+		storageTrend: StorageSnapshotPoint[]
+		namespaceGrowth: NamespaceGrowth[]
 	}
+	trendPeriod?: StorageTrendPeriod
+	reclaimable?: ReclaimEstimate
+	// wholeRegistry is false when the user cannot view every namespace, and
+	// the registry-wide trend and reclaimable estimate are left out.
+	wholeRegistry?: boolean
 	repositories: RegistryRepositoryRow[]
 }
+
+export type TagEventKind = "created" | "moved" | "deleted"
+
+export interface DigestSummary {
+	digest: string
+	kind: string
+	size: number
+	createdAt: string | null
+	platforms: string[]
+	available: boolean
+	current: boolean
+}
+
+export interface TagEvent {
+	id: number
+	event: TagEventKind
+	oldDigest: string
+	newDigest: string
+	syncRunId: number | null
+	occurredAt: string
+	old: DigestSummary | null
+	new: DigestSummary | null
+}
+
+export type ApiTokenScope = "read" | "sync" | "delete"
+
+export interface ApiToken {
+	id: number
+	prefix: string
+	name: string
+	scopes: ApiTokenScope[]
+	userSubject: string
+	userEmail: string
+	userName: string
+	userGroups: string[]
+	userRoles: string[]
+	createdAt: string
+	expiresAt: string | null
+	lastUsedAt: string | null
+	revokedAt: string | null
+}
+
+export interface TokensPageProps extends SharedProps {
+	tokens: ApiToken[]
+	scopes: ApiTokenScope[]
+	all: boolean
+}
+
+export type AuditOutcome = "success" | "failure" | "denied"
+
+export interface AuditEvent {
+	id: number
+	occurredAt: string
+	action: string
+	outcome: AuditOutcome
+	actorSubject: string
+	actorEmail: string
+	actorName: string
+	tokenId: number | null
+	tokenName: string
+	registry: string
+	repository: string
+	tags: string[]
+	digests: string[]
+	detail: string
+	requestId: string
+	clientIp: string
+}
+
+export interface AuditFilters {
+	action: string
+	outcome: string
+	actor: string
+	target: string
+	since: string
+	until: string
+}
+
+export interface AuditPageProps extends SharedProps {
+	events: AuditEvent[]
+	nextBefore: number
+	actions: string[]
+	outcomes: AuditOutcome[]
+	filters: AuditFilters
+}